@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"parenta/internal/config"
+	"parenta/internal/models"
+	"parenta/internal/services"
+	"parenta/internal/storage"
+)
+
+const adminUsage = `Usage: parenta admin <command> [flags]
+
+Commands:
+  list             List admin accounts
+  create           Create an admin account
+  reset-password   Set a new password for an admin (and clear any lockout)
+
+Run "parenta admin <command> -h" for the command's flags.
+The Parenta service must be stopped first, since it holds the data directory.
+`
+
+// runAdmin dispatches the "parenta admin" subcommands and returns the exit code
+func runAdmin(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, adminUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = adminList(args[1:])
+	case "create":
+		err = adminCreate(args[1:])
+	case "reset-password":
+		err = adminResetPassword(args[1:])
+	case "-h", "--help", "help":
+		fmt.Print(adminUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown admin command %q\n\n%s", args[0], adminUsage)
+		return 2
+	}
+
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// adminEnv is the storage and auth service opened by an admin subcommand
+type adminEnv struct {
+	store   *storage.Storage
+	authSvc *services.AuthService
+	unlock  func()
+}
+
+// openAdminEnv loads the config and takes the data directory lock, refusing
+// to run while the service has it
+func openAdminEnv(configPath string) (*adminEnv, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	dataDir := resolveDataDir(cfg)
+	unlock, err := storage.Lock(dataDir, cfg.Storage.DirPerm())
+	if errors.Is(err, storage.ErrLocked) {
+		return nil, fmt.Errorf("%s is in use; stop the Parenta service first (/etc/init.d/parenta stop)", dataDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := storage.New(dataDir, cfg.Storage.DirPerm(), cfg.Storage.FilePerm())
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	authSvc := services.NewAuthService(store, cfg.Session.JWTSecret, cfg.Session.JWTExpiryHours,
+		cfg.Session.MaxLoginAttempts, time.Duration(cfg.Session.LockoutMinutes)*time.Minute)
+
+	return &adminEnv{store: store, authSvc: authSvc, unlock: unlock}, nil
+}
+
+// adminList prints every admin account
+func adminList(args []string) error {
+	fs := flag.NewFlagSet("admin list", flag.ContinueOnError)
+	configPath := fs.String("config", "configs/parenta.json", "Path to config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	env, err := openAdminEnv(*configPath)
+	if err != nil {
+		return err
+	}
+	defer env.unlock()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "USERNAME\tDISPLAY NAME\tROLE\tSTATUS")
+	for _, a := range env.store.ListAdmins() {
+		status := "ok"
+		switch {
+		case a.IsLocked():
+			status = "locked until " + a.LockedUntil.Format(time.RFC3339)
+		case a.ForcePasswordChange:
+			status = "must change password"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.Username, a.GetDisplayName(), a.Role, status)
+	}
+	return tw.Flush()
+}
+
+// adminCreate provisions a new admin account
+func adminCreate(args []string) error {
+	fs := flag.NewFlagSet("admin create", flag.ContinueOnError)
+	configPath := fs.String("config", "configs/parenta.json", "Path to config file")
+	username := fs.String("username", "", "Username (required)")
+	displayName := fs.String("display-name", "", "Display name (defaults to the username)")
+	role := fs.String("role", "admin", "Role: admin, super or viewer")
+	random := fs.Bool("random", false, "Generate a random password and print it instead of prompting")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		return errors.New("--username is required")
+	}
+	userRole := models.RoleAdmin
+	switch *role {
+	case "admin":
+	case "super":
+		userRole = models.RoleSuper
+	case "viewer":
+		userRole = models.RoleViewer
+	default:
+		return fmt.Errorf("--role must be admin, super or viewer, not %q", *role)
+	}
+
+	env, err := openAdminEnv(*configPath)
+	if err != nil {
+		return err
+	}
+	defer env.unlock()
+
+	password, err := newAdminPassword(env, *username, *random)
+	if err != nil {
+		return err
+	}
+
+	name := *displayName
+	if name == "" {
+		name = *username
+	}
+	if _, err := env.authSvc.CreateAdmin(*username, password, name, userRole); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created %s %q; they must change the password at first login\n", userRole, *username)
+	return nil
+}
+
+// adminResetPassword sets a new password for an existing admin
+func adminResetPassword(args []string) error {
+	fs := flag.NewFlagSet("admin reset-password", flag.ContinueOnError)
+	configPath := fs.String("config", "configs/parenta.json", "Path to config file")
+	username := fs.String("username", "", "Username (required)")
+	random := fs.Bool("random", false, "Generate a random password and print it instead of prompting")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		return errors.New("--username is required")
+	}
+
+	env, err := openAdminEnv(*configPath)
+	if err != nil {
+		return err
+	}
+	defer env.unlock()
+
+	admin := env.store.GetAdminByUsername(*username)
+	if admin == nil {
+		return fmt.Errorf("no admin named %q", *username)
+	}
+
+	password, err := newAdminPassword(env, admin.Username, *random)
+	if err != nil {
+		return err
+	}
+
+	if err := env.authSvc.ResetAdminPassword(admin.ID, password); err != nil {
+		return err
+	}
+	if err := env.authSvc.UnlockAdmin(admin.ID, "cli", services.LoginAttempt{}); err != nil {
+		return err
+	}
+
+	fmt.Printf("Password for %q reset; it must be changed at next login\n", admin.Username)
+	return nil
+}
+
+// newAdminPassword prompts for a password (twice) or generates a random one,
+// checking it against the admin password policy
+func newAdminPassword(env *adminEnv, username string, random bool) (string, error) {
+	authSvc := env.authSvc
+	if random {
+		length := 16
+		if min := env.store.GetSettings().PasswordPolicy.Admin.MinLength; min > length {
+			length = min
+		}
+		// Retry the rare draw that misses a required character class
+		for i := 0; i < 100; i++ {
+			password, err := randomPassword(length)
+			if err != nil {
+				return "", err
+			}
+			if authSvc.ValidateAdminPassword(password, username) == nil {
+				fmt.Printf("New password: %s\n", password)
+				return password, nil
+			}
+		}
+		return "", errors.New("could not generate a password meeting the admin password policy")
+	}
+
+	password, err := promptPassword("New password: ")
+	if err != nil {
+		return "", err
+	}
+	if err := authSvc.ValidateAdminPassword(password, username); err != nil {
+		return "", err
+	}
+	confirm, err := promptPassword("Confirm password: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm != password {
+		return "", errors.New("passwords do not match")
+	}
+	return password, nil
+}
+
+// passwordAlphabet leaves out look-alike characters so printed passwords are easy to type
+const passwordAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789!@#%+=-_"
+
+// randomPassword returns n characters drawn uniformly from passwordAlphabet
+func randomPassword(n int) (string, error) {
+	max := big.NewInt(int64(len(passwordAlphabet)))
+	b := make([]byte, n)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = passwordAlphabet[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+// stdin is shared by every prompt so buffered input isn't lost between them
+var stdin = bufio.NewReader(os.Stdin)
+
+// promptPassword reads a line from stdin, turning off terminal echo while it
+// is typed when stdin is a terminal
+func promptPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		if setEcho(false) == nil {
+			defer func() {
+				setEcho(true)
+				fmt.Println()
+			}()
+		}
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password entered")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// setEcho toggles terminal echo with stty, which busybox provides on OpenWrt
+func setEcho(on bool) error {
+	arg := "-echo"
+	if on {
+		arg = "echo"
+	}
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
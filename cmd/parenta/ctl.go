@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const ctlUsage = `Usage: parenta ctl [flags] <command> [args]
+
+Commands:
+  children list                 List children and their time today
+  children pause <child>        Disable a child and end their sessions
+  children resume <child>       Enable a child again
+  sessions                      List active sessions
+  quota add <child> <minutes>   Give a child more time today (negative takes it away)
+  filters add-block <domain>    Block a domain and reload dnsmasq
+  filters add-allow <domain>    Always allow a domain and reload dnsmasq
+
+A child is named by ID, username or name.
+
+Flags:
+  --server URL    Parenta's address (default $PARENTA_SERVER, then ~/.parenta.json, then http://127.0.0.1:8080)
+  --token TOKEN   An API key or access token (default $PARENTA_TOKEN, then ~/.parenta.json)
+  --json          Print the API's JSON response instead of a table
+  --timeout D     Give up on a request after D (default 15s)
+
+~/.parenta.json holds {"server": "http://router:8080", "token": "pk_..."}.
+`
+
+// Where ctl looks for its server and token
+const (
+	ctlServerEnv     = "PARENTA_SERVER"
+	ctlTokenEnv      = "PARENTA_TOKEN"
+	ctlSettingsFile  = ".parenta.json"
+	ctlDefaultServer = "http://127.0.0.1:8080"
+)
+
+// ctlSettings is the server and token kept in ~/.parenta.json
+type ctlSettings struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+}
+
+// loadCtlSettings reads ~/.parenta.json, which is optional
+func loadCtlSettings() (ctlSettings, error) {
+	var settings ctlSettings
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return settings, nil
+	}
+
+	path := filepath.Join(home, ctlSettingsFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, fmt.Errorf("%s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// ctlClient calls the API of a running Parenta
+type ctlClient struct {
+	base  string // Ends in /api/v1
+	token string
+	http  *http.Client
+	out   io.Writer
+	json  bool
+}
+
+// apiError is a non-2xx response from the API
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	msg := fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Status == http.StatusUnauthorized {
+		msg += " (check --token or $" + ctlTokenEnv + ")"
+	}
+	return msg
+}
+
+// runCtl runs a "parenta ctl" command and returns the exit code
+func runCtl(args []string) int {
+	return ctl(args, os.Stdout, os.Stderr)
+}
+
+// ctl runs a ctl command writing to stdout and stderr
+func ctl(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, ctlUsage) }
+	server := fs.String("server", "", "Parenta's address")
+	token := fs.String("token", "", "API key or access token")
+	asJSON := fs.Bool("json", false, "Print JSON")
+	timeout := fs.Duration("timeout", 15*time.Second, "Request timeout")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	// --json may also come after the command
+	var rest []string
+	for _, arg := range fs.Args() {
+		if arg == "--json" || arg == "-json" {
+			*asJSON = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if len(rest) == 0 {
+		fmt.Fprint(stderr, ctlUsage)
+		return 2
+	}
+	if rest[0] == "help" {
+		fmt.Fprint(stdout, ctlUsage)
+		return 0
+	}
+
+	settings, err := loadCtlSettings()
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	base := firstNonEmpty(*server, os.Getenv(ctlServerEnv), settings.Server, ctlDefaultServer)
+	base, err = apiBase(base)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	c := &ctlClient{
+		base:  base,
+		token: firstNonEmpty(*token, os.Getenv(ctlTokenEnv), settings.Token),
+		http:  &http.Client{Timeout: *timeout},
+		out:   stdout,
+		json:  *asJSON,
+	}
+
+	var usage bool
+	switch cmd := strings.Join(rest[:min(2, len(rest))], " "); {
+	case cmd == "children list" || cmd == "children":
+		if usage = len(rest) > 2; !usage {
+			err = c.childrenList()
+		}
+	case cmd == "children pause" || cmd == "children resume":
+		if usage = len(rest) != 3; !usage {
+			err = c.childrenSetActive(rest[2], rest[1] == "resume")
+		}
+	case rest[0] == "sessions":
+		if usage = len(rest) != 1; !usage {
+			err = c.sessions()
+		}
+	case cmd == "quota add":
+		if usage = len(rest) != 4; !usage {
+			err = c.quotaAdd(rest[2], rest[3])
+		}
+	case cmd == "filters add-block" || cmd == "filters add-allow":
+		if usage = len(rest) != 3; !usage {
+			ruleType := "blacklist"
+			if rest[1] == "add-allow" {
+				ruleType = "whitelist"
+			}
+			err = c.filtersAdd(rest[2], ruleType)
+		}
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", strings.Join(rest, " "), ctlUsage)
+		return 2
+	}
+
+	if usage {
+		fmt.Fprintf(stderr, "wrong arguments for %q\n\n%s", strings.Join(rest, " "), ctlUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// apiBase turns a server address into the API's base URL, accepting one
+// that already ends in /api or /api/v1
+func apiBase(server string) (string, error) {
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("server %q is not an http(s) URL", server)
+	}
+
+	path := strings.TrimRight(u.Path, "/")
+	path = strings.TrimSuffix(path, "/v1")
+	path = strings.TrimSuffix(path, "/api")
+	u.Path = path + "/api/v1"
+	u.RawQuery, u.Fragment = "", ""
+	return u.String(), nil
+}
+
+// firstNonEmpty returns the first of values that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// do sends a request with body as JSON, decodes the response into result
+// if it isn't nil, and returns the raw response body
+func (c *ctlClient) do(method, path string, body, result any) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach Parenta: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, parseAPIError(resp.StatusCode, data)
+	}
+	if result != nil {
+		if err := json.Unmarshal(data, result); err != nil {
+			return nil, fmt.Errorf("unexpected response from %s: %w", path, err)
+		}
+	}
+	return data, nil
+}
+
+// parseAPIError reads the code and message out of an error response,
+// {"error": {"code", "message"}}
+func parseAPIError(status int, data []byte) error {
+	e := &apiError{Status: status}
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil {
+		e.Code, e.Message = body.Error.Code, body.Error.Message
+	}
+	return e
+}
+
+// printJSON writes an API response indented
+func (c *ctlClient) printJSON(data []byte) error {
+	var b bytes.Buffer
+	if err := json.Indent(&b, data, "", "  "); err != nil {
+		return err
+	}
+	b.WriteByte('\n')
+	_, err := c.out.Write(b.Bytes())
+	return err
+}
+
+// ctlChild is the part of a child in API responses that ctl shows
+type ctlChild struct {
+	ID                string `json:"id"`
+	Username          string `json:"username"`
+	Name              string `json:"name"`
+	DailyQuotaMin     int    `json:"daily_quota_min"`
+	UsedTodayMin      int    `json:"used_today_min"`
+	RemainingMin      int    `json:"remaining_min"`
+	BankMinutes       int    `json:"bank_minutes"`
+	IsActive          bool   `json:"is_active"`
+	ScheduleID        string `json:"schedule_id"`
+	UseBankAfterQuota bool   `json:"use_bank_after_quota"`
+}
+
+// ctlSession is the part of a session in API responses that ctl shows
+type ctlSession struct {
+	ChildName    string    `json:"child_name"`
+	MAC          string    `json:"mac"`
+	IP           string    `json:"ip"`
+	StartedAt    time.Time `json:"started_at"`
+	DurationMin  int       `json:"duration_min"`
+	RemainingMin int       `json:"remaining_min"`
+}
+
+// childrenList prints every child
+func (c *ctlClient) childrenList() error {
+	var children []ctlChild
+	data, err := c.do(http.MethodGet, "/children", nil, &children)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(data)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tUSERNAME\tUSED\tQUOTA\tLEFT\tBANK\tSTATUS")
+	for _, child := range children {
+		status := "active"
+		if !child.IsActive {
+			status = "paused"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", child.Name, child.Username,
+			ctlMinutes(child.UsedTodayMin), ctlMinutes(child.DailyQuotaMin), ctlMinutes(child.RemainingMin),
+			ctlMinutes(child.BankMinutes), status)
+	}
+	return tw.Flush()
+}
+
+// findChild looks a child up by ID, username or name, in that order
+func (c *ctlClient) findChild(ref string) (*ctlChild, error) {
+	var children []ctlChild
+	if _, err := c.do(http.MethodGet, "/children", nil, &children); err != nil {
+		return nil, err
+	}
+
+	for i := range children {
+		if children[i].ID == ref {
+			return &children[i], nil
+		}
+	}
+	for i := range children {
+		if strings.EqualFold(children[i].Username, ref) {
+			return &children[i], nil
+		}
+	}
+	var named []*ctlChild
+	for i := range children {
+		if strings.EqualFold(children[i].Name, ref) {
+			named = append(named, &children[i])
+		}
+	}
+	switch len(named) {
+	case 0:
+		return nil, fmt.Errorf("no child named %q", ref)
+	case 1:
+		return named[0], nil
+	default:
+		return nil, fmt.Errorf("%d children are named %q; use the username", len(named), ref)
+	}
+}
+
+// childrenSetActive pauses or resumes a child. PUT /children/{id} replaces
+// the schedule and time bank setting too, so they're sent back unchanged.
+func (c *ctlClient) childrenSetActive(ref string, active bool) error {
+	child, err := c.findChild(ref)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]any{
+		"is_active":            active,
+		"schedule_id":          child.ScheduleID,
+		"use_bank_after_quota": child.UseBankAfterQuota,
+	}
+	var updated ctlChild
+	data, err := c.do(http.MethodPut, "/children/"+url.PathEscape(child.ID), body, &updated)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(data)
+	}
+
+	if active {
+		fmt.Fprintf(c.out, "Resumed %s, %s left today\n", updated.Name, ctlMinutes(updated.RemainingMin))
+	} else {
+		fmt.Fprintf(c.out, "Paused %s and ended their sessions\n", updated.Name)
+	}
+	return nil
+}
+
+// sessions prints the active sessions
+func (c *ctlClient) sessions() error {
+	var sessions []ctlSession
+	data, err := c.do(http.MethodGet, "/sessions", nil, &sessions)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(data)
+	}
+
+	if len(sessions) == 0 {
+		fmt.Fprintln(c.out, "No active sessions")
+		return nil
+	}
+	now := time.Now()
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHILD\tMAC\tIP\tSTARTED\tONLINE\tLEFT")
+	for _, s := range sessions {
+		started := s.StartedAt.Local()
+		layout := "Jan 2 15:04"
+		if y, m, d := started.Date(); y == now.Year() && m == now.Month() && d == now.Day() {
+			layout = "15:04"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.ChildName, s.MAC, s.IP,
+			started.Format(layout), ctlMinutes(s.DurationMin), ctlMinutes(s.RemainingMin))
+	}
+	return tw.Flush()
+}
+
+// quotaAdd gives a child more time today, or takes some away
+func (c *ctlClient) quotaAdd(ref, minutes string) error {
+	n, err := strconv.Atoi(minutes)
+	if err != nil || n == 0 {
+		return fmt.Errorf("minutes must be a whole number other than 0, not %q", minutes)
+	}
+	child, err := c.findChild(ref)
+	if err != nil {
+		return err
+	}
+
+	var updated ctlChild
+	data, err := c.do(http.MethodPost, "/children/"+url.PathEscape(child.ID)+"/adjust-quota",
+		map[string]int{"minutes": n}, &updated)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(data)
+	}
+
+	fmt.Fprintf(c.out, "%s now has %s left today\n", updated.Name, ctlMinutes(updated.RemainingMin))
+	return nil
+}
+
+// filtersAdd adds a blacklist or whitelist rule for a domain and reloads
+// dnsmasq so it applies right away
+func (c *ctlClient) filtersAdd(domain, ruleType string) error {
+	var rule struct {
+		ID     string `json:"id"`
+		Domain string `json:"domain"`
+	}
+	data, err := c.do(http.MethodPost, "/filters", map[string]string{"domain": domain, "rule_type": ruleType}, &rule)
+	if err != nil {
+		return err
+	}
+	if _, err := c.do(http.MethodPost, "/filters/reload", nil, nil); err != nil {
+		return fmt.Errorf("added rule %s for %s, but reloading dnsmasq failed: %w", rule.ID, rule.Domain, err)
+	}
+	if c.json {
+		return c.printJSON(data)
+	}
+
+	verb := "Blocking"
+	if ruleType == "whitelist" {
+		verb = "Allowing"
+	}
+	fmt.Fprintf(c.out, "%s %s (rule %s)\n", verb, rule.Domain, rule.ID)
+	return nil
+}
+
+// ctlMinutes formats minutes as "1h05" or "45m"
+func ctlMinutes(minutes int) string {
+	sign := ""
+	if minutes < 0 {
+		sign, minutes = "-", -minutes
+	}
+	if minutes < 60 {
+		return fmt.Sprintf("%s%dm", sign, minutes)
+	}
+	return fmt.Sprintf("%s%dh%02d", sign, minutes/60, minutes%60)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeAPI serves the endpoints ctl calls and records the writes
+type fakeAPI struct {
+	token       string
+	children    []map[string]any
+	failFilters bool
+	requests    []string // "METHOD path body"
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"code":"INVALID_API_KEY","message":"invalid api key"}}`))
+		return
+	}
+
+	var body bytes.Buffer
+	body.ReadFrom(r.Body)
+	if r.Method != http.MethodGet {
+		f.requests = append(f.requests, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+body.String()))
+	}
+
+	reply := func(v any) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/children":
+		reply(f.children)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/sessions":
+		reply([]map[string]any{{"child_name": "Alice", "mac": "a8:bb:cc:00:00:01", "ip": "192.168.1.50",
+			"started_at": "2024-03-18T16:00:00Z", "duration_min": 75, "remaining_min": 15}})
+	case r.Method == http.MethodPut && r.URL.Path == "/api/v1/children/c1":
+		reply(map[string]any{"id": "c1", "name": "Alice", "is_active": false})
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/children/c1/adjust-quota":
+		reply(map[string]any{"id": "c1", "name": "Alice", "remaining_min": 95})
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/filters" && f.failFilters:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"code":"VALIDATION_FAILED","message":"domain is required"}}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/filters":
+		reply(map[string]any{"id": "f1", "domain": "example.com", "rule_type": "blacklist"})
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/filters/reload":
+		reply(map[string]bool{"success": true})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"code":"NOT_FOUND","message":"not found"}}`))
+	}
+}
+
+// runFakeCtl runs ctl against a fake API, with no settings file or
+// environment, and returns the exit code and output
+func runFakeCtl(t *testing.T, api *fakeAPI, args ...string) (int, string, string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(ctlServerEnv, "")
+	t.Setenv(ctlTokenEnv, "")
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"--server", srv.URL, "--token", api.token}, args...)
+	code := ctl(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{
+		token: "pk_test",
+		children: []map[string]any{
+			{"id": "c1", "username": "alice", "name": "Alice", "daily_quota_min": 120, "used_today_min": 45,
+				"remaining_min": 75, "bank_minutes": 30, "is_active": true, "schedule_id": "s1", "use_bank_after_quota": true},
+			{"id": "c2", "username": "bobby", "name": "Bob", "daily_quota_min": 90, "is_active": false},
+			{"id": "c3", "username": "bob2", "name": "Bob", "daily_quota_min": 90, "is_active": true},
+		},
+	}
+}
+
+func TestCtlCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		want     []string // Substrings of stdout
+		requests []string
+	}{
+		{
+			name: "children list",
+			args: []string{"children", "list"},
+			want: []string{"NAME", "Alice  alice     45m   2h00   1h15  30m   active", "Bob    bobby     0m    1h30   0m    0m    paused"},
+		},
+		{
+			name:     "pause keeps the schedule and bank setting",
+			args:     []string{"children", "pause", "alice"},
+			want:     []string{"Paused Alice"},
+			requests: []string{`PUT /api/v1/children/c1 {"is_active":false,"schedule_id":"s1","use_bank_after_quota":true}`},
+		},
+		{
+			name: "sessions",
+			args: []string{"sessions"},
+			want: []string{"CHILD", "Alice  a8:bb:cc:00:00:01  192.168.1.50", "1h15    15m"},
+		},
+		{
+			name:     "quota add by name",
+			args:     []string{"quota", "add", "ALICE", "20"},
+			want:     []string{"Alice now has 1h35 left today"},
+			requests: []string{`POST /api/v1/children/c1/adjust-quota {"minutes":20}`},
+		},
+		{
+			name: "filters add-block",
+			args: []string{"filters", "add-block", "example.com"},
+			want: []string{"Blocking example.com (rule f1)"},
+			requests: []string{
+				`POST /api/v1/filters {"domain":"example.com","rule_type":"blacklist"}`,
+				`POST /api/v1/filters/reload`,
+			},
+		},
+		{
+			name: "json after the command",
+			args: []string{"quota", "add", "c1", "-10", "--json"},
+			want: []string{"{\n  \"id\": \"c1\",", "\"remaining_min\": 95"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI()
+			code, stdout, stderr := runFakeCtl(t, api, tt.args...)
+			if code != 0 {
+				t.Fatalf("exit code %d, stderr %q", code, stderr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("output missing %q:\n%s", want, stdout)
+				}
+			}
+			if tt.requests != nil && strings.Join(api.requests, "\n") != strings.Join(tt.requests, "\n") {
+				t.Errorf("requests\n got %q\nwant %q", api.requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestCtlErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{"unknown command", []string{"children", "dance"}, 2, `unknown command "children dance"`},
+		{"missing argument", []string{"quota", "add", "alice"}, 2, `wrong arguments for "quota add alice"`},
+		{"bad minutes", []string{"quota", "add", "alice", "lots"}, 1, "minutes must be a whole number"},
+		{"unknown child", []string{"children", "pause", "carol"}, 1, `no child named "carol"`},
+		{"ambiguous name", []string{"children", "pause", "Bob"}, 1, `2 children are named "Bob"; use the username`},
+		{"bad token", []string{"--token", "wrong", "sessions"}, 1, "401 Unauthorized: invalid api key (check --token or $PARENTA_TOKEN)"},
+		{"api error message", []string{"filters", "add-allow", "-"}, 1, "400 Bad Request: domain is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI()
+			api.failFilters = true
+			code, _, stderr := runFakeCtl(t, api, tt.args...)
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d", code, tt.code)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("stderr missing %q:\n%s", tt.stderr, stderr)
+			}
+		})
+	}
+}
+
+func TestCtlSettings(t *testing.T) {
+	api := newFakeAPI()
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	// The settings file supplies both, the environment overrides it and
+	// flags override both
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	settings := `{"server": "` + srv.URL + `/api/", "token": "pk_test"}`
+	if err := os.WriteFile(filepath.Join(home, ctlSettingsFile), []byte(settings), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(ctlServerEnv, "")
+	t.Setenv(ctlTokenEnv, "")
+
+	var stdout, stderr bytes.Buffer
+	if code := ctl([]string{"sessions"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("settings file: exit code %d, stderr %q", code, stderr.String())
+	}
+
+	t.Setenv(ctlTokenEnv, "wrong")
+	stderr.Reset()
+	if code := ctl([]string{"sessions"}, &stdout, &stderr); code != 1 {
+		t.Errorf("env token: exit code %d, want 1", code)
+	}
+	stderr.Reset()
+	if code := ctl([]string{"--token", "pk_test", "sessions"}, &stdout, &stderr); code != 0 {
+		t.Errorf("flag token: exit code %d, stderr %q", code, stderr.String())
+	}
+}
+
+func TestAPIBase(t *testing.T) {
+	tests := map[string]string{
+		"http://router:8080":           "http://router:8080/api/v1",
+		"router:8080/":                 "http://router:8080/api/v1",
+		"https://router/api":           "https://router/api/v1",
+		"http://router:8080/api/v1/":   "http://router:8080/api/v1",
+		"http://router/parenta/api/v1": "http://router/parenta/api/v1",
+	}
+	for in, want := range tests {
+		if got, err := apiBase(in); err != nil || got != want {
+			t.Errorf("apiBase(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := apiBase("ftp://router"); err == nil {
+		t.Error("apiBase accepted an ftp URL")
+	}
+}
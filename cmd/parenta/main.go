@@ -1,36 +1,60 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"syscall"
+	"time"
 
 	"parenta/internal/api"
 	"parenta/internal/config"
 	"parenta/internal/services"
 	"parenta/internal/storage"
+	"parenta/internal/version"
 )
 
-var Version = "1.0.0"
+// Data files larger than this are logged at startup
+const startupFileSizeWarning = 5 << 20
+
+// Number of Parenta's own log lines kept in memory
+const logBufferLines = 1000
 
 func main() {
+	// Admin maintenance subcommands run against the data directory directly;
+	// ctl commands call the API of a running Parenta
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(runAdmin(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(runCtl(os.Args[2:]))
+	}
+
 	// Parse command line flags
 	configPath := flag.String("config", "configs/parenta.json", "Path to config file")
 	webDir := flag.String("web", "web", "Path to web static files directory")
 	showVersion := flag.Bool("version", false, "Show version and exit")
+	checkConfig := flag.Bool("check-config", false, "Validate config and exit")
 	flag.Parse()
 
 	if *showVersion {
-		fmt.Printf("Parenta v%s\n", Version)
+		fmt.Printf("Parenta v%s\n", version.Version)
 		os.Exit(0)
 	}
 
-	log.Printf("Starting Parenta v%s", Version)
+	// Keep recent log lines for the dashboard's log viewer
+	logBuffer := services.NewLogBuffer(logBufferLines)
+	log.SetOutput(io.MultiWriter(os.Stderr, logBuffer))
+
+	log.Printf("Starting Parenta v%s", version.Version)
 
 	// Load configuration
 	cfg, err := config.Load(*configPath)
@@ -39,25 +63,52 @@ func main() {
 	}
 	log.Printf("Loaded config from %s", *configPath)
 
-	// Ensure data directory exists
-	dataDir := cfg.Storage.DataDir
-	if !filepath.IsAbs(dataDir) {
-		// Make relative to executable directory
-		execDir, _ := os.Getwd()
-		dataDir = filepath.Join(execDir, dataDir)
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid config %s:\n%v", *configPath, err)
+	}
+	if *checkConfig {
+		fmt.Printf("Config %s OK\n", *configPath)
+		os.Exit(0)
+	}
+
+	// Hold the data directory for as long as we run, so "parenta admin"
+	// can't write to it underneath us
+	dataDir := resolveDataDir(cfg)
+	unlock, err := storage.Lock(dataDir, cfg.Storage.DirPerm())
+	if errors.Is(err, storage.ErrLocked) {
+		log.Fatalf("Data directory %s is in use by another Parenta process", dataDir)
 	}
+	if err != nil {
+		log.Fatalf("Failed to lock data directory: %v", err)
+	}
+	defer unlock()
 
 	// Initialize storage
-	store, err := storage.New(dataDir)
+	store, err := storage.New(dataDir, cfg.Storage.DirPerm(), cfg.Storage.FilePerm())
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 	log.Printf("Storage initialized at %s", dataDir)
+	for _, warning := range store.CheckPermissions() {
+		log.Printf("Warning: %s; tighten it with chmod", warning)
+	}
+	for name, size := range store.FileSizes() {
+		if size > startupFileSizeWarning {
+			log.Printf("Warning: %s is %.1f MB; consider pruning old history", name, float64(size)/(1<<20))
+		}
+	}
 
 	// Initialize services
-	ndsctl := services.NewNDSCtl(cfg.OpenNDS.NDSCtlPath)
-	dnsmasq := services.NewDnsmasqService(store, cfg.Dnsmasq.ConfDir, cfg.Dnsmasq.RestartCmd)
-	authSvc := services.NewAuthService(store, cfg.Session.JWTSecret, cfg.Session.JWTExpiryHours)
+	ndsPool := services.NewNDSCtlPool(cfg.OpenNDS.NDSCtlPath, cfg.OpenNDS.GatewayIPs, cfg.OpenNDS.NDSCtlPaths, cfg.OpenNDS.NDSCtlSockets)
+	dnsmasq := services.NewDnsmasqService(store, cfg.Dnsmasq.ConfDir, cfg.Dnsmasq.RestartCmd,
+		cfg.Dnsmasq.UpstreamDNS, cfg.Dnsmasq.StudyUpstreamDNS, cfg.System.RestartAttempts)
+	gardenScript := cfg.OpenNDS.WalledGardenScript
+	if gardenScript == "" {
+		gardenScript = filepath.Join(dataDir, "opennds-walledgarden.sh")
+	}
+	walledGarden := services.NewWalledGardenService(store, gardenScript, cfg.System.RestartAttempts)
+	authSvc := services.NewAuthService(store, cfg.Session.JWTSecret, cfg.Session.JWTExpiryHours,
+		cfg.Session.MaxLoginAttempts, time.Duration(cfg.Session.LockoutMinutes)*time.Minute)
 
 	// Initialize default admin user
 	if err := authSvc.InitializeAdmin(
@@ -68,22 +119,71 @@ func main() {
 		log.Printf("Warning: Failed to initialize admin: %v", err)
 	}
 
+	// Write admin session activity in batches rather than on every request
+	stopFlusher := make(chan struct{})
+	go authSvc.RunSessionFlusher(30*time.Second, stopFlusher)
+
+	// Notifications are queued from here on and sent once the router is set up
+	notifier := services.NewNotifier(store)
+
 	// Start session ticker
-	ticker := services.NewSessionTicker(store, ndsctl, cfg.Session.TickIntervalSeconds)
+	ticker := services.NewSessionTicker(store, ndsPool, dnsmasq, cfg.Session.TickIntervalSeconds, cfg.Storage.RetentionDays)
+	ticker.SetNotifier(notifier)
+
+	// Publish children's state to Home Assistant over MQTT
+	var mqttBridge *services.MQTTBridge
+	if cfg.MQTT.Enabled {
+		mqttBridge, err = services.NewMQTTBridge(store, ticker, services.MQTTOptions{
+			Broker:             cfg.MQTT.Broker,
+			Username:           cfg.MQTT.Username,
+			Password:           cfg.MQTT.Password,
+			ClientID:           cfg.MQTT.ClientID,
+			TopicPrefix:        cfg.MQTT.TopicPrefix,
+			DiscoveryPrefix:    cfg.MQTT.DiscoveryPrefix,
+			CAFile:             cfg.MQTT.CAFile,
+			InsecureSkipVerify: cfg.MQTT.InsecureSkipVerify,
+		})
+		if err != nil {
+			log.Fatalf("Invalid MQTT config: %v", err)
+		}
+		ticker.SetOnChange(mqttBridge.Refresh)
+		mqttBridge.Start()
+	}
+
 	ticker.Start()
 	log.Printf("Session ticker started (interval: %ds)", cfg.Session.TickIntervalSeconds)
 
+	// Start scheduled backups
+	backups, err := services.NewBackups(store, services.BackupTarget{
+		Destination: cfg.Backup.Destination,
+		Username:    cfg.Backup.Username,
+		Password:    cfg.Backup.Password,
+		SSHKeyFile:  cfg.Backup.SSHKeyFile,
+		HostKey:     cfg.Backup.HostKey,
+		Keep:        cfg.Backup.Keep,
+	}, cfg.Backup.Enabled, cfg.Backup.Time)
+	if err != nil {
+		log.Fatalf("Invalid backup config: %v", err)
+	}
+	backups.Start()
+
 	// Generate initial dnsmasq configs
 	if err := dnsmasq.RegenerateConfigs(); err != nil {
 		log.Printf("Warning: Failed to generate dnsmasq configs: %v", err)
 	}
 
+	addr := net.JoinHostPort(listenHost(cfg), strconv.Itoa(cfg.Server.Port))
+
 	// Setup HTTP router
-	router := api.NewRouter(cfg, store, ndsctl, dnsmasq, authSvc)
+	router := api.NewRouter(cfg, store, ndsPool, dnsmasq, authSvc, ticker, walledGarden)
+	router.SetListenAddr(addr)
+	router.SetLogBuffer(logBuffer)
+	router.SetBackups(backups)
+	router.SetNotifier(notifier)
 	handler := router.Setup(*webDir)
+	notifier.Start()
 
 	// Create HTTP server
-	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 	server := &http.Server{
 		Addr:    addr,
 		Handler: handler,
@@ -106,9 +206,68 @@ func main() {
 
 	// Stop ticker
 	ticker.Stop()
+	backups.Stop()
+	notifier.Stop()
+	if mqttBridge != nil {
+		mqttBridge.Stop()
+	}
+	close(stopFlusher)
+	authSvc.FlushAdminSessions()
 
 	// Close server
 	server.Close()
 
 	log.Println("Parenta stopped")
 }
+
+// listenHost returns the address to bind the HTTP server to: the first
+// address of the configured bind interface, preferring IPv4, or the
+// configured host if no interface is set or it has no usable address
+func listenHost(cfg *config.Config) string {
+	name := cfg.Server.BindInterface
+	if name == "" {
+		return cfg.Server.Host
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		log.Printf("Warning: bind interface %s not found, listening on %s instead", name, cfg.Server.Host)
+		return cfg.Server.Host
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		log.Printf("Warning: failed to read addresses of %s: %v; listening on %s instead", name, err, cfg.Server.Host)
+		return cfg.Server.Host
+	}
+
+	var fallback net.IP
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+		if fallback == nil {
+			fallback = ipNet.IP
+		}
+	}
+	if fallback != nil {
+		return fallback.String()
+	}
+
+	log.Printf("Warning: bind interface %s has no IP address, listening on %s instead", name, cfg.Server.Host)
+	return cfg.Server.Host
+}
+
+// resolveDataDir returns the configured data directory, relative paths being
+// taken from the working directory
+func resolveDataDir(cfg *config.Config) string {
+	dataDir := cfg.Storage.DataDir
+	if !filepath.IsAbs(dataDir) {
+		execDir, _ := os.Getwd()
+		dataDir = filepath.Join(execDir, dataDir)
+	}
+	return dataDir
+}
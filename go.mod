@@ -1,5 +1,16 @@
 module parenta
 
-go 1.21
+go 1.22
 
-require golang.org/x/crypto v0.18.0
+require (
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/crypto v0.18.0
+)
+
+require (
+	github.com/gorilla/websocket v1.5.0 // indirect
+	golang.org/x/net v0.20.0 // indirect
+	golang.org/x/sync v0.6.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+)
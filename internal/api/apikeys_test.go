@@ -0,0 +1,75 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// createAPIKey creates an API key through the API and returns it
+func createAPIKey(t *testing.T, handler http.Handler, token, body string) string {
+	t.Helper()
+	rec := call(handler, http.MethodPost, "/api/v1/auth/apikeys", token, body)
+	if rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+		t.Fatalf("create api key: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Key == "" {
+		t.Fatalf("create api key: no key in %s", rec.Body.String())
+	}
+	return resp.Key
+}
+
+// TestAPIKeyRoutes checks that API keys only reach the routes opened to
+// them, within their role and scopes, and that system settings, webhooks
+// and admin management stay closed whatever the scopes
+func TestAPIKeyRoutes(t *testing.T) {
+	env, handler, token := newAPI(t)
+	child := env.AddChild(t, "alice", "pass1234", 60)
+	adminKey := createAPIKey(t, handler, token, `{"name":"home assistant","role":"admin","scopes":["children","system"]}`)
+	readKey := createAPIKey(t, handler, token, `{"name":"dashboard","role":"read"}`)
+
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{name: "list children", key: adminKey, method: "GET", path: "/children", status: http.StatusOK},
+		{name: "reset quota", key: adminKey, method: "POST", path: "/children/" + child.ID + "/reset-quota", status: http.StatusOK},
+		{name: "system status", key: adminKey, method: "GET", path: "/system/status", status: http.StatusOK},
+		{name: "out of scope", key: adminKey, method: "GET", path: "/sessions", status: http.StatusForbidden},
+		{name: "read key can't write", key: readKey, method: "POST", path: "/children/" + child.ID + "/reset-quota", status: http.StatusForbidden},
+		{name: "read key reads", key: readKey, method: "GET", path: "/sessions", status: http.StatusOK},
+
+		{name: "ticker config", key: adminKey, method: "POST", path: "/system/ticker-config", body: `{}`, status: http.StatusForbidden},
+		{name: "dnsmasq resync", key: adminKey, method: "POST", path: "/system/dnsmasq/resync", status: http.StatusForbidden},
+		{name: "test ndsctl", key: adminKey, method: "POST", path: "/system/test-ndsctl", status: http.StatusForbidden},
+		{name: "holiday mode", key: adminKey, method: "POST", path: "/system/holiday-mode", body: `{"enabled":true}`, status: http.StatusForbidden},
+		{name: "device policy", key: adminKey, method: "PUT", path: "/system/device-policy", body: `{}`, status: http.StatusForbidden},
+		{name: "backup", key: adminKey, method: "GET", path: "/system/backup", status: http.StatusForbidden},
+		{name: "list webhooks", key: adminKey, method: "GET", path: "/webhooks", status: http.StatusForbidden},
+		{name: "add webhook", key: adminKey, method: "POST", path: "/webhooks", body: `{"url":"https://example.com/hook"}`, status: http.StatusForbidden},
+		{name: "notification settings", key: adminKey, method: "GET", path: "/notifications/settings", status: http.StatusForbidden},
+		{name: "api keys", key: adminKey, method: "GET", path: "/auth/apikeys", status: http.StatusForbidden},
+		{name: "admins", key: adminKey, method: "GET", path: "/admins", status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, prefix := range []string{"/api", "/api/v1"} {
+				rec := call(handler, tt.method, prefix+tt.path, tt.key, tt.body)
+				if rec.Code != tt.status {
+					t.Fatalf("%s %s gave %d, want %d: %s", tt.method, prefix+tt.path, rec.Code, tt.status, rec.Body.String())
+				}
+				if tt.status == http.StatusForbidden && errorCode(rec) != "FORBIDDEN" {
+					t.Errorf("error code %q", errorCode(rec))
+				}
+			}
+		})
+	}
+}
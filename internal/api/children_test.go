@@ -0,0 +1,112 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"parenta/internal/models"
+)
+
+// TestChildChangesEndSessions checks that deactivating or deleting a child
+// deauths their devices at once, recording why, and leaves other children
+// online
+func TestChildChangesEndSessions(t *testing.T) {
+	const (
+		aliceMAC = "a8:bb:cc:00:00:01"
+		bobMAC   = "a8:bb:cc:00:00:02"
+	)
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		reason string // Empty if the session must stay up
+	}{
+		{"update keeping the child active", "PUT", `{"name":"Alice","is_active":true}`, http.StatusOK, ""},
+		{"deactivate", "PUT", `{"is_active":false}`, http.StatusOK, "deactivated"},
+		{"delete", "DELETE", "", http.StatusOK, "child_deleted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, handler, token := newAPI(t)
+			alice := env.AddChild(t, "alice", "pass1234", 60)
+			env.AddChild(t, "bobby", "pass5678", 60)
+			portalLogin(t, handler, "alice", "pass1234", aliceMAC, "192.168.1.50")
+			portalLogin(t, handler, "bobby", "pass5678", bobMAC, "192.168.1.51")
+			session := env.Storage.GetSessionByMAC(aliceMAC)
+
+			rec := call(handler, tt.method, "/api/v1/children/"+alice.ID, token, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+
+			ended := env.Storage.GetSession(session.ID)
+			if tt.reason == "" {
+				if !ended.IsActive || !env.NDS.Authenticated(aliceMAC) || env.NDS.Called("deauth", aliceMAC) != 0 {
+					t.Error("alice was taken offline")
+				}
+			} else {
+				if ended.IsActive || ended.EndReason != tt.reason {
+					t.Errorf("session active %v, end reason %q, want ended with %q", ended.IsActive, ended.EndReason, tt.reason)
+				}
+				if env.NDS.Called("deauth", aliceMAC) != 1 || env.NDS.Authenticated(aliceMAC) {
+					t.Error("alice's device wasn't deauthed")
+				}
+			}
+
+			if !env.NDS.Authenticated(bobMAC) || !env.Storage.GetSessionByMAC(bobMAC).IsActive {
+				t.Error("bobby was taken offline")
+			}
+		})
+	}
+}
+
+// TestResetQuota checks resetting one child's used time through the API,
+// and that the daily reset leaves children already reset that day alone
+func TestResetQuota(t *testing.T) {
+	env, handler, token := newAPI(t)
+	alice := env.AddChild(t, "alice", "pass1234", 60)
+	bobby := env.AddChild(t, "bobby", "pass5678", 60)
+	for _, c := range []*models.Child{alice, bobby} {
+		c.UsedTodayMin = 25
+		c.LastResetDate = "2026-01-01"
+		if err := env.Storage.SaveChild(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := call(handler, http.MethodPost, "/api/v1/children/"+alice.ID+"/reset-quota", token, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		UsedTodayMin int `json:"used_today_min"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.UsedTodayMin != 0 {
+		t.Errorf("response %s", rec.Body.String())
+	}
+	if rec := call(handler, http.MethodPost, "/api/v1/children/nope/reset-quota", token, ""); rec.Code != http.StatusNotFound || errorCode(rec) != "CHILD_NOT_FOUND" {
+		t.Errorf("missing child: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Alice was reset today and has used time since; only bobby is reset
+	today := time.Now().Format("2006-01-02")
+	alice = env.Storage.GetChild(alice.ID)
+	alice.UsedTodayMin = 10
+	if err := env.Storage.SaveChild(alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Storage.ResetChildQuotas(today); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.Storage.GetChild(alice.ID); got.UsedTodayMin != 10 {
+		t.Errorf("alice used %d minutes, want 10", got.UsedTodayMin)
+	}
+	if got := env.Storage.GetChild(bobby.ID); got.UsedTodayMin != 0 || got.LastResetDate != today {
+		t.Errorf("bobby used %d minutes, last reset %s", got.UsedTodayMin, got.LastResetDate)
+	}
+}
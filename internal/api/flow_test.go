@@ -0,0 +1,78 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"parenta/internal/api"
+	"parenta/internal/testutil"
+)
+
+// TestLoginTickDeauth follows a child from a portal login through to the
+// ticker taking them offline when their quota runs out
+func TestLoginTickDeauth(t *testing.T) {
+	env := testutil.NewEnv(t)
+	child := env.AddChild(t, "alice", "pass1234", 30)
+	handler := api.NewRouter(env.Config, env.Storage, env.NDSPool, env.Dnsmasq, env.Auth, env.Ticker, env.Garden).Setup(t.TempDir())
+
+	const mac = "a8:bb:cc:00:00:01"
+	form := url.Values{
+		"username":  {"alice"},
+		"password":  {"pass1234"},
+		"mac":       {mac},
+		"ip":        {"192.168.1.50"},
+		"gatewayip": {"192.168.1.1"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/fas/auth", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound && rec.Code != http.StatusSeeOther {
+		t.Fatalf("login: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	if got := env.NDS.Called("auth", mac); got != 1 {
+		t.Fatalf("login: ndsctl auth %s called %d times, want 1", mac, got)
+	}
+	if !env.NDS.Authenticated(mac) {
+		t.Fatal("login: client not authenticated in openNDS")
+	}
+
+	session := env.Storage.GetSessionByMAC(mac)
+	if session == nil || !session.IsActive {
+		t.Fatal("login: no active session recorded")
+	}
+
+	// A tick within quota keeps the session
+	env.Ticker.Tick()
+	if !env.Storage.GetSession(session.ID).IsActive {
+		t.Fatal("tick: session ended within quota")
+	}
+
+	// Backdate the session past the child's quota
+	session.StartedAt = time.Now().Add(-31 * time.Minute)
+	session.LastTickAt = time.Time{}
+	env.Storage.SaveSession(session)
+	env.Ticker.Tick()
+
+	ended := env.Storage.GetSession(session.ID)
+	if ended.IsActive {
+		t.Fatal("tick: session still active after quota ran out")
+	}
+	if ended.EndReason != "quota_exceeded" {
+		t.Errorf("end reason = %q, want quota_exceeded", ended.EndReason)
+	}
+	if env.NDS.Called("deauth", mac) == 0 {
+		t.Error("tick: ndsctl deauth not called")
+	}
+	if env.NDS.Authenticated(mac) {
+		t.Error("tick: client still authenticated in openNDS")
+	}
+	if got := env.Storage.GetChild(child.ID).RemainingMinutes(); got > 0 {
+		t.Errorf("remaining minutes = %d, want 0", got)
+	}
+}
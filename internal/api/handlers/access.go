@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+
+	"parenta/internal/api/middleware"
+	"parenta/internal/models"
+	"parenta/internal/storage"
+)
+
+// RequireWriter wraps a handler that changes state so viewers get a 403,
+// and tokens for a deleted admin a 401. The role is looked up on every
+// request rather than read from the token, so demoting an admin takes
+// effect at once.
+func RequireWriter(store *storage.Storage, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := requestAdmin(store, r)
+		if admin == nil {
+			ErrorCode(w, http.StatusUnauthorized, CodeUnauthorized, msgUnauthorized)
+			return
+		}
+		if !admin.CanWrite() {
+			ErrorCode(w, http.StatusForbidden, CodeReadOnly, msgReadOnly)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requestAdmin returns the request's admin, or nil if there are no claims
+// or the admin no longer exists
+func requestAdmin(store *storage.Storage, r *http.Request) *models.User {
+	claims := middleware.GetClaims(r)
+	if claims == nil {
+		return nil
+	}
+	return store.GetAdminByID(claims.UserID)
+}
+
+// canWrite reports whether the request's admin exists and may change
+// state. API keys act as their owner, so a viewer's keys are read-only too.
+func canWrite(store *storage.Storage, r *http.Request) bool {
+	admin := requestAdmin(store, r)
+	return admin != nil && admin.CanWrite()
+}
+
+// requireSuper reports whether the request comes from a super admin,
+// sending a 403 with msg if not
+func requireSuper(store *storage.Storage, w http.ResponseWriter, r *http.Request, msg string) bool {
+	claims := middleware.GetClaims(r)
+	if claims == nil {
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
+		return false
+	}
+	if admin := store.GetAdminByID(claims.UserID); admin == nil || !admin.IsSuper() {
+		Error(w, http.StatusForbidden, msg)
+		return false
+	}
+	return true
+}
+
+// parseAdminRole maps a requested role to a UserRole, defaulting to admin
+func parseAdminRole(role string) (models.UserRole, bool) {
+	switch models.UserRole(role) {
+	case "", models.RoleAdmin:
+		return models.RoleAdmin, true
+	case models.RoleSuper, models.RoleViewer:
+		return models.UserRole(role), true
+	}
+	return "", false
+}
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"parenta/internal/api/middleware"
 	"parenta/internal/config"
@@ -44,69 +49,254 @@ type LoginRequest struct {
 type LoginResponse struct {
 	Token               string `json:"token"`
 	ExpiresIn           int    `json:"expires_in"`
+	RefreshToken        string `json:"refresh_token,omitempty"`
+	CSRFToken           string `json:"csrf_token,omitempty"`
 	ForcePasswordChange bool   `json:"force_password_change"`
 }
 
-// HandleLogin processes login requests
-func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
+// RefreshRequest represents a token refresh or logout request body
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// loginAttempt describes the source of a login request for lockout tracking
+func loginAttempt(r *http.Request) services.LoginAttempt {
+	return services.LoginAttempt{
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// issueAdminTokens starts an admin session and creates its access token
+// and, unless legacy tokens are configured, its refresh token
+func issueAdminTokens(
+	jwt *middleware.AuthMiddleware,
+	authSvc *services.AuthService,
+	cfg *config.Config,
+	user *models.User,
+	from services.LoginAttempt,
+) (LoginResponse, error) {
+	if cfg.Session.LegacyTokens {
+		ttl := time.Duration(cfg.Session.JWTExpiryHours) * time.Hour
+		session, err := authSvc.CreateAdminSession(user.ID, from, ttl)
+		if err != nil {
+			return LoginResponse{}, err
+		}
+		token, err := jwt.GenerateToken(user.ID, user.Username, session.ID, true, cfg.Session.JWTExpiryHours)
+		if err != nil {
+			return LoginResponse{}, err
+		}
+		return LoginResponse{
+			Token:               token,
+			ExpiresIn:           cfg.Session.JWTExpiryHours * 3600,
+			ForcePasswordChange: user.ForcePasswordChange,
+		}, nil
+	}
+
+	session, err := authSvc.CreateAdminSession(user.ID, from, refreshTTL(cfg))
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	accessTTL := time.Duration(cfg.Session.AccessTokenMinutes) * time.Minute
+	token, err := jwt.GenerateTokenTTL(user.ID, user.Username, session.ID, true, accessTTL)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	refreshToken, _, err := authSvc.IssueRefreshToken(user.ID, session.ID, refreshTTL(cfg))
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	return LoginResponse{
+		Token:               token,
+		ExpiresIn:           int(accessTTL.Seconds()),
+		RefreshToken:        refreshToken,
+		ForcePasswordChange: user.ForcePasswordChange,
+	}, nil
+}
+
+// setSessionCookies stores the tokens in HttpOnly cookies when cookie
+// sessions are enabled and adds the CSRF token to resp. In cookie-only mode
+// the tokens are removed from the response body.
+func setSessionCookies(w http.ResponseWriter, r *http.Request, jwt *middleware.AuthMiddleware, cfg *config.Config, resp *LoginResponse) {
+	if !jwt.AllowsCookie() {
+		return
+	}
+
+	claims, err := jwt.ValidateToken(resp.Token)
+	if err != nil {
 		return
 	}
+	resp.CSRFToken = jwt.CSRFToken(claims)
+	secure := r.TLS != nil || cfg.Session.SecureCookies
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    resp.Token,
+		Path:     "/",
+		MaxAge:   resp.ExpiresIn,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	// Readable by the dashboard JS so it can send the X-CSRF-Token header
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.CSRFCookie,
+		Value:    resp.CSRFToken,
+		Path:     "/",
+		MaxAge:   resp.ExpiresIn,
+		Secure:   secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	if resp.RefreshToken != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     middleware.RefreshCookie,
+			Value:    resp.RefreshToken,
+			Path:     "/api/",
+			MaxAge:   int(refreshTTL(cfg).Seconds()),
+			HttpOnly: true,
+			Secure:   secure,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	if !jwt.AllowsHeader() {
+		resp.Token = ""
+		resp.RefreshToken = ""
+	}
+}
+
+// clearSessionCookies expires all session cookies
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, c := range []struct{ name, path string }{
+		{middleware.SessionCookie, "/"},
+		{middleware.CSRFCookie, "/"},
+		{middleware.RefreshCookie, "/api/"},
+	} {
+		http.SetCookie(w, &http.Cookie{Name: c.name, Value: "", Path: c.path, MaxAge: -1})
+	}
+}
 
+// refreshTTL returns the configured refresh token lifetime
+func refreshTTL(cfg *config.Config) time.Duration {
+	return time.Duration(cfg.Session.RefreshTokenDays) * 24 * time.Hour
+}
+
+// HandleLogin processes login requests
+func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	user, err := h.authSvc.AuthenticateAdmin(req.Username, req.Password, loginAttempt(r))
+	if err != nil {
+		var locked *services.LockedError
+		if errors.As(err, &locked) {
+			retry := int(time.Until(locked.Until).Seconds()) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			ErrorCode(w, http.StatusTooManyRequests, CodeAccountLocked, fmt.Sprintf("too many failed attempts, try again in %d seconds", retry))
+			return
+		}
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidCredentials, "invalid credentials")
+		return
+	}
+
+	resp, err := issueAdminTokens(h.jwt, h.authSvc, h.config, user, loginAttempt(r))
+	if err != nil {
+		Error(w, http.StatusInternalServerError, msgTokenFailed)
 		return
 	}
+	setSessionCookies(w, r, h.jwt, h.config, &resp)
 
-	user, err := h.authSvc.AuthenticateAdmin(req.Username, req.Password)
+	JSON(w, http.StatusOK, resp)
+}
+
+// HandleRefresh exchanges a refresh token for a new access token and a
+// rotated refresh token
+func (h *AuthHandler) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	if h.config.Session.LegacyTokens {
+		Error(w, http.StatusNotFound, "refresh tokens are disabled")
+		return
+	}
+
+	// Cookie sessions send the refresh token as a cookie with an empty body
+	var req RefreshRequest
+	ParseJSON(r, &req)
+	if req.RefreshToken == "" && h.jwt.AllowsCookie() {
+		if cookie, err := r.Cookie(middleware.RefreshCookie); err == nil {
+			req.RefreshToken = cookie.Value
+		}
+	}
+	if req.RefreshToken == "" {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, "refresh_token is required")
+		return
+	}
+
+	user, session, refreshToken, err := h.authSvc.RotateRefreshToken(req.RefreshToken, refreshTTL(h.config), loginAttempt(r))
 	if err != nil {
-		Error(w, http.StatusUnauthorized, "invalid credentials")
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidToken, "invalid refresh token")
 		return
 	}
 
-	token, err := h.jwt.GenerateToken(user.ID, user.Username, true, h.config.Session.JWTExpiryHours)
+	accessTTL := time.Duration(h.config.Session.AccessTokenMinutes) * time.Minute
+	token, err := h.jwt.GenerateTokenTTL(user.ID, user.Username, session.ID, true, accessTTL)
 	if err != nil {
-		Error(w, http.StatusInternalServerError, "failed to generate token")
+		Error(w, http.StatusInternalServerError, msgTokenFailed)
 		return
 	}
 
-	JSON(w, http.StatusOK, LoginResponse{
+	resp := LoginResponse{
 		Token:               token,
-		ExpiresIn:           h.config.Session.JWTExpiryHours * 3600,
+		ExpiresIn:           int(accessTTL.Seconds()),
+		RefreshToken:        refreshToken,
 		ForcePasswordChange: user.ForcePasswordChange,
-	})
+	}
+	setSessionCookies(w, r, h.jwt, h.config, &resp)
+
+	JSON(w, http.StatusOK, resp)
 }
 
-// HandleLogout processes logout requests
+// HandleLogout revokes the current access token and, if provided, the refresh token
 func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
+	claims := middleware.GetClaims(r)
+	h.jwt.Revoke(claims)
+	if claims != nil && claims.SessionID != "" {
+		if session, err := h.authSvc.RevokeAdminSession(claims.UserID, claims.SessionID); err == nil {
+			h.jwt.RevokeSession(session.ID, session.ExpiresAt)
+		}
+	}
+
+	// Body is optional; older clients send none
+	var req RefreshRequest
+	ParseJSON(r, &req)
+	if req.RefreshToken == "" {
+		if cookie, err := r.Cookie(middleware.RefreshCookie); err == nil {
+			req.RefreshToken = cookie.Value
+		}
+	}
+	if req.RefreshToken != "" {
+		h.authSvc.RevokeRefreshToken(req.RefreshToken)
 	}
+	clearSessionCookies(w)
 
-	// JWT is stateless, so we just return success
-	// Client should discard the token
 	JSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
 // HandleMe returns current user info
 func (h *AuthHandler) HandleMe(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
-
 	claims := middleware.GetClaims(r)
 	if claims == nil {
-		Error(w, http.StatusUnauthorized, "unauthorized")
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
 		return
 	}
 
 	admin := h.storage.GetAdminByID(claims.UserID)
 	if admin == nil {
-		Error(w, http.StatusNotFound, "user not found")
+		Error(w, http.StatusNotFound, msgUserNotFound)
 		return
 	}
 
@@ -119,39 +309,98 @@ func (h *AuthHandler) HandleMe(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// UpdateMeRequest represents a self-service profile update
+type UpdateMeRequest struct {
+	DisplayName string `json:"display_name"`
+}
+
+// maxDisplayNameLen is the longest display name accepted
+const maxDisplayNameLen = 50
+
+// HandleUpdateMe handles PUT /api/auth/me, letting any admin change their
+// own display name
+func (h *AuthHandler) HandleUpdateMe(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	if claims == nil {
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
+		return
+	}
+
+	var req UpdateMeRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	name := strings.TrimSpace(req.DisplayName)
+	if n := utf8.RuneCountInString(name); n < 1 || n > maxDisplayNameLen {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, "display_name must be 1-50 characters")
+		return
+	}
+
+	admin := h.storage.GetAdminByID(claims.UserID)
+	if admin == nil {
+		Error(w, http.StatusNotFound, msgUserNotFound)
+		return
+	}
+
+	admin.DisplayName = name
+	admin.UpdatedAt = time.Now()
+	if err := h.storage.SaveAdmin(admin); err != nil {
+		Error(w, http.StatusInternalServerError, msgUpdateAdminFailed)
+		return
+	}
+
+	JSON(w, http.StatusOK, newAdminResponse(admin))
+}
+
 // ChangePasswordRequest represents password change request
 type ChangePasswordRequest struct {
 	OldPassword string `json:"old_password"`
 	NewPassword string `json:"new_password"`
 }
 
-// HandleChangePassword processes password change requests
-func (h *AuthHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
+// PasswordErrorResponse lists the password policy rules a password failed
+type PasswordErrorResponse struct {
+	Error    ErrorDetail `json:"error"`
+	Failures []string    `json:"failures"`
+}
+
+// passwordError sends a 400 listing the failed policy rules
+func passwordError(w http.ResponseWriter, err error) {
+	var policyErr *services.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		ErrorCode(w, http.StatusBadRequest, CodeWeakPassword, err.Error())
 		return
 	}
+	JSON(w, http.StatusBadRequest, PasswordErrorResponse{
+		Error:    ErrorDetail{Code: CodeWeakPassword, Message: policyErr.Error()},
+		Failures: policyErr.Failures,
+	})
+}
 
+// HandleChangePassword processes password change requests
+func (h *AuthHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaims(r)
 	if claims == nil {
-		Error(w, http.StatusUnauthorized, "unauthorized")
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
 		return
 	}
 
 	var req ChangePasswordRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
-	if len(req.NewPassword) < 6 {
-		Error(w, http.StatusBadRequest, "password must be at least 6 characters")
+	if err := h.authSvc.ValidateAdminPassword(req.NewPassword, claims.Username); err != nil {
+		passwordError(w, err)
 		return
 	}
 
 	if err := h.authSvc.ChangeAdminPassword(claims.UserID, req.OldPassword, req.NewPassword); err != nil {
 		if err == services.ErrInvalidCredentials {
-			Error(w, http.StatusUnauthorized, "invalid old password")
+			ErrorCode(w, http.StatusUnauthorized, CodeInvalidCredentials, "invalid old password")
 			return
 		}
 		Error(w, http.StatusInternalServerError, "failed to change password")
@@ -165,11 +414,32 @@ func (h *AuthHandler) HandleChangePassword(w http.ResponseWriter, r *http.Reques
 
 // AdminResponse represents an admin in API responses (without password hash)
 type AdminResponse struct {
-	ID          string          `json:"id"`
-	Username    string          `json:"username"`
-	DisplayName string          `json:"display_name"`
-	Role        models.UserRole `json:"role"`
-	CreatedAt   string          `json:"created_at"`
+	ID           string          `json:"id"`
+	Username     string          `json:"username"`
+	DisplayName  string          `json:"display_name"`
+	Role         models.UserRole `json:"role"`
+	CreatedAt    string          `json:"created_at"`
+	Locked       bool            `json:"locked"`
+	LockedUntil  *time.Time      `json:"locked_until,omitempty"`
+	FailedLogins int             `json:"failed_logins"`
+}
+
+// newAdminResponse builds an AdminResponse including lockout state
+func newAdminResponse(a *models.User) AdminResponse {
+	resp := AdminResponse{
+		ID:           a.ID,
+		Username:     a.Username,
+		DisplayName:  a.GetDisplayName(),
+		Role:         a.Role,
+		CreatedAt:    a.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		Locked:       a.IsLocked(),
+		FailedLogins: a.FailedLogins,
+	}
+	if resp.Locked {
+		until := a.LockedUntil
+		resp.LockedUntil = &until
+	}
+	return resp
 }
 
 // CreateAdminRequest represents create admin request body
@@ -191,67 +461,52 @@ type ResetPasswordRequest struct {
 	NewPassword string `json:"new_password"`
 }
 
-// HandleListAdmins handles GET (list) and POST (create) for /api/admins
+// HandleListAdmins handles GET /api/admins
 func (h *AuthHandler) HandleListAdmins(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		h.listAdmins(w, r)
-	case http.MethodPost:
-		h.createAdmin(w, r)
-	default:
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-	}
-}
-
-func (h *AuthHandler) listAdmins(w http.ResponseWriter, r *http.Request) {
 	admins := h.storage.ListAdmins()
 	response := make([]AdminResponse, len(admins))
 	for i, a := range admins {
-		response[i] = AdminResponse{
-			ID:          a.ID,
-			Username:    a.Username,
-			DisplayName: a.GetDisplayName(),
-			Role:        a.Role,
-			CreatedAt:   a.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		}
+		response[i] = newAdminResponse(a)
 	}
 
-	JSON(w, http.StatusOK, response)
+	JSONWithETag(w, r, http.StatusOK, response)
 }
 
-func (h *AuthHandler) createAdmin(w http.ResponseWriter, r *http.Request) {
+// HandleCreateAdmin handles POST /api/admins
+func (h *AuthHandler) HandleCreateAdmin(w http.ResponseWriter, r *http.Request) {
 	// Check if current user is super admin
 	claims := middleware.GetClaims(r)
 	if claims == nil {
-		Error(w, http.StatusUnauthorized, "unauthorized")
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
 		return
 	}
 
 	currentAdmin := h.storage.GetAdminByID(claims.UserID)
 	if currentAdmin == nil || !currentAdmin.IsSuper() {
-		Error(w, http.StatusForbidden, "only super admins can create new admins")
+		ErrorCode(w, http.StatusForbidden, CodeSuperAdminRequired, "only super admins can create new admins")
 		return
 	}
 
 	var req CreateAdminRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
 	if req.Username == "" || req.Password == "" {
-		Error(w, http.StatusBadRequest, "username and password are required")
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, "username and password are required")
 		return
 	}
 
-	if len(req.Password) < 6 {
-		Error(w, http.StatusBadRequest, "password must be at least 6 characters")
+	if err := h.authSvc.ValidateAdminPassword(req.Password, req.Username); err != nil {
+		passwordError(w, err)
 		return
 	}
 
-	role := models.RoleAdmin
-	if req.Role == "super" {
-		role = models.RoleSuper
+	role, ok := parseAdminRole(req.Role)
+	if !ok {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgInvalidRole)
+		return
 	}
 
 	displayName := req.DisplayName
@@ -262,125 +517,91 @@ func (h *AuthHandler) createAdmin(w http.ResponseWriter, r *http.Request) {
 	admin, err := h.authSvc.CreateAdmin(req.Username, req.Password, displayName, role)
 	if err != nil {
 		if strings.Contains(err.Error(), "already exists") {
-			Error(w, http.StatusConflict, "username already exists")
+			ErrorCode(w, http.StatusConflict, CodeUsernameTaken, msgUsernameTaken)
 			return
 		}
 		Error(w, http.StatusInternalServerError, "failed to create admin")
 		return
 	}
 
-	JSON(w, http.StatusCreated, AdminResponse{
-		ID:          admin.ID,
-		Username:    admin.Username,
-		DisplayName: admin.GetDisplayName(),
-		Role:        admin.Role,
-		CreatedAt:   admin.CreatedAt.Format("2006-01-02T15:04:05Z"),
-	})
+	JSON(w, http.StatusCreated, newAdminResponse(admin))
 }
 
-// HandleAdmin handles single admin operations (GET, PUT, DELETE)
-func (h *AuthHandler) HandleAdmin(w http.ResponseWriter, r *http.Request) {
-	// Extract ID from URL path: /api/admins/{id}
-	path := r.URL.Path
-	parts := strings.Split(strings.TrimPrefix(path, "/api/admins/"), "/")
-	if len(parts) == 0 || parts[0] == "" {
-		Error(w, http.StatusBadRequest, "admin ID required")
-		return
-	}
-	adminID := parts[0]
-
-	// Check for password reset endpoint: /api/admins/{id}/reset-password
-	if len(parts) > 1 && parts[1] == "reset-password" {
-		h.handleResetPassword(w, r, adminID)
-		return
-	}
-
-	switch r.Method {
-	case http.MethodGet:
-		h.getAdmin(w, r, adminID)
-	case http.MethodPut:
-		h.updateAdmin(w, r, adminID)
-	case http.MethodDelete:
-		h.deleteAdmin(w, r, adminID)
-	default:
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-	}
-}
-
-func (h *AuthHandler) getAdmin(w http.ResponseWriter, r *http.Request, adminID string) {
+// HandleGetAdmin handles GET /api/admins/{id}
+func (h *AuthHandler) HandleGetAdmin(w http.ResponseWriter, r *http.Request) {
+	adminID := r.PathValue("id")
 	admin := h.storage.GetAdminByID(adminID)
 	if admin == nil {
-		Error(w, http.StatusNotFound, "admin not found")
+		Error(w, http.StatusNotFound, msgAdminNotFound)
 		return
 	}
 
-	JSON(w, http.StatusOK, AdminResponse{
-		ID:          admin.ID,
-		Username:    admin.Username,
-		DisplayName: admin.GetDisplayName(),
-		Role:        admin.Role,
-		CreatedAt:   admin.CreatedAt.Format("2006-01-02T15:04:05Z"),
-	})
+	JSON(w, http.StatusOK, newAdminResponse(admin))
 }
 
-func (h *AuthHandler) updateAdmin(w http.ResponseWriter, r *http.Request, adminID string) {
+// HandleUpdateAdmin handles PUT /api/admins/{id}
+func (h *AuthHandler) HandleUpdateAdmin(w http.ResponseWriter, r *http.Request) {
+	adminID := r.PathValue("id")
 	claims := middleware.GetClaims(r)
 	if claims == nil {
-		Error(w, http.StatusUnauthorized, "unauthorized")
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
 		return
 	}
 
 	currentAdmin := h.storage.GetAdminByID(claims.UserID)
 	if currentAdmin == nil || !currentAdmin.IsSuper() {
-		Error(w, http.StatusForbidden, "only super admins can update admins")
+		ErrorCode(w, http.StatusForbidden, CodeSuperAdminRequired, "only super admins can update admins")
 		return
 	}
 
 	var req UpdateAdminRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
-	role := models.RoleAdmin
-	if req.Role == "super" {
-		role = models.RoleSuper
+	role, ok := parseAdminRole(req.Role)
+	if !ok {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgInvalidRole)
+		return
 	}
 
 	if err := h.authSvc.UpdateAdmin(adminID, req.DisplayName, role); err != nil {
 		if err == services.ErrUserNotFound {
-			Error(w, http.StatusNotFound, "admin not found")
+			Error(w, http.StatusNotFound, msgAdminNotFound)
 			return
 		}
-		Error(w, http.StatusInternalServerError, "failed to update admin")
+		Error(w, http.StatusInternalServerError, msgUpdateAdminFailed)
 		return
 	}
 
 	JSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
-func (h *AuthHandler) deleteAdmin(w http.ResponseWriter, r *http.Request, adminID string) {
+// HandleDeleteAdmin handles DELETE /api/admins/{id}
+func (h *AuthHandler) HandleDeleteAdmin(w http.ResponseWriter, r *http.Request) {
+	adminID := r.PathValue("id")
 	claims := middleware.GetClaims(r)
 	if claims == nil {
-		Error(w, http.StatusUnauthorized, "unauthorized")
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
 		return
 	}
 
 	currentAdmin := h.storage.GetAdminByID(claims.UserID)
 	if currentAdmin == nil || !currentAdmin.IsSuper() {
-		Error(w, http.StatusForbidden, "only super admins can delete admins")
+		ErrorCode(w, http.StatusForbidden, CodeSuperAdminRequired, "only super admins can delete admins")
 		return
 	}
 
 	// Prevent self-deletion
 	if adminID == claims.UserID {
-		Error(w, http.StatusBadRequest, "cannot delete your own account")
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, "cannot delete your own account")
 		return
 	}
 
 	// Prevent deleting last admin
 	if h.storage.AdminCount() <= 1 {
-		Error(w, http.StatusBadRequest, "cannot delete the last admin")
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, "cannot delete the last admin")
 		return
 	}
 
@@ -392,38 +613,39 @@ func (h *AuthHandler) deleteAdmin(w http.ResponseWriter, r *http.Request, adminI
 	JSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
-func (h *AuthHandler) handleResetPassword(w http.ResponseWriter, r *http.Request, adminID string) {
-	if r.Method != http.MethodPost {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
-
+// HandleResetPassword handles POST /api/admins/{id}/reset-password
+func (h *AuthHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	adminID := r.PathValue("id")
 	claims := middleware.GetClaims(r)
 	if claims == nil {
-		Error(w, http.StatusUnauthorized, "unauthorized")
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
 		return
 	}
 
 	currentAdmin := h.storage.GetAdminByID(claims.UserID)
 	if currentAdmin == nil || !currentAdmin.IsSuper() {
-		Error(w, http.StatusForbidden, "only super admins can reset passwords")
+		ErrorCode(w, http.StatusForbidden, CodeSuperAdminRequired, "only super admins can reset passwords")
 		return
 	}
 
 	var req ResetPasswordRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
-	if len(req.NewPassword) < 6 {
-		Error(w, http.StatusBadRequest, "password must be at least 6 characters")
+	var username string
+	if target := h.storage.GetAdminByID(adminID); target != nil {
+		username = target.Username
+	}
+	if err := h.authSvc.ValidateAdminPassword(req.NewPassword, username); err != nil {
+		passwordError(w, err)
 		return
 	}
 
 	if err := h.authSvc.ResetAdminPassword(adminID, req.NewPassword); err != nil {
 		if err == services.ErrUserNotFound {
-			Error(w, http.StatusNotFound, "admin not found")
+			Error(w, http.StatusNotFound, msgAdminNotFound)
 			return
 		}
 		Error(w, http.StatusInternalServerError, "failed to reset password")
@@ -432,3 +654,283 @@ func (h *AuthHandler) handleResetPassword(w http.ResponseWriter, r *http.Request
 
 	JSON(w, http.StatusOK, map[string]bool{"success": true})
 }
+
+// HandleUnlockAdmin handles POST /api/admins/{id}/unlock
+func (h *AuthHandler) HandleUnlockAdmin(w http.ResponseWriter, r *http.Request) {
+	adminID := r.PathValue("id")
+	claims := middleware.GetClaims(r)
+	if claims == nil {
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
+		return
+	}
+
+	currentAdmin := h.storage.GetAdminByID(claims.UserID)
+	if currentAdmin == nil || !currentAdmin.IsSuper() {
+		ErrorCode(w, http.StatusForbidden, CodeSuperAdminRequired, "only super admins can unlock admins")
+		return
+	}
+
+	if err := h.authSvc.UnlockAdmin(adminID, currentAdmin.Username, loginAttempt(r)); err != nil {
+		if err == services.ErrUserNotFound {
+			Error(w, http.StatusNotFound, msgAdminNotFound)
+			return
+		}
+		Error(w, http.StatusInternalServerError, "failed to unlock admin")
+		return
+	}
+
+	JSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// LockoutClearRequest names the username to unlock
+type LockoutClearRequest struct {
+	Username string `json:"username"`
+}
+
+// LockoutClearResponse says how many lockouts were lifted
+type LockoutClearResponse struct {
+	Cleared int `json:"cleared"`
+}
+
+// HandleListLockouts handles GET /api/system/lockouts
+func (h *AuthHandler) HandleListLockouts(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, h.authSvc.Lockouts())
+}
+
+// HandleClearLockouts handles POST /api/system/lockouts/clear. Unlocking an
+// admin account takes a super admin, as POST /api/admins/{id}/unlock does.
+func (h *AuthHandler) HandleClearLockouts(w http.ResponseWriter, r *http.Request) {
+	var req LockoutClearRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, "username is required")
+		return
+	}
+
+	claims := middleware.GetClaims(r)
+	if claims == nil {
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
+		return
+	}
+	if h.storage.GetAdminByUsername(req.Username) != nil {
+		if current := h.storage.GetAdminByID(claims.UserID); current == nil || !current.IsSuper() {
+			ErrorCode(w, http.StatusForbidden, CodeSuperAdminRequired, "only super admins can unlock admins")
+			return
+		}
+	}
+
+	cleared, err := h.authSvc.ClearLockouts(req.Username, claims.Username, loginAttempt(r))
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to clear lockouts")
+		return
+	}
+
+	JSON(w, http.StatusOK, LockoutClearResponse{Cleared: cleared})
+}
+
+// APIKeyRequest represents an API key creation request
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Role   string   `json:"role"`
+	Scopes []string `json:"scopes"`
+}
+
+// APIKeyResponse represents an API key in API responses. The key itself is
+// never included.
+type APIKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	UserID     string     `json:"user_id"`
+	Role       string     `json:"role"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// CreateAPIKeyResponse is returned once, when a key is created
+type CreateAPIKeyResponse struct {
+	Key    string         `json:"key"`
+	APIKey APIKeyResponse `json:"api_key"`
+}
+
+// newAPIKeyResponse converts an APIKey to an APIKeyResponse
+func newAPIKeyResponse(k *models.APIKey) APIKeyResponse {
+	scopes := k.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return APIKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		UserID:     k.UserID,
+		Role:       string(k.Role),
+		Scopes:     scopes,
+		CreatedAt:  k.CreatedAt,
+		LastUsedAt: k.LastUsedAt,
+	}
+}
+
+// HandleCreateAPIKey handles POST /api/auth/apikeys
+func (h *AuthHandler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	if claims == nil {
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
+		return
+	}
+
+	var req APIKeyRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if n := utf8.RuneCountInString(name); n < 1 || n > maxDisplayNameLen {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, "name must be 1-50 characters")
+		return
+	}
+
+	role := models.APIKeyRole(req.Role)
+	if role == "" {
+		role = models.APIKeyRoleRead
+	}
+	if role == models.APIKeyRoleAdmin && !canWrite(h.storage, r) {
+		ErrorCode(w, http.StatusForbidden, CodeReadOnly, "viewers can only create read keys")
+		return
+	}
+
+	key, record, err := h.authSvc.CreateAPIKey(claims.UserID, name, role, req.Scopes)
+	if err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusCreated, CreateAPIKeyResponse{
+		Key:    key,
+		APIKey: newAPIKeyResponse(record),
+	})
+}
+
+// HandleListAPIKeys handles GET /api/auth/apikeys. Super admins see every
+// admin's keys.
+func (h *AuthHandler) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	if claims == nil {
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
+		return
+	}
+
+	userID := claims.UserID
+	if admin := h.storage.GetAdminByID(claims.UserID); admin != nil && admin.IsSuper() {
+		userID = ""
+	}
+
+	keys := h.storage.ListAPIKeys(userID)
+	response := make([]APIKeyResponse, len(keys))
+	for i, k := range keys {
+		response[i] = newAPIKeyResponse(k)
+	}
+
+	JSONWithETag(w, r, http.StatusOK, response)
+}
+
+// HandleRevokeAPIKey handles DELETE /api/auth/apikeys/{id}. Admins can
+// revoke their own keys; super admins can revoke any.
+func (h *AuthHandler) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	if claims == nil {
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
+		return
+	}
+
+	key := h.storage.GetAPIKey(r.PathValue("id"))
+	if key == nil {
+		Error(w, http.StatusNotFound, msgAPIKeyNotFound)
+		return
+	}
+
+	if key.UserID != claims.UserID {
+		currentAdmin := h.storage.GetAdminByID(claims.UserID)
+		if currentAdmin == nil || !currentAdmin.IsSuper() {
+			Error(w, http.StatusNotFound, msgAPIKeyNotFound)
+			return
+		}
+	}
+
+	if err := h.storage.DeleteAPIKey(key.ID); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to revoke api key")
+		return
+	}
+
+	JSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// AdminSessionResponse represents a signed-in admin session in API responses
+type AdminSessionResponse struct {
+	ID         string    `json:"id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// HandleListSessions handles GET /api/auth/sessions, listing where the
+// current admin is signed in
+func (h *AuthHandler) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	if claims == nil {
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
+		return
+	}
+
+	sessions := h.authSvc.ListAdminSessions(claims.UserID)
+	response := make([]AdminSessionResponse, len(sessions))
+	for i, s := range sessions {
+		response[i] = AdminSessionResponse{
+			ID:         s.ID,
+			IP:         s.IP,
+			UserAgent:  s.UserAgent,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			ExpiresAt:  s.ExpiresAt,
+			Current:    s.ID == claims.SessionID,
+		}
+	}
+
+	JSON(w, http.StatusOK, response)
+}
+
+// HandleRevokeSession handles DELETE /api/auth/sessions/{id}, signing the
+// current admin out of one of their sessions
+func (h *AuthHandler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaims(r)
+	if claims == nil {
+		Error(w, http.StatusUnauthorized, msgUnauthorized)
+		return
+	}
+
+	session, err := h.authSvc.RevokeAdminSession(claims.UserID, r.PathValue("id"))
+	if err != nil {
+		if err == services.ErrSessionNotFound {
+			Error(w, http.StatusNotFound, msgSessionNotFound)
+			return
+		}
+		Error(w, http.StatusInternalServerError, "failed to revoke session")
+		return
+	}
+	h.jwt.RevokeSession(session.ID, session.ExpiresAt)
+
+	if session.ID == claims.SessionID {
+		clearSessionCookies(w)
+	}
+
+	JSON(w, http.StatusOK, map[string]bool{"success": true})
+}
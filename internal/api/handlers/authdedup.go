@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// authDedupWindow is how long a finished login is replayed to
+	// identical submissions, such as a double-tapped login button
+	authDedupWindow = 3 * time.Second
+
+	// authDedupTTL is when a finished login is dropped from the cache
+	authDedupTTL = 10 * time.Second
+
+	// authDedupMaxBody is the largest response kept for replay
+	authDedupMaxBody = 64 << 10
+)
+
+// authResult is a login being processed, and once done is closed, the
+// response it sent
+type authResult struct {
+	done chan struct{}
+
+	// Set before done is closed
+	finished time.Time
+	status   int
+	header   http.Header
+	body     []byte
+	complete bool // false if the response was too large to replay
+}
+
+// authDedupKey identifies identical logins from one device: the MAC, the
+// username or voucher, and a hash of the password so a corrected retry
+// isn't answered with the earlier failure. Logins without a MAC aren't
+// deduplicated.
+func authDedupKey(req AuthRequest) string {
+	if req.MAC == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(req.Password))
+	return strings.Join([]string{
+		req.MAC,
+		strings.ToLower(req.Username),
+		strings.ToUpper(req.Voucher),
+		hex.EncodeToString(sum[:8]),
+	}, "\x00")
+}
+
+// beginAuth claims key for this request. If an identical login is in
+// flight it waits for it, and returns its result while that's under
+// authDedupWindow old. Otherwise it returns a new result the caller must
+// pass to finishAuth. Both are nil if the client went away while waiting.
+func (h *FASHandler) beginAuth(ctx context.Context, key string) (prev, result *authResult) {
+	h.pruneAuthDedup()
+
+	result = &authResult{done: make(chan struct{})}
+	for {
+		actual, loaded := h.authDedup.LoadOrStore(key, result)
+		if !loaded {
+			return nil, result
+		}
+		prev = actual.(*authResult)
+		select {
+		case <-prev.done:
+		case <-ctx.Done():
+			return nil, nil
+		}
+		if prev.complete && time.Since(prev.finished) < authDedupWindow {
+			return prev, nil
+		}
+		// Too old to replay; take its place
+		h.authDedup.CompareAndDelete(key, prev)
+	}
+}
+
+// finishAuth records the response sent for a login and wakes up any
+// identical requests waiting on it
+func (h *FASHandler) finishAuth(result *authResult, rec *authRecorder) {
+	result.status = rec.status
+	if result.status == 0 {
+		result.status = http.StatusOK
+	}
+	result.header = rec.header
+	if result.header == nil {
+		result.header = rec.Header().Clone()
+	}
+	result.body = rec.body.Bytes()
+	result.complete = !rec.truncated
+	result.finished = time.Now()
+	close(result.done)
+}
+
+// pruneAuthDedup drops finished logins older than authDedupTTL
+func (h *FASHandler) pruneAuthDedup() {
+	h.authDedup.Range(func(key, value any) bool {
+		result := value.(*authResult)
+		select {
+		case <-result.done:
+			if time.Since(result.finished) > authDedupTTL {
+				h.authDedup.CompareAndDelete(key, result)
+			}
+		default:
+		}
+		return true
+	})
+}
+
+// replay sends the recorded response again
+func (a *authResult) replay(w http.ResponseWriter) {
+	for k, v := range a.header {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(a.status)
+	w.Write(a.body)
+}
+
+// authRecorder passes a response through while keeping a copy of it
+type authRecorder struct {
+	http.ResponseWriter
+	status    int
+	header    http.Header
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (rec *authRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *authRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.body.Len()+len(b) > authDedupMaxBody {
+		rec.truncated = true
+	} else if !rec.truncated {
+		rec.body.Write(b)
+	}
+	return rec.ResponseWriter.Write(b)
+}
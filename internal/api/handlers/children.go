@@ -1,9 +1,16 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
+	"parenta/internal/api/middleware"
 	"parenta/internal/models"
 	"parenta/internal/services"
 	"parenta/internal/storage"
@@ -13,13 +20,15 @@ import (
 type ChildrenHandler struct {
 	storage *storage.Storage
 	authSvc *services.AuthService
+	ticker  *services.SessionTicker
 }
 
 // NewChildrenHandler creates a new ChildrenHandler
-func NewChildrenHandler(store *storage.Storage, authSvc *services.AuthService) *ChildrenHandler {
+func NewChildrenHandler(store *storage.Storage, authSvc *services.AuthService, ticker *services.SessionTicker) *ChildrenHandler {
 	return &ChildrenHandler{
 		storage: store,
 		authSvc: authSvc,
+		ticker:  ticker,
 	}
 }
 
@@ -28,46 +37,123 @@ type ChildRequest struct {
 	Username      string `json:"username"`
 	Password      string `json:"password"`
 	Name          string `json:"name"`
+	Age           int    `json:"age"`
 	DailyQuotaMin int    `json:"daily_quota_min"`
 	FilterMode    string `json:"filter_mode"`
 	ScheduleID    string `json:"schedule_id"`
 	IsActive      bool   `json:"is_active"`
+
+	UseBankAfterQuota bool `json:"use_bank_after_quota"`
+
+	// Pointers so updates can tell "unlimited" (0) from "not sent"
+	MaxConcurrentDevices *int `json:"max_concurrent_devices"`
+	DailyDataQuotaMB     *int `json:"daily_data_quota_mb"`
+	MaxSessionMinutes    *int `json:"max_session_minutes"`
+	BreakMinutes         *int `json:"break_minutes"`
+
+	// Omitted keeps the current setting
+	AllowSelfPasswordChange *bool `json:"allow_self_password_change"`
+
+	// Omitted fields keep the current bedtime; empty times turn it off
+	BedtimeStart *string `json:"bedtime_start"`
+	BedtimeEnd   *string `json:"bedtime_end"`
+	BedtimeDays  []int   `json:"bedtime_days"`
+}
+
+// applyBedtime sets the bedtime fields sent in req on child. The child is
+// left unchanged if the resulting bedtime is invalid.
+func (req *ChildRequest) applyBedtime(child *models.Child) error {
+	start, end, days := child.BedtimeStart, child.BedtimeEnd, child.BedtimeDays
+	if req.BedtimeStart != nil {
+		start = *req.BedtimeStart
+	}
+	if req.BedtimeEnd != nil {
+		end = *req.BedtimeEnd
+	}
+	if req.BedtimeDays != nil {
+		days = req.BedtimeDays
+	}
+	if err := models.ValidateBedtime(start, end, days); err != nil {
+		return err
+	}
+	child.BedtimeStart, child.BedtimeEnd, child.BedtimeDays = start, end, days
+	return nil
+}
+
+// validSessionLimit checks max_session_minutes or break_minutes, if sent
+func validSessionLimit(minutes *int) bool {
+	return minutes == nil || (*minutes >= 0 && *minutes <= models.MaxSessionLimitMinutes)
 }
 
 // ChildResponse represents child in API response (no password)
 type ChildResponse struct {
-	ID              string          `json:"id"`
-	Username        string          `json:"username"`
-	Name            string          `json:"name"`
-	DailyQuotaMin   int             `json:"daily_quota_min"`
-	UsedTodayMin    int             `json:"used_today_min"`
-	RemainingMin    int             `json:"remaining_min"`
-	FilterMode      string          `json:"filter_mode"`
-	ScheduleID      string          `json:"schedule_id"`
-	ScheduleName    string          `json:"schedule_name"`
-	Devices         []models.Device `json:"devices"`
-	IsActive        bool            `json:"is_active"`
-	LastResetDate   string          `json:"last_reset_date"`
-	CreatedAt       time.Time       `json:"created_at"`
-	UpdatedAt       time.Time       `json:"updated_at"`
+	ID                   string          `json:"id"`
+	Username             string          `json:"username"`
+	Name                 string          `json:"name"`
+	Age                  int             `json:"age,omitempty"`
+	DailyQuotaMin        int             `json:"daily_quota_min"`
+	UsedTodayMin         int             `json:"used_today_min"`
+	RemainingMin         int             `json:"remaining_min"`
+	DailyDataQuotaMB     int             `json:"daily_data_quota_mb"` // 0 = unlimited
+	UsedTodayMB          float64         `json:"used_today_mb"`
+	FilterMode           string          `json:"filter_mode"`
+	ScheduleID           string          `json:"schedule_id"`
+	ScheduleName         string          `json:"schedule_name"`
+	Devices              []models.Device `json:"devices"`
+	IsActive             bool            `json:"is_active"`
+	BankMinutes          int             `json:"bank_minutes"`
+	UseBankAfterQuota    bool            `json:"use_bank_after_quota"`
+	MaxConcurrentDevices int             `json:"max_concurrent_devices"`
+	MaxSessionMinutes    int             `json:"max_session_minutes"` // 0 = unlimited
+	BreakMinutes         int             `json:"break_minutes"`
+	AllowSelfPassword    bool            `json:"allow_self_password_change"`
+	BedtimeStart         string          `json:"bedtime_start"`
+	BedtimeEnd           string          `json:"bedtime_end"`
+	BedtimeDays          []int           `json:"bedtime_days"`
+	LastResetDate        string          `json:"last_reset_date"`
+	Warnings             []string        `json:"warnings,omitempty"`
+	CreatedAt            time.Time       `json:"created_at"`
+	UpdatedAt            time.Time       `json:"updated_at"`
+
+	// Lifetime session stats; left out of the list unless asked for
+	*ChildSessionStats
+}
+
+// ChildSessionStats summarizes all of a child's sessions
+type ChildSessionStats struct {
+	TotalSessionsAllTime int     `json:"total_sessions_all_time"`
+	TotalMinutesAllTime  int     `json:"total_minutes_all_time"`
+	AvgSessionMinutes    float64 `json:"avg_session_minutes"`
 }
 
 // toResponse converts Child to ChildResponse
 func (h *ChildrenHandler) toChildResponse(c *models.Child) ChildResponse {
 	resp := ChildResponse{
-		ID:            c.ID,
-		Username:      c.Username,
-		Name:          c.Name,
-		DailyQuotaMin: c.DailyQuotaMin,
-		UsedTodayMin:  c.UsedTodayMin,
-		RemainingMin:  c.RemainingMinutes(),
-		FilterMode:    string(c.FilterMode),
-		ScheduleID:    c.ScheduleID,
-		Devices:       c.Devices,
-		IsActive:      c.IsActive,
-		LastResetDate: c.LastResetDate,
-		CreatedAt:     c.CreatedAt,
-		UpdatedAt:     c.UpdatedAt,
+		ID:                   c.ID,
+		Username:             c.Username,
+		Name:                 c.Name,
+		Age:                  c.Age,
+		DailyQuotaMin:        c.DailyQuotaMin,
+		UsedTodayMin:         c.UsedTodayMin,
+		RemainingMin:         c.RemainingMinutes(),
+		DailyDataQuotaMB:     c.DailyDataQuotaMB,
+		UsedTodayMB:          math.Round(c.UsedTodayMB*10) / 10,
+		FilterMode:           string(c.FilterMode),
+		ScheduleID:           c.ScheduleID,
+		Devices:              c.Devices,
+		IsActive:             c.IsActive,
+		BankMinutes:          c.BankMinutes,
+		UseBankAfterQuota:    c.UseBankAfterQuota,
+		MaxConcurrentDevices: c.MaxConcurrentDevices,
+		MaxSessionMinutes:    c.MaxSessionMinutes,
+		BreakMinutes:         c.BreakMinutes,
+		AllowSelfPassword:    c.CanChangeOwnPassword(),
+		BedtimeStart:         c.BedtimeStart,
+		BedtimeEnd:           c.BedtimeEnd,
+		BedtimeDays:          c.BedtimeDays,
+		LastResetDate:        c.LastResetDate,
+		CreatedAt:            c.CreatedAt,
+		UpdatedAt:            c.UpdatedAt,
 	}
 
 	// Lookup schedule name
@@ -77,96 +163,165 @@ func (h *ChildrenHandler) toChildResponse(c *models.Child) ChildResponse {
 		}
 	}
 
+	resp.Warnings = deviceWarnings(c)
 	return resp
 }
 
-// Handle handles /api/children (list and create)
-func (h *ChildrenHandler) Handle(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		h.list(w, r)
-	case http.MethodPost:
-		h.create(w, r)
-	default:
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
+// sessionStats aggregates the child's session history
+func (h *ChildrenHandler) sessionStats(c *models.Child) *ChildSessionStats {
+	stats := &ChildSessionStats{}
+	for _, s := range h.storage.ListSessionsByChildID(c.ID) {
+		stats.TotalSessionsAllTime++
+		stats.TotalMinutesAllTime += s.DurationMinutes()
 	}
+	if stats.TotalSessionsAllTime > 0 {
+		stats.AvgSessionMinutes = float64(stats.TotalMinutesAllTime) / float64(stats.TotalSessionsAllTime)
+	}
+	return stats
 }
 
-// HandleByID handles /api/children/{id} (get, update, delete)
-func (h *ChildrenHandler) HandleByID(w http.ResponseWriter, r *http.Request) {
-	id := ExtractID(r.URL.Path, "/api/children")
-	if id == "" {
-		Error(w, http.StatusBadRequest, "missing child id")
-		return
+// deviceWarnings explains what the child's private MAC devices need. A
+// private address changes whenever the device forgets the network, which
+// registers it again and slips past per-device rules.
+func deviceWarnings(c *models.Child) []string {
+	var warnings []string
+	if n := c.RandomizedDevices(); n > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d device(s) use a private MAC address; turn off Private Wi-Fi Address for this network on them", n))
+	}
+	pending := 0
+	for _, d := range c.Devices {
+		if d.Pending {
+			pending++
+		}
+	}
+	if pending > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d device(s) are waiting for approval", pending))
 	}
+	return warnings
+}
 
-	// Check for actions like /api/children/{id}/reset-quota
-	action := ExtractAction(r.URL.Path, "/api/children")
+// HandleList handles GET /api/children. Session stats go through every
+// child's history, so they are only included with ?include_stats=true.
+func (h *ChildrenHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	includeStats, _ := strconv.ParseBool(r.URL.Query().Get("include_stats"))
 
-	switch {
-	case action == "reset-quota" && r.Method == http.MethodPost:
-		h.resetQuota(w, r, id)
-	case action == "adjust-quota" && r.Method == http.MethodPost:
-		h.adjustQuota(w, r, id)
-	case action == "devices" && r.Method == http.MethodPost:
-		h.addDevice(w, r, id)
-	case action == "devices" && r.Method == http.MethodDelete:
-		h.removeDevice(w, r, id)
-	case r.Method == http.MethodGet:
-		h.get(w, r, id)
-	case r.Method == http.MethodPut:
-		h.update(w, r, id)
-	case r.Method == http.MethodDelete:
-		h.delete(w, r, id)
-	default:
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
+	// Remaining minutes include the holiday bonus and each child shows its
+	// schedule's name. Stats change with sessions, which aren't tracked here.
+	if !includeStats && NotModified(w, r, h.storage.LastModifiedAt("children", "holiday", "schedules")) {
+		return
 	}
+
+	JSONWithETag(w, r, http.StatusOK, h.List(includeStats))
 }
 
-func (h *ChildrenHandler) list(w http.ResponseWriter, r *http.Request) {
+// List returns every child as in GET /api/children
+func (h *ChildrenHandler) List(includeStats bool) []ChildResponse {
 	children := h.storage.ListChildren()
 	response := make([]ChildResponse, len(children))
 	for i, c := range children {
 		response[i] = h.toChildResponse(c)
+		if includeStats {
+			response[i].ChildSessionStats = h.sessionStats(c)
+		}
+	}
+	return response
+}
+
+// HandleQuotaPresets handles GET /api/children/quota-presets?age=12
+func (h *ChildrenHandler) HandleQuotaPresets(w http.ResponseWriter, r *http.Request) {
+	age, err := strconv.Atoi(r.URL.Query().Get("age"))
+	if err != nil || age < 1 || age > models.MaxChildAge {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgInvalidAge)
+		return
 	}
-	JSON(w, http.StatusOK, response)
+	JSON(w, http.StatusOK, models.QuotaPresetForAge(age))
 }
 
-func (h *ChildrenHandler) get(w http.ResponseWriter, r *http.Request, id string) {
+// HandleGet handles GET /api/children/{id}
+func (h *ChildrenHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
 	child := h.storage.GetChild(id)
 	if child == nil {
-		Error(w, http.StatusNotFound, "child not found")
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
 		return
 	}
-	JSON(w, http.StatusOK, h.toChildResponse(child))
+	resp := h.toChildResponse(child)
+	resp.ChildSessionStats = h.sessionStats(child)
+	JSON(w, http.StatusOK, resp)
+}
+
+// HandleQR handles GET /api/children/{id}/qr: a QR code of the portal
+// with the child's username filled in. It never includes the password.
+func (h *ChildrenHandler) HandleQR(w http.ResponseWriter, r *http.Request) {
+	child := h.storage.GetChild(r.PathValue("id"))
+	if child == nil {
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
+		return
+	}
+	writeQR(w, r, portalURL(r, url.Values{"username": {child.Username}}))
 }
 
-func (h *ChildrenHandler) create(w http.ResponseWriter, r *http.Request) {
+// HandleCreate handles POST /api/children
+func (h *ChildrenHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
 	var req ChildRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
 	// Validate required fields
 	if req.Username == "" || req.Password == "" || req.Name == "" {
-		Error(w, http.StatusBadRequest, "username, password, and name are required")
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, "username, password, and name are required")
+		return
+	}
+
+	if err := h.authSvc.ValidateChildPassword(req.Password, req.Username); err != nil {
+		passwordError(w, err)
+		return
+	}
+
+	if req.MaxConcurrentDevices != nil && *req.MaxConcurrentDevices < 0 {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgNegDevices)
+		return
+	}
+	if req.DailyDataQuotaMB != nil && (*req.DailyDataQuotaMB < 0 || *req.DailyDataQuotaMB > models.MaxDailyDataQuotaMB) {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgInvalidData)
+		return
+	}
+	if !validSessionLimit(req.MaxSessionMinutes) || !validSessionLimit(req.BreakMinutes) {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgSessionLimit)
+		return
+	}
+	if req.Age < 0 || req.Age > models.MaxChildAge {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgInvalidAge)
 		return
 	}
 
 	// Check username uniqueness
 	if existing := h.storage.GetChildByUsername(req.Username); existing != nil {
-		Error(w, http.StatusConflict, "username already exists")
+		ErrorCode(w, http.StatusConflict, CodeUsernameTaken, msgUsernameTaken)
 		return
 	}
 
 	// Hash password
 	hash, err := services.HashPassword(req.Password)
 	if err != nil {
-		Error(w, http.StatusInternalServerError, "failed to hash password")
+		Error(w, http.StatusInternalServerError, msgHashFailed)
 		return
 	}
 
+	// With an age and no quota, fill in what wasn't sent from the age preset
+	if req.Age > 0 && req.DailyQuotaMin == 0 {
+		preset := models.QuotaPresetForAge(req.Age)
+		req.DailyQuotaMin = preset.DailyQuotaMin
+		if req.MaxConcurrentDevices == nil {
+			req.MaxConcurrentDevices = &preset.MaxDevices
+		}
+		if req.FilterMode == "" {
+			req.FilterMode = string(preset.RecommendedFilterMode)
+		}
+	}
+
 	// Set defaults
 	filterMode := models.FilterModeNormal
 	if req.FilterMode == "study" {
@@ -177,39 +332,93 @@ func (h *ChildrenHandler) create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	child := &models.Child{
-		ID:            services.GenerateID(),
-		Username:      req.Username,
-		PasswordHash:  hash,
-		Name:          req.Name,
-		DailyQuotaMin: req.DailyQuotaMin,
-		UsedTodayMin:  0,
-		FilterMode:    filterMode,
-		ScheduleID:    req.ScheduleID,
-		Devices:       make([]models.Device, 0),
-		IsActive:      true,
-		LastResetDate: time.Now().Format("2006-01-02"),
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+		ID:                services.GenerateID(),
+		Username:          req.Username,
+		PasswordHash:      hash,
+		Name:              req.Name,
+		Age:               req.Age,
+		DailyQuotaMin:     req.DailyQuotaMin,
+		UsedTodayMin:      0,
+		FilterMode:        filterMode,
+		ScheduleID:        req.ScheduleID,
+		Devices:           make([]models.Device, 0),
+		IsActive:          true,
+		UseBankAfterQuota: req.UseBankAfterQuota,
+		LastResetDate:     time.Now().Format("2006-01-02"),
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+	}
+	if req.MaxConcurrentDevices != nil {
+		child.MaxConcurrentDevices = *req.MaxConcurrentDevices
+	}
+	if req.DailyDataQuotaMB != nil {
+		child.DailyDataQuotaMB = *req.DailyDataQuotaMB
+	}
+	if req.MaxSessionMinutes != nil {
+		child.MaxSessionMinutes = *req.MaxSessionMinutes
+	}
+	if req.BreakMinutes != nil {
+		child.BreakMinutes = *req.BreakMinutes
+	}
+	child.AllowSelfPasswordChange = req.AllowSelfPasswordChange
+	if err := req.applyBedtime(child); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, err.Error())
+		return
 	}
 
 	if err := h.storage.SaveChild(child); err != nil {
-		Error(w, http.StatusInternalServerError, "failed to save child")
+		Error(w, http.StatusInternalServerError, msgSaveChildFailed)
 		return
 	}
 
 	JSON(w, http.StatusCreated, h.toChildResponse(child))
 }
 
-func (h *ChildrenHandler) update(w http.ResponseWriter, r *http.Request, id string) {
+// HandleUpdate handles PUT /api/children/{id}
+func (h *ChildrenHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
 	child := h.storage.GetChild(id)
 	if child == nil {
-		Error(w, http.StatusNotFound, "child not found")
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
 		return
 	}
 
 	var req ChildRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	if req.MaxConcurrentDevices != nil && *req.MaxConcurrentDevices < 0 {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgNegDevices)
+		return
+	}
+	if req.DailyDataQuotaMB != nil && (*req.DailyDataQuotaMB < 0 || *req.DailyDataQuotaMB > models.MaxDailyDataQuotaMB) {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgInvalidData)
+		return
+	}
+	if !validSessionLimit(req.MaxSessionMinutes) || !validSessionLimit(req.BreakMinutes) {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgSessionLimit)
+		return
+	}
+	if req.Age < 0 || req.Age > models.MaxChildAge {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgInvalidAge)
+		return
+	}
+
+	if req.Password != "" {
+		username := req.Username
+		if username == "" {
+			username = child.Username
+		}
+		if err := h.authSvc.ValidateChildPassword(req.Password, username); err != nil {
+			passwordError(w, err)
+			return
+		}
+	}
+
+	if err := req.applyBedtime(child); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, err.Error())
 		return
 	}
 
@@ -220,7 +429,7 @@ func (h *ChildrenHandler) update(w http.ResponseWriter, r *http.Request, id stri
 	if req.Username != "" && req.Username != child.Username {
 		// Check uniqueness
 		if existing := h.storage.GetChildByUsername(req.Username); existing != nil && existing.ID != id {
-			Error(w, http.StatusConflict, "username already exists")
+			ErrorCode(w, http.StatusConflict, CodeUsernameTaken, msgUsernameTaken)
 			return
 		}
 		child.Username = req.Username
@@ -228,11 +437,14 @@ func (h *ChildrenHandler) update(w http.ResponseWriter, r *http.Request, id stri
 	if req.Password != "" {
 		hash, err := services.HashPassword(req.Password)
 		if err != nil {
-			Error(w, http.StatusInternalServerError, "failed to hash password")
+			Error(w, http.StatusInternalServerError, msgHashFailed)
 			return
 		}
 		child.PasswordHash = hash
 	}
+	if req.Age > 0 {
+		child.Age = req.Age
+	}
 	if req.DailyQuotaMin > 0 {
 		child.DailyQuotaMin = req.DailyQuotaMin
 	}
@@ -241,20 +453,45 @@ func (h *ChildrenHandler) update(w http.ResponseWriter, r *http.Request, id stri
 	}
 	child.ScheduleID = req.ScheduleID
 	child.IsActive = req.IsActive
+	child.UseBankAfterQuota = req.UseBankAfterQuota
+	if req.MaxConcurrentDevices != nil {
+		child.MaxConcurrentDevices = *req.MaxConcurrentDevices
+	}
+	if req.DailyDataQuotaMB != nil {
+		child.DailyDataQuotaMB = *req.DailyDataQuotaMB
+	}
+	if req.MaxSessionMinutes != nil {
+		child.MaxSessionMinutes = *req.MaxSessionMinutes
+	}
+	if req.BreakMinutes != nil {
+		child.BreakMinutes = *req.BreakMinutes
+	}
+	if req.AllowSelfPasswordChange != nil {
+		child.AllowSelfPasswordChange = req.AllowSelfPasswordChange
+	}
 	child.UpdatedAt = time.Now()
 
 	if err := h.storage.SaveChild(child); err != nil {
-		Error(w, http.StatusInternalServerError, "failed to save child")
+		Error(w, http.StatusInternalServerError, msgSaveChildFailed)
 		return
 	}
 
+	// Take a deactivated child offline now rather than at the next tick
+	if !child.IsActive {
+		if n := h.ticker.EndChildSessions(child.ID, "deactivated"); n > 0 {
+			log.Printf("Child %s deactivated, ended %d session(s)", child.Name, n)
+		}
+	}
+
 	JSON(w, http.StatusOK, h.toChildResponse(child))
 }
 
-func (h *ChildrenHandler) delete(w http.ResponseWriter, r *http.Request, id string) {
+// HandleDelete handles DELETE /api/children/{id}
+func (h *ChildrenHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
 	child := h.storage.GetChild(id)
 	if child == nil {
-		Error(w, http.StatusNotFound, "child not found")
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
 		return
 	}
 
@@ -263,25 +500,31 @@ func (h *ChildrenHandler) delete(w http.ResponseWriter, r *http.Request, id stri
 		return
 	}
 
+	if n := h.ticker.EndChildSessions(id, "child_deleted"); n > 0 {
+		log.Printf("Child %s deleted, ended %d session(s)", child.Name, n)
+	}
+
 	JSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
-func (h *ChildrenHandler) resetQuota(w http.ResponseWriter, r *http.Request, id string) {
-	child := h.storage.GetChild(id)
-	if child == nil {
-		Error(w, http.StatusNotFound, "child not found")
+// HandleResetQuota handles POST /api/children/{id}/reset-quota
+func (h *ChildrenHandler) HandleResetQuota(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	err := h.storage.ResetChildQuota(id, time.Now().Format("2006-01-02"))
+	if errors.Is(err, storage.ErrChildNotFound) {
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
 		return
 	}
-
-	child.UsedTodayMin = 0
-	child.LastResetDate = time.Now().Format("2006-01-02")
-	child.UpdatedAt = time.Now()
-
-	if err := h.storage.SaveChild(child); err != nil {
+	if err != nil {
 		Error(w, http.StatusInternalServerError, "failed to reset quota")
 		return
 	}
 
+	child := h.storage.GetChild(id)
+	if child == nil {
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
+		return
+	}
 	JSON(w, http.StatusOK, h.toChildResponse(child))
 }
 
@@ -290,68 +533,268 @@ type AdjustQuotaRequest struct {
 	Minutes int `json:"minutes"` // Positive to add time, negative to subtract
 }
 
-func (h *ChildrenHandler) adjustQuota(w http.ResponseWriter, r *http.Request, id string) {
+// HandleAdjustQuota handles POST /api/children/{id}/adjust-quota
+func (h *ChildrenHandler) HandleAdjustQuota(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
 	child := h.storage.GetChild(id)
 	if child == nil {
-		Error(w, http.StatusNotFound, "child not found")
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
 		return
 	}
 
 	var req AdjustQuotaRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
-	// Adjust the used time (negative minutes = more remaining time)
-	// If we want to give +30 minutes, we subtract from used time
-	child.UsedTodayMin -= req.Minutes
+	child.AdjustQuota(req.Minutes)
+	child.UpdatedAt = time.Now()
 
-	// Ensure used time doesn't go negative
-	if child.UsedTodayMin < 0 {
-		child.UsedTodayMin = 0
+	if err := h.storage.SaveChild(child); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to adjust quota")
+		return
 	}
 
-	// Ensure used time doesn't exceed a reasonable maximum
-	if child.UsedTodayMin > child.DailyQuotaMin+480 { // Max 8 hours over quota
-		child.UsedTodayMin = child.DailyQuotaMin + 480
+	JSON(w, http.StatusOK, h.toChildResponse(child))
+}
+
+// GrantRequest represents a time bank credit request
+type GrantRequest struct {
+	Minutes int    `json:"minutes"`
+	Reason  string `json:"reason"`
+}
+
+// BankResponse represents a child's time bank balance and grant history
+type BankResponse struct {
+	BankMinutes       int                      `json:"bank_minutes"`
+	UseBankAfterQuota bool                     `json:"use_bank_after_quota"`
+	History           []models.BankTransaction `json:"history"`
+}
+
+// HandleGrant handles POST /api/children/{id}/grant
+func (h *ChildrenHandler) HandleGrant(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	child := h.storage.GetChild(id)
+	if child == nil {
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
+		return
+	}
+
+	var req GrantRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	if req.Minutes <= 0 {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgMinutesPos)
+		return
+	}
+
+	grantedBy := ""
+	if claims := middleware.GetClaims(r); claims != nil {
+		grantedBy = claims.Username
 	}
 
+	child.GrantBankMinutes(req.Minutes, req.Reason, grantedBy)
 	child.UpdatedAt = time.Now()
 
 	if err := h.storage.SaveChild(child); err != nil {
-		Error(w, http.StatusInternalServerError, "failed to adjust quota")
+		Error(w, http.StatusInternalServerError, "failed to grant minutes")
 		return
 	}
 
 	JSON(w, http.StatusOK, h.toChildResponse(child))
 }
 
+// HandleBank handles GET /api/children/{id}/bank
+func (h *ChildrenHandler) HandleBank(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	child := h.storage.GetChild(id)
+	if child == nil {
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
+		return
+	}
+
+	history := child.BankHistory
+	if history == nil {
+		history = make([]models.BankTransaction, 0)
+	}
+
+	JSON(w, http.StatusOK, BankResponse{
+		BankMinutes:       child.BankMinutes,
+		UseBankAfterQuota: child.UseBankAfterQuota,
+		History:           history,
+	})
+}
+
+// Maximum number of days returned by the daily history endpoint
+const maxHistoryDays = 90
+
+// HandleDailyHistory handles GET /api/children/{id}/history/daily?days=7
+func (h *ChildrenHandler) HandleDailyHistory(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if h.storage.GetChild(id) == nil {
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
+		return
+	}
+
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxHistoryDays {
+			ErrorCode(w, http.StatusBadRequest, CodeValidation, "days must be between 1 and 90")
+			return
+		}
+		days = n
+	}
+
+	JSON(w, http.StatusOK, h.storage.GetChildDailyUsage(id, days))
+}
+
+// CategoryUsageResponse is a child's time per usage category over a period
+type CategoryUsageResponse struct {
+	Totals map[string]int         `json:"totals"` // Minutes per category over all days
+	Days   []models.CategoryUsage `json:"days"`
+}
+
+// HandleCategoryUsage handles GET /api/children/{id}/category-usage?days=7
+func (h *ChildrenHandler) HandleCategoryUsage(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	child := h.storage.GetChild(id)
+	if child == nil {
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
+		return
+	}
+
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > models.MaxCategoryUsageDays {
+			ErrorCode(w, http.StatusBadRequest, CodeValidation, fmt.Sprintf("days must be between 1 and %d", models.MaxCategoryUsageDays))
+			return
+		}
+		days = n
+	}
+
+	resp := CategoryUsageResponse{
+		Totals: make(map[string]int),
+		Days:   child.CategoryUsageFor(time.Now(), days),
+	}
+	for _, day := range resp.Days {
+		for category, minutes := range day.Minutes {
+			resp.Totals[category] += minutes
+		}
+	}
+	JSON(w, http.StatusOK, resp)
+}
+
+// ChildPolicyResponse is a child's effective policy at the moment
+type ChildPolicyResponse struct {
+	ChildID           string     `json:"child_id"`
+	EffectiveQuotaMin int        `json:"effective_quota_min"` // Daily quota plus any holiday bonus
+	UsedTodayMin      int        `json:"used_today_min"`
+	RemainingMin      int        `json:"remaining_min"`
+	BankMinutes       int        `json:"bank_minutes"`
+	DataExceeded      bool       `json:"data_exceeded"` // The daily data cap is used up
+	ScheduleID        string     `json:"schedule_id"`
+	ScheduleAllows    bool       `json:"schedule_allows"` // True without a schedule
+	NextChange        *time.Time `json:"next_change,omitempty"`
+	FilterMode        string     `json:"filter_mode"`
+	FilterModeSource  string     `json:"filter_mode_source"` // "child" or "schedule"
+	Paused            bool       `json:"paused"`             // The child's account is disabled
+	Bedtime           bool       `json:"bedtime"`            // Inside the child's bedtime
+	ActiveDevices     int        `json:"active_devices"`
+	MaxDevices        int        `json:"max_devices"` // 0 = unlimited
+	MaxSessionMinutes int        `json:"max_session_minutes"`
+	BreakUntil        *time.Time `json:"break_until,omitempty"` // The child must take a break until then
+	CanAccessNow      bool       `json:"can_access_now"`
+}
+
+// HandlePolicy handles GET /api/children/{id}/policy
+func (h *ChildrenHandler) HandlePolicy(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	child := h.storage.GetChild(id)
+	if child == nil {
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
+		return
+	}
+
+	now := time.Now()
+	resp := ChildPolicyResponse{
+		ChildID:           child.ID,
+		EffectiveQuotaMin: child.EffectiveQuotaMin(),
+		UsedTodayMin:      child.UsedTodayMin,
+		RemainingMin:      child.RemainingMinutes(),
+		BankMinutes:       child.BankMinutes,
+		DataExceeded:      child.DataExceeded(),
+		ScheduleAllows:    true,
+		FilterMode:        string(models.FilterModeNormal),
+		FilterModeSource:  "child",
+		Paused:            !child.IsActive,
+		Bedtime:           child.InBedtime(now),
+		ActiveDevices:     len(h.storage.ListChildSessions(child.ID)),
+		MaxDevices:        child.MaxConcurrentDevices,
+		MaxSessionMinutes: child.MaxSessionMinutes,
+	}
+	if until := child.BreakEndsAt(h.storage.ListSessionsByChildID(child.ID)); now.Before(until) {
+		resp.BreakUntil = &until
+	}
+
+	// Study mode applies if either the child or the current block asks for
+	// it, matching how the dnsmasq config is generated
+	if child.FilterMode == models.FilterModeStudy {
+		resp.FilterMode = string(models.FilterModeStudy)
+	}
+	if schedule := h.storage.GetSchedule(child.ScheduleID); schedule != nil {
+		resp.ScheduleID = schedule.ID
+		resp.ScheduleAllows = schedule.IsAllowedAt(now)
+		if next, ok := schedule.NextChange(now); ok {
+			resp.NextChange = &next
+		}
+		if resp.FilterMode != string(models.FilterModeStudy) && schedule.FilterModeAt(now) == models.FilterModeStudy {
+			resp.FilterMode = string(models.FilterModeStudy)
+			resp.FilterModeSource = "schedule"
+		}
+	}
+
+	resp.CanAccessNow = !resp.Paused && !resp.Bedtime && resp.ScheduleAllows && resp.RemainingMin > 0 && !resp.DataExceeded && resp.BreakUntil == nil
+	JSON(w, http.StatusOK, resp)
+}
+
 // DeviceRequest represents add device request
 type DeviceRequest struct {
 	MAC  string `json:"mac"`
 	Name string `json:"name"`
 }
 
-func (h *ChildrenHandler) addDevice(w http.ResponseWriter, r *http.Request, id string) {
+// HandleAddDevice handles POST /api/children/{id}/devices
+func (h *ChildrenHandler) HandleAddDevice(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
 	child := h.storage.GetChild(id)
 	if child == nil {
-		Error(w, http.StatusNotFound, "child not found")
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
 		return
 	}
 
 	var req DeviceRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
 	if req.MAC == "" {
-		Error(w, http.StatusBadRequest, "mac is required")
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgMACRequired)
+		return
+	}
+	mac := parseMAC(req.MAC)
+	if mac == "" {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgInvalidMAC)
 		return
 	}
 
-	child.AddDevice(req.MAC, req.Name)
+	child.AddDevice(mac, req.Name)
 	child.UpdatedAt = time.Now()
 
 	if err := h.storage.SaveChild(child); err != nil {
@@ -362,23 +805,33 @@ func (h *ChildrenHandler) addDevice(w http.ResponseWriter, r *http.Request, id s
 	JSON(w, http.StatusOK, h.toChildResponse(child))
 }
 
-func (h *ChildrenHandler) removeDevice(w http.ResponseWriter, r *http.Request, id string) {
+// HandleRemoveDevice handles DELETE /api/children/{id}/devices/{mac}
+func (h *ChildrenHandler) HandleRemoveDevice(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
 	child := h.storage.GetChild(id)
 	if child == nil {
-		Error(w, http.StatusNotFound, "child not found")
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
 		return
 	}
 
-	mac := r.URL.Query().Get("mac")
+	// Legacy clients pass the MAC as a query parameter
+	mac := r.PathValue("mac")
+	if mac == "" {
+		mac = r.URL.Query().Get("mac")
+	}
 	if mac == "" {
-		Error(w, http.StatusBadRequest, "mac query parameter is required")
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgMACRequired)
+		return
+	}
+	if mac = parseMAC(mac); mac == "" {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgInvalidMAC)
 		return
 	}
 
 	// Remove device
 	newDevices := make([]models.Device, 0)
 	for _, d := range child.Devices {
-		if d.MAC != mac {
+		if normalizeMAC(d.MAC) != mac {
 			newDevices = append(newDevices, d)
 		}
 	}
@@ -392,3 +845,179 @@ func (h *ChildrenHandler) removeDevice(w http.ResponseWriter, r *http.Request, i
 
 	JSON(w, http.StatusOK, h.toChildResponse(child))
 }
+
+// HandleClearDevices handles DELETE /api/children/{id}/devices/all. Devices
+// online now are logged out first, so the child has to log in again and
+// each device is registered afresh.
+func (h *ChildrenHandler) HandleClearDevices(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	child := h.storage.GetChild(id)
+	if child == nil {
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
+		return
+	}
+
+	removed := make(map[string]bool, len(child.Devices))
+	for _, d := range child.Devices {
+		removed[d.MAC] = true
+	}
+	h.endDeviceSessions(child, removed)
+
+	child.Devices = []models.Device{}
+	child.UpdatedAt = time.Now()
+	if err := h.storage.SaveChild(child); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to remove devices")
+		return
+	}
+
+	JSON(w, http.StatusOK, h.toChildResponse(child))
+}
+
+// HandleReplaceDevices handles PUT /api/children/{id}/devices: the body is
+// the child's whole device list, as an array of {"mac", "name"}. Devices
+// already registered keep their history, and an empty name keeps theirs.
+// Devices left out are logged out if online. Nothing changes unless every
+// MAC is valid.
+func (h *ChildrenHandler) HandleReplaceDevices(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	child := h.storage.GetChild(id)
+	if child == nil {
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
+		return
+	}
+
+	var req []DeviceRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	devices := make([]models.Device, 0, len(req))
+	listed := make(map[string]bool, len(req))
+	for i, d := range req {
+		mac := parseMAC(d.MAC)
+		if mac == "" {
+			ErrorCode(w, http.StatusBadRequest, CodeValidation, fmt.Sprintf("device %d: %s", i, msgInvalidMAC))
+			return
+		}
+		if listed[mac] {
+			ErrorCode(w, http.StatusBadRequest, CodeValidation, fmt.Sprintf("device %d: %s is listed twice", i, mac))
+			return
+		}
+		listed[mac] = true
+
+		device := models.Device{MAC: mac, Name: d.Name, FirstSeen: time.Now(), Randomized: models.IsRandomizedMAC(mac)}
+		if existing := child.Device(mac); existing != nil {
+			device = *existing
+			if d.Name != "" {
+				device.Name = d.Name
+			}
+		}
+		devices = append(devices, device)
+	}
+
+	removed := make(map[string]bool)
+	for _, d := range child.Devices {
+		if !listed[normalizeMAC(d.MAC)] {
+			removed[d.MAC] = true
+		}
+	}
+	h.endDeviceSessions(child, removed)
+
+	child.Devices = devices
+	child.UpdatedAt = time.Now()
+	if err := h.storage.SaveChild(child); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to replace devices")
+		return
+	}
+
+	JSON(w, http.StatusOK, h.toChildResponse(child))
+}
+
+// endDeviceSessions deauths the child's active sessions on the given MACs
+func (h *ChildrenHandler) endDeviceSessions(child *models.Child, macs map[string]bool) {
+	for _, session := range h.storage.ListChildSessions(child.ID) {
+		if macs[session.MAC] {
+			h.ticker.EndSession(session, "device_removed")
+		}
+	}
+}
+
+// HandleApproveDevice handles POST /api/children/{id}/devices/{mac}/approve
+func (h *ChildrenHandler) HandleApproveDevice(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	child := h.storage.GetChild(id)
+	if child == nil {
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
+		return
+	}
+
+	device := child.Device(parseMAC(r.PathValue("mac")))
+	if device == nil {
+		ErrorCode(w, http.StatusNotFound, CodeDeviceNotFound, msgDeviceNotFound)
+		return
+	}
+
+	if device.Pending {
+		device.Pending = false
+		child.UpdatedAt = time.Now()
+		if err := h.storage.SaveChild(child); err != nil {
+			Error(w, http.StatusInternalServerError, msgSaveChildFailed)
+			return
+		}
+	}
+
+	JSON(w, http.StatusOK, h.toChildResponse(child))
+}
+
+// Private MAC devices not seen for this many days are removed by default
+const defaultStaleDeviceDays = 30
+
+// HandleCleanupDevices handles POST /api/children/{id}/devices/cleanup?days=30.
+// It removes private MAC devices that haven't logged in for the given number
+// of days: each time a phone forgets the network it comes back with a new
+// address, leaving the old entry behind. Devices online now are kept.
+func (h *ChildrenHandler) HandleCleanupDevices(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	child := h.storage.GetChild(id)
+	if child == nil {
+		ErrorCode(w, http.StatusNotFound, CodeChildNotFound, msgChildNotFound)
+		return
+	}
+
+	days := defaultStaleDeviceDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			ErrorCode(w, http.StatusBadRequest, CodeValidation, "days must be a positive number")
+			return
+		}
+		days = n
+	}
+
+	online := make(map[string]bool)
+	for _, s := range h.storage.ListChildSessions(child.ID) {
+		online[s.MAC] = true
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	kept := make([]models.Device, 0, len(child.Devices))
+	for _, d := range child.Devices {
+		if d.Randomized && !online[d.MAC] && d.LastActive().Before(cutoff) {
+			continue
+		}
+		kept = append(kept, d)
+	}
+
+	removed := len(child.Devices) - len(kept)
+	if removed > 0 {
+		child.Devices = kept
+		child.UpdatedAt = time.Now()
+		if err := h.storage.SaveChild(child); err != nil {
+			Error(w, http.StatusInternalServerError, "failed to remove devices")
+			return
+		}
+	}
+
+	JSON(w, http.StatusOK, map[string]int{"deleted": removed})
+}
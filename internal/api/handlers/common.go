@@ -2,8 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
 	"net/http"
 	"strings"
+	"time"
+
+	"parenta/internal/api/middleware"
 )
 
 // JSON sends a JSON response
@@ -13,34 +19,166 @@ func JSON(w http.ResponseWriter, status int, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
-// Error sends a JSON error response
+// ErrCode is a machine-readable error code. It is shared with the
+// middleware, which sends the auth and timeout errors.
+type ErrCode = middleware.ErrCode
+
+// Error codes. The generic ones follow the HTTP status; the others name a
+// specific failure.
+const (
+	CodeBadRequest       ErrCode = "BAD_REQUEST"
+	CodeUnauthorized             = middleware.CodeUnauthorized
+	CodeForbidden                = middleware.CodeForbidden
+	CodeNotFound         ErrCode = "NOT_FOUND"
+	CodeMethodNotAllowed ErrCode = "METHOD_NOT_ALLOWED"
+	CodeConflict         ErrCode = "CONFLICT"
+	CodePayloadTooLarge  ErrCode = "PAYLOAD_TOO_LARGE"
+	CodeRateLimited      ErrCode = "RATE_LIMITED"
+	CodeInternal                 = middleware.CodeInternal
+	CodeUnavailable              = middleware.CodeUnavailable
+
+	CodeInvalidBody        ErrCode = "INVALID_BODY"
+	CodeValidation         ErrCode = "VALIDATION_FAILED"
+	CodeWeakPassword       ErrCode = "WEAK_PASSWORD"
+	CodeInvalidCredentials ErrCode = "INVALID_CREDENTIALS"
+	CodeAccountLocked      ErrCode = "ACCOUNT_LOCKED"
+	CodeInvalidToken       ErrCode = "INVALID_TOKEN"
+	CodeSuperAdminRequired ErrCode = "SUPER_ADMIN_REQUIRED"
+	CodeReadOnly           ErrCode = "READ_ONLY"
+	CodeUsernameTaken      ErrCode = "USERNAME_TAKEN"
+	CodeChildNotFound      ErrCode = "CHILD_NOT_FOUND"
+	CodeQuotaExceeded      ErrCode = "QUOTA_EXCEEDED"
+	CodeOutsideSchedule    ErrCode = "OUTSIDE_SCHEDULE"
+	CodeBedtime            ErrCode = "BEDTIME"
+	CodeDataExceeded       ErrCode = "DATA_EXCEEDED"
+	CodeOnBreak            ErrCode = "ON_BREAK"
+	CodeInvalidVoucher     ErrCode = "INVALID_VOUCHER"
+	CodeNoActiveSession    ErrCode = "NO_ACTIVE_SESSION"
+	CodeInvalidFASPayload  ErrCode = "INVALID_FAS_PAYLOAD"
+	CodeDevicePending      ErrCode = "DEVICE_PENDING"
+	CodeDeviceNotFound     ErrCode = "DEVICE_NOT_FOUND"
+)
+
+// ErrorDetail is the body of every error response
+type ErrorDetail = middleware.ErrorDetail
+
+// ErrorCode sends a JSON error response: {"error":{"code":...,"message":...}}
+func ErrorCode(w http.ResponseWriter, status int, code ErrCode, message string) {
+	middleware.WriteError(w, status, code, message)
+}
+
+// Error sends a JSON error response with the generic code for the status
 func Error(w http.ResponseWriter, status int, message string) {
-	JSON(w, status, map[string]string{"error": message})
+	ErrorCode(w, status, statusCode(status), message)
 }
 
-// ParseJSON decodes JSON from request body
-func ParseJSON(r *http.Request, v interface{}) error {
-	return json.NewDecoder(r.Body).Decode(v)
+// statusCode returns the generic error code for an HTTP status
+func statusCode(status int) ErrCode {
+	switch status {
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeBadRequest
 }
 
-// ExtractID extracts the ID from a URL path like /api/children/{id}
-func ExtractID(path, prefix string) string {
-	path = strings.TrimPrefix(path, prefix)
-	path = strings.TrimPrefix(path, "/")
-	// Handle paths like "123/kick"
-	if idx := strings.Index(path, "/"); idx != -1 {
-		path = path[:idx]
+// JSONWithETag sends a JSON response with a weak ETag computed from the body.
+// If the client's If-None-Match already names that ETag, a bodyless 304 is
+// sent instead.
+func JSONWithETag(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to encode response")
+		return
 	}
-	return path
+
+	h := fnv.New64a()
+	h.Write(body)
+	etag := fmt.Sprintf(`W/"%x"`, h.Sum64())
+	w.Header().Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
 }
 
-// ExtractAction extracts action from URL like /api/sessions/{id}/kick
-func ExtractAction(path, prefix string) string {
-	path = strings.TrimPrefix(path, prefix)
-	path = strings.TrimPrefix(path, "/")
-	parts := strings.Split(path, "/")
-	if len(parts) >= 2 {
-		return parts[1]
+// etagMatches reports whether an If-None-Match header lists etag, using the
+// weak comparison If-None-Match calls for
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
 	}
-	return ""
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// NotModified sets the Last-Modified header and reports whether the client's
+// If-Modified-Since header shows it already has the current version. When it
+// returns true a 304 has been written and the handler should return.
+// If-Modified-Since is ignored when If-None-Match is sent, as RFC 9110
+// requires; JSONWithETag answers that one.
+func NotModified(w http.ResponseWriter, r *http.Request, modTime time.Time) bool {
+	if modTime.IsZero() {
+		return false
+	}
+
+	// HTTP dates have second precision
+	modTime = modTime.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+
+	since := r.Header.Get("If-Modified-Since")
+	if since == "" || r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	t, err := http.ParseTime(since)
+	if err != nil || modTime.After(t) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// clientIP returns the request's source IP without the port or an IPv6
+// zone, with IPv4-mapped addresses as plain IPv4
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := normalizeIP(host); ip != "" {
+		return ip
+	}
+	return host
+}
+
+// ParseJSON decodes JSON from request body
+func ParseJSON(r *http.Request, v interface{}) error {
+	return json.NewDecoder(r.Body).Decode(v)
 }
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	modTime := time.Date(2024, 3, 18, 16, 0, 0, 0, time.UTC)
+	current := modTime.Format(http.TimeFormat)
+	earlier := modTime.Add(-time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name        string
+		since       string
+		noneMatch   string
+		notModified bool
+	}{
+		{"no conditions", "", "", false},
+		{"current copy", current, "", true},
+		{"older copy", earlier, "", false},
+		{"bad date", "yesterday", "", false},
+		{"If-None-Match wins over a current date", current, `W/"stale"`, false},
+		{"If-None-Match wins even if it would match", current, "*", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/children", nil)
+			if tt.since != "" {
+				r.Header.Set("If-Modified-Since", tt.since)
+			}
+			if tt.noneMatch != "" {
+				r.Header.Set("If-None-Match", tt.noneMatch)
+			}
+			w := httptest.NewRecorder()
+
+			if got := NotModified(w, r, modTime); got != tt.notModified {
+				t.Fatalf("NotModified = %v, want %v", got, tt.notModified)
+			}
+			if tt.notModified && w.Code != http.StatusNotModified {
+				t.Errorf("status %d, want 304", w.Code)
+			}
+			if got := w.Header().Get("Last-Modified"); got != current {
+				t.Errorf("Last-Modified = %q, want %q", got, current)
+			}
+		})
+	}
+}
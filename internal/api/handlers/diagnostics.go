@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"parenta/internal/services"
+)
+
+// DiagnosticsHandler serves the router's network and process state as
+// structured data, in place of running commands from the dashboard
+type DiagnosticsHandler struct {
+	diag *services.Diagnostics
+}
+
+// NewDiagnosticsHandler creates a new DiagnosticsHandler
+func NewDiagnosticsHandler(diag *services.Diagnostics) *DiagnosticsHandler {
+	return &DiagnosticsHandler{diag: diag}
+}
+
+// HandleInterfaces handles GET /api/diagnostics/interfaces
+func (h *DiagnosticsHandler) HandleInterfaces(w http.ResponseWriter, r *http.Request) {
+	ifaces, err := h.diag.Interfaces()
+	respondDiagnostic(w, ifaces, err)
+}
+
+// HandleRoutes handles GET /api/diagnostics/routes
+func (h *DiagnosticsHandler) HandleRoutes(w http.ResponseWriter, r *http.Request) {
+	routes, err := h.diag.Routes()
+	respondDiagnostic(w, routes, err)
+}
+
+// HandleWireless handles GET /api/diagnostics/wireless
+func (h *DiagnosticsHandler) HandleWireless(w http.ResponseWriter, r *http.Request) {
+	ifaces, err := h.diag.Wireless()
+	respondDiagnostic(w, ifaces, err)
+}
+
+// HandleProcesses handles GET /api/diagnostics/processes
+func (h *DiagnosticsHandler) HandleProcesses(w http.ResponseWriter, r *http.Request) {
+	procs, err := h.diag.Processes()
+	respondDiagnostic(w, procs, err)
+}
+
+// respondDiagnostic sends a diagnostic's result, or a 503 if the tool it
+// needs is missing
+func respondDiagnostic(w http.ResponseWriter, result any, err error) {
+	switch {
+	case errors.Is(err, services.ErrDiagnosticUnavailable):
+		Error(w, http.StatusServiceUnavailable, err.Error())
+	case err != nil:
+		log.Printf("Diagnostics failed: %v", err)
+		Error(w, http.StatusInternalServerError, err.Error())
+	default:
+		JSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import "math"
+
+// DiskStats is the space on the filesystem holding a path
+type DiskStats struct {
+	Path        string  `json:"path"`
+	TotalMB     uint64  `json:"total_mb"`
+	UsedMB      uint64  `json:"used_mb"`
+	FreeMB      uint64  `json:"free_mb"`
+	PercentUsed float64 `json:"percent_used"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// newDiskStats builds DiskStats from byte counts. Like df, the percentage
+// is of the space usable by unprivileged users, so reserved blocks count
+// as neither used nor free.
+func newDiskStats(path string, total, used, avail uint64) DiskStats {
+	const mb = 1024 * 1024
+	stats := DiskStats{
+		Path:    path,
+		TotalMB: total / mb,
+		UsedMB:  used / mb,
+		FreeMB:  avail / mb,
+	}
+	if used+avail > 0 {
+		stats.PercentUsed = math.Round(float64(used)/float64(used+avail)*1000) / 10
+	}
+	return stats
+}
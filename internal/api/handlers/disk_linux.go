@@ -0,0 +1,18 @@
+//go:build linux
+
+package handlers
+
+import "syscall"
+
+// diskUsage reads the filesystem stats for path with statfs
+func diskUsage(path string) (DiskStats, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return DiskStats{}, err
+	}
+
+	bsize := uint64(stat.Bsize)
+	total := stat.Blocks * bsize
+	used := (stat.Blocks - stat.Bfree) * bsize
+	return newDiskStats(path, total, used, stat.Bavail*bsize), nil
+}
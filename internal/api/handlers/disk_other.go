@@ -0,0 +1,38 @@
+//go:build !linux
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// diskUsage reads the filesystem stats for path from POSIX df output
+func diskUsage(path string) (DiskStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "df", "-kP", path).Output()
+	if err != nil {
+		return DiskStats{}, err
+	}
+
+	// Filesystem 1024-blocks Used Available Capacity Mounted-on
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 6 {
+		return DiskStats{}, fmt.Errorf("unexpected df output")
+	}
+
+	var kb [3]uint64
+	for i := range kb {
+		if kb[i], err = strconv.ParseUint(fields[i+1], 10, 64); err != nil {
+			return DiskStats{}, fmt.Errorf("unexpected df output: %w", err)
+		}
+	}
+	return newDiskStats(path, kb[0]*1024, kb[1]*1024, kb[2]*1024), nil
+}
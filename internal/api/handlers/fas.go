@@ -2,13 +2,16 @@ package handlers
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
-	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"parenta/internal/api/middleware"
@@ -21,26 +24,48 @@ import (
 // FASHandler handles OpenNDS FAS authentication endpoints
 type FASHandler struct {
 	storage *storage.Storage
-	ndsctl  *services.NDSCtl
+	ndsPool *services.NDSCtlPool
 	authSvc *services.AuthService
 	config  *config.Config
 	auth    *middleware.AuthMiddleware
+
+	// Told about new devices and failed logins
+	notifier *services.Notifier
+
+	// Serializes session creation so two quick logins from one device
+	// can't both create a session
+	sessionMu sync.Mutex
+
+	// Lets portal clients check their status without their password
+	portalTokens *services.PortalTokens
+
+	// Verified level 2/3 FAS payloads that portal logins are bound to
+	handshakes *services.FASHandshakes
+
+	// Recent logins by authDedupKey, so a double submit gets the first
+	// response instead of a second session (*authResult)
+	authDedup sync.Map
 }
 
 // NewFASHandler creates a new FASHandler
 func NewFASHandler(
 	store *storage.Storage,
-	ndsctl *services.NDSCtl,
+	ndsPool *services.NDSCtlPool,
 	authSvc *services.AuthService,
 	cfg *config.Config,
 	auth *middleware.AuthMiddleware,
+	notifier *services.Notifier,
 ) *FASHandler {
 	return &FASHandler{
-		storage: store,
-		ndsctl:  ndsctl,
-		authSvc: authSvc,
-		config:  cfg,
-		auth:    auth,
+		storage:  store,
+		ndsPool:  ndsPool,
+		authSvc:  authSvc,
+		config:   cfg,
+		auth:     auth,
+		notifier: notifier,
+
+		portalTokens: services.NewPortalTokens(),
+		handshakes:   services.NewFASHandshakes(),
 	}
 }
 
@@ -51,27 +76,11 @@ type FASData struct {
 	ClientMAC   string
 	GatewayName string
 	GatewayHash string
+	GatewayAddr string
 	AuthDir     string
 	OriginURL   string
 }
 
-// getMACFromIP pulls the MAC address from the router's ARP table
-func getMACFromIP(ip string) string {
-	data, err := os.ReadFile("/proc/net/arp")
-	if err != nil {
-		return ""
-	}
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		// /proc/net/arp format: IP address, HW type, Flags, HW address, Mask, Device
-		if len(fields) >= 4 && fields[0] == ip {
-			return fields[3]
-		}
-	}
-	return ""
-}
-
 // HandleFAS handles the initial FAS redirect from openNDS
 func (h *FASHandler) HandleFAS(w http.ResponseWriter, r *http.Request) {
 	fasParam := r.URL.Query().Get("fas")
@@ -84,39 +93,28 @@ func (h *FASHandler) HandleFAS(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("FAS: Raw fas param (first 200 chars): %.200s", fasParam)
 
-	// 1. Normalize base64: URL query params turn '+' into spaces
-	fasParam = strings.ReplaceAll(fasParam, " ", "+")
-
-	// 2. Strip any padding issues and try multiple decode strategies
-	var decodedBytes []byte
-	var err error
-
-	// Try standard base64 first
-	decodedBytes, err = base64.StdEncoding.DecodeString(fasParam)
-	if err != nil {
-		// Try with padding fixed
-		padded := fasParam
-		if m := len(padded) % 4; m != 0 {
-			padded += strings.Repeat("=", 4-m)
+	// 1-2. Decrypt (fas_secure_enabled 2/3) or decode (0/1) the payload
+	var rawString string
+	secure := h.secureFAS()
+	if secure {
+		plain, err := services.DecryptFASPayload(fasParam, r.URL.Query().Get("iv"), h.config.OpenNDS.FASKey)
+		if err != nil {
+			log.Printf("FAS: Rejected payload that does not decrypt with the faskey")
+			ErrorCode(w, http.StatusForbidden, CodeInvalidFASPayload, "invalid FAS payload")
+			return
 		}
-		decodedBytes, err = base64.StdEncoding.DecodeString(padded)
+		rawString = plain
+	} else {
+		decodedBytes, err := decodeFASBase64(fasParam)
 		if err != nil {
-			// Try URL-safe encoding
-			decodedBytes, err = base64.URLEncoding.DecodeString(fasParam)
-			if err != nil {
-				// Try RawStdEncoding (no padding)
-				decodedBytes, err = base64.RawStdEncoding.DecodeString(fasParam)
-				if err != nil {
-					log.Printf("FAS: All base64 decode attempts failed: %v", err)
-					http.Redirect(w, r, "/portal", http.StatusFound)
-					return
-				}
-			}
+			log.Printf("FAS: All base64 decode attempts failed: %v", err)
+			http.Redirect(w, r, "/portal", http.StatusFound)
+			return
 		}
+		rawString = string(decodedBytes)
 	}
 
-	// 3. Convert to string and clean thoroughly
-	rawString := string(decodedBytes)
+	// 3. Clean the decoded string thoroughly
 
 	// Strip null bytes and all non-printable characters
 	rawString = strings.Map(func(r rune) rune {
@@ -139,17 +137,30 @@ func (h *FASHandler) HandleFAS(w http.ResponseWriter, r *http.Request) {
 	// 4. Parse the cleaned string
 	fasData := h.parseFASData(rawString)
 
+	// An encrypted payload must come from a known gateway. The config
+	// requires gateway_hashes at these levels, so none known rejects all.
+	if secure {
+		if _, ok := h.config.OpenNDS.GatewayHashes[fasData.GatewayHash]; !ok || fasData.GatewayHash == "" {
+			log.Printf("FAS: Rejected payload from unknown gatewayhash %q", fasData.GatewayHash)
+			ErrorCode(w, http.StatusForbidden, CodeInvalidFASPayload, "unknown gateway")
+			return
+		}
+	}
+
 	// 5. Fallback for MAC address via ARP if parsing failed or was missing
 	if fasData.ClientMAC == "" {
-		clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
 		if fasData.ClientIP == "" {
-			fasData.ClientIP = clientIP
+			fasData.ClientIP = clientIP(r)
 		}
 		fasData.ClientMAC = getMACFromIP(fasData.ClientIP)
-		log.Printf("FAS: Auto-discovered MAC %s for IP %s via ARP", fasData.ClientMAC, fasData.ClientIP)
+		log.Printf("FAS: Auto-discovered MAC %s for IP %s via the neighbor table", fasData.ClientMAC, fasData.ClientIP)
 	}
 
-	// 6. Normalize MAC
+	// 6. Normalize MAC, dropping one that isn't a MAC so it never reaches ndsctl
+	if fasData.ClientMAC != "" && !models.IsValidMAC(fasData.ClientMAC) {
+		log.Printf("FAS: ignoring malformed client MAC %q", fasData.ClientMAC)
+		fasData.ClientMAC = ""
+	}
 	fasData.ClientMAC = normalizeMAC(fasData.ClientMAC)
 
 	// 7. URL-decode values that OpenNDS may have percent-encoded inside the base64 payload
@@ -164,9 +175,41 @@ func (h *FASHandler) HandleFAS(w http.ResponseWriter, r *http.Request) {
 	fasData.GatewayName = sanitizeHeaderValue(fasData.GatewayName)
 	fasData.AuthDir = sanitizeHeaderValue(fasData.AuthDir)
 	fasData.OriginURL = sanitizeHeaderValue(fasData.OriginURL)
+	fasData.GatewayAddr = sanitizeHeaderValue(fasData.GatewayAddr)
+
+	// Pick the gateway this client came through (multi-gateway setups)
+	gatewayIP := h.config.OpenNDS.SelectGateway(fasData.GatewayHash, fasData.GatewayAddr)
 
-	log.Printf("FAS Parsed: hid=%s mac=%s ip=%s gw=%s originurl=%s",
-		fasData.HID, fasData.ClientMAC, fasData.ClientIP, fasData.GatewayName, fasData.OriginURL)
+	log.Printf("FAS Parsed: hid=%s mac=%s ip=%s gw=%s gwip=%s originurl=%s",
+		fasData.HID, fasData.ClientMAC, fasData.ClientIP, fasData.GatewayName, gatewayIP, fasData.OriginURL)
+
+	// Bind the portal login to this payload, refusing one already used
+	if secure {
+		err := services.ErrFASUnknownHID
+		if fasData.HID != "" {
+			err = h.handshakes.Record(services.FASHandshake{
+				HID:            fasData.HID,
+				ClientMAC:      fasData.ClientMAC,
+				ClientIP:       fasData.ClientIP,
+				GatewayName:    fasData.GatewayName,
+				GatewayHash:    fasData.GatewayHash,
+				GatewayAddress: fasData.GatewayAddr,
+				AuthDir:        fasData.AuthDir,
+			})
+		}
+		if err != nil {
+			log.Printf("FAS: Rejected payload for hid %q: %v", fasData.HID, err)
+			ErrorCode(w, http.StatusForbidden, CodeInvalidFASPayload, "invalid FAS payload")
+			return
+		}
+	}
+
+	// Devices on the preauth list go straight online without the portal
+	if fasData.ClientMAC != "" && h.storage.GetPreAuthDevice(fasData.ClientMAC) != nil {
+		if h.preauthBypass(w, r, fasData, gatewayIP) {
+			return
+		}
+	}
 
 	// 9. Safely construct the redirect URL using url.Values
 	redirectParams := url.Values{}
@@ -174,11 +217,14 @@ func (h *FASHandler) HandleFAS(w http.ResponseWriter, r *http.Request) {
 	redirectParams.Set("mac", fasData.ClientMAC)
 	redirectParams.Set("ip", fasData.ClientIP)
 	redirectParams.Set("gatewayname", fasData.GatewayName)
+	redirectParams.Set("gatewayhash", fasData.GatewayHash)
 	redirectParams.Set("authdir", fasData.AuthDir)
 	redirectParams.Set("originurl", fasData.OriginURL)
+	redirectParams.Set("gatewayip", gatewayIP)
+	redirectParams.Set("gatewayaddress", fasData.GatewayAddr)
 
 	portalURL := fmt.Sprintf("http://%s:%v/portal?%s",
-		h.config.OpenNDS.GatewayIP,
+		gatewayIP,
 		h.config.Server.Port,
 		redirectParams.Encode(),
 	)
@@ -189,6 +235,163 @@ func (h *FASHandler) HandleFAS(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, portalURL, http.StatusFound)
 }
 
+// preauthBypass authenticates a preauth device with unlimited access and
+// sends it on to the page it asked for. Reports false if openNDS refused,
+// in which case the device gets the portal as usual.
+func (h *FASHandler) preauthBypass(w http.ResponseWriter, r *http.Request, fasData FASData, gatewayIP string) bool {
+	if err := h.ndsPool.Get(gatewayIP).Auth(fasData.ClientMAC, 0, 0, 0); err != nil {
+		log.Printf("FAS: Preauth bypass for %s failed, showing the portal: %v", fasData.ClientMAC, err)
+		return false
+	}
+	if h.secureFAS() {
+		h.handshakes.Consume(fasData.HID)
+	}
+	log.Printf("FAS: Preauth device %s (IP: %s) bypassed the portal on gateway %s", fasData.ClientMAC, fasData.ClientIP, gatewayIP)
+
+	// Only send the browser on to real web pages
+	target := h.successURL(gatewayIP, "", "")
+	if u, err := url.Parse(fasData.OriginURL); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		target = u.String()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+	return true
+}
+
+// successURL returns the portal success page on the gateway for the device
+// with the given MAC. The origin URL goes in as a query value whatever
+// query string of its own it has; "null", which openNDS sends when it has
+// none, is left out.
+func (h *FASHandler) successURL(gatewayIP, mac, originURL string) string {
+	params := url.Values{}
+	if mac != "" {
+		params.Set("mac", mac)
+	}
+	if originURL != "" && originURL != "null" {
+		params.Set("originurl", originURL)
+	}
+	u := url.URL{
+		Scheme:   "http",
+		Host:     net.JoinHostPort(gatewayIP, strconv.Itoa(h.config.Server.Port)),
+		Path:     "/portal/success",
+		RawQuery: params.Encode(),
+	}
+	return u.String()
+}
+
+// decodeFASBase64 decodes a level 0/1 fas parameter, tolerating the padding
+// and alphabet variations seen from different openNDS versions
+func decodeFASBase64(fasParam string) ([]byte, error) {
+	// Normalize base64: URL query params turn '+' into spaces
+	fasParam = strings.ReplaceAll(fasParam, " ", "+")
+
+	// Try standard base64 first
+	decoded, err := base64.StdEncoding.DecodeString(fasParam)
+	if err == nil {
+		return decoded, nil
+	}
+
+	// Try with padding fixed
+	padded := fasParam
+	if m := len(padded) % 4; m != 0 {
+		padded += strings.Repeat("=", 4-m)
+	}
+	if decoded, err = base64.StdEncoding.DecodeString(padded); err == nil {
+		return decoded, nil
+	}
+
+	// Try URL-safe encoding
+	if decoded, err = base64.URLEncoding.DecodeString(fasParam); err == nil {
+		return decoded, nil
+	}
+
+	// Try RawStdEncoding (no padding)
+	return base64.RawStdEncoding.DecodeString(fasParam)
+}
+
+// secureFAS reports whether FAS payloads are encrypted (levels 2 and 3)
+func (h *FASHandler) secureFAS() bool {
+	return h.config.OpenNDS.FASSecureEnabled >= services.FASLevelEncrypted
+}
+
+// bindHandshake replaces what a level 2/3 login form says about the client
+// with what its verified FAS payload said. A login without a hid, as from
+// ARP rescue, keeps no client details at all, so the MAC can only come
+// from the neighbor tables for the address it connected from.
+func (h *FASHandler) bindHandshake(req *AuthRequest) error {
+	req.GatewayIP = ""
+	if req.HID == "" {
+		req.MAC, req.IP = "", ""
+		req.GatewayName, req.GatewayHash, req.GatewayAddress, req.AuthDir = "", "", "", ""
+		return nil
+	}
+
+	hs, err := h.handshakes.Lookup(req.HID)
+	if err != nil && !errors.Is(err, services.ErrFASUsedHID) {
+		return err
+	}
+	req.MAC, req.IP = hs.ClientMAC, hs.ClientIP
+	req.GatewayName, req.GatewayHash, req.GatewayAddress, req.AuthDir = hs.GatewayName, hs.GatewayHash, hs.GatewayAddress, hs.AuthDir
+	return err
+}
+
+// openNDSAuthURL returns the openNDS FAS return URL that completes the
+// client's login, with openNDS sending the browser on to redir, or "" if the
+// request lacks what it needs
+func (h *FASHandler) openNDSAuthURL(req AuthRequest, redir string) string {
+	if req.HID == "" || req.GatewayAddress == "" || req.AuthDir == "" || h.config.OpenNDS.FASKey == "" {
+		return ""
+	}
+
+	params := url.Values{}
+	params.Set("tok", services.FASReturnHash(req.HID, h.config.OpenNDS.FASKey))
+	if redir != "" && redir != "null" {
+		params.Set("redir", redir)
+	}
+	return fmt.Sprintf("http://%s/%s/?%s", req.GatewayAddress, strings.Trim(req.AuthDir, "/"), params.Encode())
+}
+
+// grantAccess lets the client online for the given number of minutes (0 for
+// the openNDS default). When the FAS handshake can be completed it returns
+// the openNDS return URL, and the browser must be sent there. Otherwise, as
+// for ARP-rescue logins without a hid, the MAC is authed with ndsctl.
+// Neither a session timeout nor bandwidth limits (0 = unlimited) can be
+// passed through the handshake, so a client with a known MAC is authed with
+// ndsctl whenever one applies, and both paths give it the same timeout.
+// Without a MAC the handshake is still used, and the session ticker ends
+// the session when its time runs out.
+//
+// At FAS level 2/3 the hid is used up once access is granted.
+func (h *FASHandler) grantAccess(ndsctl services.NDSClient, req AuthRequest, minutes, uploadKbps, downloadKbps int, redir string) (string, error) {
+	authURL, err := h.authorize(ndsctl, req, minutes, uploadKbps, downloadKbps, redir)
+	if err == nil && req.HID != "" && h.secureFAS() {
+		h.handshakes.Consume(req.HID)
+	}
+	return authURL, err
+}
+
+// authorize grants access by the handshake or ndsctl, as grantAccess says
+func (h *FASHandler) authorize(ndsctl services.NDSClient, req AuthRequest, minutes, uploadKbps, downloadKbps int, redir string) (string, error) {
+	custom := minutes > 0 || uploadKbps > 0 || downloadKbps > 0
+	if !custom || req.MAC == "" {
+		if authURL := h.openNDSAuthURL(req, redir); authURL != "" {
+			return authURL, nil
+		}
+	}
+	if req.MAC == "" {
+		return "", nil
+	}
+
+	// Deauth a client openNDS already knows first, so it applies the new
+	// session timeout. If the client list can't be read, deauth anyway.
+	if client, err := ndsctl.GetClient(req.MAC); err != nil || client != nil {
+		if err := ndsctl.Deauth(req.MAC); err != nil {
+			log.Printf("Pre-deauth failed for MAC %s: %v", req.MAC, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return "", ndsctl.Auth(req.MAC, minutes, uploadKbps, downloadKbps)
+}
+
 // safeURLUnescape decodes percent-encoded strings, returning original on error
 func safeURLUnescape(s string) string {
 	if unescaped, err := url.QueryUnescape(s); err == nil {
@@ -243,6 +446,8 @@ func (h *FASHandler) parseFASData(data string) FASData {
 			fas.GatewayName = value
 		case "gatewayhash":
 			fas.GatewayHash = value
+		case "gatewayaddress":
+			fas.GatewayAddr = value
 		case "authdir":
 			fas.AuthDir = value
 		case "originurl":
@@ -261,6 +466,47 @@ type AuthRequest struct {
 	IP        string `json:"ip"`
 	AuthDir   string `json:"authdir"`
 	OriginURL string `json:"originurl"`
+	GatewayIP string `json:"gatewayip"`
+
+	// Passed through from the FAS payload so sessions record their gateway
+	GatewayName string `json:"gatewayname"`
+	GatewayHash string `json:"gatewayhash"`
+
+	// openNDS address (ip:port) from the FAS payload, for /opennds_auth/
+	GatewayAddress string `json:"gatewayaddress"`
+
+	// Guest code, used instead of a username and password
+	Voucher string `json:"voucher"`
+
+	// IPv6 address of a dual-stack or IPv6-only client. IP keeps the IPv4
+	// address openNDS knows the client by.
+	IPv6 string `json:"-"`
+
+	// Classified from the User-Agent header, if one was sent
+	Client *models.ClientInfo `json:"-"`
+}
+
+// resolveAddresses sorts the client's addresses by family. The FAS payload
+// gives the IP openNDS sees; the address the browser connected from fills
+// in whichever family is still missing.
+func (req *AuthRequest) resolveAddresses(remote string) {
+	if ip := normalizeIP(req.IP); strings.Contains(ip, ":") {
+		req.IP, req.IPv6 = "", ip
+	} else {
+		req.IP = ip
+	}
+
+	remote = normalizeIP(remote)
+	if remote == "" || net.ParseIP(remote).IsLoopback() {
+		return
+	}
+	if strings.Contains(remote, ":") {
+		if req.IPv6 == "" {
+			req.IPv6 = remote
+		}
+	} else if req.IP == "" {
+		req.IP = remote
+	}
 }
 
 // HandleAuth processes login from captive portal (supports both admin and child)
@@ -281,7 +527,7 @@ func (h *FASHandler) HandleAuth(w http.ResponseWriter, r *http.Request) {
 
 	if isJSON {
 		if err := ParseJSON(r, &req); err != nil {
-			Error(w, http.StatusBadRequest, "invalid request")
+			ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, portalCatalog(h.storage, r).T("error.invalid_request"))
 			return
 		}
 	} else {
@@ -295,63 +541,145 @@ func (h *FASHandler) HandleAuth(w http.ResponseWriter, r *http.Request) {
 			IP:       r.FormValue("ip"),
 			AuthDir:   r.FormValue("authdir"),
 			OriginURL: r.FormValue("originurl"),
+			GatewayIP: r.FormValue("gatewayip"),
+
+			GatewayName:    r.FormValue("gatewayname"),
+			GatewayHash:    r.FormValue("gatewayhash"),
+			GatewayAddress: r.FormValue("gatewayaddress"),
+			Voucher:        r.FormValue("voucher"),
 		}
 	}
 
-	// Auto-discover MAC via ARP if missing (Plug & Play Rescue)
-	if req.MAC == "" {
-		clientIP := req.IP
-		if clientIP == "" {
-			clientIP, _, _ = net.SplitHostPort(r.RemoteAddr)
+	// At FAS level 2/3 only the verified payload is trusted about the client.
+	// A used hid is refused below, unless it is a double submit.
+	var hidErr error
+	if h.secureFAS() {
+		hidErr = h.bindHandshake(&req)
+		if hidErr != nil && !errors.Is(hidErr, services.ErrFASUsedHID) {
+			log.Printf("Auth: Rejected login for hid %q: %v", req.HID, hidErr)
+			h.portalError(w, r, AuthRequest{OriginURL: req.OriginURL}, isJSON, http.StatusForbidden, "portal_expired")
+			return
 		}
-		req.MAC = getMACFromIP(clientIP)
-		if req.MAC != "" {
-			log.Printf("Auth: Auto-discovered MAC %s for IP %s via ARP", req.MAC, clientIP)
+	}
+
+	// Route ndsctl calls to the gateway the client came through
+	gatewayAddr := req.GatewayIP
+	if gatewayAddr == "" {
+		gatewayAddr = r.Host
+	}
+	gatewayIP := h.config.OpenNDS.SelectGateway(req.GatewayHash, gatewayAddr)
+	ndsctl := h.ndsPool.Get(gatewayIP)
+	req.GatewayIP = gatewayIP
+
+	req.resolveAddresses(clientIP(r))
+	if client := services.ClassifyUserAgent(r.UserAgent()); client.UserAgent != "" {
+		req.Client = &client
+	}
+
+	// Auto-discover MAC from the neighbor tables if missing (Plug & Play Rescue)
+	if req.MAC == "" {
+		for _, ip := range []string{req.IP, req.IPv6} {
+			if ip == "" {
+				continue
+			}
+			if req.MAC = getMACFromIP(ip); req.MAC != "" {
+				log.Printf("Auth: Auto-discovered MAC %s for IP %s", req.MAC, ip)
+				break
+			}
 		}
 	}
 
 	// Validate MAC format if provided
 	if req.MAC != "" {
+		if !models.IsValidMAC(req.MAC) {
+			h.portalError(w, r, req, isJSON, http.StatusBadRequest, "invalid_mac")
+			return
+		}
 		req.MAC = normalizeMAC(req.MAC)
 	}
 
-	// Try admin authentication first
-	admin, err := h.authSvc.AuthenticateAdmin(req.Username, req.Password)
-	if err == nil {
+	// Answer repeated submissions from one device with the first response
+	if key := authDedupKey(req); key != "" {
+		prev, result := h.beginAuth(r.Context(), key)
+		if prev != nil {
+			log.Printf("Auth: replaying the response to a duplicate login from MAC %s", req.MAC)
+			prev.replay(w)
+			return
+		}
+		if result == nil {
+			return
+		}
+		rec := &authRecorder{ResponseWriter: w}
+		defer h.finishAuth(result, rec)
+		w = rec
+	}
+
+	if hidErr != nil {
+		log.Printf("Auth: Rejected login for hid %q: %v", req.HID, hidErr)
+		h.portalError(w, r, AuthRequest{OriginURL: req.OriginURL}, isJSON, http.StatusForbidden, "portal_expired")
+		return
+	}
+
+	// Guests redeem a voucher instead of logging in
+	if req.Voucher != "" {
+		h.handleVoucherAuth(w, r, req, isJSON, ndsctl)
+		return
+	}
+
+	// Try admin authentication first. Only admin usernames go through the
+	// lockout counters so children's typos don't lock out the device.
+	var admin *models.User
+	if h.storage.GetAdminByUsername(req.Username) != nil {
+		var err error
+		admin, err = h.authSvc.AuthenticateAdmin(req.Username, req.Password, loginAttempt(r))
+		if errors.Is(err, services.ErrAccountLocked) {
+			h.portalError(w, r, req, isJSON, http.StatusTooManyRequests, "account_locked")
+			return
+		}
+	}
+	if admin != nil {
 		// Admin success - generate JWT
-		token, err := h.auth.GenerateToken(admin.ID, admin.Username, true, h.config.Session.JWTExpiryHours)
+		tokens, err := issueAdminTokens(h.auth, h.authSvc, h.config, admin, loginAttempt(r))
 		if err != nil {
 			log.Printf("Failed to generate token for admin %s: %v", admin.Username, err)
-			Error(w, http.StatusInternalServerError, "authentication error")
+			h.portalError(w, r, req, isJSON, http.StatusInternalServerError, "auth_failed")
 			return
 		}
+		setSessionCookies(w, r, h.auth, h.config, &tokens)
 
-		// Grant internet access via OpenNDS if MAC provided
-		if req.MAC != "" {
-			if err := h.ndsctl.Deauth(req.MAC); err != nil {
-				log.Printf("Pre-deauth failed for MAC %s: %v", req.MAC, err)
-			}
-			time.Sleep(100 * time.Millisecond)
+		// Tokens never go in the URL; the form flow relies on the session cookie
+		redirectURL := fmt.Sprintf("/portal?auth_type=admin&force_password_change=%t", admin.ForcePasswordChange)
 
-			if err := h.ndsctl.Auth(req.MAC, 0, 0, 0); err != nil {
-				log.Printf("ndsctl auth failed for admin %s (MAC: %s): %v", admin.Username, req.MAC, err)
-			} else {
-				log.Printf("Admin %s authenticated on MAC %s with unlimited access", admin.Username, req.MAC)
-			}
-		} else {
+		// Grant internet access via OpenNDS
+		portalURL := fmt.Sprintf("http://%s:%d%s", gatewayIP, h.config.Server.Port, redirectURL)
+		authURL, err := h.grantAccess(ndsctl, req, 0, 0, 0, portalURL)
+		switch {
+		case authURL != "":
+			log.Printf("Admin %s will be authenticated by openNDS at %s", admin.Username, req.GatewayAddress)
+		case req.MAC == "":
 			log.Printf("Admin %s logged in without MAC (dashboard only)", admin.Username)
+		case err != nil:
+			log.Printf("ndsctl auth failed for admin %s (MAC: %s): %v", admin.Username, req.MAC, err)
+		default:
+			log.Printf("Admin %s authenticated on MAC %s with unlimited access", admin.Username, req.MAC)
 		}
 
 		if isJSON {
-			JSON(w, http.StatusOK, map[string]interface{}{
+			resp := map[string]interface{}{
 				"type":                  "admin",
-				"token":                 token,
-				"expires_in":            h.config.Session.JWTExpiryHours * 3600,
+				"token":                 tokens.Token,
+				"expires_in":            tokens.ExpiresIn,
+				"refresh_token":         tokens.RefreshToken,
+				"csrf_token":            tokens.CSRFToken,
 				"force_password_change": admin.ForcePasswordChange,
-			})
+			}
+			if authURL != "" {
+				resp["auth_url"] = authURL
+			}
+			JSON(w, http.StatusOK, resp)
+		} else if authURL != "" {
+			http.Redirect(w, r, authURL, http.StatusFound)
 		} else {
-			redirectURL := fmt.Sprintf("/portal?auth_type=admin&token=%s&force_password_change=%t",
-				url.QueryEscape(token), admin.ForcePasswordChange)
 			http.Redirect(w, r, redirectURL, http.StatusFound)
 		}
 		return
@@ -361,14 +689,14 @@ func (h *FASHandler) HandleAuth(w http.ResponseWriter, r *http.Request) {
 	child, err := h.authSvc.AuthenticateChild(req.Username, req.Password)
 	if err != nil {
 		log.Printf("Failed login attempt for username: %s from IP: %s MAC: %s", req.Username, req.IP, req.MAC)
-		if isJSON {
-			Error(w, http.StatusUnauthorized, "Invalid username or password")
-		} else {
-			errorURL := fmt.Sprintf("/portal?hid=%s&mac=%s&ip=%s&authdir=%s&originurl=%s&error=%s",
-				url.QueryEscape(req.HID), url.QueryEscape(req.MAC), url.QueryEscape(req.IP),
-				url.QueryEscape(req.AuthDir), url.QueryEscape(req.OriginURL), url.QueryEscape("Invalid username or password"))
-			http.Redirect(w, r, errorURL, http.StatusFound)
+		device := req.MAC
+		if device == "" {
+			device = req.IP
+		}
+		if device != "" {
+			h.notifier.LoginFailed(device, req.Username)
 		}
+		h.portalError(w, r, req, isJSON, http.StatusUnauthorized, "invalid_credentials")
 		return
 	}
 
@@ -376,36 +704,217 @@ func (h *FASHandler) HandleAuth(w http.ResponseWriter, r *http.Request) {
 
 	if child.RemainingMinutes() <= 0 {
 		log.Printf("Child %s denied: no time remaining", child.Name)
-		if isJSON {
-			Error(w, http.StatusForbidden, "No time remaining for today")
-		} else {
-			errorURL := fmt.Sprintf("/portal?hid=%s&mac=%s&ip=%s&authdir=%s&originurl=%s&error=%s",
-				url.QueryEscape(req.HID), url.QueryEscape(req.MAC), url.QueryEscape(req.IP),
-				url.QueryEscape(req.AuthDir), url.QueryEscape(req.OriginURL), url.QueryEscape("No time remaining for today"))
-			http.Redirect(w, r, errorURL, http.StatusFound)
-		}
+		h.portalError(w, r, req, isJSON, http.StatusForbidden, "no_time_remaining")
+		return
+	}
+
+	if child.DataExceeded() {
+		log.Printf("Child %s denied: data cap used up", child.Name)
+		h.portalError(w, r, req, isJSON, http.StatusForbidden, "data_exceeded")
 		return
 	}
 
 	if child.ScheduleID != "" {
 		schedule := h.storage.GetSchedule(child.ScheduleID)
 		if schedule != nil && !schedule.IsAllowedNow() {
-			if isJSON {
-				Error(w, http.StatusForbidden, "Internet access not allowed at this time")
-			} else {
-				errorURL := fmt.Sprintf("/portal?hid=%s&mac=%s&ip=%s&authdir=%s&originurl=%s&error=%s",
-					url.QueryEscape(req.HID), url.QueryEscape(req.MAC), url.QueryEscape(req.IP),
-					url.QueryEscape(req.AuthDir), url.QueryEscape(req.OriginURL), url.QueryEscape("Internet access not allowed at this time"))
-				http.Redirect(w, r, errorURL, http.StatusFound)
+			h.portalError(w, r, req, isJSON, http.StatusForbidden, "outside_schedule")
+			return
+		}
+	}
+
+	if child.InBedtime(time.Now()) {
+		log.Printf("Child %s denied: bedtime", child.Name)
+		h.portalError(w, r, req, isJSON, http.StatusForbidden, "bedtime")
+		return
+	}
+
+	if until := child.BreakEndsAt(h.storage.ListSessionsByChildID(child.ID)); time.Now().Before(until) {
+		log.Printf("Child %s denied: on a break until %s", child.Name, until.Format("15:04"))
+		h.portalError(w, r, req, isJSON, http.StatusForbidden, "on_break")
+		return
+	}
+
+	// Without openNDS no internet can be granted, so don't report a false success
+	if req.MAC != "" && !ndsctl.IsRunning() {
+		log.Printf("Child %s login refused: openNDS is not running", child.Name)
+		h.portalError(w, r, req, isJSON, http.StatusServiceUnavailable, "service_unavailable")
+		return
+	}
+
+	privateMAC := false
+	if req.MAC != "" {
+		isNew := !child.HasDevice(req.MAC)
+		changed := isNew
+		if changed {
+			deviceName := fmt.Sprintf("Device %d", len(child.Devices)+1)
+			child.AddDevice(req.MAC, deviceName)
+			if device := child.Device(req.MAC); device.Randomized && h.storage.GetSettings().DevicePolicy.ApproveRandomized {
+				device.Pending = true
 			}
+		}
+		// Keep the browser the device first logged in with
+		if device := child.Device(req.MAC); device.Client == nil && req.Client != nil {
+			device.Client = req.Client
+			changed = true
+		}
+		if child.SeeDevice(req.MAC, req.IP, req.IPv6) || changed {
+			h.storage.SaveChild(child)
+		}
+
+		device := child.Device(req.MAC)
+		if isNew {
+			h.notifyNewDevice(child, device)
+		}
+		if device.Pending {
+			log.Printf("Child %s login refused: device %s is waiting for approval", child.Name, req.MAC)
+			h.portalError(w, r, req, isJSON, http.StatusForbidden, "device_pending")
 			return
 		}
+		privateMAC = device.Randomized
+	}
+
+	session := h.startSession(child, req)
+	portalToken := h.portalTokens.Issue(child.ID, req.MAC, session.ID)
+
+	remainingMin := child.RemainingMinutes()
+
+	// The welcome page shows remaining time and house rules, then links on to the origin URL
+	welcomeURL := h.successURL(gatewayIP, req.MAC, req.OriginURL)
+
+	// openNDS sends the browser on to the welcome page once the handshake completes
+	authURL, err := h.grantAccess(ndsctl, req, child.SessionTimeoutMin(), 0, 0, welcomeURL)
+	switch {
+	case authURL != "":
+		log.Printf("Child %s will be authenticated by openNDS at %s", child.Name, req.GatewayAddress)
+	case req.MAC == "":
+		// No device to authenticate
+	case err != nil:
+		log.Printf("ndsctl auth failed for child %s (MAC: %s): %v", child.Name, req.MAC, err)
+	default:
+		log.Printf("Child %s authenticated on MAC %s with %d minutes", child.Name, req.MAC, remainingMin)
+	}
+
+	if isJSON {
+		resp := map[string]interface{}{
+			"type":              "child",
+			"child_name":        child.Name,
+			"remaining_minutes": remainingMin,
+			"redirect_url":      req.OriginURL,
+			"welcome_url":       welcomeURL,
+			"portal_token":      portalToken.Token,
+			"token_expires_at":  portalToken.ExpiresAt,
+		}
+		if authURL != "" {
+			resp["auth_url"] = authURL
+		}
+		if privateMAC {
+			resp["warning"] = portalCatalog(h.storage, r).T("warning.private_mac")
+		}
+		JSON(w, http.StatusOK, resp)
+	} else if authURL != "" {
+		http.Redirect(w, r, authURL, http.StatusFound)
+	} else {
+		http.Redirect(w, r, welcomeURL, http.StatusFound)
+	}
+}
+
+// portalErrorCodes maps portal error keys to their API error codes
+var portalErrorCodes = map[string]ErrCode{
+	"invalid_credentials": CodeInvalidCredentials,
+	"account_locked":      CodeAccountLocked,
+	"no_time_remaining":   CodeQuotaExceeded,
+	"data_exceeded":       CodeDataExceeded,
+	"on_break":            CodeOnBreak,
+	"outside_schedule":    CodeOutsideSchedule,
+	"bedtime":             CodeBedtime,
+	"service_unavailable": CodeUnavailable,
+	"auth_failed":         CodeInternal,
+	"invalid_voucher":     CodeInvalidVoucher,
+	"device_pending":      CodeDevicePending,
+	"invalid_mac":         CodeValidation,
+	"portal_expired":      CodeInvalidFASPayload,
+}
+
+// portalError reports a failed portal login in the client's language. JSON
+// clients get the code and message; form logins go back to the portal with
+// the error key, which HandlePortal translates.
+func (h *FASHandler) portalError(w http.ResponseWriter, r *http.Request, req AuthRequest, isJSON bool, status int, key string) {
+	if isJSON {
+		ErrorCode(w, status, portalErrorCodes[key], portalCatalog(h.storage, r).T("error."+key))
+		return
+	}
+	params := url.Values{}
+	params.Set("hid", req.HID)
+	params.Set("mac", req.MAC)
+	params.Set("ip", req.IP)
+	params.Set("authdir", req.AuthDir)
+	params.Set("originurl", req.OriginURL)
+	params.Set("gatewayip", req.GatewayIP)
+	params.Set("gatewayname", req.GatewayName)
+	params.Set("gatewayhash", req.GatewayHash)
+	params.Set("gatewayaddress", req.GatewayAddress)
+	params.Set("error", key)
+	http.Redirect(w, r, "/portal?"+params.Encode(), http.StatusFound)
+}
+
+// notifyNewDevice tells parents a child logged in on a device not seen before
+func (h *FASHandler) notifyNewDevice(child *models.Child, device *models.Device) {
+	name := device.MAC
+	if device.Client != nil && device.Client.Label != "" {
+		name = fmt.Sprintf("%s (%s)", device.Client.Label, device.MAC)
+	}
+	message := fmt.Sprintf("%s logged in on a new device: %s.", child.Name, name)
+	if device.Pending {
+		message += " It is waiting for your approval."
+	}
+	h.notifier.Notify(services.NewNotification(models.EventNewDevice, "New device for "+child.Name, message,
+		map[string]any{"child_id": child.ID, "name": child.Name, "mac": device.MAC, "pending": device.Pending}))
+}
+
+// startSession records a child's login on a device. A device keeps one
+// session: if the same child already has one on this MAC it is reused, and
+// a session of another child (a lent device) is deauthed and ended first.
+// Past the child's device limit, the oldest of their other sessions is ended.
+// Returns the device's session.
+func (h *FASHandler) startSession(child *models.Child, req AuthRequest) *models.Session {
+	h.sessionMu.Lock()
+	defer h.sessionMu.Unlock()
+
+	if req.MAC != "" {
+		if existing := h.storage.GetSessionByMAC(req.MAC); existing != nil {
+			if existing.ChildID == child.ID {
+				changed := existing.SetAddresses(req.IP, req.IPv6)
+				if existing.SetGateway(req.GatewayIP, req.GatewayName, req.GatewayHash) || changed {
+					h.storage.SaveSession(existing)
+				}
+				return existing
+			}
+
+			log.Printf("Device %s moved from %s to %s, ending the old session", req.MAC, existing.ChildName, child.Name)
+			if err := h.ndsPool.ForSession(existing).Deauth(req.MAC); err != nil {
+				log.Printf("ndsctl deauth error for %s: %v", req.MAC, err)
+			}
+			existing.End("device_moved")
+			h.storage.SaveSession(existing)
+			h.notifier.SessionEnded(existing)
+		}
 	}
 
-	if req.MAC != "" && !child.HasDevice(req.MAC) {
-		deviceName := fmt.Sprintf("Device %d", len(child.Devices)+1)
-		child.AddDevice(req.MAC, deviceName)
-		h.storage.SaveChild(child)
+	if max := child.MaxConcurrentDevices; max > 0 {
+		active := h.storage.ListChildSessions(child.ID)
+		for len(active) >= max {
+			oldest := active[0]
+			active = active[1:]
+			log.Printf("Child %s is at the %d-device limit, ending session on %s", child.Name, max, oldest.MAC)
+			if oldest.MAC != "" {
+				if err := h.ndsPool.ForSession(oldest).Deauth(oldest.MAC); err != nil {
+					log.Printf("ndsctl deauth error for %s: %v", oldest.MAC, err)
+				}
+			}
+			oldest.End("device_limit")
+			h.storage.SaveSession(oldest)
+			h.notifier.SessionEnded(oldest)
+		}
 	}
 
 	session := &models.Session{
@@ -414,40 +923,137 @@ func (h *FASHandler) HandleAuth(w http.ResponseWriter, r *http.Request) {
 		ChildName: child.Name,
 		MAC:       req.MAC,
 		IP:        req.IP,
+		IPv6:      req.IPv6,
 		StartedAt: time.Now(),
 		IsActive:  true,
+		Client:    req.Client,
 	}
+	session.SetGateway(req.GatewayIP, req.GatewayName, req.GatewayHash)
 	h.storage.SaveSession(session)
+	h.notifier.SessionStarted(session)
+	return session
+}
 
-	remainingMin := child.RemainingMinutes()
+// handleVoucherAuth lets a guest online with a voucher code for the
+// voucher's duration, within its bandwidth limits
+func (h *FASHandler) handleVoucherAuth(w http.ResponseWriter, r *http.Request, req AuthRequest, isJSON bool, ndsctl services.NDSClient) {
+	if req.MAC != "" && !ndsctl.IsRunning() {
+		log.Printf("Guest login refused: openNDS is not running")
+		h.portalError(w, r, req, isJSON, http.StatusServiceUnavailable, "service_unavailable")
+		return
+	}
 
-	if req.MAC != "" {
-		_ = h.ndsctl.Deauth(req.MAC)
-		time.Sleep(50 * time.Millisecond)
+	voucher, session, err := h.startVoucherSession(services.NormalizeVoucherCode(req.Voucher), req)
+	if err != nil {
+		log.Printf("Guest login failed: %v", err)
+		h.portalError(w, r, req, isJSON, http.StatusInternalServerError, "auth_failed")
+		return
+	}
+	if voucher == nil {
+		log.Printf("Invalid guest code from IP: %s MAC: %s", req.IP, req.MAC)
+		h.portalError(w, r, req, isJSON, http.StatusUnauthorized, "invalid_voucher")
+		return
+	}
 
-		if err := h.ndsctl.Auth(req.MAC, remainingMin, 0, 0); err != nil {
-			log.Printf("ndsctl auth failed for child %s (MAC: %s): %v", child.Name, req.MAC, err)
-		} else {
-			log.Printf("Child %s authenticated on MAC %s with %d minutes", child.Name, req.MAC, remainingMin)
-		}
+	portalToken := h.portalTokens.Issue("", req.MAC, session.ID)
+
+	remainingMin := guestRemainingMinutes(voucher, session)
+	if remainingMin < 1 {
+		remainingMin = 1
+	}
+
+	redirectURL := ""
+	if req.OriginURL != "null" {
+		redirectURL = req.OriginURL
+	}
+	welcomeURL := h.successURL(req.GatewayIP, req.MAC, req.OriginURL)
+	authURL, err := h.grantAccess(ndsctl, req, remainingMin, voucher.UploadKbps, voucher.DownloadKbps, welcomeURL)
+	switch {
+	case authURL != "":
+		log.Printf("Guest %s will be authenticated by openNDS at %s", voucher.Code, req.GatewayAddress)
+	case req.MAC == "":
+		// No device to authenticate
+	case err != nil:
+		log.Printf("ndsctl auth failed for guest %s (MAC: %s): %v", voucher.Code, req.MAC, err)
+	default:
+		log.Printf("Guest %s authenticated on MAC %s with %d minutes", voucher.Code, req.MAC, remainingMin)
 	}
 
 	if isJSON {
-		JSON(w, http.StatusOK, map[string]interface{}{
-			"type":              "child",
-			"child_name":        child.Name,
+		resp := map[string]interface{}{
+			"type":              "guest",
 			"remaining_minutes": remainingMin,
-			"redirect_url":      req.OriginURL,
-		})
+			"redirect_url":      redirectURL,
+			"welcome_url":       welcomeURL,
+			"portal_token":      portalToken.Token,
+			"token_expires_at":  portalToken.ExpiresAt,
+		}
+		if authURL != "" {
+			resp["auth_url"] = authURL
+		}
+		JSON(w, http.StatusOK, resp)
+	} else if authURL != "" {
+		http.Redirect(w, r, authURL, http.StatusFound)
 	} else {
-		redirectTarget := req.OriginURL
-		if redirectTarget == "" || redirectTarget == "null" {
-			redirectTarget = "http://" + h.config.OpenNDS.GatewayIP + ":8080/portal?success=1"
+		http.Redirect(w, r, welcomeURL, http.StatusFound)
+	}
+}
+
+// startVoucherSession redeems a voucher code and records the guest's
+// session. A device already online with the same code keeps its session
+// (and its original end time) without using up the code again; any other
+// session on the device is ended. Returns a nil voucher if the code can't
+// be used.
+func (h *FASHandler) startVoucherSession(code string, req AuthRequest) (*models.Voucher, *models.Session, error) {
+	h.sessionMu.Lock()
+	defer h.sessionMu.Unlock()
+
+	var existing *models.Session
+	if req.MAC != "" {
+		existing = h.storage.GetSessionByMAC(req.MAC)
+	}
+	if existing != nil && existing.VoucherID != "" {
+		if v := h.storage.GetVoucher(existing.VoucherID); v != nil && v.Code == code {
+			changed := existing.SetAddresses(req.IP, req.IPv6)
+			if existing.SetGateway(req.GatewayIP, req.GatewayName, req.GatewayHash) || changed {
+				h.storage.SaveSession(existing)
+			}
+			return v, existing, nil
+		}
+	}
+
+	voucher, err := h.storage.UseVoucher(code, time.Now())
+	if voucher == nil || err != nil {
+		return nil, nil, err
+	}
+
+	if existing != nil {
+		log.Printf("Device %s moved from %s to guest %s, ending the old session", req.MAC, existing.ChildName, voucher.Code)
+		if err := h.ndsPool.ForSession(existing).Deauth(req.MAC); err != nil {
+			log.Printf("ndsctl deauth error for %s: %v", req.MAC, err)
 		}
-		redirectURL := fmt.Sprintf("%s&child_name=%s&remaining=%d",
-			redirectTarget, url.QueryEscape(child.Name), remainingMin)
-		http.Redirect(w, r, redirectURL, http.StatusFound)
+		existing.End("device_moved")
+		h.storage.SaveSession(existing)
+		h.notifier.SessionEnded(existing)
+	}
+
+	session := &models.Session{
+		ID:        services.GenerateID(),
+		ChildName: "Guest " + voucher.Code,
+		VoucherID: voucher.ID,
+		MAC:       req.MAC,
+		IP:        req.IP,
+		IPv6:      req.IPv6,
+		StartedAt: time.Now(),
+		IsActive:  true,
+		Client:    req.Client,
+	}
+	session.SetGateway(req.GatewayIP, req.GatewayName, req.GatewayHash)
+	if err := h.storage.SaveSession(session); err != nil {
+		return voucher, session, err
 	}
+	h.notifier.SessionStarted(session)
+	return voucher, session, nil
 }
 
 // normalizeMAC standardizes MAC address format
@@ -463,31 +1069,146 @@ func normalizeMAC(mac string) string {
 	return mac
 }
 
+// ChildPasswordRequest is a child changing their own password
+type ChildPasswordRequest struct {
+	Username    string `json:"username"`
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// HandleChildPassword handles POST /fas/child/password, where a child
+// changes their own password. It doesn't log the device in.
+func (h *FASHandler) HandleChildPassword(w http.ResponseWriter, r *http.Request) {
+	catalog := portalCatalog(h.storage, r)
+
+	var req ChildPasswordRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, catalog.T("error.invalid_request"))
+		return
+	}
+	if req.Username == "" || req.OldPassword == "" || req.NewPassword == "" {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, catalog.T("error.invalid_request"))
+		return
+	}
+
+	err := h.authSvc.ChangeChildPassword(req.Username, req.OldPassword, req.NewPassword, loginAttempt(r))
+	var locked *services.LockedError
+	var policyErr *services.PasswordPolicyError
+	switch {
+	case err == nil:
+		log.Printf("Child %s changed their password from %s", req.Username, clientIP(r))
+		JSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": catalog.T("portal.password_changed"),
+		})
+	case errors.As(err, &locked):
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(locked.Until).Seconds())+1))
+		ErrorCode(w, http.StatusTooManyRequests, CodeAccountLocked, catalog.T("error.account_locked"))
+	case errors.Is(err, services.ErrInvalidCredentials):
+		ErrorCode(w, http.StatusUnauthorized, CodeInvalidCredentials, catalog.T("error.invalid_credentials"))
+	case errors.Is(err, services.ErrSelfPasswordChangeOff):
+		ErrorCode(w, http.StatusForbidden, CodeForbidden, catalog.T("error.password_change_disabled"))
+	case errors.As(err, &policyErr):
+		passwordError(w, err)
+	default:
+		log.Printf("Child %s password change failed: %v", req.Username, err)
+		ErrorCode(w, http.StatusInternalServerError, CodeInternal, catalog.T("error.auth_failed"))
+	}
+}
+
 // HandleStatus shows remaining time for a logged-in client
 func (h *FASHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
-	mac := r.URL.Query().Get("mac")
-	if mac == "" {
-		Error(w, http.StatusBadRequest, "missing mac parameter")
+	q := r.URL.Query()
+	catalog := portalCatalog(h.storage, r)
+	var session *models.Session
+	switch {
+	case q.Get("token") != "":
+		// A portal token is good until it expires or its session ends
+		token := h.portalTokens.Get(q.Get("token"))
+		if token == nil {
+			ErrorCode(w, http.StatusUnauthorized, CodeInvalidToken, catalog.T("error.invalid_token"))
+			return
+		}
+		session = h.storage.GetSession(token.SessionID)
+		if session == nil || !session.IsActive {
+			h.portalTokens.Revoke(token.Token)
+			ErrorCode(w, http.StatusUnauthorized, CodeInvalidToken, catalog.T("error.invalid_token"))
+			return
+		}
+	case q.Get("mac") != "":
+		mac := parseMAC(q.Get("mac"))
+		if mac == "" {
+			ErrorCode(w, http.StatusBadRequest, CodeValidation, catalog.T("error.invalid_mac"))
+			return
+		}
+		session = h.storage.GetSessionByMAC(mac)
+	case q.Get("ip") != "":
+		// Prefer the device the ARP table says holds the IP, then whichever
+		// session logged in from it
+		ip := normalizeIP(q.Get("ip"))
+		if mac := getMACFromIP(ip); mac != "" {
+			session = h.storage.GetSessionByMAC(normalizeMAC(mac))
+		}
+		if session == nil {
+			session = h.storage.GetSessionByIP(ip)
+		}
+	default:
+		Error(w, http.StatusBadRequest, catalog.T("error.missing_client"))
 		return
 	}
 
-	session := h.storage.GetSessionByMAC(mac)
 	if session == nil || !session.IsActive {
-		Error(w, http.StatusNotFound, "no active session")
+		ErrorCode(w, http.StatusNotFound, CodeNoActiveSession, catalog.T("error.no_active_session"))
+		return
+	}
+
+	if session.VoucherID != "" {
+		voucher := h.storage.GetVoucher(session.VoucherID)
+		if voucher == nil {
+			Error(w, http.StatusNotFound, catalog.T("error.account_not_found"))
+			return
+		}
+		JSON(w, http.StatusOK, map[string]interface{}{
+			"guest":             true,
+			"child_name":        session.ChildName,
+			"remaining_minutes": guestRemainingMinutes(voucher, session),
+			"session_start":     session.StartedAt,
+		})
 		return
 	}
 
 	child := h.storage.GetChild(session.ChildID)
 	if child == nil {
-		Error(w, http.StatusNotFound, "child not found")
+		Error(w, http.StatusNotFound, catalog.T("error.account_not_found"))
 		return
 	}
 
-	JSON(w, http.StatusOK, map[string]interface{}{
-		"child_name":        child.Name,
-		"remaining_minutes": child.RemainingMinutes(),
-		"used_today":        child.UsedTodayMin,
-		"daily_quota":       child.DailyQuotaMin,
-		"session_start":     session.StartedAt,
-	})
+	status := map[string]interface{}{
+		"child_name":          child.Name,
+		"remaining_minutes":   child.RemainingMinutes(),
+		"used_today":          child.UsedTodayMin,
+		"daily_quota":         child.DailyQuotaMin,
+		"bank_minutes":        child.BankMinutes,
+		"daily_data_quota_mb": child.DailyDataQuotaMB,
+		"used_today_mb":       math.Round(child.UsedTodayMB*10) / 10,
+		"session_start":       session.StartedAt,
+		"schedule_allowed":    true,
+		"filter_mode":         models.FilterModeNormal,
+	}
+
+	// Report the schedule as the ticker enforces it, so the portal doesn't
+	// suggest browsing while the schedule blocks access. Study mode applies
+	// if either the child or the current block asks for it.
+	now := time.Now()
+	schedule := h.storage.GetSchedule(child.ScheduleID)
+	if schedule != nil {
+		status["schedule_allowed"] = schedule.IsAllowedAt(now)
+		if next, ok := schedule.NextChange(now); ok {
+			status["next_change"] = next
+		}
+	}
+	if child.FilterMode == models.FilterModeStudy || (schedule != nil && schedule.FilterModeAt(now) == models.FilterModeStudy) {
+		status["filter_mode"] = models.FilterModeStudy
+	}
+	JSON(w, http.StatusOK, status)
 }
\ No newline at end of file
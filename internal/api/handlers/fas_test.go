@@ -0,0 +1,580 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"parenta/internal/api/middleware"
+	"parenta/internal/config"
+	"parenta/internal/services"
+	"parenta/internal/testutil"
+)
+
+func TestParseFASData(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want FASData
+	}{
+		{
+			name: "standard openNDS format",
+			data: "hid=f9a1b2, clientip=192.168.1.50, clientmac=aa:bb:cc:dd:ee:ff, gatewayname=Home%20Router, " +
+				"gatewayaddress=192.168.1.1:2050, gatewayhash=abc123, authdir=opennds_auth, " +
+				"originurl=http%3a%2f%2fexample.com%2f, clientif=br-lan, themespec=(null)",
+			want: FASData{
+				HID:         "f9a1b2",
+				ClientIP:    "192.168.1.50",
+				ClientMAC:   "aa:bb:cc:dd:ee:ff",
+				GatewayName: "Home%20Router",
+				GatewayHash: "abc123",
+				GatewayAddr: "192.168.1.1:2050",
+				AuthDir:     "opennds_auth",
+				OriginURL:   "http%3a%2f%2fexample.com%2f",
+			},
+		},
+		{
+			name: "missing fields",
+			data: "hid=f9a1b2, clientip=192.168.1.50",
+			want: FASData{HID: "f9a1b2", ClientIP: "192.168.1.50"},
+		},
+		{
+			name: "null first",
+			data: "hid=(null), clientip=192.168.1.50, clientmac=aa:bb:cc:dd:ee:ff",
+			want: FASData{ClientIP: "192.168.1.50", ClientMAC: "aa:bb:cc:dd:ee:ff"},
+		},
+		{
+			name: "null in the middle",
+			data: "hid=f9a1b2, clientmac=(null), clientip=192.168.1.50",
+			want: FASData{HID: "f9a1b2", ClientIP: "192.168.1.50"},
+		},
+		{
+			name: "null last",
+			data: "hid=f9a1b2, originurl=(null)",
+			want: FASData{HID: "f9a1b2"},
+		},
+		{
+			name: "all null",
+			data: "hid=(null), clientip=(null), clientmac=(null)",
+			want: FASData{},
+		},
+		{
+			name: "empty values",
+			data: "hid=, clientip=192.168.1.50, clientmac=",
+			want: FASData{ClientIP: "192.168.1.50"},
+		},
+		{
+			name: "extra whitespace",
+			data: "  hid = f9a1b2 ,   clientip=  192.168.1.50\t,  clientmac =aa:bb:cc:dd:ee:ff  ",
+			want: FASData{HID: "f9a1b2", ClientIP: "192.168.1.50", ClientMAC: "aa:bb:cc:dd:ee:ff"},
+		},
+		{
+			name: "mixed case keys",
+			data: "HID=f9a1b2, ClientIP=192.168.1.50, CLIENTMAC=aa:bb:cc:dd:ee:ff, GatewayAddress=192.168.1.1:2050",
+			want: FASData{HID: "f9a1b2", ClientIP: "192.168.1.50", ClientMAC: "aa:bb:cc:dd:ee:ff", GatewayAddr: "192.168.1.1:2050"},
+		},
+		{
+			// Values are decoded by HandleFAS, not here
+			name: "url-encoded values kept as is",
+			data: "gatewayname=My%20Wi-Fi%2C%20Upstairs, originurl=https%3A%2F%2Fexample.com%2F%3Fa%3D1%26b%3D2",
+			want: FASData{GatewayName: "My%20Wi-Fi%2C%20Upstairs", OriginURL: "https%3A%2F%2Fexample.com%2F%3Fa%3D1%26b%3D2"},
+		},
+		{
+			name: "equals sign in value",
+			data: "originurl=http://example.com/?a=1&b=2, hid=f9a1b2",
+			want: FASData{HID: "f9a1b2", OriginURL: "http://example.com/?a=1&b=2"},
+		},
+		{
+			name: "pairs without equals sign are skipped",
+			data: "garbage, hid=f9a1b2, =orphan, clientip",
+			want: FASData{HID: "f9a1b2"},
+		},
+		{
+			name: "unknown keys are ignored",
+			data: "clientif=br-lan, themespec=theme.sh, hid=f9a1b2",
+			want: FASData{HID: "f9a1b2"},
+		},
+		{
+			name: "last duplicate wins",
+			data: "hid=first, hid=second",
+			want: FASData{HID: "second"},
+		},
+		{
+			name: "empty input",
+			data: "",
+			want: FASData{},
+		},
+		{
+			name: "separators only",
+			data: ", , ,",
+			want: FASData{},
+		},
+	}
+
+	h := &FASHandler{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.parseFASData(tt.data); got != tt.want {
+				t.Errorf("parseFASData(%q)\n got %+v\nwant %+v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeMAC(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"aa:bb:cc:dd:ee:ff", "aa:bb:cc:dd:ee:ff"},
+		{"aa-bb-cc-dd-ee-ff", "aa:bb:cc:dd:ee:ff"},
+		{"aabb.ccdd.eeff", "aa:bb:cc:dd:ee:ff"},
+		{"AA:BB:CC:DD:EE:FF", "aa:bb:cc:dd:ee:ff"},
+		{"AA-BB-CC-DD-EE-FF", "aa:bb:cc:dd:ee:ff"},
+		{"AABB.CCDD.EEFF", "aa:bb:cc:dd:ee:ff"},
+		{"aabbccddeeff", "aa:bb:cc:dd:ee:ff"},
+		{"", ""},
+		// Anything that isn't 12 hex digits long is only lowercased
+		{"AA:BB:CC", "aabbcc"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeMAC(tt.in); got != tt.want {
+			t.Errorf("normalizeMAC(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func FuzzParseFASData(f *testing.F) {
+	f.Add("hid=f9a1b2, clientip=192.168.1.50, clientmac=aa:bb:cc:dd:ee:ff, gatewayname=Home%20Router")
+	f.Add("hid=(null), clientmac=(null)")
+	f.Add("  HID = x ,, =, a==b, originurl=http://x/?a=1")
+	f.Add("")
+
+	h := &FASHandler{}
+	f.Fuzz(func(t *testing.T, data string) {
+		fas := h.parseFASData(data)
+		for _, v := range []string{
+			fas.HID, fas.ClientIP, fas.ClientMAC, fas.GatewayName,
+			fas.GatewayHash, fas.GatewayAddr, fas.AuthDir, fas.OriginURL,
+		} {
+			if v == "(null)" {
+				t.Errorf("parseFASData(%q) kept a (null) value", data)
+			}
+			if v != strings.TrimSpace(v) {
+				t.Errorf("parseFASData(%q) kept surrounding whitespace in %q", data, v)
+			}
+			if strings.Contains(v, ", ") {
+				t.Errorf("parseFASData(%q) kept a separator in %q", data, v)
+			}
+		}
+	})
+}
+
+func TestOpenNDSAuthURL(t *testing.T) {
+	// printf '%s%s' f9a1b2 faskey-0123456789 | sha256sum
+	const tok = "dfee03101db0afa4f5155fed9d2dbc660ea7e3cf5c4c04104ed583b207efe5aa"
+
+	full := AuthRequest{HID: "f9a1b2", GatewayAddress: "192.168.1.1:2050", AuthDir: "opennds_auth"}
+	with := func(change func(*AuthRequest)) AuthRequest {
+		req := full
+		change(&req)
+		return req
+	}
+
+	tests := []struct {
+		name  string
+		req   AuthRequest
+		redir string
+		noKey bool // No faskey configured
+		want  string
+	}{
+		{
+			name:  "plain redirect",
+			req:   full,
+			redir: "http://example.com/",
+			want:  "http://192.168.1.1:2050/opennds_auth/?redir=http%3A%2F%2Fexample.com%2F&tok=" + tok,
+		},
+		{
+			name:  "redirect with a query and fragment",
+			req:   full,
+			redir: "https://example.com/a b?x=1&y=2#top",
+			want:  "http://192.168.1.1:2050/opennds_auth/?redir=https%3A%2F%2Fexample.com%2Fa+b%3Fx%3D1%26y%3D2%23top&tok=" + tok,
+		},
+		{
+			name:  "redirect that tries to add a tok",
+			req:   full,
+			redir: "http://example.com/?tok=forged",
+			want:  "http://192.168.1.1:2050/opennds_auth/?redir=http%3A%2F%2Fexample.com%2F%3Ftok%3Dforged&tok=" + tok,
+		},
+		{name: "no redirect", req: full, want: "http://192.168.1.1:2050/opennds_auth/?tok=" + tok},
+		{name: "null redirect", req: full, redir: "null", want: "http://192.168.1.1:2050/opennds_auth/?tok=" + tok},
+		{
+			name: "authdir with slashes",
+			req:  with(func(r *AuthRequest) { r.AuthDir = "/opennds_auth/" }),
+			want: "http://192.168.1.1:2050/opennds_auth/?tok=" + tok,
+		},
+		{
+			name: "hid with surrounding whitespace",
+			req:  with(func(r *AuthRequest) { r.HID = " f9a1b2 " }),
+			want: "http://192.168.1.1:2050/opennds_auth/?tok=" + tok,
+		},
+
+		{name: "no hid", req: with(func(r *AuthRequest) { r.HID = "" })},
+		{name: "no gateway address", req: with(func(r *AuthRequest) { r.GatewayAddress = "" })},
+		{name: "no authdir", req: with(func(r *AuthRequest) { r.AuthDir = "" })},
+		{name: "no faskey", req: full, noKey: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &FASHandler{config: &config.Config{}}
+			h.config.OpenNDS.FASKey = "faskey-0123456789"
+			if tt.noKey {
+				h.config.OpenNDS.FASKey = ""
+			}
+			if got := h.openNDSAuthURL(tt.req, tt.redir); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrantAccess(t *testing.T) {
+	const mac = "a8:bb:cc:00:00:01"
+	handshake := AuthRequest{HID: "f9a1b2", MAC: mac, GatewayAddress: "192.168.1.1:2050", AuthDir: "opennds_auth"}
+
+	tests := []struct {
+		name      string
+		req       AuthRequest
+		minutes   int
+		upload    int
+		known     bool // openNDS already lists the client
+		wantURL   bool
+		wantAuth  bool
+		wantCalls int
+	}{
+		{name: "handshake", req: handshake, wantURL: true},
+		{name: "timeout with a MAC uses ndsctl", req: handshake, minutes: 60, wantAuth: true, wantCalls: 2},
+		{name: "limited with a MAC uses ndsctl", req: handshake, upload: 512, wantAuth: true, wantCalls: 2},
+		{name: "limited without a MAC keeps the handshake", req: AuthRequest{HID: "f9a1b2", GatewayAddress: "192.168.1.1:2050", AuthDir: "opennds_auth"}, minutes: 60, upload: 512, wantURL: true},
+		{name: "no hid uses ndsctl", req: AuthRequest{MAC: mac}, minutes: 60, wantAuth: true, wantCalls: 2},
+		{name: "known client is deauthed first", req: AuthRequest{MAC: mac}, minutes: 60, known: true, wantAuth: true, wantCalls: 3},
+		{name: "nothing to go on", req: AuthRequest{}, minutes: 60},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := testutil.NewEnv(t)
+			env.Config.OpenNDS.FASKey = "faskey-0123456789"
+			h := &FASHandler{config: env.Config}
+			if tt.known {
+				env.NDS.AddClient(mac, "192.168.1.50")
+			}
+
+			authURL, err := h.grantAccess(env.NDSPool.Get("192.168.1.1"), tt.req, tt.minutes, tt.upload, 0, "http://example.com/")
+			if err != nil {
+				t.Fatalf("grantAccess: %v", err)
+			}
+			if tt.wantURL != strings.HasPrefix(authURL, "http://192.168.1.1:2050/opennds_auth/?redir=http%3A%2F%2Fexample.com%2F&tok=") {
+				t.Errorf("auth URL %q", authURL)
+			}
+			if got := env.NDS.Called("auth", mac) == 1; got != tt.wantAuth {
+				t.Errorf("ndsctl auth run: %v, want %v", got, tt.wantAuth)
+			}
+			calls := env.NDS.Calls()
+			if len(calls) != tt.wantCalls {
+				t.Errorf("ndsctl calls %v, want %d", calls, tt.wantCalls)
+			}
+			for _, call := range calls {
+				if call[0] == "auth" && call[2] != strconv.Itoa(tt.minutes) {
+					t.Errorf("ndsctl %v, want a %d minute session", call, tt.minutes)
+				}
+			}
+		})
+	}
+}
+
+// TestGrantAccessTimeout checks that a child's session timeout reaches
+// openNDS the same way with and without a hid
+func TestGrantAccessTimeout(t *testing.T) {
+	const mac = "a8:bb:cc:00:00:01"
+	for _, req := range []AuthRequest{
+		{HID: "f9a1b2", MAC: mac, GatewayAddress: "192.168.1.1:2050", AuthDir: "opennds_auth"},
+		{MAC: mac},
+	} {
+		env := testutil.NewEnv(t)
+		env.Config.OpenNDS.FASKey = "faskey-0123456789"
+		h := &FASHandler{config: env.Config}
+
+		if _, err := h.grantAccess(env.NDSPool.Get("192.168.1.1"), req, 45, 0, 0, ""); err != nil {
+			t.Fatalf("grantAccess(hid %q): %v", req.HID, err)
+		}
+		var timeouts []string
+		for _, call := range env.NDS.Calls() {
+			if call[0] == "auth" {
+				timeouts = append(timeouts, call[2])
+			}
+		}
+		if len(timeouts) != 1 || timeouts[0] != "45" {
+			t.Errorf("hid %q: ndsctl auth timeouts %v, want [45]", req.HID, timeouts)
+		}
+	}
+}
+
+func TestSuccessURL(t *testing.T) {
+	const mac = "a8:bb:cc:00:00:01"
+	tests := []struct {
+		name       string
+		gatewayIP  string
+		mac        string
+		originURL  string
+		want       string
+		wantOrigin string // originurl as the success page reads it back
+	}{
+		{
+			name: "no origin",
+			mac:  mac,
+			want: "http://192.168.1.1:8080/portal/success?mac=a8%3Abb%3Acc%3A00%3A00%3A01",
+		},
+		{
+			name:      "null origin",
+			mac:       mac,
+			originURL: "null",
+			want:      "http://192.168.1.1:8080/portal/success?mac=a8%3Abb%3Acc%3A00%3A00%3A01",
+		},
+		{
+			name:       "plain origin",
+			mac:        mac,
+			originURL:  "http://example.com/",
+			want:       "http://192.168.1.1:8080/portal/success?mac=a8%3Abb%3Acc%3A00%3A00%3A01&originurl=http%3A%2F%2Fexample.com%2F",
+			wantOrigin: "http://example.com/",
+		},
+		{
+			name:       "origin with a query",
+			mac:        mac,
+			originURL:  "https://example.com/search?q=a b&mac=ff:ff:ff:ff:ff:ff#top",
+			want:       "http://192.168.1.1:8080/portal/success?mac=a8%3Abb%3Acc%3A00%3A00%3A01&originurl=https%3A%2F%2Fexample.com%2Fsearch%3Fq%3Da+b%26mac%3Dff%3Aff%3Aff%3Aff%3Aff%3Aff%23top",
+			wantOrigin: "https://example.com/search?q=a b&mac=ff:ff:ff:ff:ff:ff#top",
+		},
+		{
+			name:       "percent-encoded origin",
+			mac:        mac,
+			originURL:  "http%3A%2F%2Fexample.com%2Fa%20b",
+			want:       "http://192.168.1.1:8080/portal/success?mac=a8%3Abb%3Acc%3A00%3A00%3A01&originurl=http%253A%252F%252Fexample.com%252Fa%2520b",
+			wantOrigin: "http%3A%2F%2Fexample.com%2Fa%20b",
+		},
+		{
+			// Carried as a value only; the success page won't link to it
+			name:       "non-http scheme",
+			mac:        mac,
+			originURL:  "javascript:alert(document.cookie)",
+			want:       "http://192.168.1.1:8080/portal/success?mac=a8%3Abb%3Acc%3A00%3A00%3A01&originurl=javascript%3Aalert%28document.cookie%29",
+			wantOrigin: "javascript:alert(document.cookie)",
+		},
+		{
+			name:       "no MAC",
+			originURL:  "http://example.com/",
+			want:       "http://192.168.1.1:8080/portal/success?originurl=http%3A%2F%2Fexample.com%2F",
+			wantOrigin: "http://example.com/",
+		},
+		{
+			name:      "IPv6 gateway",
+			gatewayIP: "fd00::1",
+			mac:       mac,
+			want:      "http://[fd00::1]:8080/portal/success?mac=a8%3Abb%3Acc%3A00%3A00%3A01",
+		},
+	}
+
+	h := &FASHandler{config: &config.Config{}}
+	h.config.Server.Port = 8080
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gatewayIP := tt.gatewayIP
+			if gatewayIP == "" {
+				gatewayIP = "192.168.1.1"
+			}
+			got := h.successURL(gatewayIP, tt.mac, tt.originURL)
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+
+			u, err := url.Parse(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Path != "/portal/success" || u.Fragment != "" {
+				t.Errorf("origin leaked into path %q or fragment %q", u.Path, u.Fragment)
+			}
+			if q := u.Query(); q.Get("originurl") != tt.wantOrigin || q.Get("mac") != tt.mac {
+				t.Errorf("read back mac %q originurl %q", q.Get("mac"), q.Get("originurl"))
+			}
+		})
+	}
+}
+
+// newFASHandler builds a FASHandler over a test Env
+func newFASHandler(env *testutil.Env) *FASHandler {
+	auth := middleware.NewAuthMiddleware(env.Config.Session.JWTSecret, env.Config.Session.AuthMode, "test")
+	return NewFASHandler(env.Storage, env.NDSPool, env.Auth, env.Config, auth, services.NewNotifier(env.Storage))
+}
+
+// A level 3 payload in openNDS's format, made like the fixtures in
+// services/fassecure_test.go with the faskey's SHA-256 digest as the key
+const (
+	testFASKey         = "1234567890abcdef-parenta"
+	testFASIV          = "b3e91d5c07f2a846"
+	testFASHid         = "5d1e8a3b7c9f20e4a6b8c0d2e4f60718"
+	testFASGatewayHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	testFASPayload     = "T2xIdnJsbUk1VTFQbEdwSWhNVnI5N09aR1N0ZDh2UnQ0a2FtWXd4N2ZvWlZTL0VpbUZWN05RRkVYVG43TXRnMW5yU2ptcURROGh2SytlYm1QUmRzRXlEVjFxeUVPYjRjbEdVKzZOelByTE9NdlpTZGUxQ0ZFWmM1dElmWXc3bjNTQnpsS0paWTlzTmgxMlVTMWlWTTZCREFocG9vR3pmZkhGRzdteFJiV0VwRVY2L3l4dVBGWVZrWGtlQVpjZlowR1lzZkxwZWY0eUgyaGF5OGNSQTV6RTFzRWZvNWF1NFJ4dU1vNUdtNm1xSTRQcW5SeEVUcFJNMHFlaWRsZy9xQVM4eXljZmlGUnE2QmY2MWlXcTN3MU9iRDNTa1o3ZUFJc0NzR000cG5GWlM3dTgwdC9xNWZRZFR1a3dHeStPT0VKcGZwVk9NbUQxWkxZMmZ3TUpkUUtnODNWRDBNYmlvUFJaaWlUSnNPUTR1blBHSXBNT3FSbXBILzllV2h3c2JUdnp3cVpjQ2JFbW9DNmo4MTg0THlYRFNJZFhOWHF2SC93R0pHanBBd2lvSXhwWkFac2FjVkJwbE9hQVgvVUZsc1NWUTJnYVVDVlo0a3Ewd3Jmd1dkREhDT0I2NEJWajVUZ3F2dXozTkF0V3JzQ3ZBT3JKTGhkUXN4YVpaNU1WUEFjVVduSGwzaW5oaS9La0RkUFRiNndJRGZ6aVE0U2w2c3V4Y0lQam5pUkVrMlNoRnBmZXZNY25FSWV0UUhERE4v"
+)
+
+// newSecureFAS returns an Env and FASHandler at FAS level 3 that know the
+// fixture's gateway
+func newSecureFAS(t *testing.T) (*testutil.Env, *FASHandler) {
+	env := testutil.NewEnv(t)
+	env.Config.OpenNDS.FASSecureEnabled = services.FASLevelHTTPS
+	env.Config.OpenNDS.FASKey = testFASKey
+	env.Config.OpenNDS.GatewayHashes = map[string]string{testFASGatewayHash: "192.168.1.1"}
+	return env, newFASHandler(env)
+}
+
+// serveFAS sends openNDS's redirect to /fas/ with the given payload and IV
+func serveFAS(h *FASHandler, payload, iv string) *httptest.ResponseRecorder {
+	q := url.Values{"fas": {payload}, "iv": {iv}}
+	rec := httptest.NewRecorder()
+	h.HandleFAS(rec, httptest.NewRequest(http.MethodGet, "/fas/?"+q.Encode(), nil))
+	return rec
+}
+
+func TestHandleFASSecure(t *testing.T) {
+	tests := []struct {
+		name   string
+		hashes map[string]string // nil keeps the fixture's gateway
+		iv     string
+		status int
+	}{
+		{name: "verified payload", iv: testFASIV, status: http.StatusFound},
+		{name: "wrong IV", iv: "0000000000000000", status: http.StatusForbidden},
+		{name: "unknown gatewayhash", hashes: map[string]string{"0badc0de": "192.168.1.1"}, iv: testFASIV, status: http.StatusForbidden},
+		{name: "no gateway hashes configured", hashes: map[string]string{}, iv: testFASIV, status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, h := newSecureFAS(t)
+			if tt.hashes != nil {
+				env.Config.OpenNDS.GatewayHashes = tt.hashes
+			}
+
+			rec := serveFAS(h, testFASPayload, tt.iv)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusFound {
+				if !strings.Contains(rec.Body.String(), string(CodeInvalidFASPayload)) {
+					t.Errorf("body %s", rec.Body.String())
+				}
+				return
+			}
+			portal, _ := url.Parse(rec.Header().Get("Location"))
+			if q := portal.Query(); q.Get("hid") != testFASHid || q.Get("mac") != "a8:bb:cc:00:00:01" || q.Get("gatewayhash") != testFASGatewayHash {
+				t.Errorf("portal redirect %s", portal)
+			}
+		})
+	}
+}
+
+// postAuth sends a JSON portal login from remote
+func postAuth(h *FASHandler, login map[string]string, remote string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(login)
+	req := httptest.NewRequest(http.MethodPost, "/fas/auth", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = remote
+	rec := httptest.NewRecorder()
+	h.HandleAuth(rec, req)
+	return rec
+}
+
+// TestHandleAuthSecure checks that at FAS level 3 a portal login takes the
+// client from its verified payload, not the login, and can't reuse a hid
+func TestHandleAuthSecure(t *testing.T) {
+	useNeighborFixtures(t)
+	const payloadMAC = "a8:bb:cc:00:00:01"
+
+	t.Run("login can't change the client", func(t *testing.T) {
+		env, h := newSecureFAS(t)
+		child := env.AddChild(t, "alice", "pass1234", 60)
+		if rec := serveFAS(h, testFASPayload, testFASIV); rec.Code != http.StatusFound {
+			t.Fatalf("/fas/ status %d: %s", rec.Code, rec.Body.String())
+		}
+
+		login := map[string]string{"username": "alice", "password": "pass1234", "hid": testFASHid,
+			"mac": "a8:bb:cc:00:00:09", "ip": "10.0.0.23", "gatewayhash": "0badc0de"}
+		if rec := postAuth(h, login, "10.0.0.23:51234"); rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		sessions := env.Storage.ListSessionsByChildID(child.ID)
+		if len(sessions) != 1 || sessions[0].MAC != payloadMAC {
+			t.Fatalf("sessions %+v, want one for %s", sessions, payloadMAC)
+		}
+	})
+
+	t.Run("unknown hid", func(t *testing.T) {
+		env, h := newSecureFAS(t)
+		env.AddChild(t, "alice", "pass1234", 60)
+
+		login := map[string]string{"username": "alice", "password": "pass1234", "hid": testFASHid, "mac": payloadMAC}
+		rec := postAuth(h, login, "192.168.1.50:51234")
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), string(CodeInvalidFASPayload)) {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("used hid", func(t *testing.T) {
+		env, h := newSecureFAS(t)
+		env.AddChild(t, "alice", "pass1234", 60)
+		env.AddChild(t, "bob", "pass5678", 60)
+		serveFAS(h, testFASPayload, testFASIV)
+
+		login := map[string]string{"username": "alice", "password": "pass1234", "hid": testFASHid}
+		first := postAuth(h, login, "192.168.1.50:51234")
+		if first.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", first.Code, first.Body.String())
+		}
+
+		// A double submit still gets the first response
+		if rec := postAuth(h, login, "192.168.1.50:51234"); rec.Code != http.StatusOK || rec.Body.String() != first.Body.String() {
+			t.Errorf("double submit: status %d: %s", rec.Code, rec.Body.String())
+		}
+
+		if rec := serveFAS(h, testFASPayload, testFASIV); rec.Code != http.StatusForbidden {
+			t.Errorf("replayed /fas/: status %d", rec.Code)
+		}
+		login = map[string]string{"username": "bob", "password": "pass5678", "hid": testFASHid}
+		if rec := postAuth(h, login, "192.168.1.50:51234"); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), string(CodeInvalidFASPayload)) {
+			t.Errorf("replayed login: status %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("no hid", func(t *testing.T) {
+		env, h := newSecureFAS(t)
+		child := env.AddChild(t, "alice", "pass1234", 60)
+
+		login := map[string]string{"username": "alice", "password": "pass1234", "mac": "a8:bb:cc:00:00:09", "ip": "10.0.0.23"}
+		if rec := postAuth(h, login, "192.168.1.50:51234"); rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		sessions := env.Storage.ListSessionsByChildID(child.ID)
+		if len(sessions) != 1 || sessions[0].MAC != payloadMAC || sessions[0].IP != "192.168.1.50" {
+			t.Fatalf("sessions %+v, want one for %s at 192.168.1.50", sessions, payloadMAC)
+		}
+		if !env.NDS.Authenticated(payloadMAC) {
+			t.Errorf("%s not authenticated in openNDS", payloadMAC)
+		}
+	})
+}
@@ -1,9 +1,17 @@
 package handlers
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
+	"parenta/internal/config"
 	"parenta/internal/models"
 	"parenta/internal/services"
 	"parenta/internal/storage"
@@ -11,60 +19,33 @@ import (
 
 // FiltersHandler handles filter rules CRUD endpoints
 type FiltersHandler struct {
-	storage *storage.Storage
-	dnsmasq *services.DnsmasqService
+	storage  *storage.Storage
+	dnsmasq  *services.DnsmasqService
+	config   *config.Config
+	notifier *services.Notifier
 }
 
 // NewFiltersHandler creates a new FiltersHandler
-func NewFiltersHandler(store *storage.Storage, dnsmasq *services.DnsmasqService) *FiltersHandler {
+func NewFiltersHandler(store *storage.Storage, dnsmasq *services.DnsmasqService, cfg *config.Config, notifier *services.Notifier) *FiltersHandler {
 	return &FiltersHandler{
-		storage: store,
-		dnsmasq: dnsmasq,
+		storage:  store,
+		dnsmasq:  dnsmasq,
+		config:   cfg,
+		notifier: notifier,
 	}
 }
 
 // FilterRequest represents create filter request
 type FilterRequest struct {
-	Domain   string `json:"domain"`
-	RuleType string `json:"rule_type"` // "whitelist" or "blacklist"
-	Category string `json:"category"`
+	Domain         string     `json:"domain"`
+	RuleType       string     `json:"rule_type"` // "whitelist" or "blacklist"
+	Category       string     `json:"category"`
+	EffectiveFrom  *time.Time `json:"effective_from,omitempty"`
+	EffectiveUntil *time.Time `json:"effective_until,omitempty"`
 }
 
-// Handle handles /api/filters (list and create)
-func (h *FiltersHandler) Handle(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		h.list(w, r)
-	case http.MethodPost:
-		h.create(w, r)
-	default:
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-	}
-}
-
-// HandleByID handles /api/filters/{id} (get, delete)
-func (h *FiltersHandler) HandleByID(w http.ResponseWriter, r *http.Request) {
-	id := ExtractID(r.URL.Path, "/api/filters")
-	if id == "" {
-		Error(w, http.StatusBadRequest, "missing filter id")
-		return
-	}
-
-	switch r.Method {
-	case http.MethodDelete:
-		h.delete(w, r, id)
-	default:
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-	}
-}
-
-// HandleReload handles /api/filters/reload
+// HandleReload handles POST /api/filters/reload
 func (h *FiltersHandler) HandleReload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
-
 	if err := h.dnsmasq.ApplyAndReload(); err != nil {
 		Error(w, http.StatusInternalServerError, "failed to reload filters: "+err.Error())
 		return
@@ -73,7 +54,12 @@ func (h *FiltersHandler) HandleReload(w http.ResponseWriter, r *http.Request) {
 	JSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
-func (h *FiltersHandler) list(w http.ResponseWriter, r *http.Request) {
+// HandleList handles GET /api/filters
+func (h *FiltersHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	if NotModified(w, r, h.storage.LastModifiedAt("filters")) {
+		return
+	}
+
 	// Optional filter by type
 	ruleType := r.URL.Query().Get("type")
 	var rt models.RuleType
@@ -84,13 +70,14 @@ func (h *FiltersHandler) list(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filters := h.storage.ListFilters(rt)
-	JSON(w, http.StatusOK, filters)
+	JSONWithETag(w, r, http.StatusOK, filters)
 }
 
-func (h *FiltersHandler) create(w http.ResponseWriter, r *http.Request) {
+// HandleCreate handles POST /api/filters
+func (h *FiltersHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
 	var req FilterRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
@@ -100,16 +87,23 @@ func (h *FiltersHandler) create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if req.RuleType != "whitelist" && req.RuleType != "blacklist" {
-		Error(w, http.StatusBadRequest, "rule_type must be 'whitelist' or 'blacklist'")
+		Error(w, http.StatusBadRequest, msgInvalidRule)
+		return
+	}
+
+	if req.EffectiveFrom != nil && req.EffectiveUntil != nil && !req.EffectiveFrom.Before(*req.EffectiveUntil) {
+		Error(w, http.StatusBadRequest, "effective_from must be before effective_until")
 		return
 	}
 
 	filter := &models.FilterRule{
-		ID:        services.GenerateID(),
-		Domain:    req.Domain,
-		RuleType:  models.RuleType(req.RuleType),
-		Category:  req.Category,
-		CreatedAt: time.Now(),
+		ID:             services.GenerateID(),
+		Domain:         req.Domain,
+		RuleType:       models.RuleType(req.RuleType),
+		Category:       req.Category,
+		CreatedAt:      time.Now(),
+		EffectiveFrom:  req.EffectiveFrom,
+		EffectiveUntil: req.EffectiveUntil,
 	}
 
 	if err := h.storage.SaveFilter(filter); err != nil {
@@ -119,18 +113,294 @@ func (h *FiltersHandler) create(w http.ResponseWriter, r *http.Request) {
 
 	// Regenerate dnsmasq configs (but don't reload yet)
 	h.dnsmasq.RegenerateConfigs()
+	h.notifier.FiltersChanged("added", []*models.FilterRule{filter})
 
 	JSON(w, http.StatusCreated, filter)
 }
 
-func (h *FiltersHandler) delete(w http.ResponseWriter, r *http.Request, id string) {
+// HandleDelete handles DELETE /api/filters/{id}
+func (h *FiltersHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var removed []*models.FilterRule
+	for _, f := range h.storage.ListFilters("") {
+		if f.ID == id {
+			removed = append(removed, f)
+		}
+	}
 	if err := h.storage.DeleteFilter(id); err != nil {
-		Error(w, http.StatusInternalServerError, "failed to delete filter")
+		Error(w, http.StatusInternalServerError, msgDeleteFilterFailed)
 		return
 	}
 
 	// Regenerate dnsmasq configs (but don't reload yet)
 	h.dnsmasq.RegenerateConfigs()
+	h.notifier.FiltersChanged("removed", removed)
 
 	JSON(w, http.StatusOK, map[string]bool{"success": true})
 }
+
+// HandleBulkDelete handles DELETE /api/filters?category=X&rule_type=Y. At
+// least one of the two is required so a bare DELETE can't wipe every rule.
+func (h *FiltersHandler) HandleBulkDelete(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	category := q.Get("category")
+	ruleType := q.Get("rule_type")
+	if category == "" && ruleType == "" {
+		Error(w, http.StatusBadRequest, "category or rule_type is required")
+		return
+	}
+	if ruleType != "" && ruleType != "whitelist" && ruleType != "blacklist" {
+		Error(w, http.StatusBadRequest, msgInvalidRule)
+		return
+	}
+
+	var removed []*models.FilterRule
+	for _, f := range h.storage.ListFilters(models.RuleType(ruleType)) {
+		if category != "" && f.Category != category {
+			continue
+		}
+		if err := h.storage.DeleteFilter(f.ID); err != nil {
+			h.notifier.FiltersChanged("removed", removed)
+			Error(w, http.StatusInternalServerError, msgDeleteFilterFailed)
+			return
+		}
+		removed = append(removed, f)
+	}
+
+	// Regenerate dnsmasq configs once (but don't reload yet)
+	if len(removed) > 0 {
+		h.dnsmasq.RegenerateConfigs()
+		h.notifier.FiltersChanged("removed", removed)
+	}
+
+	JSON(w, http.StatusOK, map[string]int{"deleted": len(removed)})
+}
+
+// ============ Bulk Import ============
+
+// maxImportBytes limits the size of an imported domain list
+const maxImportBytes = 10 << 20
+
+// domainPattern matches a plain or wildcard domain name
+var domainPattern = regexp.MustCompile(`^(\*\.)?([a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9])?\.)+[a-z0-9-]{2,63}$`)
+
+// ImportResult reports the outcome of a bulk import
+type ImportResult struct {
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+	Invalid    int `json:"invalid"`
+}
+
+// parseDomainLine extracts a domain from one line of a domain list. Blank
+// lines and comments yield "". Hosts-file lines ("0.0.0.0 example.com") are
+// accepted.
+func parseDomainLine(line string) string {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	domain := fields[0]
+	if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+		domain = fields[1]
+	}
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// importDomains reads a newline-delimited domain list and saves each new
+// domain as a filter rule, writing them all at once
+func (h *FiltersHandler) importDomains(r io.Reader, ruleType models.RuleType, category string) (ImportResult, error) {
+	var result ImportResult
+	var filters []*models.FilterRule
+
+	existing := make(map[string]bool)
+	for _, f := range h.storage.ListFilters(ruleType) {
+		existing[f.Domain] = true
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(r, maxImportBytes))
+	for scanner.Scan() {
+		domain := parseDomainLine(scanner.Text())
+		if domain == "" {
+			continue
+		}
+		if domain == "localhost" || net.ParseIP(domain) != nil || !domainPattern.MatchString(domain) {
+			result.Invalid++
+			continue
+		}
+		if existing[domain] {
+			result.Duplicates++
+			continue
+		}
+
+		filters = append(filters, &models.FilterRule{
+			ID:        services.GenerateID(),
+			Domain:    domain,
+			RuleType:  ruleType,
+			Category:  category,
+			CreatedAt: time.Now(),
+		})
+		existing[domain] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+
+	if err := h.storage.BatchSaveFilters(filters); err != nil {
+		return result, err
+	}
+	result.Imported = len(filters)
+
+	if result.Imported > 0 {
+		h.dnsmasq.RegenerateConfigs()
+		h.notifier.FiltersChanged("imported", filters)
+	}
+	return result, nil
+}
+
+// HandleImport handles POST /api/filters/import?type=blacklist&category=...
+// The domain list (one per line) is either the request body or a multipart
+// "file" upload.
+func (h *FiltersHandler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	ruleType := models.RuleType(r.URL.Query().Get("type"))
+	if ruleType == "" {
+		ruleType = models.RuleTypeBlacklist
+	}
+	if ruleType != models.RuleTypeWhitelist && ruleType != models.RuleTypeBlacklist {
+		Error(w, http.StatusBadRequest, "type must be 'whitelist' or 'blacklist'")
+		return
+	}
+
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			Error(w, http.StatusBadRequest, "missing file upload")
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	result, err := h.importDomains(body, ruleType, r.URL.Query().Get("category"))
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to import filters: "+err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, result)
+}
+
+// ============ Presets ============
+
+// FilterPreset is a downloadable domain list for one category
+type FilterPreset struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	RuleType    models.RuleType `json:"rule_type"`
+	Category    string          `json:"category"`
+	URL         string          `json:"url"`
+}
+
+// FilterPresets defines the built-in preset lists
+var FilterPresets = []FilterPreset{
+	{
+		Name:        "social",
+		Description: "Social media sites (Facebook, TikTok, Instagram, ...)",
+		RuleType:    models.RuleTypeBlacklist,
+		Category:    "social",
+		URL:         "https://raw.githubusercontent.com/StevenBlack/hosts/master/alternates/social-only/hosts",
+	},
+	{
+		Name:        "gambling",
+		Description: "Gambling and betting sites",
+		RuleType:    models.RuleTypeBlacklist,
+		Category:    "other",
+		URL:         "https://raw.githubusercontent.com/StevenBlack/hosts/master/alternates/gambling-only/hosts",
+	},
+	{
+		Name:        "adult",
+		Description: "Adult content",
+		RuleType:    models.RuleTypeBlacklist,
+		Category:    "other",
+		URL:         "https://raw.githubusercontent.com/StevenBlack/hosts/master/alternates/porn-only/hosts",
+	},
+	{
+		Name:        "fakenews",
+		Description: "Known fake news sites",
+		RuleType:    models.RuleTypeBlacklist,
+		Category:    "other",
+		URL:         "https://raw.githubusercontent.com/StevenBlack/hosts/master/alternates/fakenews-only/hosts",
+	},
+}
+
+// HandleListPresets handles GET /api/filters/presets
+func (h *FiltersHandler) HandleListPresets(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, FilterPresets)
+}
+
+// HandleApplyPreset handles POST /api/filters/presets/{name}/apply
+func (h *FiltersHandler) HandleApplyPreset(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var preset *FilterPreset
+	for i := range FilterPresets {
+		if FilterPresets[i].Name == name {
+			preset = &FilterPresets[i]
+			break
+		}
+	}
+	if preset == nil {
+		Error(w, http.StatusNotFound, "preset not found")
+		return
+	}
+
+	if err := h.checkPresetURL(preset.URL); err != nil {
+		Error(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	// Redirects must stay on allowlisted hosts too
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return h.checkPresetURL(req.URL.String())
+		},
+	}
+
+	resp, err := client.Get(preset.URL)
+	if err != nil {
+		Error(w, http.StatusBadGateway, "failed to download preset: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		Error(w, http.StatusBadGateway, fmt.Sprintf("failed to download preset: HTTP %d", resp.StatusCode))
+		return
+	}
+
+	result, err := h.importDomains(resp.Body, preset.RuleType, preset.Category)
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to import preset: "+err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, result)
+}
+
+// checkPresetURL verifies a preset URL uses HTTPS and an allowlisted host
+func (h *FiltersHandler) checkPresetURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" {
+		return fmt.Errorf("preset URL must be https")
+	}
+	for _, host := range h.config.Filters.PresetAllowedHosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("preset host %s is not in filters.preset_allowed_hosts", u.Hostname())
+}
@@ -0,0 +1,44 @@
+package handlers
+
+// Error messages shared by the admin API. They stay in English, but keeping
+// them here lets them be translated later. Portal messages children and
+// guests see are translated through the i18n catalogs instead.
+const (
+	msgInvalidBody   = "invalid request body"
+	msgUnauthorized  = "unauthorized"
+	msgUsernameTaken = "username already exists"
+	msgMACRequired   = "mac is required"
+	msgInvalidMAC    = "mac must be a MAC address"
+	msgInvalidRule   = "rule_type must be 'whitelist' or 'blacklist'"
+	msgMinutesPos    = "minutes must be positive"
+	msgNegDevices    = "max_concurrent_devices cannot be negative"
+	msgInvalidData   = "daily_data_quota_mb must be between 0 and 1048576"
+	msgSessionLimit  = "max_session_minutes and break_minutes must be between 0 and 1440"
+	msgInvalidAge    = "age must be between 1 and 25"
+	msgInvalidRole   = "role must be 'super', 'admin' or 'viewer'"
+	msgReadOnly      = "viewers have read-only access"
+
+	msgChildNotFound    = "child not found"
+	msgSessionNotFound  = "session not found"
+	msgAdminNotFound    = "admin not found"
+	msgUserNotFound     = "user not found"
+	msgVoucherNotFound  = "voucher not found"
+	msgScheduleNotFound = "schedule not found"
+	msgDeviceNotFound   = "device not found"
+	msgAPIKeyNotFound   = "api key not found"
+	msgWebhookNotFound  = "webhook not found"
+
+	msgWalledGardenNotFound = "walled garden entry not found"
+
+	msgSaveChildFailed    = "failed to save child"
+	msgSaveScheduleFailed = "failed to save schedule"
+	msgSaveVoucherFailed  = "failed to save voucher"
+	msgSaveSettingsFailed = "failed to save settings"
+	msgSaveWebhookFailed  = "failed to save webhook"
+
+	msgSaveWalledGardenFailed = "failed to save walled garden entry"
+	msgUpdateAdminFailed      = "failed to update admin"
+	msgDeleteFilterFailed     = "failed to delete filter"
+	msgHashFailed             = "failed to hash password"
+	msgTokenFailed            = "failed to generate token"
+)
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"parenta/internal/models"
+)
+
+// arpTablePath is the kernel's IPv4 ARP table
+var arpTablePath = "/proc/net/arp"
+
+// neighborCommand lists the IPv6 neighbor table. The ARP table only has IPv4.
+var neighborCommand = []string{"ip", "-6", "neigh", "show"}
+
+// normalizeIP parses an address as it appears in RemoteAddr, FAS payloads or
+// query strings: brackets and zone IDs are dropped, and IPv4-mapped IPv6
+// addresses become plain IPv4. Returns "" if it isn't an IP.
+func normalizeIP(s string) string {
+	s = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(s), "["), "]")
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	return ip.String()
+}
+
+// getMACFromIP looks up a client's MAC in the router's ARP table, or for
+// IPv6 clients in the neighbor table
+func getMACFromIP(ip string) string {
+	ip = normalizeIP(ip)
+	if ip == "" {
+		return ""
+	}
+
+	var mac string
+	if !strings.Contains(ip, ":") {
+		data, err := os.ReadFile(arpTablePath)
+		if err != nil {
+			return ""
+		}
+		mac = parseARPTable(string(data), ip)
+	} else {
+		out, err := exec.Command(neighborCommand[0], neighborCommand[1:]...).Output()
+		if err != nil {
+			return ""
+		}
+		mac = parseNeighborTable(string(out), ip)
+	}
+
+	if !models.IsValidMAC(mac) {
+		return ""
+	}
+	return mac
+}
+
+// parseARPTable finds ip in the contents of /proc/net/arp. Incomplete
+// entries have an all-zero MAC and are skipped.
+func parseARPTable(table, ip string) string {
+	for _, line := range strings.Split(table, "\n") {
+		fields := strings.Fields(line)
+		// /proc/net/arp format: IP address, HW type, Flags, HW address, Mask, Device
+		if len(fields) >= 4 && fields[0] == ip && fields[3] != "00:00:00:00:00:00" {
+			return fields[3]
+		}
+	}
+	return ""
+}
+
+// parseNeighborTable finds ip in `ip neigh` output, whose lines look like
+// "fe80::1 dev br-lan lladdr aa:bb:cc:dd:ee:ff REACHABLE". FAILED and
+// INCOMPLETE entries have no lladdr, and NOARP ones an all-zero one; both
+// are skipped.
+func parseNeighborTable(table, ip string) string {
+	target := net.ParseIP(ip)
+	if target == nil {
+		return ""
+	}
+	for _, line := range strings.Split(table, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !target.Equal(net.ParseIP(fields[0])) {
+			continue
+		}
+		for i := 1; i+1 < len(fields); i++ {
+			if fields[i] == "lladdr" && fields[i+1] != "00:00:00:00:00:00" {
+				return fields[i+1]
+			}
+		}
+	}
+	return ""
+}
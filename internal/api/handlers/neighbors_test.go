@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"parenta/internal/testutil"
+)
+
+// readFixture returns a file from testdata
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// useNeighborFixtures makes getMACFromIP read the ARP and neighbor tables
+// from testdata until the test ends
+func useNeighborFixtures(t *testing.T) {
+	savedARP, savedNeighbor := arpTablePath, neighborCommand
+	arpTablePath = "testdata/proc-net-arp.txt"
+	neighborCommand = []string{"cat", "testdata/ip-6-neigh.txt"}
+	t.Cleanup(func() { arpTablePath, neighborCommand = savedARP, savedNeighbor })
+}
+
+func TestParseARPTable(t *testing.T) {
+	table := readFixture(t, "proc-net-arp.txt")
+	tests := []struct {
+		ip, want string
+	}{
+		{"192.168.1.50", "a8:bb:cc:00:00:01"},
+		{"192.168.1.5", "a8:bb:cc:00:00:05"},
+		{"10.0.0.23", "a8:bb:cc:00:00:02"},
+		{"192.168.1.51", ""}, // Incomplete
+		{"192.168.1.99", ""},
+	}
+	for _, tt := range tests {
+		if got := parseARPTable(table, tt.ip); got != tt.want {
+			t.Errorf("parseARPTable(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestParseNeighborTable(t *testing.T) {
+	table := readFixture(t, "ip-6-neigh.txt")
+	tests := []struct {
+		ip, want string
+	}{
+		{"2001:db8:1::50", "a8:bb:cc:00:00:01"},
+		{"2001:0db8:0001:0000:0000:0000:0000:0050", "a8:bb:cc:00:00:01"},
+		{"fe80::a8bb:ccff:fe00:1", "a8:bb:cc:00:00:01"},
+		{"fe80::1c2d:3eff:fe4f:5a6b", "1e:2d:3e:4f:5a:6b"}, // Router entry
+		{"fd00::60", "A8:BB:CC:00:00:02"},
+		{"2001:db8:1::70", "a8:bb:cc:00:00:03"},
+		{"2001:db8:1::51", ""}, // FAILED
+		{"2001:db8:1::52", ""}, // INCOMPLETE
+		{"2001:db8:1::71", ""}, // NOARP
+		{"2001:db8:1::99", ""},
+		{"not-an-ip", ""},
+	}
+	for _, tt := range tests {
+		if got := parseNeighborTable(table, tt.ip); got != tt.want {
+			t.Errorf("parseNeighborTable(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestGetMACFromIP(t *testing.T) {
+	useNeighborFixtures(t)
+	tests := []struct {
+		ip, want string
+	}{
+		{"192.168.1.50", "a8:bb:cc:00:00:01"},
+		{"::ffff:192.168.1.50", "a8:bb:cc:00:00:01"},
+		{"192.168.1.51", ""},
+		{"2001:db8:1::50", "a8:bb:cc:00:00:01"},
+		{"[2001:db8:1::50]", "a8:bb:cc:00:00:01"},
+		{"fe80::a8bb:ccff:fe00:1%br-lan", "a8:bb:cc:00:00:01"},
+		{"2001:db8:1::52", ""},
+		{"2001:db8:1::71", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := getMACFromIP(tt.ip); got != tt.want {
+			t.Errorf("getMACFromIP(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	arpTablePath = "testdata/missing"
+	if got := getMACFromIP("192.168.1.50"); got != "" {
+		t.Errorf("missing ARP table gave %q", got)
+	}
+	neighborCommand = []string{"false"}
+	if got := getMACFromIP("2001:db8:1::50"); got != "" {
+		t.Errorf("failing neighbor command gave %q", got)
+	}
+}
+
+// TestHandleAuthIPv6 checks a portal login from a client connected over
+// IPv6: its MAC comes from the neighbor table and the session keeps both
+// addresses
+func TestHandleAuthIPv6(t *testing.T) {
+	tests := []struct {
+		name     string
+		remote   string
+		form     url.Values
+		wantMAC  string
+		wantIP   string
+		wantIPv6 string
+	}{
+		{
+			name:     "IPv6 only",
+			remote:   "[2001:db8:1::50]:51234",
+			wantMAC:  "a8:bb:cc:00:00:01",
+			wantIPv6: "2001:db8:1::50",
+		},
+		{
+			name:     "link-local with a zone",
+			remote:   "[fe80::a8bb:ccff:fe00:1%br-lan]:51234",
+			wantMAC:  "a8:bb:cc:00:00:01",
+			wantIPv6: "fe80::a8bb:ccff:fe00:1",
+		},
+		{
+			name:     "dual stack",
+			remote:   "[2001:db8:1::70]:51234",
+			form:     url.Values{"ip": {"10.0.0.23"}},
+			wantMAC:  "a8:bb:cc:00:00:02", // IPv4 is looked up first
+			wantIP:   "10.0.0.23",
+			wantIPv6: "2001:db8:1::70",
+		},
+		{
+			name:     "FAS payload gave the MAC",
+			remote:   "[2001:db8:1::99]:51234",
+			form:     url.Values{"mac": {"A8-BB-CC-00-00-09"}},
+			wantMAC:  "a8:bb:cc:00:00:09",
+			wantIPv6: "2001:db8:1::99",
+		},
+		{
+			name:     "no neighbor entry",
+			remote:   "[2001:db8:1::52]:51234",
+			wantIPv6: "2001:db8:1::52",
+		},
+		{
+			name:    "IPv4-mapped address",
+			remote:  "[::ffff:192.168.1.50]:51234",
+			wantMAC: "a8:bb:cc:00:00:01",
+			wantIP:  "192.168.1.50",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useNeighborFixtures(t)
+			env := testutil.NewEnv(t)
+			child := env.AddChild(t, "alice", "pass1234", 60)
+			h := newFASHandler(env)
+
+			form := url.Values{"username": {"alice"}, "password": {"pass1234"}, "gatewayip": {"192.168.1.1"}}
+			for k, v := range tt.form {
+				form[k] = v
+			}
+			req := httptest.NewRequest(http.MethodPost, "/fas/auth", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.RemoteAddr = tt.remote
+			rec := httptest.NewRecorder()
+			h.HandleAuth(rec, req)
+
+			if rec.Code != http.StatusFound {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			sessions := env.Storage.ListSessionsByChildID(child.ID)
+			if len(sessions) != 1 {
+				t.Fatalf("%d sessions, want 1", len(sessions))
+			}
+			s := sessions[0]
+			if s.MAC != tt.wantMAC || s.IP != tt.wantIP || s.IPv6 != tt.wantIPv6 {
+				t.Errorf("session MAC %q IP %q IPv6 %q, want %q %q %q", s.MAC, s.IP, s.IPv6, tt.wantMAC, tt.wantIP, tt.wantIPv6)
+			}
+			if tt.wantMAC != "" && !env.NDS.Authenticated(tt.wantMAC) {
+				t.Errorf("%s not authenticated in openNDS", tt.wantMAC)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"parenta/internal/models"
+	"parenta/internal/services"
+	"parenta/internal/storage"
+)
+
+// maxWarningMinutes bounds notifications.warning_minutes
+const maxWarningMinutes = 240
+
+// NotificationsHandler configures notifications and sends test messages
+type NotificationsHandler struct {
+	storage  *storage.Storage
+	notifier *services.Notifier
+}
+
+// NewNotificationsHandler creates a new NotificationsHandler
+func NewNotificationsHandler(store *storage.Storage, notifier *services.Notifier) *NotificationsHandler {
+	return &NotificationsHandler{storage: store, notifier: notifier}
+}
+
+// HandleGetSettings handles GET /api/notifications/settings. Secrets are
+// masked.
+func (h *NotificationsHandler) HandleGetSettings(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, maskNotificationSecrets(h.storage.GetSettings().Notifications))
+}
+
+// HandleUpdateSettings handles PUT /api/notifications/settings (super
+// admin). A secret sent back as the masked value it was shown as is kept.
+func (h *NotificationsHandler) HandleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can change notification settings") {
+		return
+	}
+
+	var req models.NotificationSettings
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	settings := h.storage.GetSettings()
+	current := settings.Notifications
+	req.Email.Password = keepSecret(req.Email.Password, current.Email.Password)
+	req.Telegram.BotToken = keepSecret(req.Telegram.BotToken, current.Telegram.BotToken)
+	req.Webhook.URL = keepSecret(req.Webhook.URL, current.Webhook.URL)
+
+	if err := validateNotificationSettings(&req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, err.Error())
+		return
+	}
+
+	settings.Notifications = req
+	if err := h.storage.SaveSettings(settings); err != nil {
+		Error(w, http.StatusInternalServerError, msgSaveSettingsFailed)
+		return
+	}
+
+	JSON(w, http.StatusOK, maskNotificationSecrets(req))
+}
+
+// NotificationTestRequest names the channel to test. The webhook is
+// tested when it is left out.
+type NotificationTestRequest struct {
+	Channel string `json:"channel"`
+}
+
+// NotificationTestResponse reports a test send
+type NotificationTestResponse struct {
+	Channel    string `json:"channel"`
+	OK         bool   `json:"ok"`
+	StatusCode int    `json:"status_code,omitempty"` // HTTP status from Telegram or the webhook
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// HandleTest handles POST /api/system/notifications/test (super admin): it sends a
+// sample notification through a channel right away, by the same code the
+// background delivery uses, and reports the outcome
+func (h *NotificationsHandler) HandleTest(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can send test notifications") {
+		return
+	}
+
+	var req NotificationTestRequest
+	if err := ParseJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+	if req.Channel == "" {
+		req.Channel = models.ChannelWebhook
+	}
+	if !slices.Contains(models.NotificationChannels, req.Channel) {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, "channel must be one of: "+strings.Join(models.NotificationChannels, ", "))
+		return
+	}
+
+	start := time.Now()
+	status, err := h.notifier.Test(r.Context(), req.Channel)
+	resp := NotificationTestResponse{
+		Channel:    req.Channel,
+		OK:         err == nil,
+		StatusCode: status,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		if errors.Is(err, services.ErrChannelNotConfigured) {
+			resp.Error = req.Channel + " is not configured"
+		}
+	}
+	JSON(w, http.StatusOK, resp)
+}
+
+// validateNotificationSettings checks the settings, filling in defaults
+func validateNotificationSettings(n *models.NotificationSettings) error {
+	if n.SummaryTime == "" {
+		n.SummaryTime = models.DefaultSettings().Notifications.SummaryTime
+	}
+	if _, err := time.Parse("15:04", n.SummaryTime); err != nil || len(n.SummaryTime) != 5 {
+		return errors.New("summary_time must be HH:MM")
+	}
+	if n.WarningMinutes < 0 || n.WarningMinutes > maxWarningMinutes {
+		return fmt.Errorf("warning_minutes must be between 0 and %d", maxWarningMinutes)
+	}
+
+	for event, channels := range n.Routes {
+		if !slices.Contains(models.NotificationEvents, event) {
+			return fmt.Errorf("unknown event %q in routes", event)
+		}
+		for _, channel := range channels {
+			if !slices.Contains(models.NotificationChannels, channel) {
+				return fmt.Errorf("unknown channel %q for %s; use %s", channel, event, strings.Join(models.NotificationChannels, ", "))
+			}
+		}
+	}
+
+	e := &n.Email
+	if e.Port < 0 || e.Port > 65535 {
+		return errors.New("email.port must be a port number")
+	}
+	if e.From != "" {
+		if _, err := mail.ParseAddress(e.From); err != nil {
+			return fmt.Errorf("email.from %q is not an email address", e.From)
+		}
+	}
+	for _, to := range e.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("email.to %q is not an email address", to)
+		}
+	}
+
+	if n.Webhook.URL != "" {
+		u, err := url.Parse(n.Webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("webhook.url must be an http(s) URL")
+		}
+	}
+	if strings.ContainsAny(n.Telegram.BotToken, "/?#") {
+		return errors.New("telegram.bot_token is not a bot token")
+	}
+	return nil
+}
+
+// maskNotificationSecrets hides the password, bot token and webhook URL
+func maskNotificationSecrets(n models.NotificationSettings) models.NotificationSettings {
+	n.Email.Password = maskSecret(n.Email.Password)
+	n.Telegram.BotToken = maskSecret(n.Telegram.BotToken)
+	n.Webhook.URL = maskSecret(n.Webhook.URL)
+	return n
+}
+
+// maskSecret shows only the last 4 characters of a secret, and nothing of
+// a short one
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) < 12 {
+		return "********"
+	}
+	return "********" + s[len(s)-4:]
+}
+
+// keepSecret returns the stored secret when the request sent back its
+// masked form, and the request's value otherwise
+func keepSecret(sent, stored string) string {
+	if sent != "" && sent == maskSecret(stored) {
+		return stored
+	}
+	return sent
+}
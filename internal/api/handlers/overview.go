@@ -0,0 +1,39 @@
+package handlers
+
+import "net/http"
+
+// OverviewHandler serves the dashboard's home screen in one request,
+// combining the system, children and sessions handlers
+type OverviewHandler struct {
+	system   *SystemHandler
+	children *ChildrenHandler
+	sessions *SessionsHandler
+}
+
+// NewOverviewHandler creates a new OverviewHandler
+func NewOverviewHandler(system *SystemHandler, children *ChildrenHandler, sessions *SessionsHandler) *OverviewHandler {
+	return &OverviewHandler{
+		system:   system,
+		children: children,
+		sessions: sessions,
+	}
+}
+
+// OverviewResponse is what the dashboard home screen shows
+type OverviewResponse struct {
+	System         DashboardResponse `json:"system"`
+	Children       []ChildResponse   `json:"children"`
+	ActiveSessions []SessionResponse `json:"active_sessions"`
+}
+
+// HandleOverview handles GET /api/overview: the dashboard metrics, the
+// children and the active sessions, as GET /api/system/dashboard,
+// /api/children and /api/sessions return them
+func (h *OverviewHandler) HandleOverview(w http.ResponseWriter, r *http.Request) {
+	system, _ := h.system.Dashboard(r.URL.Query().Get("refresh") == "1")
+	JSONWithETag(w, r, http.StatusOK, OverviewResponse{
+		System:         system,
+		Children:       h.children.List(false),
+		ActiveSessions: h.sessions.ListActive(),
+	})
+}
@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"parenta/internal/config"
+	"parenta/internal/i18n"
+	"parenta/internal/storage"
+)
+
+//go:embed templates/welcome.html
+var defaultWelcomeTemplate string
+
+// PortalHandler serves the captive portal page and the post-login welcome page
+type PortalHandler struct {
+	storage *storage.Storage
+	config  *config.Config
+	webDir  string
+	portal  *template.Template
+	welcome *template.Template
+}
+
+// NewPortalHandler creates a new PortalHandler. A configured welcome template
+// that fails to load is logged and replaced by the built-in one.
+func NewPortalHandler(store *storage.Storage, cfg *config.Config, webDir string) *PortalHandler {
+	var tmpl *template.Template
+	if path := cfg.Portal.WelcomeTemplate; path != "" {
+		t, err := template.ParseFiles(path)
+		if err != nil {
+			log.Printf("Failed to load welcome template %s, using the default: %v", path, err)
+		} else {
+			tmpl = t
+		}
+	}
+	if tmpl == nil {
+		tmpl = template.Must(template.New("welcome").Parse(defaultWelcomeTemplate))
+	}
+
+	// Without a parsable portal page the file is served as is
+	portal, err := template.ParseFiles(filepath.Join(webDir, "portal.html"))
+	if err != nil {
+		log.Printf("Failed to parse portal template, serving it unbranded: %v", err)
+	}
+
+	return &PortalHandler{
+		storage: store,
+		config:  cfg,
+		webDir:  webDir,
+		portal:  portal,
+		welcome: tmpl,
+	}
+}
+
+// accentColorPattern matches the "#rrggbb" accent colors the portal accepts
+var accentColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// PortalBranding is the look and language shared by the portal and the
+// welcome page
+type PortalBranding struct {
+	Lang        string
+	Title       string
+	Message     string
+	AccentColor string // Empty keeps the theme's accent
+	LogoURL     string
+	catalog     *i18n.Catalog
+}
+
+// T translates a message key for the templates, as in {{.T "portal.login"}}
+func (b PortalBranding) T(key string, args ...any) string {
+	return b.catalog.T(key, args...)
+}
+
+// portalCatalog picks the portal language for a request: the locale set in
+// the portal settings, else the browser's Accept-Language
+func portalCatalog(store *storage.Storage, r *http.Request) *i18n.Catalog {
+	return i18n.Select(store.GetSettings().Portal.Locale, r.Header.Get("Accept-Language"))
+}
+
+// branding returns the current portal settings ready for the templates
+func (h *PortalHandler) branding(r *http.Request) PortalBranding {
+	settings := h.storage.GetSettings().Portal
+	catalog := portalCatalog(h.storage, r)
+	b := PortalBranding{
+		Lang:    catalog.Lang,
+		Title:   settings.Title,
+		Message: settings.Message,
+		catalog: catalog,
+	}
+	if b.Title == "" {
+		b.Title = "Parenta"
+	}
+	if accentColorPattern.MatchString(settings.AccentColor) {
+		b.AccentColor = settings.AccentColor
+	}
+	if settings.Logo != "" && settings.LogoUpdatedAt != nil {
+		b.LogoURL = fmt.Sprintf("/portal/logo?v=%d", settings.LogoUpdatedAt.Unix())
+	}
+	return b
+}
+
+// PortalData is what the portal page is rendered with
+type PortalData struct {
+	PortalBranding
+	Error  string // Translated login error passed back by a form login
+	Notice string // Shown after a successful login that has no welcome page
+}
+
+// WelcomeData is what the welcome template is rendered with
+type WelcomeData struct {
+	PortalBranding
+	ChildName        string
+	IsGuest          bool
+	PrivateMAC       bool // The device uses a private MAC, which it may change
+	RemainingMinutes int
+	DailyQuota       int
+	UsedToday        int
+	BankMinutes      int
+	AllowedNow       bool
+	NextChange       string // "15:04", or "Mon 15:04" if not today; empty without a schedule
+	HouseRules       []string
+	ContinueURL      string
+}
+
+// HandlePortal handles GET /portal, the login portal. The older
+// ?success=1 form of the success page is still served here.
+func (h *PortalHandler) HandlePortal(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("success") == "1" && q.Get("error") == "" {
+		h.HandleSuccess(w, r)
+		return
+	}
+	h.renderPortal(w, r, "")
+}
+
+// HandleSuccess handles GET /portal/success?mac=&originurl=, where logins
+// land. It shows the welcome page with the remaining time and a Continue
+// link to the origin URL, so captive portal sheets that can't open the
+// origin (often an HTTPS page) still end on a working page. Without an
+// active session for mac it shows the portal with a connected notice.
+func (h *PortalHandler) HandleSuccess(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if mac := q.Get("mac"); mac != "" {
+		if data := h.welcomeData(r, mac, q.Get("originurl")); data != nil {
+			h.render(w, h.welcome, data)
+			return
+		}
+	}
+	h.renderPortal(w, r, "portal.connected")
+}
+
+// renderPortal renders the login portal, with a notice if one is given
+func (h *PortalHandler) renderPortal(w http.ResponseWriter, r *http.Request, notice string) {
+	q := r.URL.Query()
+	if h.portal == nil {
+		http.ServeFile(w, r, filepath.Join(h.webDir, "portal.html"))
+		return
+	}
+
+	data := PortalData{PortalBranding: h.branding(r)}
+	if key := q.Get("error"); key != "" {
+		// Only known keys are shown, so links can't put arbitrary text on the portal
+		if !i18n.Has("error." + key) {
+			key = "login_failed"
+		}
+		data.Error = data.T("error." + key)
+	} else if notice != "" {
+		data.Notice = data.T(notice)
+	}
+	h.render(w, h.portal, data)
+}
+
+// render executes a page template into a buffer so a failing template
+// doesn't leave a half-written page
+func (h *PortalHandler) render(w http.ResponseWriter, tmpl *template.Template, data any) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Printf("Failed to render %s template: %v", tmpl.Name(), err)
+		Error(w, http.StatusInternalServerError, "failed to render page")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+// welcomeData gathers the child's quota and schedule, or the guest's
+// voucher time, for the active session on mac, or nil if there is none
+func (h *PortalHandler) welcomeData(r *http.Request, mac, originURL string) *WelcomeData {
+	mac = parseMAC(mac)
+	if mac == "" {
+		return nil
+	}
+	session := h.storage.GetSessionByMAC(mac)
+	if session == nil {
+		return nil
+	}
+
+	settings := h.storage.GetSettings().Portal
+	data := &WelcomeData{
+		PortalBranding: h.branding(r),
+		AllowedNow:     true,
+		HouseRules:     settings.HouseRules,
+	}
+	if data.HouseRules == nil {
+		data.HouseRules = h.config.Portal.HouseRules
+	}
+
+	if session.VoucherID != "" {
+		voucher := h.storage.GetVoucher(session.VoucherID)
+		if voucher == nil {
+			return nil
+		}
+		data.IsGuest = true
+		data.ChildName = session.ChildName
+		data.RemainingMinutes = guestRemainingMinutes(voucher, session)
+	} else {
+		child := h.storage.GetChild(session.ChildID)
+		if child == nil {
+			return nil
+		}
+		data.ChildName = child.Name
+		if device := child.Device(session.MAC); device != nil {
+			data.PrivateMAC = device.Randomized
+		}
+		data.RemainingMinutes = child.RemainingMinutes()
+		data.DailyQuota = child.DailyQuotaMin
+		data.UsedToday = child.UsedTodayMin
+		data.BankMinutes = child.BankMinutes
+
+		if schedule := h.storage.GetSchedule(child.ScheduleID); schedule != nil {
+			now := time.Now()
+			data.AllowedNow = schedule.IsAllowedAt(now)
+			if next, ok := schedule.NextChange(now); ok {
+				layout := "15:04"
+				if next.YearDay() != now.YearDay() {
+					layout = "Mon 15:04"
+				}
+				data.NextChange = next.Format(layout)
+			}
+		}
+	}
+
+	// Only link back to real web pages
+	if u, err := url.Parse(originURL); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		data.ContinueURL = u.String()
+	}
+
+	return data
+}
+
+// Portal settings limits
+const (
+	maxPortalTitleLen   = 60
+	maxPortalMessageLen = 500
+	maxHouseRules       = 20
+	maxHouseRuleLen     = 200
+	maxLogoSize         = 256 << 10
+)
+
+// logoTypes maps the accepted logo content types to file extensions. SVG is
+// left out on purpose since it can carry scripts.
+var logoTypes = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// PortalSettingsRequest represents a portal settings update
+type PortalSettingsRequest struct {
+	Title       string   `json:"title"`
+	Message     string   `json:"message"`
+	AccentColor string   `json:"accent_color"`
+	Locale      string   `json:"locale"`
+	HouseRules  []string `json:"house_rules"`
+}
+
+// HandleGetSettings handles GET /api/portal/settings
+func (h *PortalHandler) HandleGetSettings(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, h.storage.GetSettings().Portal)
+}
+
+// HandleUpdateSettings handles PUT /api/portal/settings
+func (h *PortalHandler) HandleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var req PortalSettingsRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	if req.Title == "" {
+		Error(w, http.StatusBadRequest, "title is required")
+		return
+	}
+	if len([]rune(req.Title)) > maxPortalTitleLen {
+		Error(w, http.StatusBadRequest, fmt.Sprintf("title must be at most %d characters", maxPortalTitleLen))
+		return
+	}
+	if len([]rune(req.Message)) > maxPortalMessageLen {
+		Error(w, http.StatusBadRequest, fmt.Sprintf("message must be at most %d characters", maxPortalMessageLen))
+		return
+	}
+	if req.AccentColor != "" && !accentColorPattern.MatchString(req.AccentColor) {
+		Error(w, http.StatusBadRequest, "accent_color must look like #rrggbb")
+		return
+	}
+	if req.Locale != "" && !i18n.IsSupported(req.Locale) {
+		Error(w, http.StatusBadRequest, "locale must be empty or one of "+strings.Join(i18n.Supported(), ", "))
+		return
+	}
+	if len(req.HouseRules) > maxHouseRules {
+		Error(w, http.StatusBadRequest, fmt.Sprintf("at most %d house rules are allowed", maxHouseRules))
+		return
+	}
+	for _, rule := range req.HouseRules {
+		if len([]rune(rule)) > maxHouseRuleLen {
+			Error(w, http.StatusBadRequest, fmt.Sprintf("house rules must be at most %d characters", maxHouseRuleLen))
+			return
+		}
+	}
+
+	settings := h.storage.GetSettings()
+	settings.Portal.Title = req.Title
+	settings.Portal.Message = req.Message
+	settings.Portal.AccentColor = req.AccentColor
+	settings.Portal.Locale = req.Locale
+	settings.Portal.HouseRules = req.HouseRules
+	if err := h.storage.SaveSettings(settings); err != nil {
+		Error(w, http.StatusInternalServerError, msgSaveSettingsFailed)
+		return
+	}
+
+	JSON(w, http.StatusOK, settings.Portal)
+}
+
+// HandleUploadLogo handles POST /api/portal/logo with a multipart "logo" file
+func (h *PortalHandler) HandleUploadLogo(w http.ResponseWriter, r *http.Request) {
+	// Leave room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxLogoSize+4096)
+	file, _, err := r.FormFile("logo")
+	if err != nil {
+		Error(w, http.StatusBadRequest, "logo file is required and must be at most 256KB")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxLogoSize+1))
+	if err != nil {
+		Error(w, http.StatusBadRequest, "failed to read logo")
+		return
+	}
+	if len(data) > maxLogoSize {
+		Error(w, http.StatusRequestEntityTooLarge, "logo must be at most 256KB")
+		return
+	}
+
+	ext, ok := logoTypes[http.DetectContentType(data)]
+	if !ok {
+		Error(w, http.StatusBadRequest, "logo must be a PNG, JPEG, GIF or WebP image")
+		return
+	}
+
+	name := "portal-logo." + ext
+	if err := h.storage.SaveDataFile(name, data); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to save logo")
+		return
+	}
+
+	settings := h.storage.GetSettings()
+	if old := settings.Portal.Logo; old != "" && old != name {
+		if err := h.storage.DeleteDataFile(old); err != nil {
+			log.Printf("Failed to remove old portal logo %s: %v", old, err)
+		}
+	}
+	now := time.Now()
+	settings.Portal.Logo = name
+	settings.Portal.LogoUpdatedAt = &now
+	if err := h.storage.SaveSettings(settings); err != nil {
+		Error(w, http.StatusInternalServerError, msgSaveSettingsFailed)
+		return
+	}
+
+	JSON(w, http.StatusOK, settings.Portal)
+}
+
+// HandleDeleteLogo handles DELETE /api/portal/logo
+func (h *PortalHandler) HandleDeleteLogo(w http.ResponseWriter, r *http.Request) {
+	settings := h.storage.GetSettings()
+	if settings.Portal.Logo == "" {
+		Error(w, http.StatusNotFound, "no logo uploaded")
+		return
+	}
+
+	if err := h.storage.DeleteDataFile(settings.Portal.Logo); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to remove logo")
+		return
+	}
+	settings.Portal.Logo = ""
+	settings.Portal.LogoUpdatedAt = nil
+	if err := h.storage.SaveSettings(settings); err != nil {
+		Error(w, http.StatusInternalServerError, msgSaveSettingsFailed)
+		return
+	}
+
+	JSON(w, http.StatusOK, settings.Portal)
+}
+
+// HandleQR handles GET /api/portal/qr: a QR code of the portal's address
+func (h *PortalHandler) HandleQR(w http.ResponseWriter, r *http.Request) {
+	writeQR(w, r, portalURL(r, nil))
+}
+
+// HandleLogo handles GET /portal/logo, which the portal shows before login
+func (h *PortalHandler) HandleLogo(w http.ResponseWriter, r *http.Request) {
+	logo := h.storage.GetSettings().Portal.Logo
+	if logo == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, h.storage.DataFilePath(logo))
+}
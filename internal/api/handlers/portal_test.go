@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"parenta/internal/testutil"
+)
+
+// TestPortalLanguage checks that the portal is rendered in the language
+// set in the portal settings, else the one the browser asks for
+func TestPortalLanguage(t *testing.T) {
+	tests := []struct {
+		name     string
+		locale   string // PortalSettings.Locale
+		header   string // Accept-Language
+		wantLang string
+		wantText string
+	}{
+		{"browser language", "", "de-DE,de;q=0.9,en;q=0.8", "de", "Anmelden"},
+		{"browser q-values", "", "en;q=0.3, fr;q=0.7", "fr", "Se connecter"},
+		{"unsupported browser language", "", "ja-JP", "en", "Login"},
+		{"no header", "", "", "en", "Login"},
+		{"settings override the browser", "es", "de-DE,de;q=0.9", "es", "Entrar"},
+		{"settings without a header", "de", "", "de", "Anmelden"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := testutil.NewEnv(t)
+			settings := env.Storage.GetSettings()
+			settings.Portal.Locale = tt.locale
+			if err := env.Storage.SaveSettings(settings); err != nil {
+				t.Fatal(err)
+			}
+			h := NewPortalHandler(env.Storage, env.Config, "../../../web")
+			if h.portal == nil {
+				t.Fatal("portal template didn't parse")
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/portal", nil)
+			if tt.header != "" {
+				req.Header.Set("Accept-Language", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.HandlePortal(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d", rec.Code)
+			}
+			body := rec.Body.String()
+			if want := `<html lang="` + tt.wantLang + `">`; !strings.Contains(body, want) {
+				t.Errorf("page isn't marked %s", want)
+			}
+			if !strings.Contains(body, tt.wantText) {
+				t.Errorf("page doesn't say %q", tt.wantText)
+			}
+		})
+	}
+}
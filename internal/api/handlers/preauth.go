@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"parenta/internal/api/middleware"
+	"parenta/internal/models"
+	"parenta/internal/services"
+	"parenta/internal/storage"
+)
+
+// PreAuthHandler manages devices that bypass the captive portal
+type PreAuthHandler struct {
+	storage *storage.Storage
+	ndsPool *services.NDSCtlPool
+}
+
+// NewPreAuthHandler creates a new PreAuthHandler
+func NewPreAuthHandler(store *storage.Storage, ndsPool *services.NDSCtlPool) *PreAuthHandler {
+	return &PreAuthHandler{
+		storage: store,
+		ndsPool: ndsPool,
+	}
+}
+
+// PreAuthRequest represents create/update preauth device request
+type PreAuthRequest struct {
+	MAC  string `json:"mac"`
+	Name string `json:"name"`
+}
+
+// parseMAC normalizes a MAC address, returning "" if it isn't one
+func parseMAC(mac string) string {
+	if !models.IsValidMAC(mac) {
+		return ""
+	}
+	return normalizeMAC(mac)
+}
+
+// HandleList handles GET /api/preauth-devices
+func (h *PreAuthHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, h.storage.ListPreAuthDevices())
+}
+
+// HandleCreate handles POST /api/preauth-devices
+func (h *PreAuthHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	var req PreAuthRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	mac := parseMAC(req.MAC)
+	if mac == "" {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msgInvalidMAC)
+		return
+	}
+	if h.storage.GetPreAuthDevice(mac) != nil {
+		ErrorCode(w, http.StatusConflict, CodeConflict, "device is already on the preauth list")
+		return
+	}
+
+	addedBy := ""
+	if claims := middleware.GetClaims(r); claims != nil {
+		addedBy = claims.Username
+	}
+
+	device := &models.PreAuthDevice{
+		MAC:     mac,
+		Name:    req.Name,
+		AddedBy: addedBy,
+		AddedAt: time.Now(),
+	}
+	if err := h.storage.SavePreAuthDevice(device); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to save preauth device")
+		return
+	}
+
+	JSON(w, http.StatusCreated, device)
+}
+
+// HandleUpdate handles PUT /api/preauth-devices/{mac}. Only the name can change.
+func (h *PreAuthHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	existing := h.storage.GetPreAuthDevice(parseMAC(r.PathValue("mac")))
+	if existing == nil {
+		ErrorCode(w, http.StatusNotFound, CodeDeviceNotFound, msgDeviceNotFound)
+		return
+	}
+
+	var req PreAuthRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	// Replace rather than modify, since readers may hold the old record
+	device := *existing
+	device.Name = req.Name
+	if err := h.storage.SavePreAuthDevice(&device); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to save preauth device")
+		return
+	}
+
+	JSON(w, http.StatusOK, device)
+}
+
+// HandleDelete handles DELETE /api/preauth-devices/{mac}. The device is
+// deauthed on every gateway, so it meets the portal from now on.
+func (h *PreAuthHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	mac := parseMAC(r.PathValue("mac"))
+	if h.storage.GetPreAuthDevice(mac) == nil {
+		ErrorCode(w, http.StatusNotFound, CodeDeviceNotFound, msgDeviceNotFound)
+		return
+	}
+
+	if err := h.storage.DeletePreAuthDevice(mac); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to delete preauth device")
+		return
+	}
+
+	// A device that isn't online can't be deauthed, which is fine
+	for _, ndsctl := range h.ndsPool.All() {
+		if err := ndsctl.Deauth(mac); err != nil {
+			log.Printf("Preauth: deauth of removed device %s: %v", mac, err)
+		}
+	}
+
+	JSON(w, http.StatusOK, map[string]bool{"success": true})
+}
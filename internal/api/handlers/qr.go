@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QR code image sizes in pixels
+const (
+	defaultQRSize = 256
+	minQRSize     = 128
+	maxQRSize     = 1024
+)
+
+// portalURL returns the login portal's address as the request reached this
+// server, so the code works from the network the admin is on
+func portalURL(r *http.Request, query url.Values) string {
+	u := url.URL{Scheme: "http", Host: r.Host, Path: "/portal"}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// writeQR sends content as a PNG QR code. ?size= sets the width in pixels.
+func writeQR(w http.ResponseWriter, r *http.Request, content string) {
+	size := defaultQRSize
+	if s := r.URL.Query().Get("size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < minQRSize || n > maxQRSize {
+			ErrorCode(w, http.StatusBadRequest, CodeValidation, "size must be between 128 and 1024")
+			return
+		}
+		size = n
+	}
+
+	png, err := qrcode.Encode(content, qrcode.Medium, size)
+	if err != nil {
+		log.Printf("Failed to encode QR code: %v", err)
+		Error(w, http.StatusInternalServerError, "failed to create QR code")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
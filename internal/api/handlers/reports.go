@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"parenta/internal/models"
+	"parenta/internal/services"
+	"parenta/internal/storage"
+)
+
+// ReportsHandler serves daily and weekly usage reports
+type ReportsHandler struct {
+	reports *services.ReportGenerator
+}
+
+// NewReportsHandler creates a new ReportsHandler
+func NewReportsHandler(store *storage.Storage) *ReportsHandler {
+	return &ReportsHandler{reports: services.NewReportGenerator(store)}
+}
+
+// HandleDaily handles GET /api/reports/daily?date=YYYY-MM-DD (default
+// today)
+func (h *ReportsHandler) HandleDaily(w http.ResponseWriter, r *http.Request) {
+	date := time.Now()
+	if s := r.URL.Query().Get("date"); s != "" {
+		var err error
+		if date, err = time.ParseInLocation(models.DateLayout, s, time.Local); err != nil {
+			ErrorCode(w, http.StatusBadRequest, CodeValidation, "date must be YYYY-MM-DD")
+			return
+		}
+	}
+	writeReport(w, r, h.reports.Daily(date))
+}
+
+// HandleWeekly handles GET /api/reports/weekly?week=YYYY-Www (default this
+// week)
+func (h *ReportsHandler) HandleWeekly(w http.ResponseWriter, r *http.Request) {
+	monday := services.WeekStart(time.Now())
+	if s := r.URL.Query().Get("week"); s != "" {
+		var err error
+		if monday, err = services.ParseISOWeek(s, time.Local); err != nil {
+			ErrorCode(w, http.StatusBadRequest, CodeValidation, err.Error())
+			return
+		}
+	}
+	writeReport(w, r, h.reports.Weekly(monday))
+}
+
+// writeReport sends a report as JSON, or as an HTML page for ?format=html
+// or a client that prefers HTML
+func writeReport(w http.ResponseWriter, r *http.Request, report *models.UsageReport) {
+	format := r.URL.Query().Get("format")
+	if format == "" && strings.HasPrefix(r.Header.Get("Accept"), "text/html") {
+		format = "html"
+	}
+
+	switch format {
+	case "", "json":
+		JSON(w, http.StatusOK, report)
+	case "html":
+		page, err := services.RenderReportHTML(report)
+		if err != nil {
+			Error(w, http.StatusInternalServerError, "failed to render report")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(page)
+	default:
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, "format must be json or html")
+	}
+}
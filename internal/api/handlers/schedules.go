@@ -23,61 +23,70 @@ func NewSchedulesHandler(store *storage.Storage) *SchedulesHandler {
 
 // ScheduleRequest represents create/update schedule request
 type ScheduleRequest struct {
-	Name       string             `json:"name"`
-	TimeBlocks []models.TimeBlock `json:"time_blocks"`
-	IsDefault  bool               `json:"is_default"`
+	Name       string                 `json:"name"`
+	TimeBlocks []models.TimeBlock     `json:"time_blocks"`
+	Exceptions []models.DateException `json:"exceptions"`
+	IsDefault  bool                   `json:"is_default"`
 }
 
-// Handle handles /api/schedules (list and create)
-func (h *SchedulesHandler) Handle(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		h.list(w, r)
-	case http.MethodPost:
-		h.create(w, r)
-	default:
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-	}
+// TimeBlockError is the problem with one of a request's time blocks
+type TimeBlockError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
 }
 
-// HandleByID handles /api/schedules/{id} (get, update, delete)
-func (h *SchedulesHandler) HandleByID(w http.ResponseWriter, r *http.Request) {
-	id := ExtractID(r.URL.Path, "/api/schedules")
-	if id == "" {
-		Error(w, http.StatusBadRequest, "missing schedule id")
-		return
-	}
+// TimeBlockErrorResponse lists every invalid time block in a request
+type TimeBlockErrorResponse struct {
+	Error  ErrorDetail      `json:"error"`
+	Blocks []TimeBlockError `json:"blocks"`
+}
 
-	switch r.Method {
-	case http.MethodGet:
-		h.get(w, r, id)
-	case http.MethodPut:
-		h.update(w, r, id)
-	case http.MethodDelete:
-		h.delete(w, r, id)
-	default:
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
+// validateTimeBlocks checks and normalizes each block in place. If any are
+// invalid it sends a 422 listing them all and returns false.
+func validateTimeBlocks(w http.ResponseWriter, blocks []models.TimeBlock) bool {
+	var errs []TimeBlockError
+	for i := range blocks {
+		if err := models.ValidateTimeBlock(&blocks[i]); err != nil {
+			errs = append(errs, TimeBlockError{Index: i, Error: err.Error()})
+		}
+	}
+	if len(errs) == 0 {
+		return true
 	}
+
+	JSON(w, http.StatusUnprocessableEntity, TimeBlockErrorResponse{
+		Error:  ErrorDetail{Code: CodeValidation, Message: "invalid time blocks"},
+		Blocks: errs,
+	})
+	return false
 }
 
-func (h *SchedulesHandler) list(w http.ResponseWriter, r *http.Request) {
+// HandleList handles GET /api/schedules
+func (h *SchedulesHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	if NotModified(w, r, h.storage.LastModifiedAt("schedules")) {
+		return
+	}
+
 	schedules := h.storage.ListSchedules()
-	JSON(w, http.StatusOK, schedules)
+	JSONWithETag(w, r, http.StatusOK, schedules)
 }
 
-func (h *SchedulesHandler) get(w http.ResponseWriter, r *http.Request, id string) {
+// HandleGet handles GET /api/schedules/{id}
+func (h *SchedulesHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
 	schedule := h.storage.GetSchedule(id)
 	if schedule == nil {
-		Error(w, http.StatusNotFound, "schedule not found")
+		Error(w, http.StatusNotFound, msgScheduleNotFound)
 		return
 	}
 	JSON(w, http.StatusOK, schedule)
 }
 
-func (h *SchedulesHandler) create(w http.ResponseWriter, r *http.Request) {
+// HandleCreate handles POST /api/schedules
+func (h *SchedulesHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
 	var req ScheduleRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
@@ -85,63 +94,91 @@ func (h *SchedulesHandler) create(w http.ResponseWriter, r *http.Request) {
 		Error(w, http.StatusBadRequest, "name is required")
 		return
 	}
+	if !validateTimeBlocks(w, req.TimeBlocks) {
+		return
+	}
 
 	schedule := &models.Schedule{
 		ID:         services.GenerateID(),
 		Name:       req.Name,
 		TimeBlocks: req.TimeBlocks,
+		Exceptions: req.Exceptions,
 		IsDefault:  req.IsDefault,
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
 
+	if err := schedule.ValidateExceptions(); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, err.Error())
+		return
+	}
+
 	// Initialize empty time blocks if nil
 	if schedule.TimeBlocks == nil {
 		schedule.TimeBlocks = make([]models.TimeBlock, 0)
 	}
 
 	if err := h.storage.SaveSchedule(schedule); err != nil {
-		Error(w, http.StatusInternalServerError, "failed to save schedule")
+		Error(w, http.StatusInternalServerError, msgSaveScheduleFailed)
 		return
 	}
 
 	JSON(w, http.StatusCreated, schedule)
 }
 
-func (h *SchedulesHandler) update(w http.ResponseWriter, r *http.Request, id string) {
+// HandleUpdate handles PUT /api/schedules/{id}
+func (h *SchedulesHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
 	schedule := h.storage.GetSchedule(id)
 	if schedule == nil {
-		Error(w, http.StatusNotFound, "schedule not found")
+		Error(w, http.StatusNotFound, msgScheduleNotFound)
 		return
 	}
 
 	var req ScheduleRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
+	// Validate before touching the stored schedule
+	if !validateTimeBlocks(w, req.TimeBlocks) {
+		return
+	}
+	if req.Exceptions != nil {
+		check := models.Schedule{Exceptions: req.Exceptions}
+		if err := check.ValidateExceptions(); err != nil {
+			ErrorCode(w, http.StatusBadRequest, CodeValidation, err.Error())
+			return
+		}
+	}
+
 	if req.Name != "" {
 		schedule.Name = req.Name
 	}
 	if req.TimeBlocks != nil {
 		schedule.TimeBlocks = req.TimeBlocks
 	}
+	if req.Exceptions != nil {
+		schedule.Exceptions = req.Exceptions
+	}
 	schedule.IsDefault = req.IsDefault
 	schedule.UpdatedAt = time.Now()
 
 	if err := h.storage.SaveSchedule(schedule); err != nil {
-		Error(w, http.StatusInternalServerError, "failed to save schedule")
+		Error(w, http.StatusInternalServerError, msgSaveScheduleFailed)
 		return
 	}
 
 	JSON(w, http.StatusOK, schedule)
 }
 
-func (h *SchedulesHandler) delete(w http.ResponseWriter, r *http.Request, id string) {
+// HandleDelete handles DELETE /api/schedules/{id}
+func (h *SchedulesHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
 	schedule := h.storage.GetSchedule(id)
 	if schedule == nil {
-		Error(w, http.StatusNotFound, "schedule not found")
+		Error(w, http.StatusNotFound, msgScheduleNotFound)
 		return
 	}
 
@@ -152,3 +189,63 @@ func (h *SchedulesHandler) delete(w http.ResponseWriter, r *http.Request, id str
 
 	JSON(w, http.StatusOK, map[string]bool{"success": true})
 }
+
+// SchedulePreviewDay is one day of a schedule preview
+type SchedulePreviewDay struct {
+	Name  string `json:"name"`
+	Date  string `json:"date"`
+	Hours []int  `json:"hours"` // 24 entries: 1 if allowed at the start of the hour, else 0
+}
+
+// SchedulePreviewResponse is a week of hourly access for a schedule
+type SchedulePreviewResponse struct {
+	Days []SchedulePreviewDay `json:"days"`
+}
+
+// HandlePreview handles POST /api/schedules/preview. It shows hour by hour
+// when an unsaved schedule would allow access, over the current week from
+// Monday, so date exceptions in that week are applied. Nothing is saved.
+func (h *SchedulesHandler) HandlePreview(w http.ResponseWriter, r *http.Request) {
+	var req ScheduleRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+	if !validateTimeBlocks(w, req.TimeBlocks) {
+		return
+	}
+
+	schedule := models.Schedule{
+		Name:       req.Name,
+		TimeBlocks: req.TimeBlocks,
+		Exceptions: req.Exceptions,
+	}
+	if err := schedule.ValidateExceptions(); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, err.Error())
+		return
+	}
+
+	// Monday 00:00 of the current week
+	now := time.Now()
+	offset := (int(now.Weekday()) + 6) % 7
+	monday := time.Date(now.Year(), now.Month(), now.Day()-offset, 0, 0, 0, 0, now.Location())
+
+	resp := SchedulePreviewResponse{Days: make([]SchedulePreviewDay, 7)}
+	for d := range resp.Days {
+		day := monday.AddDate(0, 0, d)
+		hours := make([]int, 24)
+		for hour := range hours {
+			at := time.Date(day.Year(), day.Month(), day.Day(), hour, 0, 0, 0, day.Location())
+			if schedule.IsAllowedAt(at) {
+				hours[hour] = 1
+			}
+		}
+		resp.Days[d] = SchedulePreviewDay{
+			Name:  day.Weekday().String(),
+			Date:  day.Format(models.DateLayout),
+			Hours: hours,
+		}
+	}
+
+	JSON(w, http.StatusOK, resp)
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"parenta/internal/models"
@@ -11,15 +14,17 @@ import (
 
 // SessionsHandler handles session management endpoints
 type SessionsHandler struct {
-	storage *storage.Storage
-	ndsctl  *services.NDSCtl
+	storage  *storage.Storage
+	ndsPool  *services.NDSCtlPool
+	notifier *services.Notifier
 }
 
 // NewSessionsHandler creates a new SessionsHandler
-func NewSessionsHandler(store *storage.Storage, ndsctl *services.NDSCtl) *SessionsHandler {
+func NewSessionsHandler(store *storage.Storage, ndsPool *services.NDSCtlPool, notifier *services.Notifier) *SessionsHandler {
 	return &SessionsHandler{
-		storage: store,
-		ndsctl:  ndsctl,
+		storage:  store,
+		ndsPool:  ndsPool,
+		notifier: notifier,
 	}
 }
 
@@ -30,16 +35,28 @@ type SessionResponse struct {
 	ChildName    string    `json:"child_name"`
 	MAC          string    `json:"mac"`
 	IP           string    `json:"ip"`
+	IPv6         string    `json:"ipv6,omitempty"`
+	GatewayIP    string    `json:"gateway_ip,omitempty"`
+	GatewayName  string    `json:"gateway_name,omitempty"`
+	GatewayHash  string    `json:"gateway_hash,omitempty"`
 	StartedAt    time.Time `json:"started_at"`
 	DurationMin  int       `json:"duration_min"`
 	RemainingMin int       `json:"remaining_min"`
 	IsActive     bool      `json:"is_active"`
+	EndReason    string    `json:"end_reason,omitempty"`
+	VoucherID    string    `json:"voucher_id,omitempty"`
+
+	Client *models.ClientInfo `json:"client,omitempty"`
 }
 
 // toSessionResponse converts Session to SessionResponse
 func (h *SessionsHandler) toSessionResponse(s *models.Session) SessionResponse {
 	remainingMin := 0
-	if child := h.storage.GetChild(s.ChildID); child != nil {
+	if s.VoucherID != "" {
+		if voucher := h.storage.GetVoucher(s.VoucherID); voucher != nil && s.IsActive {
+			remainingMin = guestRemainingMinutes(voucher, s)
+		}
+	} else if child := h.storage.GetChild(s.ChildID); child != nil {
 		remainingMin = child.RemainingMinutes()
 	}
 
@@ -49,107 +66,205 @@ func (h *SessionsHandler) toSessionResponse(s *models.Session) SessionResponse {
 		ChildName:    s.ChildName,
 		MAC:          s.MAC,
 		IP:           s.IP,
+		IPv6:         s.IPv6,
+		GatewayIP:    s.GatewayIP,
+		GatewayName:  s.GatewayName,
+		GatewayHash:  s.GatewayHash,
 		StartedAt:    s.StartedAt,
 		DurationMin:  s.DurationMinutes(),
 		RemainingMin: remainingMin,
 		IsActive:     s.IsActive,
+		EndReason:    s.EndReason,
+		VoucherID:    s.VoucherID,
+		Client:       s.Client,
 	}
 }
 
-// Handle handles /api/sessions (list)
-func (h *SessionsHandler) Handle(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
+// HandleList handles GET /api/sessions
+func (h *SessionsHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	JSONWithETag(w, r, http.StatusOK, h.ListActive())
+}
 
+// ListActive returns the active sessions as in GET /api/sessions
+func (h *SessionsHandler) ListActive() []SessionResponse {
 	sessions := h.storage.ListSessions()
 	response := make([]SessionResponse, len(sessions))
 	for i, s := range sessions {
 		response[i] = h.toSessionResponse(s)
 	}
-	JSON(w, http.StatusOK, response)
+	return response
 }
 
-// HandleByID handles /api/sessions/{id} (get, kick)
-func (h *SessionsHandler) HandleByID(w http.ResponseWriter, r *http.Request) {
-	id := ExtractID(r.URL.Path, "/api/sessions")
-	if id == "" {
-		Error(w, http.StatusBadRequest, "missing session id")
+// maxHistoryPageSize caps the page size of the session history
+const maxHistoryPageSize = 100
+
+// SessionHistoryResponse is one page of session history
+type SessionHistoryResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+	Total    int               `json:"total"`
+	Page     int               `json:"page"`
+	Limit    int               `json:"limit"`
+}
+
+// HandleHistory handles GET /api/sessions/history?child_id=X&page=1&limit=20
+func (h *SessionsHandler) HandleHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	childID := q.Get("child_id")
+	if childID != "" && h.storage.GetChild(childID) == nil {
+		Error(w, http.StatusNotFound, msgChildNotFound)
 		return
 	}
 
-	action := ExtractAction(r.URL.Path, "/api/sessions")
+	page := 1
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			Error(w, http.StatusBadRequest, "page must be a positive number")
+			return
+		}
+		page = n
+	}
+
+	limit := 20
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxHistoryPageSize {
+			Error(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
 
-	switch {
-	case action == "kick" && r.Method == http.MethodPost:
-		h.kick(w, r, id)
-	case action == "extend" && r.Method == http.MethodPost:
-		h.extend(w, r, id)
-	case r.Method == http.MethodGet:
-		h.get(w, r, id)
-	case r.Method == http.MethodDelete:
-		h.kick(w, r, id)
-	default:
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
+	sessions, total := h.storage.ListAllSessions(childID, page, limit)
+	response := SessionHistoryResponse{
+		Sessions: make([]SessionResponse, len(sessions)),
+		Total:    total,
+		Page:     page,
+		Limit:    limit,
+	}
+	for i, s := range sessions {
+		response.Sessions[i] = h.toSessionResponse(s)
 	}
+	JSON(w, http.StatusOK, response)
 }
 
-func (h *SessionsHandler) get(w http.ResponseWriter, r *http.Request, id string) {
+// HandleGet handles GET /api/sessions/{id}
+func (h *SessionsHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
 	session := h.storage.GetSession(id)
 	if session == nil {
-		Error(w, http.StatusNotFound, "session not found")
+		Error(w, http.StatusNotFound, msgSessionNotFound)
 		return
 	}
 	JSON(w, http.StatusOK, h.toSessionResponse(session))
 }
 
-func (h *SessionsHandler) kick(w http.ResponseWriter, r *http.Request, id string) {
+// HandleKick handles POST /api/sessions/{id}/kick and DELETE /api/sessions/{id}
+func (h *SessionsHandler) HandleKick(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
 	session := h.storage.GetSession(id)
 	if session == nil {
-		Error(w, http.StatusNotFound, "session not found")
+		Error(w, http.StatusNotFound, msgSessionNotFound)
 		return
 	}
 
-	// Deauth from openNDS
-	if err := h.ndsctl.Deauth(session.MAC); err != nil {
-		// Log but don't fail - device might already be offline
+	// Deauth from openNDS, unless it no longer lists the device. If the
+	// device can't be taken offline, the child may be online on another of
+	// their devices, so deauth those too.
+	ndsctl := h.ndsPool.ForSession(session)
+	listed := true
+	if session.MAC != "" {
+		if client, err := ndsctl.GetClient(session.MAC); err == nil && client == nil {
+			listed = false
+		}
+	}
+	if !listed {
+		log.Printf("Kick: %s is not an openNDS client, skipping deauth", session.MAC)
+	} else if err := ndsctl.DeauthBest(session); err != nil {
+		log.Printf("Kick: deauth error for session %s: %v", session.ID, err)
+		h.deauthChildDevices(session.ChildID, session.MAC)
 	}
 
 	// Mark session as inactive
-	session.IsActive = false
+	session.End("kicked")
 	h.storage.SaveSession(session)
+	h.notifier.SessionEnded(session)
 
 	JSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
+// deauthChildDevices deauths every registered device of the child that
+// openNDS reports as authenticated, skipping the given MAC. Each gateway is
+// asked in turn, since the devices may be on any of them. If a gateway's
+// client list can't be read, all registered devices are deauthed there.
+func (h *SessionsHandler) deauthChildDevices(childID, skip string) {
+	child := h.storage.GetChild(childID)
+	if child == nil {
+		return
+	}
+
+	for _, ndsctl := range h.ndsPool.All() {
+		var authenticated map[string]bool
+		if clients, err := ndsctl.JSON(); err != nil {
+			log.Printf("Kick: failed to list openNDS clients: %v", err)
+		} else {
+			authenticated = make(map[string]bool, len(clients))
+			for _, c := range clients {
+				if strings.EqualFold(c.State, "Authenticated") {
+					authenticated[strings.ToLower(c.MAC)] = true
+				}
+			}
+		}
+
+		for _, d := range child.Devices {
+			mac := strings.ToLower(d.MAC)
+			if mac == "" || mac == strings.ToLower(skip) {
+				continue
+			}
+			if authenticated != nil && !authenticated[mac] {
+				continue
+			}
+			if err := ndsctl.Deauth(d.MAC); err != nil {
+				log.Printf("Kick: ndsctl deauth error for %s: %v", d.MAC, err)
+			}
+		}
+	}
+}
+
 // ExtendRequest represents extend session request
 type ExtendRequest struct {
 	Minutes int `json:"minutes"`
 }
 
-func (h *SessionsHandler) extend(w http.ResponseWriter, r *http.Request, id string) {
+// HandleExtend handles POST /api/sessions/{id}/extend
+func (h *SessionsHandler) HandleExtend(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
 	session := h.storage.GetSession(id)
 	if session == nil {
-		Error(w, http.StatusNotFound, "session not found")
+		Error(w, http.StatusNotFound, msgSessionNotFound)
 		return
 	}
 
 	var req ExtendRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
 	if req.Minutes <= 0 {
-		Error(w, http.StatusBadRequest, "minutes must be positive")
+		Error(w, http.StatusBadRequest, msgMinutesPos)
+		return
+	}
+
+	if session.VoucherID != "" {
+		Error(w, http.StatusBadRequest, "guest sessions last as long as their voucher allows")
 		return
 	}
 
 	// Get child and add to their quota
 	child := h.storage.GetChild(session.ChildID)
 	if child == nil {
-		Error(w, http.StatusNotFound, "child not found")
+		Error(w, http.StatusNotFound, msgChildNotFound)
 		return
 	}
 
@@ -4,67 +4,160 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"parenta/internal/api/middleware"
 	"parenta/internal/config"
+	"parenta/internal/models"
 	"parenta/internal/services"
 	"parenta/internal/storage"
+	"parenta/internal/version"
 )
 
 // SystemHandler handles system status and control endpoints
 type SystemHandler struct {
 	storage   *storage.Storage
-	ndsctl    *services.NDSCtl
+	ndsctl    services.NDSClient
 	dnsmasq   *services.DnsmasqService
+	ticker    *services.SessionTicker
 	config    *config.Config
 	startTime time.Time
+
+	// Address the HTTP server listens on
+	listenAddr string
+
+	// Parenta's own recent log lines; nil if not captured
+	logs *services.LogBuffer
+
+	connectivity *services.ConnectivityChecker
+
+	backups *services.Backups
+
+	// Nil when update.enabled is off
+	updater *services.Updater
+	// Stops the service once an update is installed
+	restart func()
+
+	// Guards config.System.AllowedCommands, which can be replaced at runtime
+	commandsMu sync.RWMutex
+
+	// Last dashboard snapshot. The mutex is held while one is built, so
+	// concurrent requests wait for it instead of each doing the work.
+	dashboardMu sync.Mutex
+	dashboard   *DashboardResponse
+	dashboardAt time.Time
 }
 
 // NewSystemHandler creates a new SystemHandler
 func NewSystemHandler(
 	store *storage.Storage,
-	ndsctl *services.NDSCtl,
+	ndsctl services.NDSClient,
 	dnsmasq *services.DnsmasqService,
+	ticker *services.SessionTicker,
 	cfg *config.Config,
+	listenAddr string,
+	logs *services.LogBuffer,
+	backups *services.Backups,
 ) *SystemHandler {
+	conn := cfg.System.Connectivity
 	return &SystemHandler{
-		storage:   store,
-		ndsctl:    ndsctl,
-		dnsmasq:   dnsmasq,
-		config:    cfg,
-		startTime: time.Now(),
+		storage:    store,
+		ndsctl:     ndsctl,
+		dnsmasq:    dnsmasq,
+		ticker:     ticker,
+		config:     cfg,
+		startTime:  time.Now(),
+		listenAddr: listenAddr,
+		logs:       logs,
+		backups:    backups,
+		connectivity: services.NewConnectivityChecker(store, services.ConnectivityTargets{
+			DNSServer:     conn.DNSServer,
+			Domain:        conn.Domain,
+			BlockedDomain: conn.BlockedDomain,
+			HTTPURL:       conn.HTTPURL,
+		}, time.Duration(conn.TimeoutSeconds)*time.Second, time.Duration(conn.CacheSeconds)*time.Second),
+		updater: newUpdater(cfg),
+		restart: restartSelf,
+	}
+}
+
+// newUpdater returns the updater, or nil if updates are off
+func newUpdater(cfg *config.Config) *services.Updater {
+	if !cfg.Update.Enabled {
+		return nil
 	}
+	return services.NewUpdater(cfg.Update.ReleaseURL, "")
+}
+
+// restartSelf stops the service as SIGTERM would. procd then starts it
+// again, running the binary now in place.
+func restartSelf() {
+	if p, err := os.FindProcess(os.Getpid()); err == nil {
+		p.Signal(syscall.SIGTERM)
+	}
+}
+
+// gatewayInfo returns the interface and address openNDS manages, as
+// reported by ndsctl status. What it doesn't report is taken from the
+// config. The bool is whether openNDS answered.
+func (h *SystemHandler) gatewayInfo() (services.GatewayInfo, bool) {
+	var info services.GatewayInfo
+	status, err := h.ndsctl.Status()
+	if err == nil {
+		info = services.ParseGatewayInfo(status)
+	}
+	if info.Interface == "" {
+		info.Interface = h.config.OpenNDS.GatewayInterface
+	}
+	if info.Address == "" {
+		info.Address = h.config.OpenNDS.PrimaryGatewayIP()
+	}
+	return info, err == nil
 }
 
 // StatusResponse represents system status
 type StatusResponse struct {
-	Version           string        `json:"version"`
-	Uptime            string        `json:"uptime"`
-	UptimeSeconds     int64         `json:"uptime_seconds"`
-	OpenNDSRunning    bool          `json:"opennds_running"`
-	DnsmasqRunning    bool          `json:"dnsmasq_running"`
-	ActiveSessions    int           `json:"active_sessions"`
-	TotalChildren     int           `json:"total_children"`
-	MemoryUsageMB     float64       `json:"memory_usage_mb"`
-	GoRoutines        int           `json:"go_routines"`
+	Version           string  `json:"version"`
+	Uptime            string  `json:"uptime"`
+	UptimeSeconds     int64   `json:"uptime_seconds"`
+	ProcessUptime     string  `json:"process_uptime"`
+	ProcessUptimeSecs int64   `json:"process_uptime_seconds"`
+	SystemUptime      string  `json:"system_uptime"`
+	SystemUptimeSecs  int64   `json:"system_uptime_seconds"`
+	OpenNDSRunning    bool    `json:"opennds_running"`
+	DnsmasqRunning    bool    `json:"dnsmasq_running"`
+	ActiveSessions    int     `json:"active_sessions"`
+	TotalChildren     int     `json:"total_children"`
+	MemoryUsageMB     float64 `json:"memory_usage_mb"`
+	GoRoutines        int     `json:"go_routines"`
+
+	OpenNDSVersion   string   `json:"opennds_version,omitempty"`
+	GatewayInterface string   `json:"gateway_interface,omitempty"`
+	GatewayAddress   string   `json:"gateway_address,omitempty"`
+	GatewayIPs       []string `json:"gateway_ips"` // Configured gateways
+	ListenAddr       string   `json:"listen_addr"`
+	DataDir          string   `json:"data_dir"`
+
+	Backup services.BackupStatus `json:"backup"`
 }
 
 // HandleStatus returns system status
 func (h *SystemHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
-
 	// Check if services are running
-	openNDSRunning := h.ndsctl.IsRunning()
+	gateway, openNDSRunning := h.gatewayInfo()
 	dnsmasqRunning := h.checkDnsmasq()
 
 	// Get counts
@@ -75,18 +168,31 @@ func (h *SystemHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
 
+	// Process uptime resets when Parenta restarts; system uptime only on reboot
 	uptime := time.Since(h.startTime)
+	systemUptime := h.getSystemUptime()
 
 	status := StatusResponse{
-		Version:        "1.0.0",
-		Uptime:         formatDuration(uptime),
-		UptimeSeconds:  int64(uptime.Seconds()),
-		OpenNDSRunning: openNDSRunning,
-		DnsmasqRunning: dnsmasqRunning,
-		ActiveSessions: len(sessions),
-		TotalChildren:  len(children),
-		MemoryUsageMB:  float64(m.Alloc) / 1024 / 1024,
-		GoRoutines:     runtime.NumGoroutine(),
+		Version:           version.Version,
+		Uptime:            formatDuration(uptime),
+		UptimeSeconds:     int64(uptime.Seconds()),
+		ProcessUptime:     formatDuration(uptime),
+		ProcessUptimeSecs: int64(uptime.Seconds()),
+		SystemUptime:      formatDuration(systemUptime),
+		SystemUptimeSecs:  int64(systemUptime.Seconds()),
+		OpenNDSRunning:    openNDSRunning,
+		DnsmasqRunning:    dnsmasqRunning,
+		ActiveSessions:    len(sessions),
+		TotalChildren:     len(children),
+		MemoryUsageMB:     float64(m.Alloc) / 1024 / 1024,
+		GoRoutines:        runtime.NumGoroutine(),
+		OpenNDSVersion:    gateway.Version,
+		GatewayInterface:  gateway.Interface,
+		GatewayAddress:    gateway.Address,
+		GatewayIPs:        h.config.OpenNDS.GatewayIPs,
+		ListenAddr:        h.listenAddr,
+		DataDir:           h.storage.DataDir(),
+		Backup:            h.backups.Status(),
 	}
 
 	JSON(w, http.StatusOK, status)
@@ -99,21 +205,16 @@ type RestartRequest struct {
 
 // HandleRestart restarts a service
 func (h *SystemHandler) HandleRestart(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
-
 	var req RestartRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
 	var err error
 	switch req.Service {
 	case "opennds":
-		err = exec.Command("/etc/init.d/opennds", "restart").Run()
+		err = services.RestartOpenNDS(h.config.System.RestartAttempts)
 	case "dnsmasq":
 		err = h.dnsmasq.Reload()
 	default:
@@ -129,6 +230,245 @@ func (h *SystemHandler) HandleRestart(w http.ResponseWriter, r *http.Request) {
 	JSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
+// HolidayModeRequest represents a holiday mode toggle
+type HolidayModeRequest struct {
+	Enabled      bool `json:"enabled"`
+	ExtraMinutes int  `json:"extra_minutes"`
+}
+
+// HandleGetHolidayMode returns the current holiday mode state
+func (h *SystemHandler) HandleGetHolidayMode(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, h.storage.GetHolidayMode())
+}
+
+// HandleSetHolidayMode enables or disables holiday mode for all children
+func (h *SystemHandler) HandleSetHolidayMode(w http.ResponseWriter, r *http.Request) {
+	var req HolidayModeRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	if req.ExtraMinutes < 0 {
+		Error(w, http.StatusBadRequest, "extra_minutes cannot be negative")
+		return
+	}
+
+	mode := models.HolidayMode{Enabled: req.Enabled}
+	if req.Enabled {
+		// Default to doubling the standard quota
+		mode.ExtraMinutes = req.ExtraMinutes
+		if mode.ExtraMinutes == 0 {
+			mode.ExtraMinutes = h.config.Defaults.DailyQuotaMinutes
+		}
+		if claims := middleware.GetClaims(r); claims != nil {
+			mode.EnabledBy = claims.Username
+		}
+		mode.EnabledAt = time.Now()
+	}
+
+	if err := h.storage.SaveHolidayMode(mode); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to save holiday mode")
+		return
+	}
+
+	JSON(w, http.StatusOK, mode)
+}
+
+// HandleDnsmasqResync handles POST /api/system/dnsmasq/resync, rewriting
+// every dnsmasq config from current data and reloading once
+func (h *SystemHandler) HandleDnsmasqResync(w http.ResponseWriter, r *http.Request) {
+	result, err := h.dnsmasq.Resync()
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to resync dnsmasq: "+err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, result)
+}
+
+// maxNDSCtlOutput caps the ndsctl status output returned by a test
+const maxNDSCtlOutput = 4096
+
+// NDSCtlTestResponse reports whether Parenta can talk to openNDS
+type NDSCtlTestResponse struct {
+	StatusOK     bool   `json:"status_ok"`
+	JSONOK       bool   `json:"json_ok"`
+	ClientCount  int    `json:"client_count"`
+	LatencyMs    int64  `json:"latency_ms"` // Both commands together
+	StatusOutput string `json:"status_output,omitempty"`
+	StatusError  string `json:"status_error,omitempty"`
+	JSONError    string `json:"json_error,omitempty"`
+}
+
+// HandleTestNDSCtl handles POST /api/system/test-ndsctl: it runs ndsctl
+// status and ndsctl json against the default openNDS, without
+// authenticating anyone, and reports what worked
+func (h *SystemHandler) HandleTestNDSCtl(w http.ResponseWriter, r *http.Request) {
+	var resp NDSCtlTestResponse
+	start := time.Now()
+
+	status, err := h.ndsctl.Status()
+	resp.StatusOK = err == nil
+	if err != nil {
+		resp.StatusError = err.Error()
+	}
+	if len(status) > maxNDSCtlOutput {
+		status = status[:maxNDSCtlOutput]
+	}
+	resp.StatusOutput = status
+
+	clients, err := h.ndsctl.JSON()
+	resp.JSONOK = err == nil
+	if err != nil {
+		resp.JSONError = err.Error()
+	}
+	resp.ClientCount = len(clients)
+
+	resp.LatencyMs = time.Since(start).Milliseconds()
+	JSON(w, http.StatusOK, resp)
+}
+
+// HandleGetPasswordPolicy returns the admin and child password policies
+func (h *SystemHandler) HandleGetPasswordPolicy(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, h.storage.GetSettings().PasswordPolicy)
+}
+
+// HandleSetPasswordPolicy replaces the password policies (super admin only).
+// Existing passwords are unaffected; the policy applies when one is set.
+func (h *SystemHandler) HandleSetPasswordPolicy(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can change the password policy") {
+		return
+	}
+
+	var req models.PasswordPolicies
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	if req.Admin.MinLength < 1 || req.Child.MinLength < 1 {
+		Error(w, http.StatusBadRequest, "min_length must be at least 1")
+		return
+	}
+
+	settings := h.storage.GetSettings()
+	settings.PasswordPolicy = req
+	if err := h.storage.SaveSettings(settings); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to save password policy")
+		return
+	}
+
+	JSON(w, http.StatusOK, req)
+}
+
+// PruneRequest represents a history prune request
+type PruneRequest struct {
+	OlderThanDays int `json:"older_than_days"`
+}
+
+// HandlePrune handles POST /api/system/prune (super admin). It removes
+// inactive sessions, usage and audit records older than the given number of
+// days and reports how many of each were removed.
+func (h *SystemHandler) HandlePrune(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can prune history") {
+		return
+	}
+
+	var req PruneRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+	if req.OlderThanDays < 1 {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, "older_than_days must be at least 1")
+		return
+	}
+
+	result, err := h.storage.Prune(time.Now().AddDate(0, 0, -req.OlderThanDays))
+	if err != nil {
+		Error(w, http.StatusInternalServerError, "failed to prune history")
+		return
+	}
+
+	JSON(w, http.StatusOK, result)
+}
+
+// HandleGetDevicePolicy returns how devices are registered at login
+func (h *SystemHandler) HandleGetDevicePolicy(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, h.storage.GetSettings().DevicePolicy)
+}
+
+// HandleSetDevicePolicy replaces the device policy
+func (h *SystemHandler) HandleSetDevicePolicy(w http.ResponseWriter, r *http.Request) {
+	var req models.DevicePolicy
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	settings := h.storage.GetSettings()
+	settings.DevicePolicy = req
+	if err := h.storage.SaveSettings(settings); err != nil {
+		Error(w, http.StatusInternalServerError, msgSaveSettingsFailed)
+		return
+	}
+
+	JSON(w, http.StatusOK, req)
+}
+
+// Bounds for the session ticker interval
+const (
+	minTickIntervalSeconds = 10
+	maxTickIntervalSeconds = 3600
+)
+
+// TickerConfigRequest changes the session ticker interval
+type TickerConfigRequest struct {
+	IntervalSeconds int `json:"interval_seconds"`
+}
+
+// HandleTickerConfig changes the session ticker interval without a restart
+func (h *SystemHandler) HandleTickerConfig(w http.ResponseWriter, r *http.Request) {
+	var req TickerConfigRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	if req.IntervalSeconds < minTickIntervalSeconds || req.IntervalSeconds > maxTickIntervalSeconds {
+		Error(w, http.StatusBadRequest, fmt.Sprintf("interval_seconds must be between %d and %d",
+			minTickIntervalSeconds, maxTickIntervalSeconds))
+		return
+	}
+
+	h.ticker.SetInterval(time.Duration(req.IntervalSeconds) * time.Second)
+	h.config.Session.TickIntervalSeconds = req.IntervalSeconds
+
+	JSON(w, http.StatusOK, TickerConfigRequest{IntervalSeconds: req.IntervalSeconds})
+}
+
+// HandleTickerEvents handles GET /api/system/ticker-events?limit=50: the
+// session ticker's recent decisions, newest first
+func (h *SystemHandler) HandleTickerEvents(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= services.MaxTickEvents {
+		limit = n
+	}
+
+	JSON(w, http.StatusOK, h.ticker.RecentEvents(limit))
+}
+
+// HandleAuditLog returns recent audit log entries, newest first
+func (h *SystemHandler) HandleAuditLog(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= models.MaxAuditEntries {
+		limit = n
+	}
+
+	JSON(w, http.StatusOK, h.storage.ListAuditEntries(limit))
+}
+
 // checkDnsmasq checks if dnsmasq is running
 func (h *SystemHandler) checkDnsmasq() bool {
 	// Try to execute a simple dnsmasq check
@@ -155,29 +495,66 @@ func formatDuration(d time.Duration) string {
 
 // HealthResponse represents health check response
 type HealthResponse struct {
-	Status           string `json:"status"`
-	OpenNDSRunning   bool   `json:"opennds_running"`
-	OpenNDSClients   int    `json:"opennds_clients"`
-	GatewayInterface string `json:"gateway_interface"`
-	GatewayAddress   string `json:"gateway_address"`
+	Status           string   `json:"status"`
+	PortalAvailable  bool     `json:"portal_available"`
+	OpenNDSRunning   bool     `json:"opennds_running"`
+	OpenNDSClients   int      `json:"opennds_clients"`
+	GatewayInterface string   `json:"gateway_interface"`
+	GatewayAddress   string   `json:"gateway_address"`
 	Errors           []string `json:"errors,omitempty"`
+
+	// Outcome of the WAN and DNS checks, e.g. "ok" or "dns_broken"
+	Connectivity string `json:"connectivity"`
+
+	// Data file sizes in bytes, and a warning naming the files over
+	// storage.max_file_size_bytes
+	Storage        map[string]int64 `json:"storage"`
+	StorageWarning string           `json:"storage_warning,omitempty"`
 }
 
-// HandleHealth returns health check info
+// HandleHealth returns health check info. Responds 503 when openNDS is
+// down, since children cannot get online at all. Failed connectivity
+// checks or a failed scheduled backup make the status "degraded".
 func (h *SystemHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
+	resp, httpStatus := h.health(r.Context())
+	JSON(w, httpStatus, resp)
+}
 
+// CheckHealth returns the health status and what's wrong, for watching
+// transitions in the background
+func (h *SystemHandler) CheckHealth(ctx context.Context) (string, []string) {
+	resp, _ := h.health(ctx)
+	return resp.Status, resp.Errors
+}
+
+// health gathers the system health and the HTTP status to report it with
+func (h *SystemHandler) health(ctx context.Context) (HealthResponse, int) {
 	var errors []string
 	status := "healthy"
+	httpStatus := http.StatusOK
 
 	// Check OpenNDS
-	openNDSRunning := h.ndsctl.IsRunning()
+	gateway, openNDSRunning := h.gatewayInfo()
 	if !openNDSRunning {
-		errors = append(errors, "OpenNDS is not running")
-		status = "degraded"
+		errors = append(errors, "OpenNDS is not running: children cannot log in and quota is paused")
+		status = "down"
+		httpStatus = http.StatusServiceUnavailable
+	}
+
+	// Check the internet connection and filtering
+	conn := h.connectivity.Check(ctx, false)
+	if conn.Status != services.ConnectivityOK {
+		errors = append(errors, "Connectivity: "+conn.Summary)
+		if status == "healthy" {
+			status = "degraded"
+		}
+	}
+
+	if h.backups.Failed() {
+		errors = append(errors, "Last scheduled backup failed: "+h.backups.Status().LastError)
+		if status == "healthy" {
+			status = "degraded"
+		}
 	}
 
 	// Get OpenNDS client count
@@ -186,37 +563,219 @@ func (h *SystemHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 		clients = len(ndsClients)
 	}
 
-	// Get gateway info from config
-	gatewayInterface := "br-guest"
-	gatewayAddress := "192.168.2.1"
-
 	resp := HealthResponse{
 		Status:           status,
+		PortalAvailable:  openNDSRunning,
 		OpenNDSRunning:   openNDSRunning,
 		OpenNDSClients:   clients,
-		GatewayInterface: gatewayInterface,
-		GatewayAddress:   gatewayAddress,
+		GatewayInterface: gateway.Interface,
+		GatewayAddress:   gateway.Address,
 		Errors:           errors,
+		Connectivity:     conn.Status,
+		Storage:          h.storage.FileSizes(),
 	}
 
-	JSON(w, http.StatusOK, resp)
+	var large []string
+	for name, size := range resp.Storage {
+		if size > h.config.Storage.MaxFileSizeBytes {
+			large = append(large, fmt.Sprintf("%s (%s)", name, formatSize(size)))
+		}
+	}
+	if len(large) > 0 {
+		sort.Strings(large)
+		resp.StorageWarning = fmt.Sprintf("data files over %s: %s; prune old history to free flash space",
+			formatSize(h.config.Storage.MaxFileSizeBytes), strings.Join(large, ", "))
+	}
+
+	return resp, httpStatus
+}
+
+// ============ Updates ============
+
+// UpdateApplyResponse reports an installed update
+type UpdateApplyResponse struct {
+	Updated    bool   `json:"updated"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Restarting bool   `json:"restarting"`
+}
+
+// updatesEnabled reports whether updates are on, sending a 403 if not
+func (h *SystemHandler) updatesEnabled(w http.ResponseWriter) bool {
+	if h.updater == nil {
+		ErrorCode(w, http.StatusForbidden, CodeForbidden, "updates are disabled; set update.enabled and update.release_url in the config")
+		return false
+	}
+	return true
+}
+
+// HandleUpdateCheck handles GET /api/system/update/check
+func (h *SystemHandler) HandleUpdateCheck(w http.ResponseWriter, r *http.Request) {
+	if !h.updatesEnabled(w) {
+		return
+	}
+	info, err := h.updater.Check(r.Context())
+	if err != nil {
+		Error(w, http.StatusBadGateway, "failed to check for updates: "+err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, info)
+}
+
+// HandleUpdateApply handles POST /api/system/update/apply (super admin). It
+// installs the latest release and restarts the service to run it.
+func (h *SystemHandler) HandleUpdateApply(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can update Parenta") || !h.updatesEnabled(w) {
+		return
+	}
+
+	info, err := h.updater.Apply(r.Context())
+	switch {
+	case errors.Is(err, services.ErrUpToDate), errors.Is(err, services.ErrUpdateInProgress):
+		ErrorCode(w, http.StatusConflict, CodeConflict, err.Error())
+		return
+	case err != nil:
+		log.Printf("Update failed, keeping the current binary: %v", err)
+		Error(w, http.StatusBadGateway, "update failed, the current version keeps running: "+err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, UpdateApplyResponse{
+		Updated:    true,
+		From:       info.CurrentVersion,
+		To:         info.LatestVersion,
+		Restarting: true,
+	})
+
+	// Let the response go out first
+	time.AfterFunc(time.Second, h.restart)
+}
+
+// HandleConnectivity handles GET /api/system/connectivity?refresh=1. It
+// tells whether the WAN or DNS is down and whether filtering works.
+func (h *SystemHandler) HandleConnectivity(w http.ResponseWriter, r *http.Request) {
+	refresh := r.URL.Query().Get("refresh") == "1"
+	JSON(w, http.StatusOK, h.connectivity.Check(r.Context(), refresh))
+}
+
+// formatSize formats a byte count as KB or MB
+func formatSize(n int64) string {
+	if n < 1<<20 {
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
+
+// ============ Backups ============
+
+// HandleBackup handles GET /api/system/backup (super admin): the data
+// files as a .tar.gz download
+func (h *SystemHandler) HandleBackup(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can download backups") {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := h.backups.Write(&buf); err != nil {
+		if errors.Is(err, services.ErrBackupInProgress) {
+			Error(w, http.StatusConflict, err.Error())
+			return
+		}
+		log.Printf("Backup failed: %v", err)
+		Error(w, http.StatusInternalServerError, "failed to create backup")
+		return
+	}
+
+	name := "parenta-backup-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// HandleBackupRun handles POST /api/system/backup/run (super admin): makes
+// a backup to the configured destination now and returns the outcome
+func (h *SystemHandler) HandleBackupRun(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can run backups") {
+		return
+	}
+	if h.config.Backup.Destination == "" {
+		Error(w, http.StatusBadRequest, "no backup destination is configured; set backup.destination")
+		return
+	}
+
+	if _, err := h.backups.Run(r.Context()); err != nil {
+		log.Printf("Backup failed: %v", err)
+		Error(w, http.StatusBadGateway, "backup failed: "+err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, h.backups.Status())
 }
 
 // ============ Command Execution ============
 
-// AllowedCommands defines the whitelist of commands that can be executed
-var AllowedCommands = map[string][]string{
-	"ifconfig": {},
-	"ip":       {"addr", "link", "route"},
-	"ps":       {},
-	"df":       {"-h"},
-	"free":     {"-m"},
-	"uptime":   {},
-	"ndsctl":   {"status", "json"},
-	"logread":  {"-l"},
-	"iwinfo":   {},
-	"uci":      {"show"},
-	"cat":      {"/proc/meminfo", "/proc/loadavg"},
+// AllowedCommandsResponse represents the command allowlist in API responses
+type AllowedCommandsResponse struct {
+	AllowedCommands map[string][]string `json:"allowed_commands"`
+	Persisted       bool                `json:"persisted"`
+	Note            string              `json:"note,omitempty"`
+}
+
+// allowedArgs returns the arguments allowed for a command and whether the
+// command is allowed at all
+func (h *SystemHandler) allowedArgs(command string) ([]string, bool) {
+	h.commandsMu.RLock()
+	defer h.commandsMu.RUnlock()
+	args, ok := h.config.System.AllowedCommands[command]
+	return args, ok
+}
+
+// HandleGetAllowedCommands handles GET /api/system/allowed-commands (super admin)
+func (h *SystemHandler) HandleGetAllowedCommands(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can view the command allowlist") {
+		return
+	}
+
+	h.commandsMu.RLock()
+	commands := h.config.System.AllowedCommands
+	h.commandsMu.RUnlock()
+
+	JSON(w, http.StatusOK, AllowedCommandsResponse{AllowedCommands: commands})
+}
+
+// HandleSetAllowedCommands handles PUT /api/system/allowed-commands (super
+// admin). The new allowlist replaces the old one for the running process
+// only; it is not written to the config file.
+func (h *SystemHandler) HandleSetAllowedCommands(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can change the command allowlist") {
+		return
+	}
+
+	var req map[string][]string
+	if err := ParseJSON(r, &req); err != nil || req == nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+	if err := config.ValidateAllowedCommands(req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, err.Error())
+		return
+	}
+	for name, args := range req {
+		if args == nil {
+			req[name] = []string{}
+		}
+	}
+
+	h.commandsMu.Lock()
+	h.config.System.AllowedCommands = req
+	h.commandsMu.Unlock()
+
+	JSON(w, http.StatusOK, AllowedCommandsResponse{
+		AllowedCommands: req,
+		Persisted:       false,
+		Note:            "applies until the service restarts; set system.allowed_commands in the config file to keep it",
+	})
 }
 
 // CommandRequest represents command execution request
@@ -233,21 +792,31 @@ type CommandResponse struct {
 	Error    string `json:"error,omitempty"`
 }
 
-// HandleCommand executes a whitelisted command
+// requireRawCommands reports whether raw commands are turned on and the
+// request comes from a super admin, sending a 403 if not
+func (h *SystemHandler) requireRawCommands(w http.ResponseWriter, r *http.Request) bool {
+	if !h.config.System.AllowRawCommands {
+		Error(w, http.StatusForbidden, "raw commands are disabled; use /api/diagnostics, or set system.allow_raw_commands")
+		return false
+	}
+	return requireSuper(h.storage, w, r, "only super admins can run commands")
+}
+
+// HandleCommand executes a whitelisted command (super admin, when
+// system.allow_raw_commands is on)
 func (h *SystemHandler) HandleCommand(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
+	if !h.requireRawCommands(w, r) {
 		return
 	}
 
 	var req CommandRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
 	// Check if command is allowed
-	allowedArgs, ok := AllowedCommands[req.Command]
+	allowedArgs, ok := h.allowedArgs(req.Command)
 	if !ok {
 		Error(w, http.StatusForbidden, "command not allowed: "+req.Command)
 		return
@@ -268,16 +837,23 @@ func (h *SystemHandler) HandleCommand(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Run the binary from a trusted directory, whatever PATH says now
+	path, err := config.ResolveCommand(req.Command)
+	if err != nil {
+		Error(w, http.StatusForbidden, "command not available: "+err.Error())
+		return
+	}
+
 	// Execute command with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, req.Command, req.Args...)
+	cmd := exec.CommandContext(ctx, path, req.Args...)
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err = cmd.Run()
 	exitCode := 0
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
@@ -320,11 +896,6 @@ type LogsResponse struct {
 
 // HandleLogs returns filtered system logs
 func (h *SystemHandler) HandleLogs(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
-
 	// Get query params
 	filter := r.URL.Query().Get("filter") // parenta, opennds, dnsmasq, or empty for all
 	linesStr := r.URL.Query().Get("lines")
@@ -336,14 +907,17 @@ func (h *SystemHandler) HandleLogs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Execute logread with filter
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "logread", "-l", strconv.Itoa(lines))
 	output, err := cmd.Output()
 	if err != nil {
-		// Fallback: try reading from /var/log/messages
+		// Fallback: try reading from /var/log/messages, then Parenta's own log
 		output, err = os.ReadFile("/var/log/messages")
+		if err != nil && h.logs != nil {
+			output, err = []byte(strings.Join(h.logs.Lines(), "\n")), nil
+		}
 		if err != nil {
 			Error(w, http.StatusInternalServerError, "failed to read logs")
 			return
@@ -354,14 +928,8 @@ func (h *SystemHandler) HandleLogs(w http.ResponseWriter, r *http.Request) {
 	var filteredLines []string
 	scanner := bufio.NewScanner(bytes.NewReader(output))
 	for scanner.Scan() {
-		line := scanner.Text()
-		if filter == "" {
+		if line := scanner.Text(); logLineMatches(line, filter) {
 			filteredLines = append(filteredLines, line)
-		} else {
-			lowerLine := strings.ToLower(line)
-			if strings.Contains(lowerLine, strings.ToLower(filter)) {
-				filteredLines = append(filteredLines, line)
-			}
 		}
 	}
 
@@ -379,6 +947,133 @@ func (h *SystemHandler) HandleLogs(w http.ResponseWriter, r *http.Request) {
 	JSON(w, http.StatusOK, resp)
 }
 
+// logLineMatches reports whether a log line contains filter, ignoring case.
+// An empty filter matches every line.
+func logLineMatches(line, filter string) bool {
+	return filter == "" || strings.Contains(strings.ToLower(line), strings.ToLower(filter))
+}
+
+// logStreamCommand follows the system log
+var logStreamCommand = []string{"logread", "-f"}
+
+// logHeartbeat is how often an idle log stream sends a comment, so proxies
+// don't close the connection
+const logHeartbeat = 15 * time.Second
+
+// HandleLogsStream handles GET /api/system/logs/stream?filter=X&source=Y as
+// server-sent events. Each log line is a "data:" event, and a "source"
+// event first says where lines come from: "syslog" (logread -f) or
+// "parenta" (Parenta's own log, also the fallback when logread isn't
+// available). The logread process ends when the client disconnects.
+func (h *SystemHandler) HandleLogsStream(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("filter")
+	source := r.URL.Query().Get("source")
+	if source != "" && source != "syslog" && source != "parenta" {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, "source must be syslog or parenta")
+		return
+	}
+
+	ctx := r.Context()
+	var lines <-chan string
+	var backlog []string
+	if source != "parenta" {
+		var err error
+		lines, err = followSyslog(ctx)
+		if err != nil {
+			if source == "syslog" {
+				Error(w, http.StatusServiceUnavailable, "failed to follow syslog: "+err.Error())
+				return
+			}
+			log.Printf("Log stream: logread unavailable (%v), streaming Parenta's log", err)
+		} else {
+			source = "syslog"
+		}
+	}
+	if lines == nil {
+		if h.logs == nil {
+			Error(w, http.StatusServiceUnavailable, "no log source available")
+			return
+		}
+		sub, unsubscribe := h.logs.Subscribe()
+		defer unsubscribe()
+		lines, backlog, source = sub, h.logs.Lines(), "parenta"
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	send := func(format string, args ...any) bool {
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if !send("event: source\ndata: %s\n\n", source) {
+		return
+	}
+	for _, line := range backlog {
+		if logLineMatches(line, filter) && !send("data: %s\n\n", line) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(logHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line, ok := <-lines:
+			if !ok {
+				send("event: end\ndata: log source closed\n\n")
+				return
+			}
+			if logLineMatches(line, filter) && !send("data: %s\n\n", strings.TrimRight(line, "\r")) {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(": ping\n\n") {
+				return
+			}
+		}
+	}
+}
+
+// followSyslog starts logStreamCommand and sends each line it prints. The
+// process is killed when ctx ends, and the channel is closed once it has
+// exited.
+func followSyslog(ctx context.Context) (<-chan string, error) {
+	cmd := exec.CommandContext(ctx, logStreamCommand[0], logStreamCommand[1:]...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		defer cmd.Wait()
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return lines, nil
+}
+
 // ============ Shell Execution ============
 
 // ShellRequest represents shell execution request
@@ -386,16 +1081,16 @@ type ShellRequest struct {
 	Command string `json:"command"` // Full command string e.g. "ls -la /etc"
 }
 
-// HandleShell executes arbitrary shell command (admin only)
+// HandleShell executes arbitrary shell command (super admin, when
+// system.allow_raw_commands is on)
 func (h *SystemHandler) HandleShell(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
+	if !h.requireRawCommands(w, r) {
 		return
 	}
 
 	var req ShellRequest
 	if err := ParseJSON(r, &req); err != nil {
-		Error(w, http.StatusBadRequest, "invalid request body")
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
 		return
 	}
 
@@ -405,7 +1100,7 @@ func (h *SystemHandler) HandleShell(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Execute command with 30 second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "sh", "-c", req.Command)
@@ -457,13 +1152,13 @@ func (h *SystemHandler) HandleShell(w http.ResponseWriter, r *http.Request) {
 // DashboardResponse represents enhanced dashboard metrics
 type DashboardResponse struct {
 	// Existing
-	Version        string  `json:"version"`
-	Uptime         string  `json:"uptime"`
-	UptimeSeconds  int64   `json:"uptime_seconds"`
-	OpenNDSRunning bool    `json:"opennds_running"`
-	DnsmasqRunning bool    `json:"dnsmasq_running"`
-	ActiveSessions int     `json:"active_sessions"`
-	TotalChildren  int     `json:"total_children"`
+	Version        string `json:"version"`
+	Uptime         string `json:"uptime"`
+	UptimeSeconds  int64  `json:"uptime_seconds"`
+	OpenNDSRunning bool   `json:"opennds_running"`
+	DnsmasqRunning bool   `json:"dnsmasq_running"`
+	ActiveSessions int    `json:"active_sessions"`
+	TotalChildren  int    `json:"total_children"`
 
 	// New metrics
 	MemoryUsedMB    float64 `json:"memory_used_mb"`
@@ -475,13 +1170,38 @@ type DashboardResponse struct {
 	LowQuotaAlerts  int     `json:"low_quota_alerts"`
 }
 
-// HandleDashboard returns enhanced dashboard metrics
+// HandleDashboard returns enhanced dashboard metrics. Collecting them reads
+// /proc and runs df and ndsctl, so a recent snapshot is reused for
+// system.dashboard_cache_seconds; ?refresh=1 forces a new one.
 func (h *SystemHandler) HandleDashboard(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		Error(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
+	ttl := time.Duration(h.config.System.DashboardCacheSeconds) * time.Second
+	resp, age := h.Dashboard(r.URL.Query().Get("refresh") == "1")
+
+	if maxAge := int(math.Ceil((ttl - age).Seconds())); maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
 	}
+	JSONWithETag(w, r, http.StatusOK, resp)
+}
 
+// Dashboard returns the dashboard snapshot and its age, collecting a new one
+// if the cached one is older than system.dashboard_cache_seconds or refresh
+// is set
+func (h *SystemHandler) Dashboard(refresh bool) (DashboardResponse, time.Duration) {
+	ttl := time.Duration(h.config.System.DashboardCacheSeconds) * time.Second
+
+	h.dashboardMu.Lock()
+	defer h.dashboardMu.Unlock()
+	if refresh || h.dashboard == nil || time.Since(h.dashboardAt) >= ttl {
+		h.dashboard = h.collectDashboard()
+		h.dashboardAt = time.Now()
+	}
+	return *h.dashboard, time.Since(h.dashboardAt)
+}
+
+// collectDashboard gathers the dashboard metrics
+func (h *SystemHandler) collectDashboard() *DashboardResponse {
 	// Basic info
 	openNDSRunning := h.ndsctl.IsRunning()
 	dnsmasqRunning := h.checkDnsmasq()
@@ -521,8 +1241,8 @@ func (h *SystemHandler) HandleDashboard(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	resp := DashboardResponse{
-		Version:         "1.0.0",
+	return &DashboardResponse{
+		Version:         version.Version,
 		Uptime:          formatDuration(uptime),
 		UptimeSeconds:   int64(uptime.Seconds()),
 		OpenNDSRunning:  openNDSRunning,
@@ -537,8 +1257,6 @@ func (h *SystemHandler) HandleDashboard(w http.ResponseWriter, r *http.Request)
 		OpenNDSClients:  ndsClients,
 		LowQuotaAlerts:  lowQuotaAlerts,
 	}
-
-	JSON(w, http.StatusOK, resp)
 }
 
 // getSystemMemory reads memory info from /proc/meminfo
@@ -564,6 +1282,23 @@ func (h *SystemHandler) getSystemMemory() (used, total float64) {
 	return used, total
 }
 
+// getSystemUptime reads the kernel uptime from /proc/uptime
+func (h *SystemHandler) getSystemUptime() time.Duration {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0
+	}
+	parts := strings.Fields(string(data))
+	if len(parts) == 0 {
+		return 0
+	}
+	seconds, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
 // getCPULoad reads load average from /proc/loadavg
 func (h *SystemHandler) getCPULoad() string {
 	data, err := os.ReadFile("/proc/loadavg")
@@ -579,27 +1314,24 @@ func (h *SystemHandler) getCPULoad() string {
 
 // getDiskUsage gets disk usage percentage for /opt
 func (h *SystemHandler) getDiskUsage() float64 {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "df", "-h", "/opt")
-	output, err := cmd.Output()
+	stats, err := diskUsage("/opt")
 	if err != nil {
 		return 0
 	}
+	return stats.PercentUsed
+}
 
-	lines := strings.Split(string(output), "\n")
-	if len(lines) < 2 {
-		return 0
-	}
-
-	fields := strings.Fields(lines[1])
-	if len(fields) < 5 {
-		return 0
+// HandleDisk handles GET /api/system/disk, reporting each of
+// system.disk_paths. A path that can't be read is listed with its error.
+func (h *SystemHandler) HandleDisk(w http.ResponseWriter, r *http.Request) {
+	paths := h.config.System.DiskPaths
+	result := make([]DiskStats, 0, len(paths))
+	for _, path := range paths {
+		stats, err := diskUsage(path)
+		if err != nil {
+			stats = DiskStats{Path: path, Error: err.Error()}
+		}
+		result = append(result, stats)
 	}
-
-	// Parse percentage (e.g., "45%")
-	pctStr := strings.TrimSuffix(fields[4], "%")
-	pct, _ := strconv.ParseFloat(pctStr, 64)
-	return pct
+	JSON(w, http.StatusOK, result)
 }
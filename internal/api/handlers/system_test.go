@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"parenta/internal/services"
+)
+
+// fakeLogread makes logStreamCommand a shell that records its PID in the
+// returned file, prints lines, then waits like logread -f does
+func fakeLogread(t *testing.T, lines ...string) string {
+	t.Helper()
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	script := "echo $$ > " + pidFile + "; printf '%s\\n'"
+	for _, line := range lines {
+		script += " '" + line + "'"
+	}
+	saved := logStreamCommand
+	logStreamCommand = []string{"sh", "-c", script + "; exec sleep 60"}
+	t.Cleanup(func() { logStreamCommand = saved })
+	return pidFile
+}
+
+// waitExited fails the test unless the process in pidFile has exited and
+// been reaped within a few seconds
+func waitExited(t *testing.T, pidFile string) {
+	t.Helper()
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("fake logread never started: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for syscall.Kill(pid, 0) != syscall.ESRCH {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("logread process %d still running after the client left", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFollowSyslogStops(t *testing.T) {
+	pidFile := fakeLogread(t, "first", "second")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lines, err := followSyslog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-lines; got != "first" {
+		t.Fatalf("first line %q", got)
+	}
+	cancel()
+
+	// The channel closes once the reader goroutine is done and the
+	// process has been waited for
+	timeout := time.After(3 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-lines:
+		case <-timeout:
+			t.Fatal("line channel still open after cancelling")
+		}
+	}
+	waitExited(t, pidFile)
+}
+
+// TestHandleLogsStreamDisconnect follows a log stream over HTTP and checks
+// that hanging up ends the handler and the logread process
+func TestHandleLogsStreamDisconnect(t *testing.T) {
+	pidFile := fakeLogread(t, "dnsmasq: query example.com", "opennds: client authed")
+
+	h := &SystemHandler{}
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		h.HandleLogsStream(w, r)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"?filter=OpenNDS", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+
+	// The source event, then only the line matching the filter
+	want := []string{"event: source", "data: syslog", "", "data: opennds: client authed"}
+	scanner := bufio.NewScanner(resp.Body)
+	for _, w := range want {
+		if !scanner.Scan() {
+			t.Fatalf("stream ended before %q: %v", w, scanner.Err())
+		}
+		if got := scanner.Text(); got != w {
+			t.Fatalf("got %q, want %q", got, w)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("handler still running after the client left")
+	}
+	waitExited(t, pidFile)
+}
+
+func TestHandleLogsStreamSources(t *testing.T) {
+	t.Run("syslog asked for but unavailable", func(t *testing.T) {
+		saved := logStreamCommand
+		logStreamCommand = []string{"/nonexistent/logread", "-f"}
+		defer func() { logStreamCommand = saved }()
+
+		rec := httptest.NewRecorder()
+		(&SystemHandler{}).HandleLogsStream(rec, httptest.NewRequest(http.MethodGet, "/?source=syslog", nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("status %d, want 503", rec.Code)
+		}
+	})
+
+	t.Run("unknown source", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		(&SystemHandler{}).HandleLogsStream(rec, httptest.NewRequest(http.MethodGet, "/?source=kernel", nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+
+	t.Run("parenta log unsubscribes on disconnect", func(t *testing.T) {
+		logs := services.NewLogBuffer(10)
+		logs.Write([]byte("Parenta started\n"))
+		h := &SystemHandler{logs: logs}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/?source=parenta", nil).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			h.HandleLogsStream(rec, req)
+		}()
+
+		deadline := time.Now().Add(3 * time.Second)
+		for logs.Subscribers() != 1 {
+			if time.Now().After(deadline) {
+				t.Fatal("stream never subscribed")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("handler still running after the client left")
+		}
+		if n := logs.Subscribers(); n != 0 {
+			t.Errorf("%d subscribers left", n)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, "data: parenta\n") || !strings.Contains(body, "Parenta started\n") {
+			t.Errorf("body %q", body)
+		}
+	})
+}
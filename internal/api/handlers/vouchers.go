@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"parenta/internal/api/middleware"
+	"parenta/internal/models"
+	"parenta/internal/services"
+	"parenta/internal/storage"
+)
+
+// New vouchers are good for three hours by default, and at most a week
+const (
+	defaultVoucherMinutes = 180
+	maxVoucherMinutes     = 7 * 24 * 60
+)
+
+// VouchersHandler handles guest voucher endpoints
+type VouchersHandler struct {
+	storage  *storage.Storage
+	ndsPool  *services.NDSCtlPool
+	notifier *services.Notifier
+}
+
+// NewVouchersHandler creates a new VouchersHandler
+func NewVouchersHandler(store *storage.Storage, ndsPool *services.NDSCtlPool, notifier *services.Notifier) *VouchersHandler {
+	return &VouchersHandler{
+		storage:  store,
+		ndsPool:  ndsPool,
+		notifier: notifier,
+	}
+}
+
+// VoucherRequest represents create/update voucher request. On update,
+// omitted fields keep their values.
+type VoucherRequest struct {
+	DurationMin  *int       `json:"duration_min"`
+	UploadKbps   *int       `json:"upload_kbps"`
+	DownloadKbps *int       `json:"download_kbps"`
+	MaxUses      *int       `json:"max_uses"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+// VoucherResponse represents a voucher in API responses
+type VoucherResponse struct {
+	models.Voucher
+	RemainingUses  int  `json:"remaining_uses"`
+	ActiveSessions int  `json:"active_sessions"`
+	Redeemable     bool `json:"redeemable"`
+}
+
+// guestRemainingMinutes returns what is left of a guest session
+func guestRemainingMinutes(v *models.Voucher, s *models.Session) int {
+	remaining := int(time.Until(v.SessionEndsAt(s.StartedAt)).Minutes())
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// toVoucherResponse converts Voucher to VoucherResponse
+func (h *VouchersHandler) toVoucherResponse(v *models.Voucher) VoucherResponse {
+	return VoucherResponse{
+		Voucher:        *v,
+		RemainingUses:  v.RemainingUses(),
+		ActiveSessions: len(h.storage.ListVoucherSessions(v.ID)),
+		Redeemable:     v.IsRedeemableAt(time.Now()),
+	}
+}
+
+// apply copies the sent fields of req onto v and validates the result
+func (req *VoucherRequest) apply(v *models.Voucher) string {
+	if req.DurationMin != nil {
+		v.DurationMin = *req.DurationMin
+	}
+	if req.UploadKbps != nil {
+		v.UploadKbps = *req.UploadKbps
+	}
+	if req.DownloadKbps != nil {
+		v.DownloadKbps = *req.DownloadKbps
+	}
+	if req.MaxUses != nil {
+		v.MaxUses = *req.MaxUses
+	}
+	if req.ExpiresAt != nil {
+		v.ExpiresAt = req.ExpiresAt
+	}
+
+	switch {
+	case v.DurationMin < 1 || v.DurationMin > maxVoucherMinutes:
+		return "duration_min must be between 1 and 10080"
+	case v.UploadKbps < 0 || v.DownloadKbps < 0:
+		return "bandwidth limits cannot be negative"
+	case v.MaxUses < 1:
+		return "max_uses must be at least 1"
+	}
+	return ""
+}
+
+// HandleList handles GET /api/vouchers
+func (h *VouchersHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	vouchers := h.storage.ListVouchers()
+	response := make([]VoucherResponse, len(vouchers))
+	for i, v := range vouchers {
+		response[i] = h.toVoucherResponse(v)
+	}
+	JSON(w, http.StatusOK, response)
+}
+
+// HandleGet handles GET /api/vouchers/{id}
+func (h *VouchersHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	voucher := h.storage.GetVoucher(r.PathValue("id"))
+	if voucher == nil {
+		Error(w, http.StatusNotFound, msgVoucherNotFound)
+		return
+	}
+	JSON(w, http.StatusOK, h.toVoucherResponse(voucher))
+}
+
+// HandleCreate handles POST /api/vouchers
+func (h *VouchersHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	var req VoucherRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	voucher := &models.Voucher{
+		ID:          services.GenerateID(),
+		DurationMin: defaultVoucherMinutes,
+		MaxUses:     1,
+		CreatedAt:   time.Now(),
+	}
+	if claims := middleware.GetClaims(r); claims != nil {
+		voucher.CreatedBy = claims.Username
+	}
+	if msg := req.apply(voucher); msg != "" {
+		Error(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	// Codes are short, so make sure a new one doesn't collide
+	for {
+		voucher.Code = services.GenerateVoucherCode()
+		if h.storage.GetVoucherByCode(voucher.Code) == nil {
+			break
+		}
+	}
+
+	if err := h.storage.SaveVoucher(voucher); err != nil {
+		Error(w, http.StatusInternalServerError, msgSaveVoucherFailed)
+		return
+	}
+
+	JSON(w, http.StatusCreated, h.toVoucherResponse(voucher))
+}
+
+// HandleUpdate handles PUT /api/vouchers/{id}
+func (h *VouchersHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	existing := h.storage.GetVoucher(r.PathValue("id"))
+	if existing == nil {
+		Error(w, http.StatusNotFound, msgVoucherNotFound)
+		return
+	}
+
+	var req VoucherRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+
+	// Edit a copy, since logins may be reading the stored voucher
+	voucher := *existing
+	if msg := req.apply(&voucher); msg != "" {
+		Error(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if err := h.storage.SaveVoucher(&voucher); err != nil {
+		Error(w, http.StatusInternalServerError, msgSaveVoucherFailed)
+		return
+	}
+
+	JSON(w, http.StatusOK, h.toVoucherResponse(&voucher))
+}
+
+// HandleDelete handles DELETE /api/vouchers/{id}. Guests online with the
+// voucher are disconnected.
+func (h *VouchersHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if h.storage.GetVoucher(id) == nil {
+		Error(w, http.StatusNotFound, msgVoucherNotFound)
+		return
+	}
+
+	if err := h.storage.DeleteVoucher(id); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to delete voucher")
+		return
+	}
+
+	for _, session := range h.storage.ListVoucherSessions(id) {
+		if err := h.ndsPool.ForSession(session).DeauthBest(session); err != nil {
+			log.Printf("Voucher delete: deauth error for session %s: %v", session.ID, err)
+		}
+		session.End("voucher_deleted")
+		h.storage.SaveSession(session)
+		h.notifier.SessionEnded(session)
+	}
+
+	JSON(w, http.StatusOK, map[string]bool{"success": true})
+}
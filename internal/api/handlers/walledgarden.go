@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"parenta/internal/api/middleware"
+	"parenta/internal/models"
+	"parenta/internal/services"
+	"parenta/internal/storage"
+)
+
+// Warnings about walled garden changes, which need an openNDS restart
+const (
+	walledGardenPendingWarning = "changes take effect after POST /api/network/walled-garden/apply, which restarts openNDS and disconnects every client"
+	walledGardenAppliedWarning = "openNDS was restarted; every client was disconnected and has to log in again"
+)
+
+// WalledGardenHandler manages hosts reachable before login
+type WalledGardenHandler struct {
+	storage      *storage.Storage
+	walledGarden *services.WalledGardenService
+}
+
+// NewWalledGardenHandler creates a new WalledGardenHandler
+func NewWalledGardenHandler(store *storage.Storage, walledGarden *services.WalledGardenService) *WalledGardenHandler {
+	return &WalledGardenHandler{
+		storage:      store,
+		walledGarden: walledGarden,
+	}
+}
+
+// WalledGardenRequest represents create/update walled garden entry request
+type WalledGardenRequest struct {
+	Host    string `json:"host"`
+	Ports   []int  `json:"ports"`
+	Comment string `json:"comment"`
+}
+
+// WalledGardenResponse lists the walled garden with its applied state
+type WalledGardenResponse struct {
+	Entries []*models.WalledGardenEntry `json:"entries"`
+	services.WalledGardenStatus
+	Warning string `json:"warning,omitempty"`
+}
+
+// WalledGardenEntryResponse is an entry that was just changed
+type WalledGardenEntryResponse struct {
+	*models.WalledGardenEntry
+	Pending bool   `json:"pending"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// validate normalizes the host and ports, returning a message if invalid
+func (req *WalledGardenRequest) validate() string {
+	host, err := models.NormalizeHost(req.Host)
+	if err != nil {
+		return err.Error()
+	}
+	if err := models.ValidatePorts(req.Ports); err != nil {
+		return err.Error()
+	}
+	req.Host = host
+	slices.Sort(req.Ports)
+	req.Ports = slices.Compact(req.Ports)
+	return ""
+}
+
+// status returns the applied state with a warning if changes are pending
+func (h *WalledGardenHandler) status() (services.WalledGardenStatus, string) {
+	status := h.walledGarden.Status()
+	if status.Pending {
+		return status, walledGardenPendingWarning
+	}
+	return status, ""
+}
+
+// HandleList handles GET /api/network/walled-garden
+func (h *WalledGardenHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	status, warning := h.status()
+	JSON(w, http.StatusOK, WalledGardenResponse{
+		Entries:            h.storage.ListWalledGarden(),
+		WalledGardenStatus: status,
+		Warning:            warning,
+	})
+}
+
+// HandleCreate handles POST /api/network/walled-garden
+func (h *WalledGardenHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	var req WalledGardenRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msg)
+		return
+	}
+	if h.storage.GetWalledGardenEntryByHost(req.Host) != nil {
+		ErrorCode(w, http.StatusConflict, CodeConflict, "host is already in the walled garden")
+		return
+	}
+
+	addedBy := ""
+	if claims := middleware.GetClaims(r); claims != nil {
+		addedBy = claims.Username
+	}
+
+	entry := &models.WalledGardenEntry{
+		ID:      services.GenerateID(),
+		Host:    req.Host,
+		Ports:   req.Ports,
+		Comment: req.Comment,
+		AddedBy: addedBy,
+		AddedAt: time.Now(),
+	}
+	if err := h.storage.SaveWalledGardenEntry(entry); err != nil {
+		Error(w, http.StatusInternalServerError, msgSaveWalledGardenFailed)
+		return
+	}
+
+	status, warning := h.status()
+	JSON(w, http.StatusCreated, WalledGardenEntryResponse{entry, status.Pending, warning})
+}
+
+// HandleUpdate handles PUT /api/network/walled-garden/{id}
+func (h *WalledGardenHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	existing := h.storage.GetWalledGardenEntry(r.PathValue("id"))
+	if existing == nil {
+		Error(w, http.StatusNotFound, msgWalledGardenNotFound)
+		return
+	}
+
+	var req WalledGardenRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msg)
+		return
+	}
+	if other := h.storage.GetWalledGardenEntryByHost(req.Host); other != nil && other.ID != existing.ID {
+		ErrorCode(w, http.StatusConflict, CodeConflict, "host is already in the walled garden")
+		return
+	}
+
+	// Replace rather than modify, since readers may hold the old record
+	entry := *existing
+	entry.Host = req.Host
+	entry.Ports = req.Ports
+	entry.Comment = req.Comment
+	if err := h.storage.SaveWalledGardenEntry(&entry); err != nil {
+		Error(w, http.StatusInternalServerError, msgSaveWalledGardenFailed)
+		return
+	}
+
+	status, warning := h.status()
+	JSON(w, http.StatusOK, WalledGardenEntryResponse{&entry, status.Pending, warning})
+}
+
+// HandleDelete handles DELETE /api/network/walled-garden/{id}
+func (h *WalledGardenHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if h.storage.GetWalledGardenEntry(id) == nil {
+		Error(w, http.StatusNotFound, msgWalledGardenNotFound)
+		return
+	}
+
+	if err := h.storage.DeleteWalledGardenEntry(id); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to delete walled garden entry")
+		return
+	}
+
+	status, warning := h.status()
+	JSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"pending": status.Pending,
+		"warning": warning,
+	})
+}
+
+// HandleApply handles POST /api/network/walled-garden/apply. It writes the
+// walled garden into the openNDS config and restarts openNDS, unless
+// nothing is pending.
+func (h *WalledGardenHandler) HandleApply(w http.ResponseWriter, r *http.Request) {
+	warning := ""
+	if h.walledGarden.Status().Pending {
+		if err := h.walledGarden.Apply(); err != nil {
+			log.Printf("Walled garden: apply failed: %v", err)
+			Error(w, http.StatusInternalServerError, "failed to apply walled garden: "+err.Error())
+			return
+		}
+		log.Printf("Walled garden applied; openNDS restarted")
+		warning = walledGardenAppliedWarning
+	}
+
+	JSON(w, http.StatusOK, WalledGardenResponse{
+		Entries:            h.storage.ListWalledGarden(),
+		WalledGardenStatus: h.walledGarden.Status(),
+		Warning:            warning,
+	})
+}
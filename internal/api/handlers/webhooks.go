@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"parenta/internal/api/middleware"
+	"parenta/internal/models"
+	"parenta/internal/services"
+	"parenta/internal/storage"
+)
+
+// minWebhookSecret is the shortest secret a subscription may be given
+const minWebhookSecret = 16
+
+// WebhooksHandler manages webhook subscriptions and their deliveries
+type WebhooksHandler struct {
+	storage  *storage.Storage
+	webhooks *services.Webhooks
+}
+
+// NewWebhooksHandler creates a new WebhooksHandler
+func NewWebhooksHandler(store *storage.Storage, webhooks *services.Webhooks) *WebhooksHandler {
+	return &WebhooksHandler{
+		storage:  store,
+		webhooks: webhooks,
+	}
+}
+
+// WebhookRequest represents create/update webhook request. On create an
+// empty secret is generated and enabled defaults to true; on update they
+// keep their values when omitted, as does a secret sent back masked.
+type WebhookRequest struct {
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"`
+}
+
+// validate checks the URL, secret and events, returning a message if
+// invalid. Events are deduplicated.
+func (req *WebhookRequest) validate() string {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "url must be an http(s) URL"
+	}
+	if req.Secret != "" && len(req.Secret) < minWebhookSecret {
+		return fmt.Sprintf("secret must be at least %d characters", minWebhookSecret)
+	}
+	if len(req.Events) == 0 {
+		return "events is required"
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(models.WebhookEvents, models.NotificationEvent(event)) {
+			return fmt.Sprintf("unknown event %q", event)
+		}
+	}
+	slices.Sort(req.Events)
+	req.Events = slices.Compact(req.Events)
+	return ""
+}
+
+// events converts the request's events
+func (req *WebhookRequest) events() []models.NotificationEvent {
+	events := make([]models.NotificationEvent, len(req.Events))
+	for i, event := range req.Events {
+		events[i] = models.NotificationEvent(event)
+	}
+	return events
+}
+
+// maskWebhook copies a subscription with its secret masked
+func maskWebhook(sub *models.WebhookSubscription) *models.WebhookSubscription {
+	masked := *sub
+	masked.Secret = maskSecret(sub.Secret)
+	return &masked
+}
+
+// HandleList handles GET /api/webhooks. Secrets are masked.
+func (h *WebhooksHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	subs := h.storage.ListWebhooks()
+	result := make([]*models.WebhookSubscription, len(subs))
+	for i, sub := range subs {
+		result[i] = maskWebhook(sub)
+	}
+	JSON(w, http.StatusOK, result)
+}
+
+// HandleGet handles GET /api/webhooks/{id}
+func (h *WebhooksHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	sub := h.storage.GetWebhook(r.PathValue("id"))
+	if sub == nil {
+		ErrorCode(w, http.StatusNotFound, CodeNotFound, msgWebhookNotFound)
+		return
+	}
+	JSON(w, http.StatusOK, maskWebhook(sub))
+}
+
+// HandleCreate handles POST /api/webhooks. The response has the secret in
+// full, which later responses mask.
+func (h *WebhooksHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can add webhooks") {
+		return
+	}
+
+	var req WebhookRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msg)
+		return
+	}
+
+	createdBy := ""
+	if claims := middleware.GetClaims(r); claims != nil {
+		createdBy = claims.Username
+	}
+
+	now := time.Now()
+	sub := &models.WebhookSubscription{
+		ID:        services.GenerateID(),
+		URL:       req.URL,
+		Secret:    req.Secret,
+		Events:    req.events(),
+		Enabled:   req.Enabled == nil || *req.Enabled,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if sub.Secret == "" {
+		sub.Secret = services.GenerateToken()
+	}
+	if err := h.storage.SaveWebhook(sub); err != nil {
+		Error(w, http.StatusInternalServerError, msgSaveWebhookFailed)
+		return
+	}
+
+	JSON(w, http.StatusCreated, sub)
+}
+
+// HandleUpdate handles PUT /api/webhooks/{id}. Enabling a subscription that
+// was disabled clears its failure count.
+func (h *WebhooksHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can change webhooks") {
+		return
+	}
+
+	existing := h.storage.GetWebhook(r.PathValue("id"))
+	if existing == nil {
+		ErrorCode(w, http.StatusNotFound, CodeNotFound, msgWebhookNotFound)
+		return
+	}
+
+	var req WebhookRequest
+	if err := ParseJSON(r, &req); err != nil {
+		ErrorCode(w, http.StatusBadRequest, CodeInvalidBody, msgInvalidBody)
+		return
+	}
+	if req.Secret == "" {
+		req.Secret = existing.Secret
+	}
+	req.Secret = keepSecret(req.Secret, existing.Secret)
+	if msg := req.validate(); msg != "" {
+		ErrorCode(w, http.StatusBadRequest, CodeValidation, msg)
+		return
+	}
+
+	// Replace rather than modify, since deliveries may hold the old record
+	sub := *existing
+	sub.URL = req.URL
+	sub.Secret = req.Secret
+	sub.Events = req.events()
+	if req.Enabled != nil {
+		if *req.Enabled && !sub.Enabled {
+			sub.ConsecutiveFailures = 0
+			sub.DisabledReason = ""
+		}
+		sub.Enabled = *req.Enabled
+	}
+	sub.UpdatedAt = time.Now()
+	if err := h.storage.SaveWebhook(&sub); err != nil {
+		Error(w, http.StatusInternalServerError, msgSaveWebhookFailed)
+		return
+	}
+
+	JSON(w, http.StatusOK, maskWebhook(&sub))
+}
+
+// HandleDelete handles DELETE /api/webhooks/{id}
+func (h *WebhooksHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can delete webhooks") {
+		return
+	}
+
+	id := r.PathValue("id")
+	if h.storage.GetWebhook(id) == nil {
+		ErrorCode(w, http.StatusNotFound, CodeNotFound, msgWebhookNotFound)
+		return
+	}
+	if err := h.storage.DeleteWebhook(id); err != nil {
+		Error(w, http.StatusInternalServerError, "failed to delete webhook")
+		return
+	}
+	h.webhooks.Forget(id)
+
+	JSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// HandleDeliveries handles GET /api/webhooks/deliveries?limit=50 and
+// GET /api/webhooks/{id}/deliveries?limit=50: recent deliveries, newest
+// first, with their payloads
+func (h *WebhooksHandler) HandleDeliveries(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id != "" && h.storage.GetWebhook(id) == nil {
+		ErrorCode(w, http.StatusNotFound, CodeNotFound, msgWebhookNotFound)
+		return
+	}
+
+	limit := 50
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= services.MaxWebhookDeliveries {
+		limit = n
+	}
+
+	JSON(w, http.StatusOK, h.webhooks.Deliveries(id, limit))
+}
+
+// HandleRedeliver handles POST /api/webhooks/{id}/deliveries/{delivery}/redeliver.
+// The event is posted again once, even to a disabled subscription, and the
+// new delivery is returned.
+func (h *WebhooksHandler) HandleRedeliver(w http.ResponseWriter, r *http.Request) {
+	if !requireSuper(h.storage, w, r, "only super admins can redeliver webhooks") {
+		return
+	}
+
+	delivery, err := h.webhooks.Redeliver(r.Context(), r.PathValue("id"), r.PathValue("delivery"))
+	switch {
+	case errors.Is(err, services.ErrWebhookNotFound):
+		ErrorCode(w, http.StatusNotFound, CodeNotFound, msgWebhookNotFound)
+	case errors.Is(err, services.ErrDeliveryNotFound):
+		ErrorCode(w, http.StatusNotFound, CodeNotFound, "delivery not found; only recent deliveries can be redelivered")
+	case err != nil:
+		Error(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		JSON(w, http.StatusOK, delivery)
+	}
+}
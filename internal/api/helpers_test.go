@@ -0,0 +1,88 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"parenta/internal/api"
+	"parenta/internal/models"
+	"parenta/internal/testutil"
+)
+
+// testAdminPassword is the password of the admin newAPI adds
+const testAdminPassword = "Test-Admin-Pass-42!"
+
+// newAPI builds the full router over a test Env, with a super admin
+// "admin" signed in. Returns the Env, the handler and the admin's token.
+func newAPI(t *testing.T) (*testutil.Env, http.Handler, string) {
+	t.Helper()
+	env := testutil.NewEnv(t)
+	env.AddAdmin(t, "admin", testAdminPassword, models.RoleSuper)
+	handler := api.NewRouter(env.Config, env.Storage, env.NDSPool, env.Dnsmasq, env.Auth, env.Ticker, env.Garden).Setup(t.TempDir())
+	return env, handler, login(t, handler, "admin", testAdminPassword)
+}
+
+// login signs an admin in through the API and returns the access token
+func login(t *testing.T, handler http.Handler, username, password string) string {
+	t.Helper()
+	body := `{"username":"` + username + `","password":"` + password + `"}`
+	rec := call(handler, http.MethodPost, "/api/v1/auth/login", "", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login %s: status %d, body %s", username, rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+		t.Fatalf("login %s: no token in %s", username, rec.Body.String())
+	}
+	return resp.Token
+}
+
+// call sends a request with an optional bearer token and JSON body
+func call(handler http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// errorCode returns the code of an error response, or "" if it isn't one
+func errorCode(rec *httptest.ResponseRecorder) string {
+	var resp struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return resp.Error.Code
+}
+
+// portalLogin logs a child in through the captive portal form on mac
+func portalLogin(t *testing.T, handler http.Handler, username, password, mac, ip string) {
+	t.Helper()
+	form := url.Values{
+		"username":  {username},
+		"password":  {password},
+		"mac":       {mac},
+		"ip":        {ip},
+		"gatewayip": {"192.168.1.1"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/fas/auth", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("portal login %s: status %d, body %s", username, rec.Code, rec.Body.String())
+	}
+}
@@ -3,74 +3,388 @@ package middleware
 import (
 	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
+
+	"parenta/internal/models"
 )
 
 type contextKey string
 
 const (
 	UserContextKey contextKey = "user"
+
+	// Set by AllowAPIKeys on routes API keys may call
+	apiKeyRouteKey contextKey = "apikey-route"
+)
+
+// Auth modes select where RequireAuth looks for the token
+const (
+	AuthModeHeader = "header" // Authorization: Bearer only
+	AuthModeCookie = "cookie" // HttpOnly session cookie only
+	AuthModeBoth   = "both"
+)
+
+// Cookie and header names for cookie sessions
+const (
+	SessionCookie = "parenta_session"
+	RefreshCookie = "parenta_refresh"
+	CSRFCookie    = "parenta_csrf"
+	CSRFHeader    = "X-CSRF-Token"
+	APIKeyHeader  = "X-API-Key"
 )
 
+// SessionTouch records that an admin session was used
+type SessionTouch func(sessionID string)
+
+// APIKeyLookup resolves a raw API key to its record and owning admin
+type APIKeyLookup func(key string) (*models.APIKey, *models.User, error)
+
+// TokenIssuer is the iss claim of every token Parenta issues
+const TokenIssuer = "parenta"
+
+// Token validation errors
+var (
+	ErrTokenMalformed = errors.New("malformed token")
+	ErrTokenSignature = errors.New("invalid token signature")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrTokenNotYet    = errors.New("token not yet valid")
+	ErrTokenClaims    = errors.New("token was not issued for this server")
+	ErrTokenRevoked   = errors.New("token revoked")
+)
+
+// tokenErrorCodes are the machine-readable codes sent to clients, so the UI
+// can tell an expired token (refresh it) from a bad one (log in again)
+var tokenErrorCodes = map[error]ErrCode{
+	ErrTokenMalformed: CodeTokenMalformed,
+	ErrTokenSignature: CodeTokenSignature,
+	ErrTokenExpired:   CodeTokenExpired,
+	ErrTokenNotYet:    CodeTokenNotYetValid,
+	ErrTokenClaims:    CodeTokenClaims,
+	ErrTokenRevoked:   CodeTokenRevoked,
+}
+
+// clockSkew is tolerated when checking nbf
+const clockSkew = 30 * time.Second
+
 // JWTClaims represents the JWT payload
 type JWTClaims struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	IsAdmin  bool   `json:"is_admin"`
-	Exp      int64  `json:"exp"`
+	ID        string `json:"jti"`
+	Issuer    string `json:"iss"`
+	Audience  string `json:"aud"`
+	SessionID string `json:"sid,omitempty"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	IsAdmin   bool   `json:"is_admin"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf"`
+	Exp       int64  `json:"exp"`
+}
+
+// jwtHeader is the only header Parenta issues or accepts
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
 }
 
 // AuthMiddleware provides JWT authentication
 type AuthMiddleware struct {
-	secret []byte
+	secret   []byte
+	mode     string
+	audience string
+
+	// Revoked token IDs mapped to their expiry, kept until the token would expire anyway
+	mu      sync.Mutex
+	revoked map[string]int64
+
+	// Revoked admin session IDs mapped to when their last token expires
+	revokedSessions map[string]int64
+
+	// Resolves X-API-Key headers; nil disables API keys
+	apiKeys APIKeyLookup
+
+	// Records admin session activity; may be nil
+	touch SessionTouch
 }
 
-// NewAuthMiddleware creates a new AuthMiddleware
-func NewAuthMiddleware(secret string) *AuthMiddleware {
+// NewAuthMiddleware creates a new AuthMiddleware. mode is one of the
+// AuthMode constants; empty means header only. audience identifies this
+// install, so tokens signed with the same secret elsewhere are rejected.
+func NewAuthMiddleware(secret, mode, audience string) *AuthMiddleware {
+	if mode == "" {
+		mode = AuthModeHeader
+	}
 	return &AuthMiddleware{
-		secret: []byte(secret),
+		secret:   []byte(secret),
+		mode:     mode,
+		audience: audience,
+		revoked:  make(map[string]int64),
+
+		revokedSessions: make(map[string]int64),
 	}
 }
 
-// RequireAuth middleware that requires valid JWT
+// SetAPIKeyLookup enables X-API-Key authentication
+func (m *AuthMiddleware) SetAPIKeyLookup(lookup APIKeyLookup) {
+	m.apiKeys = lookup
+}
+
+// SetSessionTouch sets the callback told about each authenticated request
+// of an admin session
+func (m *AuthMiddleware) SetSessionTouch(touch SessionTouch) {
+	m.touch = touch
+}
+
+// AllowsHeader reports whether Bearer header auth is accepted
+func (m *AuthMiddleware) AllowsHeader() bool {
+	return m.mode != AuthModeCookie
+}
+
+// AllowsCookie reports whether cookie session auth is accepted
+func (m *AuthMiddleware) AllowsCookie() bool {
+	return m.mode == AuthModeCookie || m.mode == AuthModeBoth
+}
+
+// CSRFToken derives the CSRF token for a session from its token ID, so it
+// needs no server-side storage
+func (m *AuthMiddleware) CSRFToken(claims *JWTClaims) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte("csrf:" + claims.ID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Revoke denylists a token ID until its expiry
+func (m *AuthMiddleware) Revoke(claims *JWTClaims) {
+	if claims == nil || claims.ID == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Drop entries for tokens that have expired on their own
+	now := time.Now().Unix()
+	for id, exp := range m.revoked {
+		if exp < now {
+			delete(m.revoked, id)
+		}
+	}
+	m.revoked[claims.ID] = claims.Exp
+}
+
+// IsRevoked reports whether a token ID has been revoked
+func (m *AuthMiddleware) IsRevoked(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.revoked[id]
+	return ok
+}
+
+// RevokeSession denylists every token of an admin session until expires,
+// when the session's last token would have expired anyway
+func (m *AuthMiddleware) RevokeSession(sessionID string, expires time.Time) {
+	if sessionID == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().Unix()
+	for id, exp := range m.revokedSessions {
+		if exp < now {
+			delete(m.revokedSessions, id)
+		}
+	}
+	m.revokedSessions[sessionID] = expires.Unix()
+}
+
+// IsSessionRevoked reports whether an admin session has been revoked
+func (m *AuthMiddleware) IsSessionRevoked(sessionID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.revokedSessions[sessionID]
+	return ok
+}
+
+// RequireAuth middleware that requires valid JWT, from the Authorization
+// header or the session cookie depending on the auth mode. Cookie-authenticated
+// requests that change state must also carry a matching CSRF header. An
+// X-API-Key header is accepted instead when API keys are enabled.
 func (m *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, `{"error":"missing authorization header"}`, http.StatusUnauthorized)
+		if key := r.Header.Get(APIKeyHeader); key != "" && m.apiKeys != nil {
+			m.serveAPIKey(w, r, key, next)
+			return
+		}
+
+		var token string
+		fromCookie := false
+
+		if authHeader := r.Header.Get("Authorization"); authHeader != "" && m.AllowsHeader() {
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				WriteError(w, http.StatusUnauthorized, CodeUnauthorized, "invalid authorization header")
+				return
+			}
+			token = parts[1]
+		} else if cookie, err := r.Cookie(SessionCookie); err == nil && m.AllowsCookie() {
+			token = cookie.Value
+			fromCookie = true
+		}
+
+		if token == "" {
+			WriteError(w, http.StatusUnauthorized, CodeUnauthorized, "missing authorization header")
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, `{"error":"invalid authorization header"}`, http.StatusUnauthorized)
+		// A bearer token that isn't shaped like a JWT may be an API key
+		if !fromCookie && m.apiKeys != nil && !strings.Contains(token, ".") {
+			m.serveAPIKey(w, r, token, next)
 			return
 		}
 
-		claims, err := m.ValidateToken(parts[1])
+		claims, err := m.ValidateToken(token)
 		if err != nil {
-			http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+			writeTokenError(w, err)
 			return
 		}
 
+		if fromCookie && !isSafeMethod(r.Method) {
+			expected := m.CSRFToken(claims)
+			if !hmac.Equal([]byte(r.Header.Get(CSRFHeader)), []byte(expected)) {
+				WriteError(w, http.StatusForbidden, CodeInvalidCSRF, "invalid csrf token")
+				return
+			}
+		}
+
+		if claims.SessionID != "" && m.touch != nil {
+			m.touch(claims.SessionID)
+		}
+
 		// Add claims to context
 		ctx := context.WithValue(r.Context(), UserContextKey, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// serveAPIKey authenticates a request by API key, enforcing the key's role
+// and scopes. Keys act as their owning admin and need no CSRF token.
+func (m *AuthMiddleware) serveAPIKey(w http.ResponseWriter, r *http.Request, key string, next http.Handler) {
+	record, admin, err := m.apiKeys(key)
+	if err != nil {
+		WriteError(w, http.StatusUnauthorized, CodeInvalidAPIKey, "invalid api key")
+		return
+	}
+
+	if r.Context().Value(apiKeyRouteKey) == nil || !apiKeyAllows(record, r) {
+		WriteError(w, http.StatusForbidden, CodeForbidden, "api key not allowed for this endpoint")
+		return
+	}
+
+	claims := &JWTClaims{
+		ID:       "apikey:" + record.ID,
+		Issuer:   TokenIssuer,
+		Audience: m.audience,
+		UserID:   admin.ID,
+		Username: admin.Username,
+		IsAdmin:  true,
+	}
+	ctx := context.WithValue(r.Context(), UserContextKey, claims)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// AllowAPIKeys marks a route as one API keys may call. RequireAuth refuses
+// keys on every other route, so a new route is closed to them until it is
+// marked.
+func AllowAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), apiKeyRouteKey, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// apiKeyCompositeGroups are route groups that combine others, or belong to
+// another. A scoped key needs every one of them.
+var apiKeyCompositeGroups = map[string][]string{
+	"overview":    {"system", "children", "sessions"},
+	"diagnostics": {"system"},
+	"reports":     {"children", "sessions"},
+}
+
+// apiKeyAllows reports whether a key's role and scopes allow a request to a
+// route open to API keys
+func apiKeyAllows(key *models.APIKey, r *http.Request) bool {
+	path := r.URL.Path
+	if rest, ok := strings.CutPrefix(path, "/api/v1"); ok {
+		path = rest
+	} else {
+		path = strings.TrimPrefix(path, "/api")
+	}
+
+	group, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if key.Role != models.APIKeyRoleAdmin && !isSafeMethod(r.Method) {
+		return false
+	}
+	if groups, ok := apiKeyCompositeGroups[group]; ok {
+		for _, g := range groups {
+			if !key.AllowsScope(g) {
+				return false
+			}
+		}
+		return true
+	}
+	return key.AllowsScope(group)
+}
+
+// writeTokenError sends a 401 naming why the token was rejected
+func writeTokenError(w http.ResponseWriter, err error) {
+	code, ok := tokenErrorCodes[err]
+	if !ok {
+		err, code = ErrTokenMalformed, tokenErrorCodes[ErrTokenMalformed]
+	}
+	WriteError(w, http.StatusUnauthorized, code, err.Error())
+}
+
+// isSafeMethod reports whether a method is read-only and exempt from CSRF checks
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
 // GenerateToken creates a new JWT token
-func (m *AuthMiddleware) GenerateToken(userID, username string, isAdmin bool, expiryHours int) (string, error) {
+func (m *AuthMiddleware) GenerateToken(userID, username, sessionID string, isAdmin bool, expiryHours int) (string, error) {
+	return m.GenerateTokenTTL(userID, username, sessionID, isAdmin, time.Duration(expiryHours)*time.Hour)
+}
+
+// GenerateTokenTTL creates a new JWT token with a unique ID that expires
+// after ttl. sessionID ties it to an admin session so it can be revoked
+// with the session.
+func (m *AuthMiddleware) GenerateTokenTTL(userID, username, sessionID string, isAdmin bool, ttl time.Duration) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
 	claims := JWTClaims{
-		UserID:   userID,
-		Username: username,
-		IsAdmin:  isAdmin,
-		Exp:      time.Now().Add(time.Duration(expiryHours) * time.Hour).Unix(),
+		ID:        hex.EncodeToString(jti),
+		Issuer:    TokenIssuer,
+		Audience:  m.audience,
+		SessionID: sessionID,
+		UserID:    userID,
+		Username:  username,
+		IsAdmin:   isAdmin,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		Exp:       now.Add(ttl).Unix(),
 	}
 
 	// Simple JWT: header.payload.signature
@@ -87,37 +401,69 @@ func (m *AuthMiddleware) GenerateToken(userID, username string, isAdmin bool, ex
 	return signatureInput + "." + signature, nil
 }
 
-// ValidateToken verifies and parses a JWT token
+// ValidateToken verifies and parses a JWT token. Errors are one of the
+// ErrToken values.
 func (m *AuthMiddleware) ValidateToken(token string) (*JWTClaims, error) {
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
-		return nil, http.ErrNoCookie
+		return nil, ErrTokenMalformed
+	}
+
+	// Only HS256 is accepted, whatever the header claims
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if header.Alg != "HS256" {
+		return nil, ErrTokenSignature
 	}
 
 	// Verify signature
-	signatureInput := parts[0] + "." + parts[1]
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
 	mac := hmac.New(sha256.New, m.secret)
-	mac.Write([]byte(signatureInput))
-	expectedSig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
-
-	if !hmac.Equal([]byte(parts[2]), []byte(expectedSig)) {
-		return nil, http.ErrNoCookie
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrTokenSignature
 	}
 
 	// Decode payload
 	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		return nil, err
+		return nil, ErrTokenMalformed
 	}
 
 	var claims JWTClaims
 	if err := json.Unmarshal(payloadBytes, &claims); err != nil {
-		return nil, err
+		return nil, ErrTokenMalformed
 	}
 
-	// Check expiry
-	if claims.Exp < time.Now().Unix() {
-		return nil, http.ErrNoCookie
+	// Check issuer and audience
+	if claims.Issuer != TokenIssuer || claims.Audience != m.audience {
+		return nil, ErrTokenClaims
+	}
+
+	// Check validity window
+	now := time.Now()
+	if claims.Exp < now.Unix() {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore > now.Add(clockSkew).Unix() {
+		return nil, ErrTokenNotYet
+	}
+
+	// Check revocation (logout, or the session was signed out elsewhere)
+	if claims.ID != "" && m.IsRevoked(claims.ID) {
+		return nil, ErrTokenRevoked
+	}
+	if claims.SessionID != "" && m.IsSessionRevoked(claims.SessionID) {
+		return nil, ErrTokenRevoked
 	}
 
 	return &claims, nil
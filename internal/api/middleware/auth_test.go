@@ -0,0 +1,270 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSecret   = "test-secret-0123456789"
+	testAudience = "install-1"
+)
+
+// newTestAuth returns an AuthMiddleware and a valid token with its claims
+func newTestAuth(t *testing.T, mode string) (*AuthMiddleware, string, *JWTClaims) {
+	t.Helper()
+	m := NewAuthMiddleware(testSecret, mode, testAudience)
+	token, err := m.GenerateTokenTTL("u1", "admin", "s1", true, 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := m.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("fresh token rejected: %v", err)
+	}
+	return m, token, claims
+}
+
+// serveAuth runs a request through RequireAuth and returns the recorder and
+// whether the wrapped handler was reached
+func serveAuth(m *AuthMiddleware, req *http.Request) (*httptest.ResponseRecorder, bool) {
+	reached := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = GetClaims(r) != nil
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rec := httptest.NewRecorder()
+	m.RequireAuth(next).ServeHTTP(rec, req)
+	return rec, reached
+}
+
+// responseCode returns the code of an error response
+func responseCode(t *testing.T, rec *httptest.ResponseRecorder) ErrCode {
+	t.Helper()
+	var body struct {
+		Error ErrorDetail `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q is not an error response: %v", rec.Body.String(), err)
+	}
+	return body.Error.Code
+}
+
+func TestRequireAuthCookie(t *testing.T) {
+	m, token, claims := newTestAuth(t, AuthModeBoth)
+	csrf := m.CSRFToken(claims)
+
+	expired, err := m.GenerateTokenTTL("u1", "admin", "s1", true, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, otherClaims := newTestAuth(t, AuthModeBoth)
+
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		bearer string
+		csrf   string
+		status int
+		code   ErrCode
+	}{
+		{name: "GET needs no CSRF token", method: http.MethodGet, cookie: token, status: http.StatusNoContent},
+		{name: "HEAD needs no CSRF token", method: http.MethodHead, cookie: token, status: http.StatusNoContent},
+		{name: "OPTIONS needs no CSRF token", method: http.MethodOptions, cookie: token, status: http.StatusNoContent},
+		{name: "POST with the CSRF token", method: http.MethodPost, cookie: token, csrf: csrf, status: http.StatusNoContent},
+		{name: "POST without a CSRF token", method: http.MethodPost, cookie: token, status: http.StatusForbidden, code: CodeInvalidCSRF},
+		{name: "PUT with a wrong CSRF token", method: http.MethodPut, cookie: token, csrf: "not-the-token", status: http.StatusForbidden, code: CodeInvalidCSRF},
+		{name: "DELETE with another session's CSRF token", method: http.MethodDelete, cookie: token, csrf: m.CSRFToken(otherClaims), status: http.StatusForbidden, code: CodeInvalidCSRF},
+		{name: "CSRF token of the cookie's own session", method: http.MethodDelete, cookie: other, csrf: m.CSRFToken(otherClaims), status: http.StatusNoContent},
+		{name: "expired cookie", method: http.MethodGet, cookie: expired, status: http.StatusUnauthorized, code: CodeTokenExpired},
+		{name: "expired cookie with a CSRF token", method: http.MethodPost, cookie: expired, csrf: csrf, status: http.StatusUnauthorized, code: CodeTokenExpired},
+		{name: "bearer header needs no CSRF token", method: http.MethodPost, bearer: token, status: http.StatusNoContent},
+		{name: "no credentials", method: http.MethodGet, status: http.StatusUnauthorized, code: CodeUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/v1/children", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.cookie})
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			if tt.csrf != "" {
+				req.Header.Set(CSRFHeader, tt.csrf)
+			}
+
+			rec, reached := serveAuth(m, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if reached != (tt.status == http.StatusNoContent) {
+				t.Errorf("handler reached = %v", reached)
+			}
+			if tt.code != "" {
+				if code := responseCode(t, rec); code != tt.code {
+					t.Errorf("code %s, want %s", code, tt.code)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireAuthModes(t *testing.T) {
+	tests := []struct {
+		mode         string
+		cookieStatus int
+		bearerStatus int
+	}{
+		{AuthModeHeader, http.StatusUnauthorized, http.StatusNoContent},
+		{AuthModeCookie, http.StatusNoContent, http.StatusUnauthorized},
+		{AuthModeBoth, http.StatusNoContent, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			m, token, _ := newTestAuth(t, tt.mode)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/children", nil)
+			req.AddCookie(&http.Cookie{Name: SessionCookie, Value: token})
+			if rec, _ := serveAuth(m, req); rec.Code != tt.cookieStatus {
+				t.Errorf("cookie: status %d, want %d", rec.Code, tt.cookieStatus)
+			}
+
+			req = httptest.NewRequest(http.MethodGet, "/api/v1/children", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			if rec, _ := serveAuth(m, req); rec.Code != tt.bearerStatus {
+				t.Errorf("bearer: status %d, want %d", rec.Code, tt.bearerStatus)
+			}
+		})
+	}
+}
+
+// signTestToken builds a JWT from a raw header and claims, signed with
+// secret using HMAC-SHA256
+func signTestToken(t *testing.T, secret, header string, claims any) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidateToken(t *testing.T) {
+	const hs256 = `{"alg":"HS256","typ":"JWT"}`
+	now := time.Now()
+	claims := func(change func(c *JWTClaims)) JWTClaims {
+		c := JWTClaims{ID: "jti-1", Issuer: TokenIssuer, Audience: testAudience, UserID: "u1", Username: "admin",
+			IsAdmin: true, IssuedAt: now.Unix(), NotBefore: now.Unix(), Exp: now.Add(time.Hour).Unix()}
+		if change != nil {
+			change(&c)
+		}
+		return c
+	}
+	valid := signTestToken(t, testSecret, hs256, claims(nil))
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid", valid, nil},
+		{"nbf within the allowed skew", signTestToken(t, testSecret, hs256, claims(func(c *JWTClaims) { c.NotBefore = now.Add(10 * time.Second).Unix() })), nil},
+
+		{"expired", signTestToken(t, testSecret, hs256, claims(func(c *JWTClaims) { c.Exp = now.Add(-time.Second).Unix() })), ErrTokenExpired},
+
+		{"empty", "", ErrTokenMalformed},
+		{"two parts", "abc.def", ErrTokenMalformed},
+		{"four parts", valid + ".x", ErrTokenMalformed},
+		{"header not base64", "!!." + b64([]byte("{}")) + ".sig", ErrTokenMalformed},
+		{"header not JSON", b64([]byte("nope")) + "." + b64([]byte("{}")) + ".sig", ErrTokenMalformed},
+		{"signature not base64", valid[:strings.LastIndex(valid, ".")] + ".!!", ErrTokenMalformed},
+		{"payload not JSON", signTestToken(t, testSecret, hs256, "not an object"), ErrTokenMalformed},
+
+		{"signed with another secret", signTestToken(t, "another-secret-0123456789", hs256, claims(nil)), ErrTokenSignature},
+		{"payload changed after signing", tamperPayload(valid, claims(func(c *JWTClaims) { c.UserID = "u2" })), ErrTokenSignature},
+
+		{"alg none", b64([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + b64(mustJSON(claims(nil))) + ".", ErrTokenSignature},
+		{"alg HS512", signTestToken(t, testSecret, `{"alg":"HS512","typ":"JWT"}`, claims(nil)), ErrTokenSignature},
+		{"alg RS256", signTestToken(t, testSecret, `{"alg":"RS256","typ":"JWT"}`, claims(nil)), ErrTokenSignature},
+
+		{"wrong issuer", signTestToken(t, testSecret, hs256, claims(func(c *JWTClaims) { c.Issuer = "someone-else" })), ErrTokenClaims},
+		{"no issuer", signTestToken(t, testSecret, hs256, claims(func(c *JWTClaims) { c.Issuer = "" })), ErrTokenClaims},
+		{"wrong audience", signTestToken(t, testSecret, hs256, claims(func(c *JWTClaims) { c.Audience = "install-2" })), ErrTokenClaims},
+
+		{"nbf in the future", signTestToken(t, testSecret, hs256, claims(func(c *JWTClaims) { c.NotBefore = now.Add(5 * time.Minute).Unix() })), ErrTokenNotYet},
+	}
+
+	m := NewAuthMiddleware(testSecret, AuthModeHeader, testAudience)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.ValidateToken(tt.token)
+			if err != tt.want {
+				t.Fatalf("error %v, want %v", err, tt.want)
+			}
+			if err == nil && (got.UserID != "u1" || got.ID != "jti-1") {
+				t.Errorf("claims %+v", got)
+			}
+		})
+	}
+}
+
+func TestValidateTokenRevoked(t *testing.T) {
+	m, token, claims := newTestAuth(t, AuthModeHeader)
+	_, other, _ := newTestAuth(t, AuthModeHeader)
+
+	m.Revoke(claims)
+	if _, err := m.ValidateToken(token); err != ErrTokenRevoked {
+		t.Errorf("revoked jti: error %v, want %v", err, ErrTokenRevoked)
+	}
+	if _, err := m.ValidateToken(other); err != nil {
+		t.Errorf("another token with the same session: %v", err)
+	}
+
+	// Revoking the session catches every token issued for it
+	m.RevokeSession(claims.SessionID, time.Now().Add(time.Hour))
+	if _, err := m.ValidateToken(other); err != ErrTokenRevoked {
+		t.Errorf("revoked session: error %v, want %v", err, ErrTokenRevoked)
+	}
+
+	// The 401 names why
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/children", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec, reached := serveAuth(m, req)
+	if rec.Code != http.StatusUnauthorized || reached {
+		t.Fatalf("status %d, reached %v", rec.Code, reached)
+	}
+	if code := responseCode(t, rec); code != CodeTokenRevoked {
+		t.Errorf("code %s, want %s", code, CodeTokenRevoked)
+	}
+}
+
+// tamperPayload swaps a token's payload for other claims, keeping the
+// original header and signature
+func tamperPayload(token string, claims JWTClaims) string {
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString(mustJSON(claims))
+	return strings.Join(parts, ".")
+}
+
+func mustJSON(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ErrCode is a machine-readable error code clients can switch on instead
+// of matching messages
+type ErrCode string
+
+// Error codes sent by the middleware. The handlers add their own.
+const (
+	CodeUnauthorized ErrCode = "UNAUTHORIZED"
+	CodeForbidden    ErrCode = "FORBIDDEN"
+	CodeInternal     ErrCode = "INTERNAL_ERROR"
+	CodeUnavailable  ErrCode = "SERVICE_UNAVAILABLE"
+
+	CodeTimeout          ErrCode = "REQUEST_TIMEOUT"
+	CodeInvalidCSRF      ErrCode = "INVALID_CSRF_TOKEN"
+	CodeInvalidAPIKey    ErrCode = "INVALID_API_KEY"
+	CodeTokenMalformed   ErrCode = "TOKEN_MALFORMED"
+	CodeTokenSignature   ErrCode = "TOKEN_SIGNATURE"
+	CodeTokenExpired     ErrCode = "TOKEN_EXPIRED"
+	CodeTokenNotYetValid ErrCode = "TOKEN_NOT_YET_VALID"
+	CodeTokenClaims      ErrCode = "TOKEN_CLAIMS"
+	CodeTokenRevoked     ErrCode = "TOKEN_REVOKED"
+)
+
+// ErrorDetail is the body of every error response
+type ErrorDetail struct {
+	Code    ErrCode `json:"code"`
+	Message string  `json:"message"`
+}
+
+// WriteError sends a JSON error response: {"error":{"code":...,"message":...}}
+func WriteError(w http.ResponseWriter, status int, code ErrCode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]ErrorDetail{"error": {Code: code, Message: message}})
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// RequestIDHeader carries the ID a request is logged under. A client's own
+// ID is kept if it is short and plain; otherwise one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+// Recover catches panics in handlers, logs the stack with the request that
+// caused it, and returns a 500 JSON error instead of dropping the connection.
+// Every response carries the request's ID, which the log and the error name.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// Deliberate aborts must keep their meaning for net/http
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			log.Printf("panic serving %s %s from %s (request %s): %v\n%s", r.Method, r.URL.Path, r.RemoteAddr, id, err, debug.Stack())
+
+			WriteError(w, http.StatusInternalServerError, CodeInternal, "internal server error (request "+id+")")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether a client's request ID is safe to log and
+// echo: 1-64 letters, digits, '-', '_' or '.'
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLog sends the standard logger to a buffer until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestRecover(t *testing.T) {
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var child *struct{ Name string }
+		w.Write([]byte(child.Name)) // nil pointer dereference
+	})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		sentID  string
+		keepsID bool
+	}{
+		{"panic", panicking, "", false},
+		{"panic with the client's request ID", panicking, "req-42.a_b", true},
+		{"unsafe request ID is replaced", panicking, "bad id\ninjected", false},
+		{"panic behind the timeout middleware", TimeoutMiddleware(time.Second)(panicking), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/children", nil)
+			if tt.sentID != "" {
+				req.Header.Set(RequestIDHeader, tt.sentID)
+			}
+			rec := httptest.NewRecorder()
+
+			Recover(tt.handler).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("status %d, want 500", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type %q, want application/json", ct)
+			}
+			var body struct {
+				Error ErrorDetail `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q is not JSON: %v", rec.Body.String(), err)
+			}
+			if body.Error.Code != CodeInternal {
+				t.Errorf("code %q, want %s", body.Error.Code, CodeInternal)
+			}
+
+			id := rec.Header().Get(RequestIDHeader)
+			if !validRequestID(id) {
+				t.Fatalf("response request ID %q", id)
+			}
+			if tt.keepsID != (id == tt.sentID) {
+				t.Errorf("request ID %q, sent %q", id, tt.sentID)
+			}
+			if !strings.Contains(body.Error.Message, id) {
+				t.Errorf("message %q doesn't name request %s", body.Error.Message, id)
+			}
+
+			logged := logs.String()
+			for _, want := range []string{"panic serving GET /api/v1/children", "(request " + id + ")", "nil pointer dereference", "recover_test.go"} {
+				if !strings.Contains(logged, want) {
+					t.Errorf("log missing %q:\n%s", want, logged)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverPassesThrough(t *testing.T) {
+	logs := captureLog(t)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fine"))
+	})
+	rec := httptest.NewRecorder()
+	Recover(ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "fine" {
+		t.Errorf("got %d %q", rec.Code, rec.Body.String())
+	}
+	if !validRequestID(rec.Header().Get(RequestIDHeader)) {
+		t.Error("no request ID on a normal response")
+	}
+	if logs.Len() != 0 {
+		t.Errorf("logged %q", logs.String())
+	}
+}
+
+func TestRecoverKeepsAbortHandler(t *testing.T) {
+	abort := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", p)
+		}
+	}()
+	Recover(abort).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -0,0 +1,49 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"parenta/internal/models"
+)
+
+// TestNotificationTest checks that a super admin can test the notification
+// webhook and sees the status it answered with
+func TestNotificationTest(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hook.Close()
+
+	env, handler, token := newAPI(t)
+	settings := env.Storage.GetSettings()
+	settings.Notifications.Webhook.URL = hook.URL
+	if err := env.Storage.SaveSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	env.AddAdmin(t, "parent", testAdminPassword, models.RoleAdmin)
+
+	rec := call(handler, http.MethodPost, "/api/v1/system/notifications/test", token, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Channel    string `json:"channel"`
+		OK         bool   `json:"ok"`
+		StatusCode int    `json:"status_code"`
+		Error      string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.OK || resp.Channel != models.ChannelWebhook || resp.StatusCode != http.StatusNoContent || resp.Error != "" {
+		t.Errorf("response %+v", resp)
+	}
+
+	parent := login(t, handler, "parent", testAdminPassword)
+	if rec := call(handler, http.MethodPost, "/api/v1/system/notifications/test", parent, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("admin: status %d, want 403", rec.Code)
+	}
+}
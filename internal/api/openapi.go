@@ -0,0 +1,456 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"parenta/internal/api/handlers"
+	"parenta/internal/models"
+	"parenta/internal/services"
+	"parenta/internal/storage"
+	"parenta/internal/version"
+)
+
+// route records a registered route so the OpenAPI document is built from the
+// real route table and can't drift from it
+type route struct {
+	Method string
+	Path   string
+	APIKey bool // registered with handleKey
+}
+
+// routeDoc describes a route in the OpenAPI document
+type routeDoc struct {
+	Summary  string
+	Tag      string
+	Request  interface{} // request body type, nil if none
+	Response interface{} // success response type, nil for a generic object
+	Status   int         // success status code, defaults to 200
+	Query    []string    // optional query parameters
+	Public   bool        // no authentication required
+}
+
+// SuccessResponse is the generic {"success": true} payload
+type SuccessResponse struct {
+	Success bool `json:"success"`
+}
+
+// DeletedResponse is the {"deleted": N} payload of bulk deletes
+type DeletedResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// ErrorResponse is the payload of every error response, from handlers.ErrorCode
+// and the middleware alike
+type ErrorResponse struct {
+	Error handlers.ErrorDetail `json:"error"`
+}
+
+// routeDocs documents every API route, keyed by "METHOD /path"
+var routeDocs = map[string]routeDoc{
+	// FAS / captive portal
+	"GET /fas/":                {Summary: "openNDS FAS entry point, redirects to the portal", Tag: "fas", Query: []string{"fas"}, Public: true, Status: http.StatusFound},
+	"GET /fas/auth":            {Summary: "Redirects to the portal", Tag: "fas", Public: true, Status: http.StatusFound},
+	"POST /fas/auth":           {Summary: "Captive portal login (admin or child), JSON or form", Tag: "fas", Request: handlers.AuthRequest{}, Public: true},
+	"GET /fas/status":          {Summary: "Remaining time for a logged-in client", Tag: "fas", Query: []string{"token", "mac", "ip"}, Public: true},
+	"POST /fas/child/password": {Summary: "A child changes their own password", Tag: "fas", Request: handlers.ChildPasswordRequest{}, Response: SuccessResponse{}, Public: true},
+	"GET /api/v1/docs":         {Summary: "API documentation page", Tag: "meta", Public: true},
+	"GET /api/v1/openapi.json": {Summary: "This OpenAPI document", Tag: "meta", Public: true},
+
+	// Auth
+	"POST /api/v1/auth/login":           {Summary: "Admin login", Tag: "auth", Request: handlers.LoginRequest{}, Response: handlers.LoginResponse{}, Public: true},
+	"POST /api/v1/auth/refresh":         {Summary: "Rotate refresh token and issue a new access token", Tag: "auth", Request: handlers.RefreshRequest{}, Response: handlers.LoginResponse{}, Public: true},
+	"POST /api/v1/auth/logout":          {Summary: "Logout and revoke tokens", Tag: "auth", Request: handlers.RefreshRequest{}, Response: SuccessResponse{}},
+	"GET /api/v1/auth/me":               {Summary: "Current admin", Tag: "auth"},
+	"PUT /api/v1/auth/me":               {Summary: "Update your own display name", Tag: "auth", Request: handlers.UpdateMeRequest{}, Response: handlers.AdminResponse{}},
+	"POST /api/v1/auth/password":        {Summary: "Change own password", Tag: "auth", Request: handlers.ChangePasswordRequest{}, Response: SuccessResponse{}},
+	"GET /api/v1/auth/apikeys":          {Summary: "List API keys", Tag: "auth", Response: []handlers.APIKeyResponse{}},
+	"POST /api/v1/auth/apikeys":         {Summary: "Create an API key (the key is returned only once)", Tag: "auth", Request: handlers.APIKeyRequest{}, Response: handlers.CreateAPIKeyResponse{}},
+	"DELETE /api/v1/auth/apikeys/{id}":  {Summary: "Revoke an API key", Tag: "auth", Response: SuccessResponse{}},
+	"GET /api/v1/auth/sessions":         {Summary: "List where you are signed in", Tag: "auth", Response: []handlers.AdminSessionResponse{}},
+	"DELETE /api/v1/auth/sessions/{id}": {Summary: "Sign out one of your sessions", Tag: "auth", Response: SuccessResponse{}},
+
+	// Admins
+	"GET /api/v1/admins":                      {Summary: "List admins", Tag: "admins", Response: []handlers.AdminResponse{}},
+	"POST /api/v1/admins":                     {Summary: "Create admin (super admin)", Tag: "admins", Request: handlers.CreateAdminRequest{}, Response: handlers.AdminResponse{}, Status: http.StatusCreated},
+	"GET /api/v1/admins/{id}":                 {Summary: "Get admin", Tag: "admins", Response: handlers.AdminResponse{}},
+	"PUT /api/v1/admins/{id}":                 {Summary: "Update admin (super admin)", Tag: "admins", Request: handlers.UpdateAdminRequest{}, Response: SuccessResponse{}},
+	"DELETE /api/v1/admins/{id}":              {Summary: "Delete admin (super admin)", Tag: "admins", Response: SuccessResponse{}},
+	"POST /api/v1/admins/{id}/reset-password": {Summary: "Reset admin password (super admin)", Tag: "admins", Request: handlers.ResetPasswordRequest{}, Response: SuccessResponse{}},
+	"POST /api/v1/admins/{id}/unlock":         {Summary: "Clear a login lockout (super admin)", Tag: "admins", Response: SuccessResponse{}},
+
+	// Children
+	"GET /api/v1/children":                             {Summary: "List children", Tag: "children", Response: []handlers.ChildResponse{}},
+	"GET /api/v1/children/quota-presets":               {Summary: "Recommended quota, devices and filter mode for an age", Tag: "children", Query: []string{"age"}, Response: models.QuotaPreset{}},
+	"POST /api/v1/children":                            {Summary: "Create child", Tag: "children", Request: handlers.ChildRequest{}, Response: handlers.ChildResponse{}, Status: http.StatusCreated},
+	"GET /api/v1/children/{id}":                        {Summary: "Get child", Tag: "children", Response: handlers.ChildResponse{}},
+	"PUT /api/v1/children/{id}":                        {Summary: "Update child", Tag: "children", Request: handlers.ChildRequest{}, Response: handlers.ChildResponse{}},
+	"DELETE /api/v1/children/{id}":                     {Summary: "Delete child", Tag: "children", Response: SuccessResponse{}},
+	"POST /api/v1/children/{id}/reset-quota":           {Summary: "Reset today's usage", Tag: "children", Response: handlers.ChildResponse{}},
+	"POST /api/v1/children/{id}/adjust-quota":          {Summary: "Add or remove minutes for today", Tag: "children", Request: handlers.AdjustQuotaRequest{}, Response: handlers.ChildResponse{}},
+	"POST /api/v1/children/{id}/grant":                 {Summary: "Credit minutes to the time bank", Tag: "children", Request: handlers.GrantRequest{}, Response: handlers.ChildResponse{}},
+	"GET /api/v1/children/{id}/bank":                   {Summary: "Time bank balance and history", Tag: "children", Response: handlers.BankResponse{}},
+	"GET /api/v1/children/{id}/qr":                     {Summary: "PNG QR code of the portal with the child's username filled in", Tag: "children"},
+	"GET /api/v1/children/{id}/policy":                 {Summary: "Effective policy right now: quota, schedule, filter mode, devices", Tag: "children", Response: handlers.ChildPolicyResponse{}},
+	"GET /api/v1/children/{id}/history/daily":          {Summary: "Minutes used per day", Tag: "children", Query: []string{"days"}, Response: []models.DailyUsage{}},
+	"GET /api/v1/children/{id}/category-usage":         {Summary: "Minutes used per category per day", Tag: "children", Query: []string{"days"}, Response: handlers.CategoryUsageResponse{}},
+	"POST /api/v1/children/{id}/devices":               {Summary: "Register a device", Tag: "children", Request: handlers.DeviceRequest{}, Response: handlers.ChildResponse{}},
+	"PUT /api/v1/children/{id}/devices":                {Summary: "Replace the device list, logging out devices left out", Tag: "children", Request: []handlers.DeviceRequest{}, Response: handlers.ChildResponse{}},
+	"DELETE /api/v1/children/{id}/devices/all":         {Summary: "Remove all devices, logging them out", Tag: "children", Response: handlers.ChildResponse{}},
+	"DELETE /api/v1/children/{id}/devices":             {Summary: "Remove a device (legacy, MAC as query)", Tag: "children", Query: []string{"mac"}, Response: handlers.ChildResponse{}},
+	"DELETE /api/v1/children/{id}/devices/{mac}":       {Summary: "Remove a device", Tag: "children", Response: handlers.ChildResponse{}},
+	"POST /api/v1/children/{id}/devices/{mac}/approve": {Summary: "Approve a device waiting for approval", Tag: "children", Response: handlers.ChildResponse{}},
+	"POST /api/v1/children/{id}/devices/cleanup":       {Summary: "Remove private MAC devices not seen for N days", Tag: "children", Query: []string{"days"}, Response: DeletedResponse{}},
+
+	// Sessions
+	"GET /api/v1/sessions":                 {Summary: "List active sessions", Tag: "sessions", Response: []handlers.SessionResponse{}},
+	"GET /api/v1/sessions/history":         {Summary: "List past and active sessions, newest first", Tag: "sessions", Query: []string{"child_id", "page", "limit"}, Response: handlers.SessionHistoryResponse{}},
+	"GET /api/v1/sessions/{id}":            {Summary: "Get session", Tag: "sessions", Response: handlers.SessionResponse{}},
+	"DELETE /api/v1/sessions/{id}":         {Summary: "Kick session", Tag: "sessions", Response: SuccessResponse{}},
+	"POST /api/v1/sessions/{id}/kick":      {Summary: "Kick session", Tag: "sessions", Response: SuccessResponse{}},
+	"POST /api/v1/sessions/{id}/extend":    {Summary: "Extend session", Tag: "sessions", Request: handlers.ExtendRequest{}, Response: handlers.SessionResponse{}},
+	"GET /api/v1/vouchers":                 {Summary: "List guest vouchers with remaining uses", Tag: "vouchers", Response: []handlers.VoucherResponse{}},
+	"POST /api/v1/vouchers":                {Summary: "Create guest voucher", Tag: "vouchers", Request: handlers.VoucherRequest{}, Response: handlers.VoucherResponse{}},
+	"GET /api/v1/vouchers/{id}":            {Summary: "Get guest voucher", Tag: "vouchers", Response: handlers.VoucherResponse{}},
+	"PUT /api/v1/vouchers/{id}":            {Summary: "Update guest voucher", Tag: "vouchers", Request: handlers.VoucherRequest{}, Response: handlers.VoucherResponse{}},
+	"DELETE /api/v1/vouchers/{id}":         {Summary: "Delete guest voucher and disconnect its guests", Tag: "vouchers", Response: SuccessResponse{}},
+	"GET /api/v1/preauth-devices":          {Summary: "List devices that bypass the portal", Tag: "preauth-devices", Response: []models.PreAuthDevice{}},
+	"POST /api/v1/preauth-devices":         {Summary: "Let a device bypass the portal", Tag: "preauth-devices", Request: handlers.PreAuthRequest{}, Response: models.PreAuthDevice{}},
+	"PUT /api/v1/preauth-devices/{mac}":    {Summary: "Rename a preauth device", Tag: "preauth-devices", Request: handlers.PreAuthRequest{}, Response: models.PreAuthDevice{}},
+	"DELETE /api/v1/preauth-devices/{mac}": {Summary: "Remove a preauth device and disconnect it", Tag: "preauth-devices", Response: SuccessResponse{}},
+
+	"GET /api/v1/network/walled-garden":         {Summary: "List hosts reachable before login", Tag: "network", Response: handlers.WalledGardenResponse{}},
+	"POST /api/v1/network/walled-garden":        {Summary: "Add a walled garden host", Tag: "network", Request: handlers.WalledGardenRequest{}, Response: handlers.WalledGardenEntryResponse{}},
+	"POST /api/v1/network/walled-garden/apply":  {Summary: "Apply the walled garden and restart openNDS", Tag: "network", Response: handlers.WalledGardenResponse{}},
+	"PUT /api/v1/network/walled-garden/{id}":    {Summary: "Update a walled garden host", Tag: "network", Request: handlers.WalledGardenRequest{}, Response: handlers.WalledGardenEntryResponse{}},
+	"DELETE /api/v1/network/walled-garden/{id}": {Summary: "Remove a walled garden host", Tag: "network", Response: SuccessResponse{}},
+
+	// Portal branding
+	"GET /api/v1/portal/settings": {Summary: "Portal title, message, accent color, locale and house rules", Tag: "portal", Response: models.PortalSettings{}},
+	"GET /api/v1/portal/qr":       {Summary: "PNG QR code of the portal's address", Tag: "portal"},
+	"PUT /api/v1/portal/settings": {Summary: "Change the portal branding", Tag: "portal", Request: handlers.PortalSettingsRequest{}, Response: models.PortalSettings{}},
+	"POST /api/v1/portal/logo":    {Summary: "Upload the portal logo as multipart field logo (PNG, JPEG, GIF or WebP, max 256KB)", Tag: "portal", Response: models.PortalSettings{}},
+	"DELETE /api/v1/portal/logo":  {Summary: "Remove the portal logo", Tag: "portal", Response: models.PortalSettings{}},
+
+	// Schedules
+	"GET /api/v1/schedules":          {Summary: "List schedules", Tag: "schedules", Response: []models.Schedule{}},
+	"POST /api/v1/schedules":         {Summary: "Create schedule", Tag: "schedules", Request: handlers.ScheduleRequest{}, Response: models.Schedule{}, Status: http.StatusCreated},
+	"POST /api/v1/schedules/preview": {Summary: "Preview a week of hourly access for an unsaved schedule", Tag: "schedules", Request: handlers.ScheduleRequest{}, Response: handlers.SchedulePreviewResponse{}},
+	"GET /api/v1/schedules/{id}":     {Summary: "Get schedule", Tag: "schedules", Response: models.Schedule{}},
+	"PUT /api/v1/schedules/{id}":     {Summary: "Update schedule", Tag: "schedules", Request: handlers.ScheduleRequest{}, Response: models.Schedule{}},
+	"DELETE /api/v1/schedules/{id}":  {Summary: "Delete schedule", Tag: "schedules", Response: SuccessResponse{}},
+
+	// Filters
+	"GET /api/v1/filters":                       {Summary: "List filter rules", Tag: "filters", Query: []string{"type"}, Response: []models.FilterRule{}},
+	"POST /api/v1/filters/import":               {Summary: "Import a newline-delimited domain list (text body or multipart file)", Tag: "filters", Query: []string{"type", "category"}, Response: handlers.ImportResult{}},
+	"GET /api/v1/filters/presets":               {Summary: "List downloadable filter presets", Tag: "filters", Response: []handlers.FilterPreset{}},
+	"POST /api/v1/filters/presets/{name}/apply": {Summary: "Download and import a filter preset", Tag: "filters", Response: handlers.ImportResult{}},
+	"POST /api/v1/filters":                      {Summary: "Create filter rule", Tag: "filters", Request: handlers.FilterRequest{}, Response: models.FilterRule{}, Status: http.StatusCreated},
+	"DELETE /api/v1/filters":                    {Summary: "Delete every filter rule in a category and/or of a type", Tag: "filters", Query: []string{"category", "rule_type"}, Response: DeletedResponse{}},
+	"DELETE /api/v1/filters/{id}":               {Summary: "Delete filter rule", Tag: "filters", Response: SuccessResponse{}},
+	"POST /api/v1/filters/reload":               {Summary: "Apply filter rules and reload dnsmasq", Tag: "filters", Response: SuccessResponse{}},
+
+	// System
+	"GET /api/v1/system/status":           {Summary: "System status", Tag: "system", Response: handlers.StatusResponse{}},
+	"POST /api/v1/system/restart":         {Summary: "Restart openNDS or dnsmasq", Tag: "system", Request: handlers.RestartRequest{}, Response: SuccessResponse{}},
+	"GET /api/v1/system/health":           {Summary: "Health check", Tag: "system", Response: handlers.HealthResponse{}},
+	"GET /api/v1/system/connectivity":     {Summary: "WAN, DNS and filtering checks", Tag: "system", Query: []string{"refresh"}, Response: services.ConnectivityReport{}},
+	"GET /api/v1/system/backup":           {Summary: "Download the data files as a .tar.gz (super admin)", Tag: "system"},
+	"POST /api/v1/system/backup/run":      {Summary: "Back up to the configured destination now (super admin)", Tag: "system", Response: services.BackupStatus{}},
+	"GET /api/v1/system/update/check":     {Summary: "Compare the running version with the latest release", Tag: "system", Response: services.UpdateInfo{}},
+	"POST /api/v1/system/update/apply":    {Summary: "Install the latest release and restart (super admin)", Tag: "system", Response: handlers.UpdateApplyResponse{}},
+	"POST /api/v1/system/command":         {Summary: "Run an allowlisted command (super admin, off by default)", Tag: "system", Request: handlers.CommandRequest{}, Response: handlers.CommandResponse{}},
+	"GET /api/v1/system/allowed-commands": {Summary: "Command allowlist (super admin)", Tag: "system", Response: handlers.AllowedCommandsResponse{}},
+	"PUT /api/v1/system/allowed-commands": {Summary: "Replace the command allowlist until restart (super admin)", Tag: "system", Request: map[string][]string{}, Response: handlers.AllowedCommandsResponse{}},
+	"GET /api/v1/system/logs":             {Summary: "Recent system logs", Tag: "system", Query: []string{"filter", "lines"}, Response: handlers.LogsResponse{}},
+	"GET /api/v1/system/logs/stream":      {Summary: "Follow the log as server-sent events (text/event-stream)", Tag: "system", Query: []string{"filter", "source"}},
+	"GET /api/v1/system/disk":             {Summary: "Disk space for each configured path", Tag: "system", Response: []handlers.DiskStats{}},
+	"GET /api/v1/system/dashboard":        {Summary: "Dashboard metrics", Tag: "system", Response: handlers.DashboardResponse{}},
+	"GET /api/v1/overview":                {Summary: "Dashboard metrics, children and active sessions in one request", Tag: "system", Query: []string{"refresh"}, Response: handlers.OverviewResponse{}},
+	"POST /api/v1/system/shell":           {Summary: "Run a shell command (super admin, off by default)", Tag: "system", Request: handlers.ShellRequest{}, Response: handlers.CommandResponse{}},
+	"GET /api/v1/system/holiday-mode":     {Summary: "Holiday mode state", Tag: "system", Response: models.HolidayMode{}},
+	"POST /api/v1/system/holiday-mode":    {Summary: "Enable or disable holiday mode", Tag: "system", Request: handlers.HolidayModeRequest{}, Response: models.HolidayMode{}},
+	"GET /api/v1/system/audit":            {Summary: "Recent audit log entries", Tag: "system", Query: []string{"limit"}, Response: []models.AuditEntry{}},
+	"GET /api/v1/system/lockouts":         {Summary: "Admin accounts and source IPs locked out after failed logins", Tag: "system", Response: []services.Lockout{}},
+	"POST /api/v1/system/lockouts/clear":  {Summary: "Unlock a username and the IPs it was locked from", Tag: "system", Request: handlers.LockoutClearRequest{}, Response: handlers.LockoutClearResponse{}},
+	"POST /api/v1/system/prune":           {Summary: "Prune history older than N days (super admin)", Tag: "system", Request: handlers.PruneRequest{}, Response: storage.PruneResult{}},
+	"GET /api/v1/system/device-policy":    {Summary: "How devices are registered at login", Tag: "system", Response: models.DevicePolicy{}},
+	"PUT /api/v1/system/device-policy":    {Summary: "Change the device policy", Tag: "system", Request: models.DevicePolicy{}, Response: models.DevicePolicy{}},
+	"GET /api/v1/system/password-policy":  {Summary: "Admin and child password policies", Tag: "system", Response: models.PasswordPolicies{}},
+	"PUT /api/v1/system/password-policy":  {Summary: "Change the password policies (super admin)", Tag: "system", Request: models.PasswordPolicies{}, Response: models.PasswordPolicies{}},
+	"POST /api/v1/system/ticker-config":   {Summary: "Change the session ticker interval", Tag: "system", Request: handlers.TickerConfigRequest{}, Response: handlers.TickerConfigRequest{}},
+	"GET /api/v1/system/ticker-events":    {Summary: "Recent session ticker decisions, newest first", Tag: "system", Query: []string{"limit"}, Response: []services.TickEvent{}},
+	"POST /api/v1/system/dnsmasq/resync":  {Summary: "Rewrite all dnsmasq configs and reload once", Tag: "system", Response: services.ResyncResult{}},
+	"POST /api/v1/system/test-ndsctl":     {Summary: "Check that ndsctl status and ndsctl json work", Tag: "system", Response: handlers.NDSCtlTestResponse{}},
+
+	"GET /api/v1/notifications/settings":     {Summary: "Notification channels and event routing, with secrets masked", Tag: "notifications", Response: models.NotificationSettings{}},
+	"PUT /api/v1/notifications/settings":     {Summary: "Change the notification settings (super admin)", Tag: "notifications", Request: models.NotificationSettings{}, Response: models.NotificationSettings{}},
+	"POST /api/v1/system/notifications/test": {Summary: "Test the notification webhook, or another channel (super admin)", Tag: "system", Request: handlers.NotificationTestRequest{}, Response: handlers.NotificationTestResponse{}},
+	"GET /api/v1/reports/daily":              {Summary: "Each child's usage on a day, as JSON or HTML", Tag: "reports", Query: []string{"date", "format"}, Response: models.UsageReport{}},
+	"GET /api/v1/reports/weekly":             {Summary: "Each child's usage in an ISO week, as JSON or HTML", Tag: "reports", Query: []string{"week", "format"}, Response: models.UsageReport{}},
+
+	"GET /api/v1/webhooks":                                       {Summary: "Webhook subscriptions, with secrets masked", Tag: "webhooks", Response: []models.WebhookSubscription{}},
+	"POST /api/v1/webhooks":                                      {Summary: "Add a webhook subscription; the response has the secret in full (super admin)", Tag: "webhooks", Request: handlers.WebhookRequest{}, Response: models.WebhookSubscription{}},
+	"GET /api/v1/webhooks/deliveries":                            {Summary: "Recent deliveries to all subscriptions, newest first", Tag: "webhooks", Query: []string{"limit"}, Response: []models.WebhookDelivery{}},
+	"GET /api/v1/webhooks/{id}":                                  {Summary: "A webhook subscription and its last delivery", Tag: "webhooks", Response: models.WebhookSubscription{}},
+	"PUT /api/v1/webhooks/{id}":                                  {Summary: "Change a webhook subscription (super admin)", Tag: "webhooks", Request: handlers.WebhookRequest{}, Response: models.WebhookSubscription{}},
+	"DELETE /api/v1/webhooks/{id}":                               {Summary: "Delete a webhook subscription (super admin)", Tag: "webhooks", Response: SuccessResponse{}},
+	"GET /api/v1/webhooks/{id}/deliveries":                       {Summary: "A subscription's recent deliveries, newest first", Tag: "webhooks", Query: []string{"limit"}, Response: []models.WebhookDelivery{}},
+	"POST /api/v1/webhooks/{id}/deliveries/{delivery}/redeliver": {Summary: "Post a delivery's event again, once (super admin)", Tag: "webhooks", Response: models.WebhookDelivery{}},
+
+	"GET /api/v1/diagnostics/interfaces": {Summary: "Network interfaces and their addresses", Tag: "diagnostics", Response: []services.NetInterface{}},
+	"GET /api/v1/diagnostics/routes":     {Summary: "IPv4 and IPv6 routing tables", Tag: "diagnostics", Response: []services.Route{}},
+	"GET /api/v1/diagnostics/wireless":   {Summary: "Wireless interfaces from iwinfo", Tag: "diagnostics", Response: []services.WirelessInterface{}},
+	"GET /api/v1/diagnostics/processes":  {Summary: "Running processes", Tag: "diagnostics", Response: []services.Process{}},
+}
+
+// register adds a route to the mux and records it for the OpenAPI document
+func (r *Router) register(pattern string, handler http.HandlerFunc) {
+	r.mux.HandleFunc(pattern, handler)
+	method, path, _ := strings.Cut(pattern, " ")
+	r.routes = append(r.routes, route{Method: method, Path: path})
+}
+
+// openAPIHandler serves the OpenAPI document, built once from the route table
+func (r *Router) openAPIHandler() http.HandlerFunc {
+	var once sync.Once
+	var spec map[string]interface{}
+	return func(w http.ResponseWriter, req *http.Request) {
+		once.Do(func() { spec = r.buildOpenAPI() })
+		handlers.JSON(w, http.StatusOK, spec)
+	}
+}
+
+// buildOpenAPI generates an OpenAPI 3 document for every recorded route
+func (r *Router) buildOpenAPI() map[string]interface{} {
+	schemas := newSchemaBuilder()
+	errorRef := schemas.schemaFor(reflect.TypeOf(ErrorResponse{}))
+
+	paths := make(map[string]map[string]interface{})
+	for _, rt := range r.routes {
+		doc := routeDocs[rt.Method+" "+rt.Path]
+
+		op := map[string]interface{}{
+			"operationId": operationID(rt.Method, rt.Path),
+		}
+		if doc.Summary != "" {
+			op["summary"] = doc.Summary
+		}
+		if doc.Tag != "" {
+			op["tags"] = []string{doc.Tag}
+		}
+		if !doc.Public {
+			security := []map[string][]string{{"bearerAuth": {}}}
+			if rt.APIKey {
+				security = append(security, map[string][]string{"apiKeyAuth": {}})
+			}
+			op["security"] = security
+		}
+
+		var params []map[string]interface{}
+		for _, name := range pathParams(rt.Path) {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]string{"type": "string"},
+			})
+		}
+		for _, name := range doc.Query {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "query",
+				"schema": map[string]string{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+
+		if doc.Request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": schemas.schemaFor(reflect.TypeOf(doc.Request)),
+					},
+				},
+			}
+		}
+
+		status := doc.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if status != http.StatusFound {
+			respSchema := map[string]interface{}{"type": "object"}
+			if doc.Response != nil {
+				respSchema = schemas.schemaFor(reflect.TypeOf(doc.Response))
+			}
+			success["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": respSchema},
+			}
+		}
+		op["responses"] = map[string]interface{}{
+			strconv.Itoa(status): success,
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": errorRef},
+				},
+			},
+		}
+
+		method := strings.ToLower(rt.Method)
+		if method == "" {
+			method = "get"
+		}
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = make(map[string]interface{})
+		}
+		paths[rt.Path][method] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":       "Parenta API",
+			"version":     version.Version,
+			"description": "Every /api/v1 path is also served under the unversioned /api prefix for older clients.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]string{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+				"apiKeyAuth": map[string]string{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "X-API-Key",
+				},
+			},
+		},
+	}
+}
+
+// pathParams returns the {name} wildcards in a route path
+func pathParams(path string) []string {
+	var names []string
+	for _, seg := range strings.Split(path, "/") {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			names = append(names, strings.TrimSuffix(strings.Trim(seg, "{}"), "..."))
+		}
+	}
+	return names
+}
+
+// operationID builds a stable identifier like "getApiV1ChildrenById"
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, seg := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '-' || r == '.' }) {
+		if strings.HasPrefix(seg, "{") {
+			name := strings.Trim(seg, "{}")
+			seg = "By" + strings.ToUpper(name[:1]) + name[1:]
+		}
+		b.WriteString(strings.ToUpper(seg[:1]) + seg[1:])
+	}
+	return b.String()
+}
+
+// ============ Schema Generation ============
+
+// schemaBuilder derives JSON schemas from Go types using their json tags
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{components: make(map[string]interface{})}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns an inline schema or a $ref to a named component
+func (b *schemaBuilder) schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := t.Name()
+		if _, ok := b.components[name]; !ok {
+			b.components[name] = map[string]interface{}{} // placeholder guards recursion
+			b.components[name] = b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema builds an object schema from a struct's exported, json-tagged fields
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// Embedded structs without a tag are flattened, as encoding/json does
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for k, v := range b.structSchema(ft)["properties"].(map[string]interface{}) {
+					props[k] = v
+				}
+				continue
+			}
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = b.schemaFor(f.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
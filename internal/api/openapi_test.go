@@ -0,0 +1,62 @@
+package api
+
+import (
+	"testing"
+
+	"parenta/internal/testutil"
+)
+
+// TestRouteDocs checks that every registered route is documented, and that
+// routeDocs has no entries for routes that don't exist
+func TestRouteDocs(t *testing.T) {
+	env := testutil.NewEnv(t)
+	r := NewRouter(env.Config, env.Storage, env.NDSPool, env.Dnsmasq, env.Auth, env.Ticker, env.Garden)
+	r.Setup(t.TempDir())
+
+	if len(r.routes) == 0 {
+		t.Fatal("no routes recorded")
+	}
+	registered := make(map[string]bool, len(r.routes))
+	for _, rt := range r.routes {
+		key := rt.Method + " " + rt.Path
+		registered[key] = true
+		if doc, ok := routeDocs[key]; !ok || doc.Summary == "" {
+			t.Errorf("%s has no routeDocs entry", key)
+		}
+	}
+	for key := range routeDocs {
+		if !registered[key] {
+			t.Errorf("routeDocs has %s, which isn't a route", key)
+		}
+	}
+}
+
+// TestOpenAPIKeySecurity checks that only routes open to API keys list the
+// apiKeyAuth scheme
+func TestOpenAPIKeySecurity(t *testing.T) {
+	env := testutil.NewEnv(t)
+	r := NewRouter(env.Config, env.Storage, env.NDSPool, env.Dnsmasq, env.Auth, env.Ticker, env.Garden)
+	r.Setup(t.TempDir())
+	paths := r.buildOpenAPI()["paths"].(map[string]map[string]interface{})
+
+	tests := []struct {
+		path, method string
+		apiKey       bool
+	}{
+		{"/api/v1/children", "get", true},
+		{"/api/v1/system/status", "get", true},
+		{"/api/v1/system/ticker-config", "post", false},
+		{"/api/v1/webhooks", "get", false},
+		{"/api/v1/admins", "get", false},
+	}
+	for _, tt := range tests {
+		op, ok := paths[tt.path][tt.method].(map[string]interface{})
+		if !ok {
+			t.Fatalf("no %s %s in the spec", tt.method, tt.path)
+		}
+		security := op["security"].([]map[string][]string)
+		if got := len(security) == 2; got != tt.apiKey {
+			t.Errorf("%s %s: security %v, want apiKeyAuth %v", tt.method, tt.path, security, tt.apiKey)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"parenta/internal/api/handlers"
 	"parenta/internal/api/middleware"
@@ -12,92 +13,307 @@ import (
 	"parenta/internal/storage"
 )
 
+// API path prefixes. Unversioned paths are kept as aliases for older clients.
+const (
+	apiPrefix       = "/api/v1"
+	legacyAPIPrefix = "/api"
+)
+
 // Router sets up all HTTP routes
 type Router struct {
-	mux        *http.ServeMux
-	auth       *middleware.AuthMiddleware
-	storage    *storage.Storage
-	config     *config.Config
-	ndsctl     *services.NDSCtl
-	dnsmasq    *services.DnsmasqService
-	authSvc    *services.AuthService
+	mux     *http.ServeMux
+	auth    *middleware.AuthMiddleware
+	storage *storage.Storage
+	config  *config.Config
+	ndsctl  services.NDSClient
+	ndsPool *services.NDSCtlPool
+	dnsmasq *services.DnsmasqService
+	authSvc *services.AuthService
+	ticker  *services.SessionTicker
+	garden  *services.WalledGardenService
+	routes  []route
+
+	// Address the HTTP server listens on, for the status endpoint
+	listenAddr string
+
+	// Parenta's captured log output, for the log viewer
+	logs *services.LogBuffer
+
+	backups *services.Backups
+
+	notifier *services.Notifier
 }
 
 // NewRouter creates a new Router
 func NewRouter(
 	cfg *config.Config,
 	store *storage.Storage,
-	ndsctl *services.NDSCtl,
+	ndsPool *services.NDSCtlPool,
 	dnsmasq *services.DnsmasqService,
 	authSvc *services.AuthService,
+	ticker *services.SessionTicker,
+	walledGarden *services.WalledGardenService,
 ) *Router {
+	auth := middleware.NewAuthMiddleware(cfg.Session.JWTSecret, cfg.Session.AuthMode, store.InstallID())
+	auth.SetAPIKeyLookup(authSvc.AuthenticateAPIKey)
+	auth.SetSessionTouch(authSvc.TouchAdminSession)
+
+	// Sessions revoked before a restart stay revoked
+	for _, s := range authSvc.RevokedAdminSessions() {
+		auth.RevokeSession(s.ID, s.ExpiresAt)
+	}
+
 	return &Router{
 		mux:     http.NewServeMux(),
-		auth:    middleware.NewAuthMiddleware(cfg.Session.JWTSecret),
+		auth:    auth,
 		storage: store,
 		config:  cfg,
-		ndsctl:  ndsctl,
+		ndsctl:  ndsPool.Default(),
+		ndsPool: ndsPool,
 		dnsmasq: dnsmasq,
 		authSvc: authSvc,
+		ticker:  ticker,
+		garden:  walledGarden,
 	}
 }
 
+// SetListenAddr records the address the HTTP server listens on
+func (r *Router) SetListenAddr(addr string) {
+	r.listenAddr = addr
+}
+
+// SetLogBuffer makes Parenta's captured log output available to the log
+// viewer
+func (r *Router) SetLogBuffer(logs *services.LogBuffer) {
+	r.logs = logs
+}
+
+// SetBackups makes scheduled backups visible in the system status and lets
+// admins download or run a backup
+func (r *Router) SetBackups(backups *services.Backups) {
+	r.backups = backups
+}
+
+// SetNotifier sends notifications from the portal and watches system health
+// for changes. Start the notifier after Setup.
+func (r *Router) SetNotifier(notifier *services.Notifier) {
+	r.notifier = notifier
+}
+
 // Setup registers all routes
 func (r *Router) Setup(webDir string) http.Handler {
+	if r.notifier == nil {
+		r.notifier = services.NewNotifier(r.storage)
+	}
+
 	// Create handlers
 	authHandler := handlers.NewAuthHandler(r.storage, r.authSvc, r.auth, r.config)
-	fasHandler := handlers.NewFASHandler(r.storage, r.ndsctl, r.authSvc, r.config, r.auth)
-	childrenHandler := handlers.NewChildrenHandler(r.storage, r.authSvc)
-	sessionsHandler := handlers.NewSessionsHandler(r.storage, r.ndsctl)
+	fasHandler := handlers.NewFASHandler(r.storage, r.ndsPool, r.authSvc, r.config, r.auth, r.notifier)
+	childrenHandler := handlers.NewChildrenHandler(r.storage, r.authSvc, r.ticker)
+	sessionsHandler := handlers.NewSessionsHandler(r.storage, r.ndsPool, r.notifier)
 	schedulesHandler := handlers.NewSchedulesHandler(r.storage)
-	filtersHandler := handlers.NewFiltersHandler(r.storage, r.dnsmasq)
-	systemHandler := handlers.NewSystemHandler(r.storage, r.ndsctl, r.dnsmasq, r.config)
+	vouchersHandler := handlers.NewVouchersHandler(r.storage, r.ndsPool, r.notifier)
+	preauthHandler := handlers.NewPreAuthHandler(r.storage, r.ndsPool)
+	walledGardenHandler := handlers.NewWalledGardenHandler(r.storage, r.garden)
+	filtersHandler := handlers.NewFiltersHandler(r.storage, r.dnsmasq, r.config, r.notifier)
+	portalHandler := handlers.NewPortalHandler(r.storage, r.config, webDir)
+	if r.backups == nil {
+		r.backups, _ = services.NewBackups(r.storage, services.BackupTarget{}, false, "")
+	}
+	systemHandler := handlers.NewSystemHandler(r.storage, r.ndsctl, r.dnsmasq, r.ticker, r.config, r.listenAddr, r.logs, r.backups)
+	r.notifier.SetHealthCheck(systemHandler.CheckHealth)
+	notificationsHandler := handlers.NewNotificationsHandler(r.storage, r.notifier)
+	webhooksHandler := handlers.NewWebhooksHandler(r.storage, r.notifier.Webhooks())
+	reportsHandler := handlers.NewReportsHandler(r.storage)
+	overviewHandler := handlers.NewOverviewHandler(systemHandler, childrenHandler, sessionsHandler)
+	diagnosticsHandler := handlers.NewDiagnosticsHandler(services.NewDiagnostics(services.ExecRunner{}, "/proc"))
 
 	// FAS routes (no auth required - these are captive portal entry points)
-	r.mux.HandleFunc("/fas/", fasHandler.HandleFAS)
-	r.mux.HandleFunc("/fas/auth", fasHandler.HandleAuth)
-	r.mux.HandleFunc("/fas/status", fasHandler.HandleStatus)
+	r.register("GET /fas/", fasHandler.HandleFAS)
+	r.register("GET /fas/auth", fasHandler.HandleAuth)
+	r.register("POST /fas/auth", fasHandler.HandleAuth)
+	r.register("GET /fas/status", fasHandler.HandleStatus)
+	r.register("POST /fas/child/password", fasHandler.HandleChildPassword)
 
-	// Portal page - serve unified portal.html
-	r.mux.HandleFunc("/portal", func(w http.ResponseWriter, req *http.Request) {
-		http.ServeFile(w, req, filepath.Join(webDir, "portal.html"))
+	// Portal page - unified portal.html, or the welcome page after a child login
+	r.mux.HandleFunc("GET /portal", portalHandler.HandlePortal)
+	r.mux.HandleFunc("GET /portal/success", portalHandler.HandleSuccess)
+	r.mux.HandleFunc("GET /portal/logo", portalHandler.HandleLogo)
+
+	// API documentation
+	r.handle("GET /openapi.json", r.openAPIHandler())
+	r.handle("GET /docs", func(w http.ResponseWriter, req *http.Request) {
+		http.ServeFile(w, req, filepath.Join(webDir, "docs.html"))
 	})
 
 	// Auth routes
-	r.mux.HandleFunc("/api/auth/login", authHandler.HandleLogin)
-	r.mux.HandleFunc("/api/auth/logout", r.requireAuth(authHandler.HandleLogout))
-	r.mux.HandleFunc("/api/auth/me", r.requireAuth(authHandler.HandleMe))
-	r.mux.HandleFunc("/api/auth/password", r.requireAuth(authHandler.HandleChangePassword))
+	r.handle("POST /auth/login", authHandler.HandleLogin)
+	r.handle("POST /auth/refresh", authHandler.HandleRefresh)
+	r.handle("POST /auth/logout", r.requireAuth(authHandler.HandleLogout))
+	r.handle("GET /auth/me", r.requireAuth(authHandler.HandleMe))
+	r.handle("PUT /auth/me", r.requireAuth(authHandler.HandleUpdateMe))
+	r.handle("POST /auth/password", r.requireAuth(authHandler.HandleChangePassword))
+	r.handle("GET /auth/apikeys", r.requireAuth(authHandler.HandleListAPIKeys))
+	r.handle("POST /auth/apikeys", r.requireAuth(authHandler.HandleCreateAPIKey))
+	r.handle("DELETE /auth/apikeys/{id}", r.requireAuth(authHandler.HandleRevokeAPIKey))
+	r.handle("GET /auth/sessions", r.requireAuth(authHandler.HandleListSessions))
+	r.handle("DELETE /auth/sessions/{id}", r.requireAuth(authHandler.HandleRevokeSession))
+
+	// Dashboard home screen in one request
+	r.handleKey("GET /overview", r.requireAuth(overviewHandler.HandleOverview))
 
 	// Admin management routes
-	r.mux.HandleFunc("/api/admins", r.requireAuth(authHandler.HandleListAdmins))
-	r.mux.HandleFunc("/api/admins/", r.requireAuth(authHandler.HandleAdmin))
+	r.handle("GET /admins", r.requireAuth(authHandler.HandleListAdmins))
+	r.handle("POST /admins", r.requireWrite(authHandler.HandleCreateAdmin))
+	r.handle("GET /admins/{id}", r.requireAuth(authHandler.HandleGetAdmin))
+	r.handle("PUT /admins/{id}", r.requireWrite(authHandler.HandleUpdateAdmin))
+	r.handle("DELETE /admins/{id}", r.requireWrite(authHandler.HandleDeleteAdmin))
+	r.handle("POST /admins/{id}/reset-password", r.requireWrite(authHandler.HandleResetPassword))
+	r.handle("POST /admins/{id}/unlock", r.requireWrite(authHandler.HandleUnlockAdmin))
 
 	// Children routes
-	r.mux.HandleFunc("/api/children", r.requireAuth(childrenHandler.Handle))
-	r.mux.HandleFunc("/api/children/", r.requireAuth(childrenHandler.HandleByID))
+	r.handleKey("GET /children", r.requireAuth(childrenHandler.HandleList))
+	r.handleKey("GET /children/quota-presets", r.requireAuth(childrenHandler.HandleQuotaPresets))
+	r.handleKey("POST /children", r.requireWrite(childrenHandler.HandleCreate))
+	r.handleKey("GET /children/{id}", r.requireAuth(childrenHandler.HandleGet))
+	r.handleKey("PUT /children/{id}", r.requireWrite(childrenHandler.HandleUpdate))
+	r.handleKey("DELETE /children/{id}", r.requireWrite(childrenHandler.HandleDelete))
+	r.handleKey("POST /children/{id}/reset-quota", r.requireWrite(childrenHandler.HandleResetQuota))
+	r.handleKey("POST /children/{id}/adjust-quota", r.requireWrite(childrenHandler.HandleAdjustQuota))
+	r.handleKey("POST /children/{id}/grant", r.requireWrite(childrenHandler.HandleGrant))
+	r.handleKey("GET /children/{id}/bank", r.requireAuth(childrenHandler.HandleBank))
+	r.handleKey("GET /children/{id}/qr", r.requireAuth(childrenHandler.HandleQR))
+	r.handleKey("GET /children/{id}/policy", r.requireAuth(childrenHandler.HandlePolicy))
+	r.handleKey("GET /children/{id}/category-usage", r.requireAuth(childrenHandler.HandleCategoryUsage))
+	r.handleKey("GET /children/{id}/history/daily", r.requireAuth(childrenHandler.HandleDailyHistory))
+	r.handleKey("POST /children/{id}/devices", r.requireWrite(childrenHandler.HandleAddDevice))
+	r.handleKey("PUT /children/{id}/devices", r.requireWrite(childrenHandler.HandleReplaceDevices))
+	r.handleKey("DELETE /children/{id}/devices", r.requireWrite(childrenHandler.HandleRemoveDevice))
+	r.handleKey("DELETE /children/{id}/devices/all", r.requireWrite(childrenHandler.HandleClearDevices))
+	r.handleKey("DELETE /children/{id}/devices/{mac}", r.requireWrite(childrenHandler.HandleRemoveDevice))
+	r.handleKey("POST /children/{id}/devices/{mac}/approve", r.requireWrite(childrenHandler.HandleApproveDevice))
+	r.handleKey("POST /children/{id}/devices/cleanup", r.requireWrite(childrenHandler.HandleCleanupDevices))
 
 	// Sessions routes
-	r.mux.HandleFunc("/api/sessions", r.requireAuth(sessionsHandler.Handle))
-	r.mux.HandleFunc("/api/sessions/", r.requireAuth(sessionsHandler.HandleByID))
+	r.handleKey("GET /sessions", r.requireAuth(sessionsHandler.HandleList))
+	r.handleKey("GET /sessions/history", r.requireAuth(sessionsHandler.HandleHistory))
+	r.handleKey("GET /sessions/{id}", r.requireAuth(sessionsHandler.HandleGet))
+	r.handleKey("DELETE /sessions/{id}", r.requireWrite(sessionsHandler.HandleKick))
+	r.handleKey("POST /sessions/{id}/kick", r.requireWrite(sessionsHandler.HandleKick))
+	r.handleKey("POST /sessions/{id}/extend", r.requireWrite(sessionsHandler.HandleExtend))
+
+	// Guest voucher routes
+	r.handleKey("GET /vouchers", r.requireAuth(vouchersHandler.HandleList))
+	r.handleKey("POST /vouchers", r.requireWrite(vouchersHandler.HandleCreate))
+	r.handleKey("GET /vouchers/{id}", r.requireAuth(vouchersHandler.HandleGet))
+	r.handleKey("PUT /vouchers/{id}", r.requireWrite(vouchersHandler.HandleUpdate))
+	r.handleKey("DELETE /vouchers/{id}", r.requireWrite(vouchersHandler.HandleDelete))
+
+	// Devices that bypass the captive portal
+	r.handleKey("GET /preauth-devices", r.requireAuth(preauthHandler.HandleList))
+	r.handleKey("POST /preauth-devices", r.requireWrite(preauthHandler.HandleCreate))
+	r.handleKey("PUT /preauth-devices/{mac}", r.requireWrite(preauthHandler.HandleUpdate))
+	r.handleKey("DELETE /preauth-devices/{mac}", r.requireWrite(preauthHandler.HandleDelete))
+
+	// Hosts reachable before login
+	r.handleKey("GET /network/walled-garden", r.requireAuth(walledGardenHandler.HandleList))
+	r.handleKey("POST /network/walled-garden", r.requireWrite(walledGardenHandler.HandleCreate))
+	r.handleKey("POST /network/walled-garden/apply", r.requireWrite(walledGardenHandler.HandleApply))
+	r.handleKey("PUT /network/walled-garden/{id}", r.requireWrite(walledGardenHandler.HandleUpdate))
+	r.handleKey("DELETE /network/walled-garden/{id}", r.requireWrite(walledGardenHandler.HandleDelete))
+
+	// Portal branding routes
+	r.handleKey("GET /portal/settings", r.requireAuth(portalHandler.HandleGetSettings))
+	r.handleKey("GET /portal/qr", r.requireAuth(portalHandler.HandleQR))
+	r.handleKey("PUT /portal/settings", r.requireWrite(portalHandler.HandleUpdateSettings))
+	r.handleKey("POST /portal/logo", r.requireWrite(portalHandler.HandleUploadLogo))
+	r.handleKey("DELETE /portal/logo", r.requireWrite(portalHandler.HandleDeleteLogo))
 
 	// Schedules routes
-	r.mux.HandleFunc("/api/schedules", r.requireAuth(schedulesHandler.Handle))
-	r.mux.HandleFunc("/api/schedules/", r.requireAuth(schedulesHandler.HandleByID))
+	r.handleKey("GET /schedules", r.requireAuth(schedulesHandler.HandleList))
+	r.handleKey("POST /schedules", r.requireWrite(schedulesHandler.HandleCreate))
+	r.handleKey("POST /schedules/preview", r.requireAuth(schedulesHandler.HandlePreview))
+	r.handleKey("GET /schedules/{id}", r.requireAuth(schedulesHandler.HandleGet))
+	r.handleKey("PUT /schedules/{id}", r.requireWrite(schedulesHandler.HandleUpdate))
+	r.handleKey("DELETE /schedules/{id}", r.requireWrite(schedulesHandler.HandleDelete))
 
 	// Filters routes
-	r.mux.HandleFunc("/api/filters", r.requireAuth(filtersHandler.Handle))
-	r.mux.HandleFunc("/api/filters/", r.requireAuth(filtersHandler.HandleByID))
-	r.mux.HandleFunc("/api/filters/reload", r.requireAuth(filtersHandler.HandleReload))
+	r.handleKey("GET /filters", r.requireAuth(filtersHandler.HandleList))
+	r.handleKey("POST /filters", r.requireWrite(filtersHandler.HandleCreate))
+	r.handleKey("DELETE /filters", r.requireWrite(filtersHandler.HandleBulkDelete))
+	r.handleKey("DELETE /filters/{id}", r.requireWrite(filtersHandler.HandleDelete))
+	r.handleKey("POST /filters/reload", r.requireWrite(filtersHandler.HandleReload))
+	r.handleKey("POST /filters/import", r.requireWrite(filtersHandler.HandleImport))
+	r.handleKey("GET /filters/presets", r.requireAuth(filtersHandler.HandleListPresets))
+	r.handleKey("POST /filters/presets/{name}/apply", r.requireWrite(filtersHandler.HandleApplyPreset))
 
 	// System routes
-	r.mux.HandleFunc("/api/system/status", r.requireAuth(systemHandler.HandleStatus))
-	r.mux.HandleFunc("/api/system/restart", r.requireAuth(systemHandler.HandleRestart))
-	r.mux.HandleFunc("/api/system/health", r.requireAuth(systemHandler.HandleHealth))
-	r.mux.HandleFunc("/api/system/command", r.requireAuth(systemHandler.HandleCommand))
-	r.mux.HandleFunc("/api/system/logs", r.requireAuth(systemHandler.HandleLogs))
-	r.mux.HandleFunc("/api/system/dashboard", r.requireAuth(systemHandler.HandleDashboard))
-	r.mux.HandleFunc("/api/system/shell", r.requireAuth(systemHandler.HandleShell))
+	r.handleKey("GET /system/status", r.requireAuth(systemHandler.HandleStatus))
+	r.handle("POST /system/restart", r.requireWrite(systemHandler.HandleRestart))
+	r.handleKey("GET /system/health", r.requireAuth(systemHandler.HandleHealth))
+	r.handleKey("GET /system/connectivity", r.requireAuth(systemHandler.HandleConnectivity))
+	r.handle("GET /system/backup", r.requireWrite(systemHandler.HandleBackup))
+	r.handle("POST /system/backup/run", r.requireWrite(systemHandler.HandleBackupRun))
+	r.handleKey("GET /system/update/check", r.requireAuth(systemHandler.HandleUpdateCheck))
+	r.handle("POST /system/update/apply", r.requireWrite(systemHandler.HandleUpdateApply))
+	r.handle("POST /system/command", r.requireWrite(systemHandler.HandleCommand))
+	r.handle("GET /system/allowed-commands", r.requireAuth(systemHandler.HandleGetAllowedCommands))
+	r.handle("PUT /system/allowed-commands", r.requireWrite(systemHandler.HandleSetAllowedCommands))
+	r.handle("GET /system/logs", r.requireAuth(systemHandler.HandleLogs))
+	r.handle("GET /system/logs/stream", r.requireAuth(systemHandler.HandleLogsStream))
+	r.handleKey("GET /system/dashboard", r.requireAuth(systemHandler.HandleDashboard))
+	r.handleKey("GET /system/disk", r.requireAuth(systemHandler.HandleDisk))
+	r.handle("POST /system/shell", r.requireWrite(systemHandler.HandleShell))
+	r.handleKey("GET /system/holiday-mode", r.requireAuth(systemHandler.HandleGetHolidayMode))
+	r.handle("POST /system/holiday-mode", r.requireWrite(systemHandler.HandleSetHolidayMode))
+	r.handle("GET /system/audit", r.requireAuth(systemHandler.HandleAuditLog))
+	r.handle("GET /system/lockouts", r.requireAuth(authHandler.HandleListLockouts))
+	r.handle("POST /system/lockouts/clear", r.requireWrite(authHandler.HandleClearLockouts))
+	r.handle("POST /system/ticker-config", r.requireWrite(systemHandler.HandleTickerConfig))
+	r.handle("GET /system/ticker-events", r.requireAuth(systemHandler.HandleTickerEvents))
+	r.handle("POST /system/dnsmasq/resync", r.requireWrite(systemHandler.HandleDnsmasqResync))
+	r.handle("POST /system/test-ndsctl", r.requireWrite(systemHandler.HandleTestNDSCtl))
+	r.handle("GET /system/password-policy", r.requireAuth(systemHandler.HandleGetPasswordPolicy))
+	r.handle("PUT /system/password-policy", r.requireWrite(systemHandler.HandleSetPasswordPolicy))
+	r.handle("POST /system/prune", r.requireWrite(systemHandler.HandlePrune))
+	r.handle("GET /system/device-policy", r.requireAuth(systemHandler.HandleGetDevicePolicy))
+	r.handle("PUT /system/device-policy", r.requireWrite(systemHandler.HandleSetDevicePolicy))
+
+	// Notification routes
+	r.handle("GET /notifications/settings", r.requireAuth(notificationsHandler.HandleGetSettings))
+	r.handle("PUT /notifications/settings", r.requireWrite(notificationsHandler.HandleUpdateSettings))
+	r.handle("POST /system/notifications/test", r.requireWrite(notificationsHandler.HandleTest))
+
+	// Webhook subscriptions
+	r.handle("GET /webhooks", r.requireAuth(webhooksHandler.HandleList))
+	r.handle("POST /webhooks", r.requireWrite(webhooksHandler.HandleCreate))
+	r.handle("GET /webhooks/deliveries", r.requireAuth(webhooksHandler.HandleDeliveries))
+	r.handle("GET /webhooks/{id}", r.requireAuth(webhooksHandler.HandleGet))
+	r.handle("PUT /webhooks/{id}", r.requireWrite(webhooksHandler.HandleUpdate))
+	r.handle("DELETE /webhooks/{id}", r.requireWrite(webhooksHandler.HandleDelete))
+	r.handle("GET /webhooks/{id}/deliveries", r.requireAuth(webhooksHandler.HandleDeliveries))
+	r.handle("POST /webhooks/{id}/deliveries/{delivery}/redeliver", r.requireWrite(webhooksHandler.HandleRedeliver))
+
+	// Usage reports
+	r.handleKey("GET /reports/daily", r.requireAuth(reportsHandler.HandleDaily))
+	r.handleKey("GET /reports/weekly", r.requireAuth(reportsHandler.HandleWeekly))
+
+	// Diagnostics routes
+	r.handleKey("GET /diagnostics/interfaces", r.requireAuth(diagnosticsHandler.HandleInterfaces))
+	r.handleKey("GET /diagnostics/routes", r.requireAuth(diagnosticsHandler.HandleRoutes))
+	r.handleKey("GET /diagnostics/wireless", r.requireAuth(diagnosticsHandler.HandleWireless))
+	r.handleKey("GET /diagnostics/processes", r.requireAuth(diagnosticsHandler.HandleProcesses))
+
+	// Unknown API paths get a JSON 404 instead of the portal redirect, and
+	// known ones called with the wrong method a 405
+	r.mux.HandleFunc(legacyAPIPrefix+"/", func(w http.ResponseWriter, req *http.Request) {
+		if allow := r.allowedMethods(req); len(allow) > 0 {
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			handlers.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		handlers.Error(w, http.StatusNotFound, "not found")
+	})
 
 	// Static files with redirect from / to /portal
 	fileServer := http.FileServer(http.Dir(webDir))
@@ -118,8 +334,67 @@ func (r *Router) Setup(webDir string) http.Handler {
 		http.Redirect(w, req, "/portal", http.StatusFound)
 	})
 
-	// Add CORS headers
-	return r.corsMiddleware(r.mux)
+	// Add CORS headers; panic recovery is outermost so it covers everything
+	return middleware.Recover(r.corsMiddleware(r.mux))
+}
+
+// handle registers an API route under the versioned /api/v1 prefix and the
+// legacy unversioned /api prefix. The pattern is "METHOD /path".
+func (r *Router) handle(pattern string, handler http.HandlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	if timeout := apiTimeout(path); timeout > 0 {
+		handler = middleware.TimeoutMiddleware(timeout)(handler).ServeHTTP
+	}
+	r.register(method+" "+apiPrefix+path, handler)
+	r.mux.HandleFunc(method+" "+legacyAPIPrefix+path, handler)
+}
+
+// handleKey registers an API route like handle, and lets API keys call it
+// within their role and scopes. Routes registered with handle refuse keys.
+func (r *Router) handleKey(pattern string, handler http.HandlerFunc) {
+	r.handle(pattern, middleware.AllowAPIKeys(handler).ServeHTTP)
+	r.routes[len(r.routes)-1].APIKey = true // the /api/v1 route handle recorded
+}
+
+// routeMethods are the methods API routes are registered with
+var routeMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete}
+
+// allowedMethods returns the methods a route other than the API catch-all
+// serves for the request's path, or nil if there is none
+func (r *Router) allowedMethods(req *http.Request) []string {
+	var allow []string
+	for _, method := range routeMethods {
+		probe := req.Clone(req.Context())
+		probe.Method = method
+		if _, pattern := r.mux.Handler(probe); pattern != "" && pattern != legacyAPIPrefix+"/" {
+			allow = append(allow, method)
+		}
+	}
+	return allow
+}
+
+// Request deadlines for API routes
+const (
+	defaultAPITimeout = 30 * time.Second
+	commandAPITimeout = 60 * time.Second
+	updateAPITimeout  = 6 * time.Minute
+	backupAPITimeout  = 6 * time.Minute
+)
+
+// apiTimeout returns the deadline for an API path. Streams get none, since
+// the timeout middleware buffers the response.
+func apiTimeout(path string) time.Duration {
+	switch path {
+	case "/system/command":
+		return commandAPITimeout
+	case "/system/update/apply":
+		return updateAPITimeout
+	case "/system/backup", "/system/backup/run":
+		return backupAPITimeout
+	case "/system/logs/stream":
+		return 0
+	}
+	return defaultAPITimeout
 }
 
 // requireAuth wraps a handler with authentication
@@ -129,12 +404,36 @@ func (r *Router) requireAuth(handler http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// requireWrite wraps a handler that changes state with authentication, and
+// refuses it to viewers
+func (r *Router) requireWrite(handler http.HandlerFunc) http.HandlerFunc {
+	return r.requireAuth(handlers.RequireWriter(r.storage, handler))
+}
+
 // corsMiddleware adds CORS headers
 func (r *Router) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		origin := req.Header.Get("Origin")
+		allowed := r.allowedOrigin(origin)
+
+		switch allowed {
+		case "":
+			// Unlisted origin: no CORS headers, and preflights are refused
+			if req.Method == "OPTIONS" && origin != "" {
+				handlers.ErrorCode(w, http.StatusForbidden, handlers.CodeForbidden, "origin not allowed")
+				return
+			}
+		case "*":
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		default:
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Add("Vary", "Origin")
+		}
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+middleware.CSRFHeader+", "+middleware.APIKeyHeader)
+		}
 
 		if req.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -144,3 +443,24 @@ func (r *Router) corsMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, req)
 	})
 }
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for a request
+// origin: "*" when server.cors_origins is empty or contains "*", the origin
+// itself when listed, or "" when it isn't allowed
+func (r *Router) allowedOrigin(origin string) string {
+	origins := r.config.Server.CORSOrigins
+	if len(origins) == 0 {
+		return "*"
+	}
+
+	origin = strings.TrimSuffix(origin, "/")
+	for _, o := range origins {
+		if o == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package api_test
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"parenta/internal/models"
+)
+
+// TestRoutePrefixes checks that routes answer the same under /api/v1 and
+// the legacy /api prefix, including both ways of removing a device
+func TestRoutePrefixes(t *testing.T) {
+	const (
+		phone  = "a8:bb:cc:00:00:01"
+		tablet = "a8:bb:cc:00:00:02"
+		laptop = "a8:bb:cc:00:00:03"
+	)
+
+	for _, prefix := range []string{"/api", "/api/v1"} {
+		t.Run(prefix, func(t *testing.T) {
+			env, handler, token := newAPI(t)
+			child := env.AddChild(t, "alice", "pass1234", 60)
+			id := child.ID
+
+			steps := []struct {
+				name    string
+				method  string
+				path    string
+				body    string
+				noToken bool
+				status  int
+				code    string
+				allow   string   // The Allow header of a 405
+				devices []string // The child's devices afterwards, if set
+			}{
+				{name: "list children", method: "GET", path: "/children", status: http.StatusOK},
+				{name: "get child", method: "GET", path: "/children/" + id, status: http.StatusOK},
+				{name: "missing child", method: "GET", path: "/children/nope", status: http.StatusNotFound, code: "CHILD_NOT_FOUND"},
+				{name: "no token", method: "GET", path: "/children", noToken: true, status: http.StatusUnauthorized, code: "UNAUTHORIZED"},
+				{name: "unknown route", method: "GET", path: "/nothing-here", status: http.StatusNotFound, code: "NOT_FOUND"},
+				{name: "wrong method", method: "PUT", path: "/children", status: http.StatusMethodNotAllowed,
+					code: "METHOD_NOT_ALLOWED", allow: "GET, HEAD, POST"},
+				{name: "wrong method with an id", method: "POST", path: "/children/" + id, status: http.StatusMethodNotAllowed,
+					code: "METHOD_NOT_ALLOWED", allow: "GET, HEAD, PUT, DELETE"},
+
+				{name: "add phone", method: "POST", path: "/children/" + id + "/devices", body: `{"mac":"` + phone + `"}`,
+					status: http.StatusOK, devices: []string{phone}},
+				{name: "add tablet", method: "POST", path: "/children/" + id + "/devices", body: `{"mac":"` + tablet + `"}`,
+					status: http.StatusOK, devices: []string{phone, tablet}},
+				{name: "remove by path", method: "DELETE", path: "/children/" + id + "/devices/" + phone,
+					status: http.StatusOK, devices: []string{tablet}},
+				{name: "remove by legacy query", method: "DELETE", path: "/children/" + id + "/devices?mac=" + tablet,
+					status: http.StatusOK, devices: []string{}},
+				{name: "remove without a MAC", method: "DELETE", path: "/children/" + id + "/devices",
+					status: http.StatusBadRequest, code: "VALIDATION_FAILED"},
+				{name: "remove a bad MAC", method: "DELETE", path: "/children/" + id + "/devices/not-a-mac",
+					status: http.StatusBadRequest, code: "VALIDATION_FAILED"},
+				{name: "remove from a missing child", method: "DELETE", path: "/children/nope/devices/" + phone,
+					status: http.StatusNotFound, code: "CHILD_NOT_FOUND"},
+
+				{name: "add laptop", method: "POST", path: "/children/" + id + "/devices", body: `{"mac":"` + laptop + `"}`,
+					status: http.StatusOK, devices: []string{laptop}},
+				{name: "clear is not a MAC", method: "DELETE", path: "/children/" + id + "/devices/all",
+					status: http.StatusOK, devices: []string{}},
+			}
+
+			for _, step := range steps {
+				token := token
+				if step.noToken {
+					token = ""
+				}
+				rec := call(handler, step.method, prefix+step.path, token, step.body)
+				if rec.Code != step.status {
+					t.Fatalf("%s: %s %s gave %d, want %d: %s", step.name, step.method, prefix+step.path, rec.Code, step.status, rec.Body.String())
+				}
+				if code := errorCode(rec); code != step.code {
+					t.Errorf("%s: error code %q, want %q", step.name, code, step.code)
+				}
+				if allow := rec.Header().Get("Allow"); allow != step.allow {
+					t.Errorf("%s: Allow %q, want %q", step.name, allow, step.allow)
+				}
+				if step.devices != nil {
+					if got := deviceMACs(env.Storage.GetChild(id)); !slices.Equal(got, step.devices) {
+						t.Errorf("%s: devices %v, want %v", step.name, got, step.devices)
+					}
+				}
+			}
+		})
+	}
+}
+
+// deviceMACs lists a child's device MACs in order
+func deviceMACs(c *models.Child) []string {
+	macs := []string{}
+	for _, d := range c.Devices {
+		macs = append(macs, d.MAC)
+	}
+	return macs
+}
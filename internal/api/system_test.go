@@ -0,0 +1,108 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"parenta/internal/models"
+)
+
+// TestSystemGatewayInfo checks that system status and health report the
+// gateway openNDS or the config names, never a built-in default
+func TestSystemGatewayInfo(t *testing.T) {
+	tests := []struct {
+		name       string
+		gatewayIPs []string
+		iface      string // opennds.gateway_interface
+		ndsStatus  string // ndsctl status output
+		wantIface  string
+		wantAddr   string
+	}{
+		{
+			name:       "from the config",
+			gatewayIPs: []string{"10.20.0.1", "10.30.0.1"},
+			iface:      "br-kids",
+			ndsStatus:  "openNDS Status",
+			wantIface:  "br-kids",
+			wantAddr:   "10.20.0.1",
+		},
+		{
+			name:       "from ndsctl status",
+			gatewayIPs: []string{"10.20.0.1"},
+			iface:      "br-kids",
+			ndsStatus:  "==================\nVersion: 10.2.0\nManaged interface: wlan-guest\nManaged IPv4 address: 172.16.5.1/24\n",
+			wantIface:  "wlan-guest",
+			wantAddr:   "172.16.5.1",
+		},
+		{
+			name:      "nothing known",
+			ndsStatus: "openNDS Status",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, handler, token := newAPI(t)
+			env.Config.OpenNDS.GatewayIPs = tt.gatewayIPs
+			env.Config.OpenNDS.GatewayInterface = tt.iface
+			env.NDS.StatusOutput = tt.ndsStatus
+
+			for _, path := range []string{"/api/v1/system/status", "/api/v1/system/health"} {
+				rec := call(handler, http.MethodGet, path, token, "")
+				if rec.Code != http.StatusOK {
+					t.Fatalf("%s: status %d: %s", path, rec.Code, rec.Body.String())
+				}
+				if body := rec.Body.String(); strings.Contains(body, "192.168.") || strings.Contains(body, "br-lan") {
+					t.Errorf("%s reports a built-in default: %s", path, body)
+				}
+
+				var resp struct {
+					GatewayInterface string   `json:"gateway_interface"`
+					GatewayAddress   string   `json:"gateway_address"`
+					GatewayIPs       []string `json:"gateway_ips"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.GatewayInterface != tt.wantIface || resp.GatewayAddress != tt.wantAddr {
+					t.Errorf("%s: gateway %q %q, want %q %q", path, resp.GatewayInterface, resp.GatewayAddress, tt.wantIface, tt.wantAddr)
+				}
+				if strings.HasSuffix(path, "/status") && !slices.Equal(resp.GatewayIPs, tt.gatewayIPs) {
+					t.Errorf("%s: gateway_ips %v, want %v", path, resp.GatewayIPs, tt.gatewayIPs)
+				}
+			}
+		})
+	}
+}
+
+// TestBackupDownloadNeedsSuper checks that only super admins can download
+// the backup archive, which holds every password hash and secret
+func TestBackupDownloadNeedsSuper(t *testing.T) {
+	env, handler, token := newAPI(t)
+	env.AddAdmin(t, "grandma", testAdminPassword, models.RoleViewer)
+	env.AddAdmin(t, "parent", testAdminPassword, models.RoleAdmin)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "viewer", token: login(t, handler, "grandma", testAdminPassword), status: http.StatusForbidden},
+		{name: "admin", token: login(t, handler, "parent", testAdminPassword), status: http.StatusForbidden},
+		{name: "super admin", token: token, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := call(handler, http.MethodGet, "/api/v1/system/backup", tt.token, "")
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK && rec.Header().Get("Content-Type") != "application/gzip" {
+				t.Errorf("Content-Type %q", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
@@ -2,7 +2,19 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds all application configuration
@@ -11,28 +23,343 @@ type Config struct {
 	Storage  StorageConfig  `json:"storage"`
 	OpenNDS  OpenNDSConfig  `json:"opennds"`
 	Dnsmasq  DnsmasqConfig  `json:"dnsmasq"`
+	Filters  FiltersConfig  `json:"filters"`
 	Defaults DefaultsConfig `json:"defaults"`
 	Session  SessionConfig  `json:"session"`
+	Portal   PortalConfig   `json:"portal"`
+	System   SystemConfig   `json:"system"`
+	Update   UpdateConfig   `json:"update"`
+	Backup   BackupConfig   `json:"backup"`
+	MQTT     MQTTConfig     `json:"mqtt"`
 }
 
 type ServerConfig struct {
 	Host string `json:"host"`
 	Port int    `json:"port"`
+
+	// Network interface to bind to, e.g. "br-lan". Its address overrides
+	// Host, so the admin API is only reachable on that interface.
+	BindInterface string `json:"bind_interface,omitempty"`
+
+	// Origins allowed to call the API from a browser; empty or "*" allows any
+	CORSOrigins []string `json:"cors_origins"`
 }
 
 type StorageConfig struct {
 	DataDir string `json:"data_dir"`
+
+	// Octal permissions for the data directory and its files. The files hold
+	// password hashes and tokens, so both default to owner only.
+	DirMode  string `json:"dir_mode"`
+	FileMode string `json:"file_mode"`
+
+	// Days of session, usage and audit history kept; older records are
+	// pruned once a day. Negative turns automatic pruning off.
+	RetentionDays int `json:"retention_days"`
+
+	// Data files larger than this are reported by the health check
+	MaxFileSizeBytes int64 `json:"max_file_size_bytes"`
+}
+
+// Default storage permissions
+const (
+	DefaultDirMode  os.FileMode = 0700
+	DefaultFileMode os.FileMode = 0600
+)
+
+// DirPerm returns dir_mode, or the default if it is unset or invalid
+func (s StorageConfig) DirPerm() os.FileMode {
+	if mode, err := parseMode(s.DirMode); err == nil && s.DirMode != "" {
+		return mode
+	}
+	return DefaultDirMode
+}
+
+// FilePerm returns file_mode, or the default if it is unset or invalid
+func (s StorageConfig) FilePerm() os.FileMode {
+	if mode, err := parseMode(s.FileMode); err == nil && s.FileMode != "" {
+		return mode
+	}
+	return DefaultFileMode
+}
+
+// parseMode parses an octal permission string such as "0700"
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%q is not an octal permission like 0700", s)
+	}
+	return os.FileMode(mode), nil
 }
 
 type OpenNDSConfig struct {
-	NDSCtlPath string `json:"ndsctl_path"`
-	FASKey     string `json:"fas_key"`
-	GatewayIP  string `json:"gateway_ip"`
+	NDSCtlPath    string            `json:"ndsctl_path"`
+	FASKey        string            `json:"fas_key"`
+	GatewayIPs    []string          `json:"gateway_ips"`
+	GatewayHashes map[string]string `json:"gateway_hashes,omitempty"` // openNDS gatewayhash -> gateway IP
+	NDSCtlPaths   map[string]string `json:"ndsctl_paths,omitempty"`   // gateway IP -> ndsctl binary
+	NDSCtlSockets map[string]string `json:"ndsctl_sockets,omitempty"` // gateway IP -> openNDS control socket
+
+	// Interface openNDS manages, reported when ndsctl status doesn't say
+	GatewayInterface string `json:"gateway_interface,omitempty"`
+
+	// Script Parenta writes the walled garden to when applying it; empty
+	// puts it in the data directory
+	WalledGardenScript string `json:"walled_garden_script,omitempty"`
+
+	// Must match openNDS fas_secure_enabled. At 2 or 3 FAS payloads are
+	// decrypted with fas_key and forged ones are rejected.
+	FASSecureEnabled int `json:"fas_secure_enabled"`
+}
+
+// UnmarshalJSON accepts the legacy single "gateway_ip" string alongside "gateway_ips"
+func (o *OpenNDSConfig) UnmarshalJSON(data []byte) error {
+	type plain OpenNDSConfig
+	aux := struct {
+		*plain
+		GatewayIP string `json:"gateway_ip"`
+	}{plain: (*plain)(o)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.GatewayIP != "" {
+		for _, ip := range o.GatewayIPs {
+			if ip == aux.GatewayIP {
+				return nil
+			}
+		}
+		o.GatewayIPs = append([]string{aux.GatewayIP}, o.GatewayIPs...)
+	}
+	return nil
+}
+
+// PrimaryGatewayIP returns the first configured gateway IP
+func (o *OpenNDSConfig) PrimaryGatewayIP() string {
+	if len(o.GatewayIPs) == 0 {
+		return ""
+	}
+	return o.GatewayIPs[0]
+}
+
+// SelectGateway picks the gateway IP for a FAS request, preferring an explicit
+// gatewayhash mapping, then the gatewayaddress reported by openNDS, then the primary gateway
+func (o *OpenNDSConfig) SelectGateway(gatewayHash, gatewayAddress string) string {
+	if ip, ok := o.GatewayHashes[gatewayHash]; ok && gatewayHash != "" {
+		return ip
+	}
+
+	host := gatewayAddress
+	if h, _, err := net.SplitHostPort(gatewayAddress); err == nil {
+		host = h
+	}
+	for _, ip := range o.GatewayIPs {
+		if ip == host {
+			return ip
+		}
+	}
+
+	return o.PrimaryGatewayIP()
 }
 
 type DnsmasqConfig struct {
 	ConfDir    string `json:"conf_dir"`
 	RestartCmd string `json:"restart_cmd"`
+
+	// Resolver whitelisted domains are forwarded to
+	UpstreamDNS string `json:"upstream_dns"`
+
+	// Resolver used instead of UpstreamDNS while study mode is on, e.g. a
+	// family-safe DNS; empty keeps UpstreamDNS
+	StudyUpstreamDNS string `json:"study_upstream_dns,omitempty"`
+}
+
+type FiltersConfig struct {
+	// Hosts that filter preset lists may be downloaded from
+	PresetAllowedHosts []string `json:"preset_allowed_hosts"`
+}
+
+type PortalConfig struct {
+	// HTML template shown to children after login; empty uses the built-in page
+	WelcomeTemplate string   `json:"welcome_template"`
+	HouseRules      []string `json:"house_rules"`
+}
+
+type SystemConfig struct {
+	// Turns on POST /api/system/command and /api/system/shell for super
+	// admins. Off by default; the dashboard uses /api/diagnostics instead.
+	AllowRawCommands bool `json:"allow_raw_commands"`
+
+	// Commands POST /api/system/command may run, mapped to the first
+	// arguments allowed for each (empty allows any)
+	AllowedCommands map[string][]string `json:"allowed_commands"`
+
+	// Mount points reported by GET /api/system/disk
+	DiskPaths []string `json:"disk_paths"`
+
+	// Times a dnsmasq or openNDS restart is tried before giving up
+	RestartAttempts int `json:"restart_attempts"`
+
+	// Seconds GET /api/system/dashboard reuses its last snapshot; negative
+	// disables the cache
+	DashboardCacheSeconds int `json:"dashboard_cache_seconds"`
+
+	// Targets of GET /api/system/connectivity
+	Connectivity ConnectivityConfig `json:"connectivity"`
+}
+
+type UpdateConfig struct {
+	// Turns on GET /api/system/update/check and POST /api/system/update/apply
+	Enabled bool `json:"enabled"`
+
+	// GitHub releases API URL, e.g.
+	// https://api.github.com/repos/OWNER/REPO/releases/latest
+	ReleaseURL string `json:"release_url"`
+}
+
+type BackupConfig struct {
+	// Turns on the daily backup
+	Enabled bool `json:"enabled"`
+
+	// Local time of the daily backup, "HH:MM"
+	Time string `json:"time"`
+
+	// A directory (such as a USB stick), scp://user@host[:port]/dir, or the
+	// http(s) URL of a WebDAV collection
+	Destination string `json:"destination"`
+
+	// Archives kept in a local directory; older ones are deleted. 0 keeps
+	// them all.
+	Keep int `json:"keep"`
+
+	// WebDAV basic auth, or the SSH password for scp
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// SSH private key for scp, and the SHA256 fingerprint of the server's
+	// host key, as `ssh-keygen -lf` prints it
+	SSHKeyFile string `json:"ssh_key_file,omitempty"`
+	HostKey    string `json:"host_key,omitempty"`
+}
+
+type MQTTConfig struct {
+	// Turns on the MQTT bridge for Home Assistant
+	Enabled bool `json:"enabled"`
+
+	// Broker URL: tcp://host:1883, ssl://host:8883, ws:// or wss://
+	Broker string `json:"broker"`
+
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Client ID, default "parenta". It must be unique on the broker.
+	ClientID string `json:"client_id"`
+
+	// Topics go under topic_prefix (default "parenta") and Home Assistant
+	// discovery configs under discovery_prefix (default "homeassistant").
+	// A discovery_prefix of "-" turns discovery off.
+	TopicPrefix     string `json:"topic_prefix"`
+	DiscoveryPrefix string `json:"discovery_prefix"`
+
+	// PEM CA certificate to verify an ssl:// or wss:// broker with, instead
+	// of the system roots
+	CAFile             string `json:"ca_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+type ConnectivityConfig struct {
+	// Resolver to test, normally the local dnsmasq ("host:port")
+	DNSServer string `json:"dns_server"`
+
+	// Domain that should resolve
+	Domain string `json:"domain"`
+
+	// Domain that should be blocked; empty uses the first blocklist rule
+	BlockedDomain string `json:"blocked_domain,omitempty"`
+
+	// URL with an IP address host, to test the WAN without DNS
+	HTTPURL string `json:"http_url"`
+
+	// Seconds the checks may take, and seconds a result is reused
+	TimeoutSeconds int `json:"timeout_seconds"`
+	CacheSeconds   int `json:"cache_seconds"`
+}
+
+// DefaultAllowedCommands returns the built-in command allowlist
+func DefaultAllowedCommands() map[string][]string {
+	return map[string][]string{
+		"ifconfig": {},
+		"ip":       {"addr", "link", "route"},
+		"ps":       {},
+		"df":       {"-h"},
+		"free":     {"-m"},
+		"uptime":   {},
+		"ndsctl":   {"status", "json"},
+		"logread":  {"-l"},
+		"iwinfo":   {},
+		"uci":      {"show"},
+		"cat":      {"/proc/meminfo", "/proc/loadavg"},
+	}
+}
+
+// trustedCommandDirs are the directories allowlisted commands may run from,
+// so a changed PATH can't swap in another binary
+var trustedCommandDirs = []string{"/usr/sbin", "/sbin", "/usr/bin", "/bin"}
+
+// ValidateAllowedCommands checks that each command is a plain binary name
+// or an absolute path, and that it resolves into a trusted directory
+func ValidateAllowedCommands(commands map[string][]string) error {
+	for name := range commands {
+		if name == "" || strings.ContainsAny(name, " \t") || strings.Contains(name, "..") {
+			return fmt.Errorf("command %q must be a binary name or absolute path", name)
+		}
+		if strings.Contains(name, "/") && !filepath.IsAbs(name) {
+			return fmt.Errorf("command %q must be a binary name or absolute path", name)
+		}
+	}
+	return validateCommandPaths(commands)
+}
+
+// validateCommandPaths resolves each command and rejects any outside the
+// trusted directories. Commands that aren't installed only log a warning,
+// since the default list names tools not every router has.
+func validateCommandPaths(cmds map[string][]string) error {
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		_, err := ResolveCommand(name)
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			log.Printf("Warning: allowed command %s not found", name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveCommand returns the absolute path an allowlisted command runs
+// from: the name itself if absolute, else its PATH lookup. The path must be
+// in a trusted directory.
+func ResolveCommand(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(trustedCommandDirs, filepath.Dir(path)) {
+		return "", fmt.Errorf("command %s resolves to %s, outside %s", name, path, strings.Join(trustedCommandDirs, ", "))
+	}
+	return path, nil
 }
 
 type DefaultsConfig struct {
@@ -47,6 +374,25 @@ type SessionConfig struct {
 	TickIntervalSeconds int    `json:"tick_interval_seconds"`
 	JWTSecret           string `json:"jwt_secret"`
 	JWTExpiryHours      int    `json:"jwt_expiry_hours"`
+
+	// Short-lived access tokens plus rotating refresh tokens. Set
+	// legacy_tokens to keep issuing jwt_expiry_hours tokens without refresh.
+	AccessTokenMinutes int  `json:"access_token_minutes"`
+	RefreshTokenDays   int  `json:"refresh_token_days"`
+	LegacyTokens       bool `json:"legacy_tokens"`
+
+	// Admin login lockout: after MaxLoginAttempts consecutive failures the
+	// account (and source IP) is locked, doubling the cooldown each time
+	MaxLoginAttempts int `json:"max_login_attempts"`
+	LockoutMinutes   int `json:"lockout_minutes"`
+
+	// Where the dashboard session lives: "header" (Bearer token), "cookie"
+	// (HttpOnly cookie + CSRF token) or "both"
+	AuthMode string `json:"auth_mode"`
+
+	// Mark session cookies Secure on plain HTTP requests too, for a
+	// dashboard served through an HTTPS reverse proxy
+	SecureCookies bool `json:"secure_cookies"`
 }
 
 // Load reads configuration from a JSON file
@@ -68,15 +414,342 @@ func Load(path string) (*Config, error) {
 	if cfg.Storage.DataDir == "" {
 		cfg.Storage.DataDir = "./data"
 	}
+	if cfg.Storage.RetentionDays == 0 {
+		cfg.Storage.RetentionDays = 365
+	}
+	if cfg.Storage.MaxFileSizeBytes <= 0 {
+		cfg.Storage.MaxFileSizeBytes = 10 << 20
+	}
 	if cfg.Session.TickIntervalSeconds == 0 {
 		cfg.Session.TickIntervalSeconds = 30
 	}
 	if cfg.Session.JWTExpiryHours == 0 {
 		cfg.Session.JWTExpiryHours = 24
 	}
+	if cfg.Session.AccessTokenMinutes == 0 {
+		cfg.Session.AccessTokenMinutes = 15
+	}
+	if cfg.Session.RefreshTokenDays == 0 {
+		cfg.Session.RefreshTokenDays = 30
+	}
+	if cfg.Session.MaxLoginAttempts == 0 {
+		cfg.Session.MaxLoginAttempts = 5
+	}
+	if cfg.Session.LockoutMinutes == 0 {
+		cfg.Session.LockoutMinutes = 5
+	}
+	if cfg.Session.AuthMode == "" {
+		cfg.Session.AuthMode = "both"
+	}
 	if cfg.Defaults.DailyQuotaMinutes == 0 {
 		cfg.Defaults.DailyQuotaMinutes = 120
 	}
+	if cfg.Dnsmasq.UpstreamDNS == "" {
+		cfg.Dnsmasq.UpstreamDNS = "8.8.8.8"
+	}
+	if cfg.Filters.PresetAllowedHosts == nil {
+		cfg.Filters.PresetAllowedHosts = []string{"raw.githubusercontent.com"}
+	}
+	if cfg.System.AllowedCommands == nil {
+		cfg.System.AllowedCommands = DefaultAllowedCommands()
+	}
+	if cfg.System.RestartAttempts < 1 {
+		cfg.System.RestartAttempts = 3
+	}
+	if cfg.System.DashboardCacheSeconds == 0 {
+		cfg.System.DashboardCacheSeconds = 3
+	}
+	if len(cfg.System.DiskPaths) == 0 {
+		cfg.System.DiskPaths = []string{"/", "/opt", "/tmp"}
+	}
+	if cfg.System.Connectivity.DNSServer == "" {
+		cfg.System.Connectivity.DNSServer = "127.0.0.1:53"
+	}
+	if cfg.System.Connectivity.Domain == "" {
+		cfg.System.Connectivity.Domain = "example.com"
+	}
+	if cfg.System.Connectivity.HTTPURL == "" {
+		cfg.System.Connectivity.HTTPURL = "http://1.1.1.1/"
+	}
+	if cfg.System.Connectivity.TimeoutSeconds < 1 {
+		cfg.System.Connectivity.TimeoutSeconds = 3
+	}
+	if cfg.System.Connectivity.CacheSeconds == 0 {
+		cfg.System.Connectivity.CacheSeconds = 15
+	}
+	if cfg.Backup.Time == "" {
+		cfg.Backup.Time = "03:00"
+	}
+	if cfg.Backup.Keep == 0 {
+		cfg.Backup.Keep = 7
+	}
+	if cfg.MQTT.ClientID == "" {
+		cfg.MQTT.ClientID = "parenta"
+	}
+	if cfg.MQTT.TopicPrefix == "" {
+		cfg.MQTT.TopicPrefix = "parenta"
+	}
+	if cfg.MQTT.DiscoveryPrefix == "" {
+		cfg.MQTT.DiscoveryPrefix = "homeassistant"
+	}
 
 	return &cfg, nil
 }
+
+// placeholderSecrets are the values shipped in configs/parenta.json
+var placeholderSecrets = map[string]bool{
+	"CHANGE_THIS_JWT_SECRET": true,
+	"CHANGE_THIS_SECRET_KEY": true,
+}
+
+// minJWTSecretLen is the shortest JWT secret accepted by Validate
+const minJWTSecretLen = 16
+
+// Validate checks that the configuration can actually run on this host and
+// returns every problem found, one per line
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port %d is out of range (1-65535)", c.Server.Port))
+	}
+
+	// ndsctl binaries
+	if c.OpenNDS.NDSCtlPath == "" {
+		errs = append(errs, errors.New("opennds.ndsctl_path is not set"))
+	} else if err := checkExecutable(c.OpenNDS.NDSCtlPath); err != nil {
+		errs = append(errs, err)
+	}
+	for ip, path := range c.OpenNDS.NDSCtlPaths {
+		if err := checkExecutable(path); err != nil {
+			errs = append(errs, fmt.Errorf("opennds.ndsctl_paths[%s]: %w", ip, err))
+		}
+	}
+
+	// Gateways
+	if len(c.OpenNDS.GatewayIPs) == 0 {
+		errs = append(errs, errors.New("opennds.gateway_ip is not set"))
+	}
+	for _, ip := range c.OpenNDS.GatewayIPs {
+		if net.ParseIP(ip) == nil {
+			errs = append(errs, fmt.Errorf("opennds gateway IP %q is not a valid IP address", ip))
+		}
+	}
+	for hash, ip := range c.OpenNDS.GatewayHashes {
+		if net.ParseIP(ip) == nil {
+			errs = append(errs, fmt.Errorf("opennds.gateway_hashes[%s]: %q is not a valid IP address", hash, ip))
+		}
+	}
+	for ip := range c.OpenNDS.NDSCtlSockets {
+		if !slices.Contains(c.OpenNDS.GatewayIPs, ip) {
+			errs = append(errs, fmt.Errorf("opennds.ndsctl_sockets: %s is not one of gateway_ips", ip))
+		}
+	}
+
+	// FAS security level
+	switch level := c.OpenNDS.FASSecureEnabled; {
+	case level < 0 || level > 3:
+		errs = append(errs, fmt.Errorf("opennds.fas_secure_enabled %d must be 0-3", level))
+	case level >= 2 && (c.OpenNDS.FASKey == "" || placeholderSecrets[c.OpenNDS.FASKey]):
+		errs = append(errs, fmt.Errorf("opennds.fas_secure_enabled %d needs fas_key set to the openNDS faskey", level))
+	case level >= 2 && len(c.OpenNDS.GatewayHashes) == 0:
+		errs = append(errs, fmt.Errorf("opennds.fas_secure_enabled %d needs gateway_hashes, mapping each openNDS gatewayhash to its gateway IP", level))
+	}
+
+	// dnsmasq config directory
+	if c.Dnsmasq.ConfDir == "" {
+		errs = append(errs, errors.New("dnsmasq.conf_dir is not set"))
+	} else if err := checkWritableDir(c.Dnsmasq.ConfDir); err != nil {
+		errs = append(errs, err)
+	}
+	if net.ParseIP(c.Dnsmasq.UpstreamDNS) == nil {
+		errs = append(errs, fmt.Errorf("dnsmasq.upstream_dns %q is not a valid IP address", c.Dnsmasq.UpstreamDNS))
+	}
+	if ip := c.Dnsmasq.StudyUpstreamDNS; ip != "" && net.ParseIP(ip) == nil {
+		errs = append(errs, fmt.Errorf("dnsmasq.study_upstream_dns %q is not a valid IP address", ip))
+	}
+
+	// JWT secret
+	secret := c.Session.JWTSecret
+	switch {
+	case secret == "":
+		errs = append(errs, errors.New("session.jwt_secret is not set"))
+	case placeholderSecrets[secret]:
+		errs = append(errs, errors.New("session.jwt_secret is still the placeholder value; set a random secret"))
+	case len(secret) < minJWTSecretLen:
+		errs = append(errs, fmt.Errorf("session.jwt_secret is too short (%d chars, need at least %d)", len(secret), minJWTSecretLen))
+	}
+
+	switch c.Session.AuthMode {
+	case "header", "cookie", "both":
+	default:
+		errs = append(errs, fmt.Errorf("session.auth_mode %q must be header, cookie or both", c.Session.AuthMode))
+	}
+
+	if path := c.Portal.WelcomeTemplate; path != "" {
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("portal.welcome_template %s not found", path))
+		}
+	}
+
+	if err := ValidateAllowedCommands(c.System.AllowedCommands); err != nil {
+		errs = append(errs, fmt.Errorf("system.allowed_commands: %w", err))
+	}
+	if _, _, err := net.SplitHostPort(c.System.Connectivity.DNSServer); err != nil {
+		errs = append(errs, fmt.Errorf("system.connectivity.dns_server %q must be host:port", c.System.Connectivity.DNSServer))
+	}
+	if u, err := url.Parse(c.System.Connectivity.HTTPURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || net.ParseIP(u.Hostname()) == nil {
+		errs = append(errs, fmt.Errorf("system.connectivity.http_url %q must be an http(s) URL with an IP address host, so it bypasses DNS", c.System.Connectivity.HTTPURL))
+	}
+
+	if c.Update.Enabled {
+		if u, err := url.Parse(c.Update.ReleaseURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("update.release_url %q must be an https URL when updates are enabled", c.Update.ReleaseURL))
+		}
+	}
+
+	if c.Backup.Enabled {
+		errs = append(errs, c.Backup.validate()...)
+	}
+	if c.MQTT.Enabled {
+		errs = append(errs, c.MQTT.validate()...)
+	}
+
+	// The service must still be able to use its own data
+	if err := checkMode(c.Storage.DirMode, 0700); err != nil {
+		errs = append(errs, fmt.Errorf("storage.dir_mode: %w", err))
+	}
+	if err := checkMode(c.Storage.FileMode, 0600); err != nil {
+		errs = append(errs, fmt.Errorf("storage.file_mode: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate checks an enabled backup configuration
+func (b BackupConfig) validate() []error {
+	var errs []error
+	if _, err := time.Parse("15:04", b.Time); err != nil {
+		errs = append(errs, fmt.Errorf("backup.time %q must be HH:MM", b.Time))
+	}
+	if b.Keep < 0 {
+		errs = append(errs, fmt.Errorf("backup.keep %d must not be negative", b.Keep))
+	}
+
+	u, err := url.Parse(b.Destination)
+	switch {
+	case b.Destination == "":
+		errs = append(errs, errors.New("backup.destination is not set"))
+	case err != nil:
+		errs = append(errs, fmt.Errorf("backup.destination: %w", err))
+	case u.Scheme == "" || u.Scheme == "file":
+		if !filepath.IsAbs(u.Path) {
+			errs = append(errs, fmt.Errorf("backup.destination %q must be an absolute path", b.Destination))
+		}
+	case u.Scheme == "http" || u.Scheme == "https":
+		if u.Host == "" {
+			errs = append(errs, fmt.Errorf("backup.destination %q has no host", b.Destination))
+		}
+	case u.Scheme == "scp":
+		if u.Host == "" || (u.User == nil && b.Username == "") {
+			errs = append(errs, fmt.Errorf("backup.destination %q must be scp://user@host[:port]/dir", b.Destination))
+		}
+		if !strings.HasPrefix(b.HostKey, "SHA256:") {
+			errs = append(errs, errors.New("backup.host_key must be the server's SHA256 host key fingerprint for scp"))
+		}
+		if b.SSHKeyFile == "" && b.Password == "" {
+			errs = append(errs, errors.New("backup.ssh_key_file or backup.password is needed for scp"))
+		}
+		if b.SSHKeyFile != "" {
+			if _, err := os.Stat(b.SSHKeyFile); err != nil {
+				errs = append(errs, fmt.Errorf("backup.ssh_key_file %s not found", b.SSHKeyFile))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("backup.destination %q must be a directory, scp:// or http(s):// URL", b.Destination))
+	}
+	return errs
+}
+
+// validate checks an enabled MQTT configuration
+func (m MQTTConfig) validate() []error {
+	var errs []error
+	u, err := url.Parse(m.Broker)
+	switch {
+	case m.Broker == "":
+		errs = append(errs, errors.New("mqtt.broker is not set"))
+	case err != nil:
+		errs = append(errs, fmt.Errorf("mqtt.broker: %w", err))
+	case !slices.Contains([]string{"tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss"}, u.Scheme) || u.Host == "":
+		errs = append(errs, fmt.Errorf("mqtt.broker %q must be a tcp://, ssl://, ws:// or wss:// URL", m.Broker))
+	}
+
+	prefixes := []struct{ name, value string }{
+		{"topic_prefix", m.TopicPrefix},
+		{"discovery_prefix", m.DiscoveryPrefix},
+	}
+	for _, p := range prefixes {
+		if strings.ContainsAny(p.value, "+#") || strings.HasPrefix(p.value, "/") || strings.HasSuffix(p.value, "/") {
+			errs = append(errs, fmt.Errorf("mqtt.%s %q must not contain wildcards or start or end with /", p.name, p.value))
+		}
+	}
+	if m.CAFile != "" {
+		if _, err := os.Stat(m.CAFile); err != nil {
+			errs = append(errs, fmt.Errorf("mqtt.ca_file %s not found", m.CAFile))
+		}
+	}
+	return errs
+}
+
+// checkMode verifies that an optional octal mode parses and grants the
+// owner at least the given bits
+func checkMode(s string, owner os.FileMode) error {
+	if s == "" {
+		return nil
+	}
+	mode, err := parseMode(s)
+	if err != nil {
+		return err
+	}
+	if mode&owner != owner {
+		return fmt.Errorf("%s must give the owner at least %#o", s, owner)
+	}
+	return nil
+}
+
+// checkExecutable verifies that path exists and is an executable file
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("ndsctl not found at %s", path)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return fmt.Errorf("ndsctl at %s is not an executable file", path)
+	}
+	return nil
+}
+
+// checkWritableDir verifies that dir (or, if it doesn't exist yet, its
+// parent) is a directory we can create files in
+func checkWritableDir(dir string) error {
+	target := dir
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		target = filepath.Dir(dir)
+		info, err = os.Stat(target)
+	}
+	if err != nil {
+		return fmt.Errorf("dnsmasq conf_dir %s is not accessible: %v", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("dnsmasq conf_dir %s is not a directory", target)
+	}
+
+	f, err := os.CreateTemp(target, ".parenta-check-*")
+	if err != nil {
+		return fmt.Errorf("dnsmasq conf_dir %s is not writable", target)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
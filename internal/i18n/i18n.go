@@ -0,0 +1,135 @@
+// Package i18n translates the captive portal. Each language is a JSON file
+// in locales/ embedded in the binary, so adding one is adding a file.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLang is used when no supported language is asked for, and fills in
+// keys other catalogs lack
+const DefaultLang = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// Catalog is the set of messages in one language
+type Catalog struct {
+	Lang     string
+	messages map[string]string
+}
+
+// catalogs maps language codes to their loaded catalogs
+var catalogs = loadCatalogs()
+
+// loadCatalogs parses every embedded locale file. A broken file is a build
+// mistake, so it panics at startup.
+func loadCatalogs() map[string]*Catalog {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	result := make(map[string]*Catalog, len(entries))
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", entry.Name(), err))
+		}
+		lang := strings.TrimSuffix(entry.Name(), ".json")
+		result[lang] = &Catalog{Lang: lang, messages: messages}
+	}
+	if result[DefaultLang] == nil {
+		panic("i18n: missing " + DefaultLang + " catalog")
+	}
+	return result
+}
+
+// Supported returns the language codes that have a catalog, sorted
+func Supported() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// IsSupported reports whether a language has a catalog
+func IsSupported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Get returns the catalog for a language, or the default one
+func Get(lang string) *Catalog {
+	if c, ok := catalogs[lang]; ok {
+		return c
+	}
+	return catalogs[DefaultLang]
+}
+
+// Select picks the catalog for a request: the override if it is set and
+// supported, else the best match for the Accept-Language header
+func Select(override, acceptLanguage string) *Catalog {
+	if IsSupported(override) {
+		return catalogs[override]
+	}
+	return Get(Negotiate(acceptLanguage))
+}
+
+// Negotiate returns the supported language the Accept-Language header
+// prefers most, or DefaultLang. Regional variants match their base
+// language, so "de-AT" selects "de".
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := DefaultLang, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if q > bestQ && IsSupported(base) {
+			best, bestQ = base, q
+		}
+	}
+	return best
+}
+
+// T returns the message for key, formatted with args. Keys missing from the
+// catalog fall back to the default language, then to the key itself.
+func (c *Catalog) T(key string, args ...any) string {
+	msg, ok := c.messages[key]
+	if !ok {
+		msg, ok = catalogs[DefaultLang].messages[key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Has reports whether key is a known message
+func Has(key string) bool {
+	_, ok := catalogs[DefaultLang].messages[key]
+	return ok
+}
@@ -0,0 +1,84 @@
+package i18n
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name, header, want string
+	}{
+		{"empty header", "", DefaultLang},
+		{"single language", "de", "de"},
+		{"regional variant", "de-AT", "de"},
+		{"case insensitive", "FR-ca", "fr"},
+		{"first of equals wins", "es, fr", "es"},
+		{"browser default list", "fr-FR,fr;q=0.9,en-US;q=0.8,en;q=0.7", "fr"},
+		{"higher q later", "de;q=0.5, es;q=0.8", "es"},
+		{"q with spaces", "de ; q=0.4 , fr ; q=0.6", "fr"},
+		{"unsupported preferred", "ja, zh;q=0.9, de;q=0.2", "de"},
+		{"nothing supported", "ja, zh-CN;q=0.9", DefaultLang},
+		{"wildcard", "*", DefaultLang},
+		{"q=0 refuses a language", "de;q=0, fr;q=0.1", "fr"},
+		{"only refused languages", "de;q=0", DefaultLang},
+		{"malformed q skipped", "de;q=abc, es;q=0.3", "es"},
+		{"garbage", ";;,,q=1", DefaultLang},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Negotiate(tt.header); got != tt.want {
+				t.Errorf("Negotiate(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		name, override, header, want string
+	}{
+		{"no override", "", "de-DE,de;q=0.9", "de"},
+		{"override wins", "es", "de-DE,de;q=0.9", "es"},
+		{"override without a header", "fr", "", "fr"},
+		{"unsupported override ignored", "ja", "de", "de"},
+		{"nothing set", "", "", DefaultLang},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Select(tt.override, tt.header).Lang; got != tt.want {
+				t.Errorf("Select(%q, %q) chose %q, want %q", tt.override, tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatalogFallback(t *testing.T) {
+	de := Get("de")
+	if got := de.T("portal.login"); got != "Anmelden" {
+		t.Errorf("de portal.login = %q", got)
+	}
+
+	// A catalog lacking a key falls back to the default language
+	partial := &Catalog{Lang: "xx", messages: map[string]string{}}
+	if got, want := partial.T("portal.login"), Get(DefaultLang).T("portal.login"); got != want {
+		t.Errorf("missing key gave %q, want the %s %q", got, DefaultLang, want)
+	}
+
+	if got := de.T("no.such.key"); got != "no.such.key" {
+		t.Errorf("unknown key gave %q", got)
+	}
+	if got := Get("ja"); got.Lang != DefaultLang {
+		t.Errorf("Get(ja) = %q, want %q", got.Lang, DefaultLang)
+	}
+}
+
+// TestCatalogsComplete checks that every catalog translates every key
+func TestCatalogsComplete(t *testing.T) {
+	for _, lang := range Supported() {
+		for key := range catalogs[DefaultLang].messages {
+			if _, ok := catalogs[lang].messages[key]; !ok {
+				t.Errorf("%s.json has no %q", lang, key)
+			}
+		}
+	}
+}
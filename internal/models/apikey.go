@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// APIKeyRole limits what an API key may do
+type APIKeyRole string
+
+const (
+	APIKeyRoleRead  APIKeyRole = "read"  // GET requests only
+	APIKeyRoleAdmin APIKeyRole = "admin" // Read and write
+)
+
+// APIKeyScopes are the route groups a key can be limited to
+var APIKeyScopes = []string{"children", "sessions", "schedules", "filters", "vouchers", "preauth-devices", "network", "portal", "system"}
+
+// APIKey is a long-lived credential an admin issues for automation.
+// Only the SHA-256 hash of the key is stored.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // First characters of the key, to tell keys apart
+	KeyHash    string     `json:"key_hash"`
+	UserID     string     `json:"user_id"`
+	Role       APIKeyRole `json:"role"`
+	Scopes     []string   `json:"scopes,omitempty"` // Empty means every group
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// AllowsScope reports whether the key may use a route group
+func (k *APIKey) AllowsScope(group string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	for _, s := range k.Scopes {
+		if s == group {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// MaxAuditEntries caps the audit log; the oldest entries are dropped first
+const MaxAuditEntries = 1000
+
+// Audit actions
+const (
+	AuditLoginSuccess = "login.success"
+	AuditLoginFailure = "login.failure"
+	AuditLoginLocked  = "login.locked"
+	AuditAdminUnlock  = "admin.unlock"
+	AuditLockoutClear = "lockout.clear"
+
+	AuditChildPasswordChange = "child.password_change"
+	AuditChildPasswordFailed = "child.password_change_failed"
+)
+
+// AuditEntry records a security-relevant event
+type AuditEntry struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Username  string    `json:"username,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+}
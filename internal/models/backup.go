@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// BackupStatus is the outcome of the last scheduled backup
+type BackupStatus struct {
+	LastRunAt     *time.Time `json:"last_run_at,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	LastFile      string     `json:"last_file,omitempty"`
+	LastSizeBytes int64      `json:"last_size_bytes,omitempty"`
+	LastError     string     `json:"last_error,omitempty"` // Empty if the last run succeeded
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// InBedtime reports whether t falls in the child's bedtime. A bedtime that
+// ends before it starts runs past midnight, and BedtimeDays are the days it
+// starts on, so {21:00, 07:00, Sun-Thu} covers school nights until the next
+// morning. Without days it applies every day.
+func (c *Child) InBedtime(t time.Time) bool {
+	if c.BedtimeStart == "" || c.BedtimeEnd == "" {
+		return false
+	}
+	now := t.Format("15:04")
+	today := int(t.Weekday())
+
+	if c.BedtimeStart < c.BedtimeEnd {
+		return c.bedtimeOn(today) && now >= c.BedtimeStart && now < c.BedtimeEnd
+	}
+	yesterday := (today + 6) % 7
+	return (c.bedtimeOn(today) && now >= c.BedtimeStart) || (c.bedtimeOn(yesterday) && now < c.BedtimeEnd)
+}
+
+// bedtimeOn reports whether bedtime starts on the given weekday
+func (c *Child) bedtimeOn(day int) bool {
+	if len(c.BedtimeDays) == 0 {
+		return true
+	}
+	for _, d := range c.BedtimeDays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateBedtime checks a bedtime: both times "HH:MM" and different, or
+// both empty to turn it off, and days 0 (Sunday) to 6
+func ValidateBedtime(start, end string, days []int) error {
+	if start == "" && end == "" {
+		return nil
+	}
+	if !validClock(start) {
+		return fmt.Errorf("bedtime_start %q must be HH:MM", start)
+	}
+	if !validClock(end) {
+		return fmt.Errorf("bedtime_end %q must be HH:MM", end)
+	}
+	if start == end {
+		return errors.New("bedtime_start and bedtime_end must differ")
+	}
+	for _, d := range days {
+		if d < 0 || d > 6 {
+			return fmt.Errorf("bedtime day %d must be 0-6", d)
+		}
+	}
+	return nil
+}
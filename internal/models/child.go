@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"strconv"
+	"strings"
+	"time"
+)
 
 // FilterMode defines the filtering behavior
 type FilterMode string
@@ -15,14 +19,78 @@ type Device struct {
 	MAC       string    `json:"mac"`
 	Name      string    `json:"name"`
 	FirstSeen time.Time `json:"first_seen"`
+
+	// Last addresses the device logged in from
+	IP       string    `json:"ip,omitempty"`
+	IPv6     string    `json:"ipv6,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+
+	// A private (locally administered) MAC, which the device replaces when
+	// it forgets the network
+	Randomized bool `json:"randomized,omitempty"`
+	// Waiting for a parent to approve it before it may log in
+	Pending bool `json:"pending,omitempty"`
+
+	// Browser the device first logged in with
+	Client *ClientInfo `json:"client,omitempty"`
+}
+
+// LastActive returns when the device last logged in, or when it was
+// registered if it hasn't been seen since
+func (d Device) LastActive() time.Time {
+	if d.LastSeen.IsZero() {
+		return d.FirstSeen
+	}
+	return d.LastSeen
 }
 
+// IsValidMAC reports whether mac is exactly 12 hex digits once its ':',
+// '-' and '.' separators are removed
+func IsValidMAC(mac string) bool {
+	digits := strings.NewReplacer(":", "", "-", "", ".", "").Replace(mac)
+	if len(digits) != 12 {
+		return false
+	}
+	for _, c := range digits {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// IsRandomizedMAC reports whether a MAC is locally administered, as the
+// private addresses of iOS, Android and Windows are: the second hex digit
+// is 2, 6, A or E
+func IsRandomizedMAC(mac string) bool {
+	if len(mac) < 2 {
+		return false
+	}
+	first, err := strconv.ParseUint(mac[:2], 16, 8)
+	if err != nil {
+		return false
+	}
+	return first&0x02 != 0
+}
+
+// BankTransaction records minutes credited to a child's time bank
+type BankTransaction struct {
+	Minutes   int       `json:"minutes"`
+	Reason    string    `json:"reason"`
+	GrantedBy string    `json:"granted_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MaxBankHistory caps how many bank transactions are kept per child
+const MaxBankHistory = 100
+
 // Child represents a child user profile
 type Child struct {
 	ID            string     `json:"id"`
 	Username      string     `json:"username"`
 	PasswordHash  string     `json:"password_hash"`
 	Name          string     `json:"name"`
+	Age           int        `json:"age,omitempty"` // 0 = not set
 	DailyQuotaMin int        `json:"daily_quota_min"`
 	UsedTodayMin  int        `json:"used_today_min"`
 	FilterMode    FilterMode `json:"filter_mode"`
@@ -30,37 +98,321 @@ type Child struct {
 	Devices       []Device   `json:"devices"`
 	LastResetDate string     `json:"last_reset_date"` // YYYY-MM-DD
 	IsActive      bool       `json:"is_active"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
+
+	// Time bank: earned minutes kept across days, separate from the daily quota
+	BankMinutes       int               `json:"bank_minutes"`
+	UseBankAfterQuota bool              `json:"use_bank_after_quota"`
+	BankHistory       []BankTransaction `json:"bank_history,omitempty"`
+
+	// Devices that may be online at once (0 = unlimited). Logging in on one
+	// more device ends the oldest session.
+	MaxConcurrentDevices int `json:"max_concurrent_devices"`
+
+	// Longest a session may last before the child is logged out (0 =
+	// unlimited), and the break they must take after a session ends before
+	// logging in again (0 = none)
+	MaxSessionMinutes int `json:"max_session_minutes"`
+	BreakMinutes      int `json:"break_minutes"`
+
+	// Nightly block ("HH:MM"), applied on top of any schedule. Empty times
+	// turn it off; empty days mean every day.
+	BedtimeStart string `json:"bedtime_start,omitempty"`
+	BedtimeEnd   string `json:"bedtime_end,omitempty"`
+	BedtimeDays  []int  `json:"bedtime_days,omitempty"`
+
+	// Whether the child may change their own password from the portal;
+	// nil allows it
+	AllowSelfPasswordChange *bool `json:"allow_self_password_change,omitempty"`
+
+	// Minutes charged per usage category, by day ("YYYY-MM-DD")
+	CategoryUsage map[string]map[string]int `json:"category_usage,omitempty"`
+
+	// Daily data cap (0 = unlimited) and today's upload plus download, from
+	// openNDS's traffic counters
+	DailyDataQuotaMB int     `json:"daily_data_quota_mb"`
+	UsedTodayMB      float64 `json:"used_today_mb"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// RemainingMinutes returns the remaining quota for today
+// RemainingMinutes returns the remaining quota for today, including the
+// time bank balance when the child is allowed to draw from it
 func (c *Child) RemainingMinutes() int {
-	remaining := c.DailyQuotaMin - c.UsedTodayMin
+	remaining := c.remainingDailyMinutes()
+	if c.UseBankAfterQuota {
+		remaining += c.BankMinutes
+	}
+	return remaining
+}
+
+// EffectiveQuotaMin returns today's daily quota including any holiday bonus
+func (c *Child) EffectiveQuotaMin() int {
+	return c.DailyQuotaMin + HolidayBonus()
+}
+
+// remainingDailyMinutes returns what is left of the daily quota alone
+func (c *Child) remainingDailyMinutes() int {
+	remaining := c.EffectiveQuotaMin() - c.UsedTodayMin
 	if remaining < 0 {
 		return 0
 	}
 	return remaining
 }
 
+// ChargeMinutes records usage. Minutes beyond the daily quota are drawn
+// from the time bank when UseBankAfterQuota is set.
+func (c *Child) ChargeMinutes(minutes int) {
+	dailyLeft := c.remainingDailyMinutes()
+	c.UsedTodayMin += minutes
+
+	if c.UseBankAfterQuota && minutes > dailyLeft {
+		draw := minutes - dailyLeft
+		if draw > c.BankMinutes {
+			draw = c.BankMinutes
+		}
+		c.BankMinutes -= draw
+	}
+}
+
+// MaxOverQuotaMin is how far past the daily quota a quota adjustment can
+// take the time used (8 hours)
+const MaxOverQuotaMin = 480
+
+// AdjustQuota gives the child minutes more time today, or takes time away
+// when minutes is negative, by changing the time used
+func (c *Child) AdjustQuota(minutes int) {
+	c.UsedTodayMin -= minutes
+	if c.UsedTodayMin < 0 {
+		c.UsedTodayMin = 0
+	}
+	if c.UsedTodayMin > c.DailyQuotaMin+MaxOverQuotaMin {
+		c.UsedTodayMin = c.DailyQuotaMin + MaxOverQuotaMin
+	}
+}
+
+// MaxSessionLimitMinutes bounds MaxSessionMinutes and BreakMinutes (a day)
+const MaxSessionLimitMinutes = 24 * 60
+
+// SessionTimeoutMin returns how long a new session may last: the time
+// remaining today, cut to MaxSessionMinutes
+func (c *Child) SessionTimeoutMin() int {
+	minutes := c.RemainingMinutes()
+	if c.MaxSessionMinutes > 0 && c.MaxSessionMinutes < minutes {
+		minutes = c.MaxSessionMinutes
+	}
+	return minutes
+}
+
+// BreakEndsAt returns when the child's break after their last session is
+// over, given their sessions. It is the zero time if no break is due,
+// including while a session is still active.
+func (c *Child) BreakEndsAt(sessions []*Session) time.Time {
+	if c.BreakMinutes <= 0 {
+		return time.Time{}
+	}
+	var last time.Time
+	for _, session := range sessions {
+		if session.IsActive {
+			return time.Time{}
+		}
+		if session.EndedAt.After(last) {
+			last = session.EndedAt
+		}
+	}
+	if last.IsZero() {
+		return time.Time{}
+	}
+	return last.Add(time.Duration(c.BreakMinutes) * time.Minute)
+}
+
+// BytesPerMB converts openNDS's byte counters to the MB of data quotas
+const BytesPerMB = 1 << 20
+
+// MaxDailyDataQuotaMB bounds the daily data cap (1 TB)
+const MaxDailyDataQuotaMB = 1 << 20
+
+// AddDataBytes records data used today
+func (c *Child) AddDataBytes(bytes int64) {
+	c.UsedTodayMB += float64(bytes) / BytesPerMB
+}
+
+// DataExceeded reports whether the child has a data cap and has used it up
+func (c *Child) DataExceeded() bool {
+	return c.DailyDataQuotaMB > 0 && c.UsedTodayMB >= float64(c.DailyDataQuotaMB)
+}
+
+// Usage categories for time outside a labelled schedule block
+const (
+	UsageCategoryStudy   = "study"
+	UsageCategoryGeneral = "general"
+)
+
+// MaxCategoryUsageDays is how many days of category usage are kept
+const MaxCategoryUsageDays = 90
+
+// UsageCategory names what the child's time at t counts as: the category
+// label of the schedule block in effect, else "study" in study mode, else
+// "general". It is an approximation from the filter context, not from the
+// sites actually visited.
+func (c *Child) UsageCategory(schedule *Schedule, t time.Time) string {
+	if schedule != nil {
+		if category := schedule.CategoryAt(t); category != "" {
+			return category
+		}
+		if schedule.FilterModeAt(t) == FilterModeStudy {
+			return UsageCategoryStudy
+		}
+	}
+	if c.FilterMode == FilterModeStudy {
+		return UsageCategoryStudy
+	}
+	return UsageCategoryGeneral
+}
+
+// AddCategoryMinutes records minutes used in a category on t's day and
+// drops days older than MaxCategoryUsageDays
+func (c *Child) AddCategoryMinutes(t time.Time, category string, minutes int) {
+	if c.CategoryUsage == nil {
+		c.CategoryUsage = make(map[string]map[string]int)
+	}
+	date := t.Format(DateLayout)
+	if c.CategoryUsage[date] == nil {
+		c.CategoryUsage[date] = make(map[string]int)
+	}
+	c.CategoryUsage[date][category] += minutes
+
+	oldest := t.AddDate(0, 0, -(MaxCategoryUsageDays - 1)).Format(DateLayout)
+	for d := range c.CategoryUsage {
+		if d < oldest {
+			delete(c.CategoryUsage, d)
+		}
+	}
+}
+
+// CategoryUsageFor returns the minutes per category for each of the days
+// days up to and including t's day, oldest first
+func (c *Child) CategoryUsageFor(t time.Time, days int) []CategoryUsage {
+	usage := make([]CategoryUsage, days)
+	for i := range usage {
+		date := t.AddDate(0, 0, i-(days-1)).Format(DateLayout)
+		usage[i] = CategoryUsage{Date: date, Minutes: make(map[string]int)}
+		for category, minutes := range c.CategoryUsage[date] {
+			usage[i].Minutes[category] = minutes
+			usage[i].TotalMin += minutes
+		}
+	}
+	return usage
+}
+
+// GrantBankMinutes credits the time bank and records the reason
+func (c *Child) GrantBankMinutes(minutes int, reason, grantedBy string) {
+	c.BankMinutes += minutes
+	c.BankHistory = append(c.BankHistory, BankTransaction{
+		Minutes:   minutes,
+		Reason:    reason,
+		GrantedBy: grantedBy,
+		CreatedAt: time.Now(),
+	})
+	if len(c.BankHistory) > MaxBankHistory {
+		c.BankHistory = c.BankHistory[len(c.BankHistory)-MaxBankHistory:]
+	}
+}
+
 // HasDevice checks if a MAC is registered to this child
 func (c *Child) HasDevice(mac string) bool {
+	return c.Device(mac) != nil
+}
+
+// Device returns the registered device with this MAC, or nil
+func (c *Child) Device(mac string) *Device {
+	for i := range c.Devices {
+		if c.Devices[i].MAC == mac {
+			return &c.Devices[i]
+		}
+	}
+	return nil
+}
+
+// RandomizedDevices counts the child's devices with private MACs
+func (c *Child) RandomizedDevices() int {
+	n := 0
 	for _, d := range c.Devices {
-		if d.MAC == mac {
-			return true
+		if d.Randomized {
+			n++
+		}
+	}
+	return n
+}
+
+// SeeDevice records the addresses a registered device logged in from.
+// Empty values keep the known address. Reports whether anything changed.
+func (c *Child) SeeDevice(mac, ip, ipv6 string) bool {
+	for i := range c.Devices {
+		d := &c.Devices[i]
+		if d.MAC != mac {
+			continue
+		}
+		changed := false
+		// Last seen is kept to the hour so logins don't all rewrite the file
+		if now := time.Now(); now.Sub(d.LastSeen) >= time.Hour {
+			d.LastSeen = now
+			changed = true
+		}
+		if ip != "" && ip != d.IP {
+			d.IP = ip
+			changed = true
 		}
+		if ipv6 != "" && ipv6 != d.IPv6 {
+			d.IPv6 = ipv6
+			changed = true
+		}
+		return changed
 	}
 	return false
 }
 
+// CanChangeOwnPassword reports whether the child may change their own
+// password
+func (c *Child) CanChangeOwnPassword() bool {
+	return c.AllowSelfPasswordChange == nil || *c.AllowSelfPasswordChange
+}
+
+// MaxChildAge is the highest age a child profile may have
+const MaxChildAge = 25
+
+// QuotaPreset is the recommended setup for a child of some age
+type QuotaPreset struct {
+	DailyQuotaMin         int        `json:"daily_quota_min"`
+	WeekendQuotaMin       int        `json:"weekend_quota_min"`
+	MaxDevices            int        `json:"max_devices"`
+	RecommendedFilterMode FilterMode `json:"recommended_filter_mode"`
+}
+
+// QuotaPresetForAge returns the recommended setup for an age: 30 minutes a
+// day up to 6, 60 up to 9, 120 up to 12 and 180 from 13
+func QuotaPresetForAge(age int) QuotaPreset {
+	switch {
+	case age <= 6:
+		return QuotaPreset{DailyQuotaMin: 30, WeekendQuotaMin: 60, MaxDevices: 1, RecommendedFilterMode: FilterModeStudy}
+	case age <= 9:
+		return QuotaPreset{DailyQuotaMin: 60, WeekendQuotaMin: 90, MaxDevices: 1, RecommendedFilterMode: FilterModeNormal}
+	case age <= 12:
+		return QuotaPreset{DailyQuotaMin: 120, WeekendQuotaMin: 180, MaxDevices: 2, RecommendedFilterMode: FilterModeNormal}
+	default:
+		return QuotaPreset{DailyQuotaMin: 180, WeekendQuotaMin: 240, MaxDevices: 3, RecommendedFilterMode: FilterModeNormal}
+	}
+}
+
 // AddDevice adds a new device to the child's device list
 func (c *Child) AddDevice(mac, name string) {
 	if c.HasDevice(mac) {
 		return
 	}
 	c.Devices = append(c.Devices, Device{
-		MAC:       mac,
-		Name:      name,
-		FirstSeen: time.Now(),
+		MAC:        mac,
+		Name:       name,
+		FirstSeen:  time.Now(),
+		Randomized: IsRandomizedMAC(mac),
 	})
 }
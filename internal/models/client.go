@@ -0,0 +1,14 @@
+package models
+
+// MaxUserAgentLen caps the stored User-Agent string
+const MaxUserAgentLen = 256
+
+// ClientInfo describes the browser a device logged in with, as classified
+// from its User-Agent
+type ClientInfo struct {
+	UserAgent  string `json:"user_agent,omitempty"`
+	OS         string `json:"os,omitempty"`          // e.g. "iOS", "Android", "Windows"
+	Browser    string `json:"browser,omitempty"`     // e.g. "Safari", "Chrome"
+	DeviceType string `json:"device_type,omitempty"` // phone, tablet, desktop, tv or console
+	Label      string `json:"label,omitempty"`       // e.g. "iPad – Safari"
+}
@@ -17,4 +17,24 @@ type FilterRule struct {
 	RuleType  RuleType  `json:"rule_type"` // "whitelist" or "blacklist"
 	Category  string    `json:"category"`  // "social", "games", "education", etc.
 	CreatedAt time.Time `json:"created_at"`
+
+	// Optional window for temporary rules (e.g. exam week); nil means unbounded
+	EffectiveFrom  *time.Time `json:"effective_from,omitempty"`
+	EffectiveUntil *time.Time `json:"effective_until,omitempty"`
+}
+
+// IsEffectiveAt returns true if the rule applies at time t
+func (f *FilterRule) IsEffectiveAt(t time.Time) bool {
+	if f.EffectiveFrom != nil && t.Before(*f.EffectiveFrom) {
+		return false
+	}
+	if f.EffectiveUntil != nil && !t.Before(*f.EffectiveUntil) {
+		return false
+	}
+	return true
+}
+
+// IsTimed returns true if the rule has an effective window
+func (f *FilterRule) IsTimed() bool {
+	return f.EffectiveFrom != nil || f.EffectiveUntil != nil
 }
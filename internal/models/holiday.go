@@ -0,0 +1,31 @@
+package models
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// HolidayMode temporarily raises every child's daily quota
+type HolidayMode struct {
+	Enabled      bool      `json:"enabled"`
+	ExtraMinutes int       `json:"extra_minutes"`
+	EnabledBy    string    `json:"enabled_by,omitempty"`
+	EnabledAt    time.Time `json:"enabled_at,omitempty"`
+}
+
+// holidayBonus holds the active bonus so quota calculations don't need storage access
+var holidayBonus atomic.Int64
+
+// HolidayBonus returns the extra daily minutes granted by holiday mode (0 when disabled)
+func HolidayBonus() int {
+	return int(holidayBonus.Load())
+}
+
+// ApplyHolidayMode publishes the holiday mode state to quota calculations
+func ApplyHolidayMode(h HolidayMode) {
+	if h.Enabled && h.ExtraMinutes > 0 {
+		holidayBonus.Store(int64(h.ExtraMinutes))
+		return
+	}
+	holidayBonus.Store(0)
+}
@@ -0,0 +1,92 @@
+package models
+
+// NotificationEvent is something parents can be told about
+type NotificationEvent string
+
+const (
+	EventQuotaExceeded NotificationEvent = "quota_exceeded" // A child ran out of time and was logged out
+	EventQuotaWarning  NotificationEvent = "quota_warning"  // A child's time is nearly up
+	EventDailySummary  NotificationEvent = "daily_summary"  // Each child's usage for the day
+	EventNewDevice     NotificationEvent = "new_device"     // A child logged in on a device not seen before
+	EventFailedLogins  NotificationEvent = "failed_logins"  // Repeated failed portal logins from one device
+	EventHealthChanged NotificationEvent = "health_changed" // System health became better or worse
+	EventWeeklyReport  NotificationEvent = "weekly_report"  // Each child's usage for the week, sent on Sundays
+	EventTest          NotificationEvent = "test"           // Sent from the dashboard to check a channel
+
+	// Only sent to webhook subscriptions
+	EventSessionStart  NotificationEvent = "session_start"  // A child or guest logged in on a device
+	EventSessionEnd    NotificationEvent = "session_end"    // A session ended, with the reason
+	EventFilterChanged NotificationEvent = "filter_changed" // Filter rules were added or removed
+)
+
+// NotificationEvents are the events that can be routed to channels
+var NotificationEvents = []NotificationEvent{
+	EventQuotaExceeded, EventQuotaWarning, EventDailySummary,
+	EventNewDevice, EventFailedLogins, EventHealthChanged, EventWeeklyReport,
+}
+
+// WebhookEvents are the events webhook subscriptions can ask for
+var WebhookEvents = []NotificationEvent{
+	EventSessionStart, EventSessionEnd, EventQuotaExceeded, EventQuotaWarning,
+	EventNewDevice, EventFilterChanged, EventHealthChanged, EventFailedLogins,
+	EventDailySummary, EventWeeklyReport,
+}
+
+// Notification channel names
+const (
+	ChannelEmail    = "email"
+	ChannelTelegram = "telegram"
+	ChannelWebhook  = "webhook"
+)
+
+// NotificationChannels are the channels notifications can be sent through
+var NotificationChannels = []string{ChannelEmail, ChannelTelegram, ChannelWebhook}
+
+// EmailSettings configure sending notifications by SMTP
+type EmailSettings struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"` // 465 uses TLS from the start; others use STARTTLS when offered
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"` // Secret
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// Configured reports whether email has enough settings to send
+func (e EmailSettings) Configured() bool {
+	return e.Host != "" && e.From != "" && len(e.To) > 0
+}
+
+// TelegramSettings configure sending notifications through a Telegram bot
+type TelegramSettings struct {
+	BotToken string `json:"bot_token,omitempty"` // Secret
+	ChatID   string `json:"chat_id"`
+}
+
+// Configured reports whether Telegram has enough settings to send
+func (t TelegramSettings) Configured() bool {
+	return t.BotToken != "" && t.ChatID != ""
+}
+
+// WebhookSettings configure posting notifications as JSON to a URL
+type WebhookSettings struct {
+	URL string `json:"url,omitempty"` // Secret, since chat webhook URLs embed a token
+}
+
+// Configured reports whether the webhook has a URL
+func (w WebhookSettings) Configured() bool {
+	return w.URL != ""
+}
+
+// NotificationSettings choose which events go to which channels
+type NotificationSettings struct {
+	Email    EmailSettings    `json:"email"`
+	Telegram TelegramSettings `json:"telegram"`
+	Webhook  WebhookSettings  `json:"webhook"`
+
+	// Channels each event is sent to; events not listed aren't sent
+	Routes map[NotificationEvent][]string `json:"routes"`
+
+	SummaryTime    string `json:"summary_time"`    // "HH:MM" to send the daily summary and, on Sundays, the weekly report
+	WarningMinutes int    `json:"warning_minutes"` // Remaining minutes that trigger quota_warning
+}
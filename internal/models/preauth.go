@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// PreAuthDevice is a device let online without the captive portal, for
+// smart TVs and other devices that can't show a login page
+type PreAuthDevice struct {
+	MAC     string    `json:"mac"`
+	Name    string    `json:"name"`
+	AddedBy string    `json:"added_by"`
+	AddedAt time.Time `json:"added_at"`
+}
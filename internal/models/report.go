@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Report periods
+const (
+	ReportDaily  = "daily"
+	ReportWeekly = "weekly"
+)
+
+// MaxReportSessions is how many of a child's longest sessions a report lists
+const MaxReportSessions = 5
+
+// UsageReport summarises each child's internet use over a day or an ISO
+// week
+type UsageReport struct {
+	Period      string        `json:"period"` // "daily" or "weekly"
+	Label       string        `json:"label"`  // "2024-03-18" or "2024-W12"
+	From        string        `json:"from"`   // First day, "YYYY-MM-DD"
+	To          string        `json:"to"`     // Last day, "YYYY-MM-DD"
+	GeneratedAt time.Time     `json:"generated_at"`
+	Children    []ChildReport `json:"children"`
+}
+
+// ChildReport is one child's part of a report. Totals and averages only
+// count tracked days.
+type ChildReport struct {
+	ChildID        string          `json:"child_id"`
+	Name           string          `json:"name"`
+	Days           []ReportDay     `json:"days"`
+	TrackedDays    int             `json:"tracked_days"`
+	TotalMinutes   int             `json:"total_minutes"`
+	AverageMinutes int             `json:"average_minutes"`
+	DaysOverQuota  int             `json:"days_over_quota"`
+	TopSessions    []ReportSession `json:"top_sessions"`
+}
+
+// ReportDay is a child's use on one day. Days before the child was created
+// or still to come aren't tracked and have no figures.
+type ReportDay struct {
+	Date          string `json:"date"` // "YYYY-MM-DD"
+	Tracked       bool   `json:"tracked"`
+	MinutesUsed   int    `json:"minutes_used"`   // Charged against the quota
+	OnlineMinutes int    `json:"online_minutes"` // Time with a session open
+	SessionCount  int    `json:"session_count"`
+	QuotaMin      int    `json:"quota_min"` // The child's current daily quota
+	OverQuota     bool   `json:"over_quota"`
+}
+
+// ReportSession is one of a child's longest sessions in a report
+type ReportSession struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	Minutes   int       `json:"minutes"`
+	MAC       string    `json:"mac"`
+	Device    string    `json:"device,omitempty"` // The device's name, if it has one
+	EndReason string    `json:"end_reason,omitempty"`
+}
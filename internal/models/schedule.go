@@ -1,55 +1,240 @@
 package models
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 )
 
 // TimeBlock represents a time period within a schedule
 type TimeBlock struct {
-	DayOfWeek  int        `json:"day_of_week"` // 0=Sunday, 6=Saturday
-	StartTime  string     `json:"start_time"`  // "HH:MM" format
-	EndTime    string     `json:"end_time"`    // "HH:MM" format
-	FilterMode FilterMode `json:"filter_mode"` // Override mode for this block
+	DayOfWeek  int        `json:"day_of_week"`        // 0=Sunday, 6=Saturday
+	StartTime  string     `json:"start_time"`         // "HH:MM" format
+	EndTime    string     `json:"end_time"`           // "HH:MM" format
+	FilterMode FilterMode `json:"filter_mode"`        // Override mode for this block
+	Category   string     `json:"category,omitempty"` // Label for usage reports, e.g. "homework"
+}
+
+// MaxBlockCategoryLen caps the length of a time block's category label
+const MaxBlockCategoryLen = 32
+
+// ExceptionMode defines how a date exception overrides the weekly blocks
+type ExceptionMode string
+
+const (
+	ExceptionAllowAll ExceptionMode = "allow_all" // Allowed all day
+	ExceptionBlockAll ExceptionMode = "block_all" // Blocked all day
+	ExceptionCustom   ExceptionMode = "custom"    // TimeBlocks replace the weekday's blocks
+)
+
+// DateLayout is the format of exception dates
+const DateLayout = "2006-01-02"
+
+// DateException overrides the weekly schedule on specific dates
+// (birthdays, school breaks)
+type DateException struct {
+	Date       string        `json:"date"`               // "YYYY-MM-DD"
+	EndDate    string        `json:"end_date,omitempty"` // Inclusive; empty for a single day
+	Mode       ExceptionMode `json:"mode"`
+	TimeBlocks []TimeBlock   `json:"time_blocks,omitempty"` // For custom mode; day_of_week is ignored
+	FilterMode FilterMode    `json:"filter_mode,omitempty"` // For allow_all mode
+	Note       string        `json:"note,omitempty"`
+}
+
+// lastDate returns the inclusive end of the exception
+func (e *DateException) lastDate() string {
+	if e.EndDate != "" {
+		return e.EndDate
+	}
+	return e.Date
+}
+
+// Covers returns true if the exception applies on the given "YYYY-MM-DD" date
+func (e *DateException) Covers(date string) bool {
+	return date >= e.Date && date <= e.lastDate()
 }
 
 // Schedule represents a weekly time schedule
 type Schedule struct {
-	ID         string      `json:"id"`
-	Name       string      `json:"name"`
-	TimeBlocks []TimeBlock `json:"time_blocks"`
-	IsDefault  bool        `json:"is_default"`
-	CreatedAt  time.Time   `json:"created_at"`
-	UpdatedAt  time.Time   `json:"updated_at"`
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	TimeBlocks []TimeBlock     `json:"time_blocks"`
+	Exceptions []DateException `json:"exceptions,omitempty"`
+	IsDefault  bool            `json:"is_default"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
 }
 
-// IsAllowedNow checks if current time falls within any allowed block
-func (s *Schedule) IsAllowedNow() bool {
-	now := time.Now()
-	dayOfWeek := int(now.Weekday())
-	currentTime := now.Format("15:04")
+// ExceptionFor returns the exception covering t's date, or nil
+func (s *Schedule) ExceptionFor(t time.Time) *DateException {
+	date := t.Format(DateLayout)
+	for i := range s.Exceptions {
+		if s.Exceptions[i].Covers(date) {
+			return &s.Exceptions[i]
+		}
+	}
+	return nil
+}
+
+// blockAt returns the block in effect at t, consulting date exceptions before
+// the weekly blocks. An allow_all exception acts as a block covering the
+// day. allowed is false if no block matches.
+func (s *Schedule) blockAt(t time.Time) (block TimeBlock, allowed bool) {
+	currentTime := t.Format("15:04")
+	blocked := TimeBlock{FilterMode: FilterModeNormal}
+
+	if ex := s.ExceptionFor(t); ex != nil {
+		switch ex.Mode {
+		case ExceptionAllowAll:
+			if ex.FilterMode != "" {
+				return TimeBlock{FilterMode: ex.FilterMode}, true
+			}
+			return TimeBlock{FilterMode: FilterModeNormal}, true
+		case ExceptionBlockAll:
+			return blocked, false
+		default:
+			for _, block := range ex.TimeBlocks {
+				if currentTime >= block.StartTime && currentTime <= block.EndTime {
+					return block, true
+				}
+			}
+			return blocked, false
+		}
+	}
 
+	dayOfWeek := int(t.Weekday())
 	for _, block := range s.TimeBlocks {
 		if block.DayOfWeek == dayOfWeek {
 			if currentTime >= block.StartTime && currentTime <= block.EndTime {
-				return true
+				return block, true
 			}
 		}
 	}
-	return false
+	return blocked, false
+}
+
+// IsAllowedAt checks if t falls within any allowed block
+func (s *Schedule) IsAllowedAt(t time.Time) bool {
+	_, allowed := s.blockAt(t)
+	return allowed
+}
+
+// CategoryAt returns the category label of the block in effect at t, or
+// "" if it has none
+func (s *Schedule) CategoryAt(t time.Time) string {
+	block, _ := s.blockAt(t)
+	return block.Category
+}
+
+// IsAllowedNow checks if current time falls within any allowed block
+func (s *Schedule) IsAllowedNow() bool {
+	return s.IsAllowedAt(time.Now())
+}
+
+// NextChange returns the next minute after t at which access switches
+// between allowed and blocked, looking up to a week ahead
+func (s *Schedule) NextChange(t time.Time) (time.Time, bool) {
+	allowed := s.IsAllowedAt(t)
+	next := t.Truncate(time.Minute)
+	for i := 0; i < 7*24*60; i++ {
+		next = next.Add(time.Minute)
+		if s.IsAllowedAt(next) != allowed {
+			return next, true
+		}
+	}
+	return time.Time{}, false
 }
 
 // GetCurrentFilterMode returns the filter mode for the current time block
 func (s *Schedule) GetCurrentFilterMode() FilterMode {
-	now := time.Now()
-	dayOfWeek := int(now.Weekday())
-	currentTime := now.Format("15:04")
+	return s.FilterModeAt(time.Now())
+}
 
-	for _, block := range s.TimeBlocks {
-		if block.DayOfWeek == dayOfWeek {
-			if currentTime >= block.StartTime && currentTime <= block.EndTime {
-				return block.FilterMode
+// FilterModeAt returns the filter mode for the time block in effect at t
+func (s *Schedule) FilterModeAt(t time.Time) FilterMode {
+	block, _ := s.blockAt(t)
+	return block.FilterMode // Default to normal if no block matches
+}
+
+// ValidateExceptions checks exception dates, modes and time blocks, and
+// rejects exceptions whose date ranges overlap
+func (s *Schedule) ValidateExceptions() error {
+	for i, ex := range s.Exceptions {
+		if _, err := time.Parse(DateLayout, ex.Date); err != nil {
+			return fmt.Errorf("exception %d: date %q must be YYYY-MM-DD", i, ex.Date)
+		}
+		if ex.EndDate != "" {
+			if _, err := time.Parse(DateLayout, ex.EndDate); err != nil {
+				return fmt.Errorf("exception %d: end_date %q must be YYYY-MM-DD", i, ex.EndDate)
+			}
+			if ex.EndDate < ex.Date {
+				return fmt.Errorf("exception %d: end_date is before date", i)
+			}
+		}
+
+		switch ex.Mode {
+		case ExceptionAllowAll, ExceptionBlockAll:
+		case ExceptionCustom:
+			if len(ex.TimeBlocks) == 0 {
+				return fmt.Errorf("exception %d: custom mode needs time_blocks", i)
 			}
+			for _, b := range ex.TimeBlocks {
+				if !validClock(b.StartTime) || !validClock(b.EndTime) || b.StartTime > b.EndTime {
+					return fmt.Errorf("exception %d: invalid time block %s-%s", i, b.StartTime, b.EndTime)
+				}
+				if len(b.Category) > MaxBlockCategoryLen {
+					return fmt.Errorf("exception %d: category must be at most %d characters", i, MaxBlockCategoryLen)
+				}
+			}
+		default:
+			return fmt.Errorf("exception %d: mode must be allow_all, block_all or custom", i)
+		}
+	}
+
+	// Sort a copy by start date; any overlap shows up between neighbours
+	sorted := make([]DateException, len(s.Exceptions))
+	copy(sorted, s.Exceptions)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Date < sorted[j].Date })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Date <= sorted[i-1].lastDate() {
+			return fmt.Errorf("exceptions for %s and %s overlap", sorted[i-1].Date, sorted[i].Date)
 		}
 	}
-	return FilterModeNormal // Default to normal if no block matches
+
+	return nil
+}
+
+// ValidateTimeBlock checks that a block's times are "HH:MM" with the start
+// before the end, and that its day is 0-6. The times are rewritten
+// zero-padded ("9:00" becomes "09:00") so they compare correctly as strings.
+func ValidateTimeBlock(tb *TimeBlock) error {
+	if tb.DayOfWeek < 0 || tb.DayOfWeek > 6 {
+		return fmt.Errorf("day_of_week %d must be 0-6", tb.DayOfWeek)
+	}
+	start, err := time.Parse("15:04", tb.StartTime)
+	if err != nil {
+		return fmt.Errorf("start_time %q must be HH:MM", tb.StartTime)
+	}
+	end, err := time.Parse("15:04", tb.EndTime)
+	if err != nil {
+		return fmt.Errorf("end_time %q must be HH:MM", tb.EndTime)
+	}
+	if !start.Before(end) {
+		return fmt.Errorf("start_time %s must be before end_time %s", tb.StartTime, tb.EndTime)
+	}
+	tb.Category = strings.ToLower(strings.TrimSpace(tb.Category))
+	if len(tb.Category) > MaxBlockCategoryLen {
+		return fmt.Errorf("category must be at most %d characters", MaxBlockCategoryLen)
+	}
+
+	tb.StartTime = start.Format("15:04")
+	tb.EndTime = end.Format("15:04")
+	return nil
+}
+
+// validClock checks an "HH:MM" time
+func validClock(s string) bool {
+	_, err := time.Parse("15:04", s)
+	return err == nil && len(s) == 5
 }
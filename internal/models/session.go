@@ -9,13 +9,89 @@ type Session struct {
 	ChildName    string    `json:"child_name"`
 	MAC          string    `json:"mac"`
 	IP           string    `json:"ip"`
+	IPv6         string    `json:"ipv6,omitempty"` // Seen when the client also talks IPv6
 	StartedAt    time.Time `json:"started_at"`
 	LastTickAt   time.Time `json:"last_tick_at"`
+	EndedAt      time.Time `json:"ended_at,omitempty"`
+	EndReason    string    `json:"end_reason,omitempty"` // e.g. "quota_exceeded", "kicked"
 	IsActive     bool      `json:"is_active"`
 	SessionToken string    `json:"session_token,omitempty"` // OpenNDS token
+	DataBytes    int64     `json:"data_bytes,omitempty"`    // Upload plus download openNDS last reported
+
+	// Set for guest sessions started with a voucher; ChildID is then empty
+	VoucherID string `json:"voucher_id,omitempty"`
+
+	// openNDS gateway the client logged in through; its ndsctl controls the session
+	GatewayIP   string `json:"gateway_ip,omitempty"`
+	GatewayName string `json:"gateway_name,omitempty"`
+	GatewayHash string `json:"gateway_hash,omitempty"`
+
+	// Browser the session was started from
+	Client *ClientInfo `json:"client,omitempty"`
+}
+
+// SetGateway records the gateway the client last logged in through.
+// Reports whether anything changed.
+func (s *Session) SetGateway(ip, name, hash string) bool {
+	if s.GatewayIP == ip && s.GatewayName == name && s.GatewayHash == hash {
+		return false
+	}
+	s.GatewayIP, s.GatewayName, s.GatewayHash = ip, name, hash
+	return true
+}
+
+// SetAddresses records the client's latest IPv4 and IPv6 addresses. Empty
+// values keep the known address. Reports whether anything changed.
+func (s *Session) SetAddresses(ip, ipv6 string) bool {
+	changed := false
+	if ip != "" && ip != s.IP {
+		s.IP = ip
+		changed = true
+	}
+	if ipv6 != "" && ipv6 != s.IPv6 {
+		s.IPv6 = ipv6
+		changed = true
+	}
+	return changed
 }
 
-// DurationMinutes returns how long this session has been active
+// End marks the session inactive as of now, recording why
+func (s *Session) End(reason string) {
+	s.IsActive = false
+	s.EndedAt = time.Now()
+	s.EndReason = reason
+}
+
+// EndTime returns when the session ended, or now if it is still active.
+// Sessions ended before EndedAt was recorded fall back to their last tick.
+func (s *Session) EndTime() time.Time {
+	switch {
+	case !s.EndedAt.IsZero():
+		return s.EndedAt
+	case s.IsActive:
+		return time.Now()
+	case !s.LastTickAt.IsZero():
+		return s.LastTickAt
+	default:
+		return s.StartedAt
+	}
+}
+
+// DurationMinutes returns how long this session has been (or was) active
 func (s *Session) DurationMinutes() int {
-	return int(time.Since(s.StartedAt).Minutes())
+	return int(s.EndTime().Sub(s.StartedAt).Minutes())
+}
+
+// DailyUsage is a child's internet use on one day
+type DailyUsage struct {
+	Date         string `json:"date"` // "YYYY-MM-DD"
+	MinutesUsed  int    `json:"minutes_used"`
+	SessionCount int    `json:"session_count"`
+}
+
+// CategoryUsage is a child's internet use on one day split by category
+type CategoryUsage struct {
+	Date     string         `json:"date"` // "YYYY-MM-DD"
+	Minutes  map[string]int `json:"minutes"`
+	TotalMin int            `json:"total_min"`
 }
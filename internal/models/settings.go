@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// PasswordPolicy describes what a password must satisfy
+type PasswordPolicy struct {
+	MinLength     int  `json:"min_length"`
+	RequireUpper  bool `json:"require_upper"`
+	RequireLower  bool `json:"require_lower"`
+	RequireDigit  bool `json:"require_digit"`
+	RequireSymbol bool `json:"require_symbol"`
+	RejectCommon  bool `json:"reject_common"` // Refuse well-known passwords
+}
+
+// PasswordPolicies holds separate policies for parents and children, so
+// young kids can have simple passwords without weakening admin accounts
+type PasswordPolicies struct {
+	Admin PasswordPolicy `json:"admin"`
+	Child PasswordPolicy `json:"child"`
+}
+
+// PortalSettings brand the captive portal and the welcome page
+type PortalSettings struct {
+	Title       string   `json:"title"`
+	Message     string   `json:"message"`      // Shown above the login form
+	AccentColor string   `json:"accent_color"` // "#rrggbb"; empty keeps the theme's
+	Locale      string   `json:"locale"`       // Empty follows the browser's Accept-Language
+	HouseRules  []string `json:"house_rules"`  // nil falls back to portal.house_rules in the config
+
+	// Logo file in the data directory, if one was uploaded
+	Logo          string     `json:"logo,omitempty"`
+	LogoUpdatedAt *time.Time `json:"logo_updated_at,omitempty"`
+}
+
+// DevicePolicy controls how devices are registered at login
+type DevicePolicy struct {
+	// New devices with a private MAC wait for a parent's approval instead
+	// of being registered automatically
+	ApproveRandomized bool `json:"approve_randomized"`
+}
+
+// Settings are runtime options changed from the dashboard, as opposed to
+// the config file
+type Settings struct {
+	PasswordPolicy PasswordPolicies `json:"password_policy"`
+	Portal         PortalSettings   `json:"portal"`
+	DevicePolicy   DevicePolicy     `json:"device_policy"`
+
+	Notifications NotificationSettings `json:"notifications"`
+}
+
+// DefaultSettings returns the settings used until an admin changes them
+func DefaultSettings() Settings {
+	return Settings{
+		PasswordPolicy: PasswordPolicies{
+			Admin: PasswordPolicy{MinLength: 8, RejectCommon: true},
+			Child: PasswordPolicy{MinLength: 4},
+		},
+		Portal: PortalSettings{
+			Title: "Parenta",
+		},
+		Notifications: NotificationSettings{
+			SummaryTime:    "21:00",
+			WarningMinutes: 10,
+		},
+	}
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// RefreshToken is a server-side record for a long-lived refresh token.
+// Only the SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID         string     `json:"id"`
+	TokenHash  string     `json:"token_hash"`
+	UserID     string     `json:"user_id"`
+	SessionID  string     `json:"session_id,omitempty"` // AdminSession the token belongs to
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy string     `json:"replaced_by,omitempty"` // ID of the token issued on rotation
+}
+
+// IsValid returns true if the token is neither revoked nor expired
+func (t *RefreshToken) IsValid() bool {
+	return t.RevokedAt == nil && time.Now().Before(t.ExpiresAt)
+}
+
+// AdminSession is one sign-in of an admin on a device. It lasts across
+// token refreshes until it expires or is revoked.
+type AdminSession struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// IsActive returns true if the session is neither revoked nor expired
+func (s *AdminSession) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
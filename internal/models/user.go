@@ -6,8 +6,9 @@ import "time"
 type UserRole string
 
 const (
-	RoleSuper UserRole = "super" // Can manage other admins
-	RoleAdmin UserRole = "admin" // Regular admin
+	RoleSuper  UserRole = "super"  // Can manage other admins
+	RoleAdmin  UserRole = "admin"  // Regular admin
+	RoleViewer UserRole = "viewer" // Can look but not change anything
 )
 
 // User represents the parent/admin user
@@ -20,6 +21,15 @@ type User struct {
 	ForcePasswordChange bool      `json:"force_password_change"`
 	CreatedAt           time.Time `json:"created_at"`
 	UpdatedAt           time.Time `json:"updated_at"`
+
+	// Login lockout state
+	FailedLogins int       `json:"failed_logins,omitempty"`
+	LockedUntil  time.Time `json:"locked_until,omitempty"`
+}
+
+// IsLocked returns true if the account is locked out after failed logins
+func (u *User) IsLocked() bool {
+	return time.Now().Before(u.LockedUntil)
 }
 
 // IsSuper returns true if the user has super admin privileges
@@ -27,6 +37,11 @@ func (u *User) IsSuper() bool {
 	return u.Role == RoleSuper
 }
 
+// CanWrite returns false for viewers, who have read-only access
+func (u *User) CanWrite() bool {
+	return u.Role != RoleViewer
+}
+
 // GetDisplayName returns DisplayName if set, otherwise Username
 func (u *User) GetDisplayName() string {
 	if u.DisplayName != "" {
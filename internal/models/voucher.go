@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Voucher is a guest access code for visitors without a child account.
+// Each use lets one device online for DurationMin from login.
+type Voucher struct {
+	ID           string     `json:"id"`
+	Code         string     `json:"code"`
+	DurationMin  int        `json:"duration_min"`
+	UploadKbps   int        `json:"upload_kbps"`   // 0 = unlimited
+	DownloadKbps int        `json:"download_kbps"` // 0 = unlimited
+	MaxUses      int        `json:"max_uses"`
+	Uses         int        `json:"uses"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"` // Last moment the code can be redeemed
+	CreatedBy    string     `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// RemainingUses returns how many more times the code can be redeemed
+func (v *Voucher) RemainingUses() int {
+	if v.Uses >= v.MaxUses {
+		return 0
+	}
+	return v.MaxUses - v.Uses
+}
+
+// IsRedeemableAt reports whether the code can be used at t
+func (v *Voucher) IsRedeemableAt(t time.Time) bool {
+	if v.ExpiresAt != nil && t.After(*v.ExpiresAt) {
+		return false
+	}
+	return v.RemainingUses() > 0
+}
+
+// SessionEndsAt returns when a session started at start runs out
+func (v *Voucher) SessionEndsAt(start time.Time) time.Time {
+	return start.Add(time.Duration(v.DurationMin) * time.Minute)
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// WalledGardenEntry is a host reachable before login, such as a school
+// portal. openNDS also lets its subdomains through.
+type WalledGardenEntry struct {
+	ID      string    `json:"id"`
+	Host    string    `json:"host"`
+	Ports   []int     `json:"ports,omitempty"` // Empty allows any port
+	Comment string    `json:"comment,omitempty"`
+	AddedBy string    `json:"added_by"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// NormalizeHost lowercases a hostname and drops a trailing dot, returning
+// an error unless it is a fully qualified domain name
+func NormalizeHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	if host == "" {
+		return "", errors.New("host is required")
+	}
+	if net.ParseIP(host) != nil {
+		return "", errors.New("host must be a domain name, not an IP address")
+	}
+	if len(host) > 253 || !strings.Contains(host, ".") {
+		return "", errors.New("host must be a fully qualified domain name")
+	}
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", errors.New("host must be a fully qualified domain name")
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return "", errors.New("host may only contain letters, digits, hyphens and dots")
+			}
+		}
+	}
+	return host, nil
+}
+
+// ValidatePorts checks that each port is between 1 and 65535
+func ValidatePorts(ports []int) error {
+	for _, p := range ports {
+		if p < 1 || p > 65535 {
+			return errors.New("ports must be between 1 and 65535")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// Webhook delivery outcomes
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookSubscription posts the events it asks for to a URL, signed with
+// its secret
+type WebhookSubscription struct {
+	ID      string              `json:"id"`
+	URL     string              `json:"url"`
+	Secret  string              `json:"secret"` // Signs each body with HMAC-SHA256
+	Events  []NotificationEvent `json:"events"`
+	Enabled bool                `json:"enabled"`
+
+	// Failed deliveries in a row, after retries. Too many turn the
+	// subscription off, saying why in DisabledReason.
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	DisabledReason      string `json:"disabled_reason,omitempty"`
+
+	// Outcome of the latest delivery
+	LastDeliveryAt *time.Time `json:"last_delivery_at,omitempty"`
+	LastStatus     string     `json:"last_status,omitempty"` // "succeeded" or "failed"
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Wants reports whether the subscription is on and asks for event
+func (s *WebhookSubscription) Wants(event NotificationEvent) bool {
+	return s.Enabled && slices.Contains(s.Events, event)
+}
+
+// WebhookDelivery is one event posted to a subscription, with retries
+type WebhookDelivery struct {
+	ID             string            `json:"id"`
+	SubscriptionID string            `json:"subscription_id"`
+	EventID        string            `json:"event_id"` // The same for a redelivery
+	Event          NotificationEvent `json:"event"`
+	Redelivery     bool              `json:"redelivery,omitempty"`
+	Status         string            `json:"status"` // "pending", "succeeded" or "failed"
+	Attempts       int               `json:"attempts"`
+	StatusCode     int               `json:"status_code,omitempty"` // HTTP status of the last attempt
+	Error          string            `json:"error,omitempty"`
+	DurationMs     int64             `json:"duration_ms"` // Of the last attempt
+	CreatedAt      time.Time         `json:"created_at"`
+	CompletedAt    *time.Time        `json:"completed_at,omitempty"`
+	Payload        json.RawMessage   `json:"payload"`
+}
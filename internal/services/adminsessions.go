@@ -0,0 +1,143 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"parenta/internal/models"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// CreateAdminSession starts a session for an admin signing in
+func (a *AuthService) CreateAdminSession(userID string, from LoginAttempt, ttl time.Duration) (*models.AdminSession, error) {
+	now := time.Now()
+	session := &models.AdminSession{
+		ID:         GenerateID(),
+		UserID:     userID,
+		IP:         from.IP,
+		UserAgent:  from.UserAgent,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+
+	if err := a.storage.SaveAdminSession(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// refreshAdminSession extends a session on token refresh, recording where
+// the refresh came from. Without a session ID a new session is started.
+func (a *AuthService) refreshAdminSession(id, userID string, ttl time.Duration, from LoginAttempt) (*models.AdminSession, error) {
+	if id == "" {
+		return a.CreateAdminSession(userID, from, ttl)
+	}
+
+	existing := a.storage.GetAdminSession(id)
+	if existing == nil || !existing.IsActive() {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	session := *existing
+	session.IP = from.IP
+	session.UserAgent = from.UserAgent
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(ttl)
+	if err := a.storage.SaveAdminSession(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListAdminSessions returns an admin's active sessions, most recently seen first
+func (a *AuthService) ListAdminSessions(userID string) []*models.AdminSession {
+	a.FlushAdminSessions()
+
+	var active []*models.AdminSession
+	for _, s := range a.storage.ListAdminSessions(userID) {
+		if s.IsActive() {
+			active = append(active, s)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].LastSeenAt.After(active[j].LastSeenAt)
+	})
+	return active
+}
+
+// RevokeAdminSession ends an admin's session and revokes its refresh tokens.
+// The caller must also denylist the session's access tokens.
+func (a *AuthService) RevokeAdminSession(userID, id string) (*models.AdminSession, error) {
+	existing := a.storage.GetAdminSession(id)
+	if existing == nil || existing.UserID != userID || !existing.IsActive() {
+		return nil, ErrSessionNotFound
+	}
+
+	now := time.Now()
+	session := *existing
+	session.RevokedAt = &now
+	if err := a.storage.SaveAdminSession(&session); err != nil {
+		return nil, err
+	}
+	if err := a.storage.RevokeRefreshTokensForSession(id); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RevokedAdminSessions returns revoked sessions whose access tokens may not
+// have expired yet, to rebuild the denylist after a restart
+func (a *AuthService) RevokedAdminSessions() []*models.AdminSession {
+	var revoked []*models.AdminSession
+	now := time.Now()
+	for _, s := range a.storage.ListAdminSessions("") {
+		if s.RevokedAt != nil && now.Before(s.ExpiresAt) {
+			revoked = append(revoked, s)
+		}
+	}
+	return revoked
+}
+
+// TouchAdminSession notes that a session was used. The time is kept in
+// memory and written by FlushAdminSessions.
+func (a *AuthService) TouchAdminSession(id string) {
+	a.seenMu.Lock()
+	a.seen[id] = time.Now()
+	a.seenMu.Unlock()
+}
+
+// FlushAdminSessions writes pending last-seen times to storage
+func (a *AuthService) FlushAdminSessions() {
+	a.seenMu.Lock()
+	seen := a.seen
+	a.seen = make(map[string]time.Time)
+	a.seenMu.Unlock()
+
+	if len(seen) == 0 {
+		return
+	}
+	if err := a.storage.TouchAdminSessions(seen); err != nil {
+		log.Printf("Failed to save admin session activity: %v", err)
+	}
+}
+
+// RunSessionFlusher flushes admin session activity every interval until stop
+// is closed
+func (a *AuthService) RunSessionFlusher(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			a.FlushAdminSessions()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"parenta/internal/models"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// apiKeyPrefix marks Parenta API keys so they are easy to spot in scripts and logs
+const apiKeyPrefix = "pk_"
+
+// apiKeyTouchInterval limits how often last-used times are written to disk
+const apiKeyTouchInterval = time.Minute
+
+// CreateAPIKey issues a new API key for an admin. The raw key is returned
+// once and only its hash is stored.
+func (a *AuthService) CreateAPIKey(userID, name string, role models.APIKeyRole, scopes []string) (string, *models.APIKey, error) {
+	if role != models.APIKeyRoleRead && role != models.APIKeyRoleAdmin {
+		return "", nil, fmt.Errorf("role must be %q or %q", models.APIKeyRoleRead, models.APIKeyRoleAdmin)
+	}
+	for _, s := range scopes {
+		if !validAPIKeyScope(s) {
+			return "", nil, fmt.Errorf("unknown scope %q (allowed: %s)", s, strings.Join(models.APIKeyScopes, ", "))
+		}
+	}
+
+	key := apiKeyPrefix + GenerateToken()
+	record := &models.APIKey{
+		ID:        GenerateID(),
+		Name:      name,
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		KeyHash:   hashToken(key),
+		UserID:    userID,
+		Role:      role,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+
+	if err := a.storage.SaveAPIKey(record); err != nil {
+		return "", nil, err
+	}
+	return key, record, nil
+}
+
+// AuthenticateAPIKey resolves a raw API key to its record and owning admin,
+// recording when it was last used
+func (a *AuthService) AuthenticateAPIKey(key string) (*models.APIKey, *models.User, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	record := a.storage.GetAPIKeyByHash(hashToken(key))
+	if record == nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	// Keys of deleted admins stop working
+	admin := a.storage.GetAdminByID(record.UserID)
+	if admin == nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) >= apiKeyTouchInterval {
+		updated := *record
+		updated.LastUsedAt = &now
+		a.storage.SaveAPIKey(&updated)
+		record = &updated
+	}
+
+	return record, admin, nil
+}
+
+// validAPIKeyScope reports whether s is a known route group
+func validAPIKeyScope(s string) bool {
+	for _, scope := range models.APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
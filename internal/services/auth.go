@@ -2,8 +2,11 @@ package services
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"log"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -15,6 +18,10 @@ import (
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidToken       = errors.New("invalid or expired refresh token")
+
+	// A parent has turned off password changes for the child
+	ErrSelfPasswordChangeOff = errors.New("password changes are turned off for this account")
 )
 
 // AuthService handles authentication
@@ -22,14 +29,35 @@ type AuthService struct {
 	storage      *storage.Storage
 	jwtSecret    []byte
 	jwtExpiryHrs int
+	limiter      *loginLimiter
+
+	// Same policy, counting failed child password changes, so those never
+	// lock admins out
+	childLimiter *loginLimiter
+
+	// Admin session last-seen times waiting to be written
+	seenMu sync.Mutex
+	seen   map[string]time.Time
 }
 
-// NewAuthService creates a new AuthService
-func NewAuthService(store *storage.Storage, jwtSecret string, jwtExpiryHrs int) *AuthService {
+// NewAuthService creates a new AuthService. Admin logins are locked for
+// lockout after maxAttempts consecutive failures.
+func NewAuthService(store *storage.Storage, jwtSecret string, jwtExpiryHrs, maxAttempts int, lockout time.Duration) *AuthService {
 	return &AuthService{
 		storage:      store,
 		jwtSecret:    []byte(jwtSecret),
 		jwtExpiryHrs: jwtExpiryHrs,
+		limiter: &loginLimiter{
+			maxAttempts: maxAttempts,
+			cooldown:    lockout,
+			ips:         make(map[string]*ipAttempts),
+		},
+		childLimiter: &loginLimiter{
+			maxAttempts: maxAttempts,
+			cooldown:    lockout,
+			ips:         make(map[string]*ipAttempts),
+		},
+		seen: make(map[string]time.Time),
 	}
 }
 
@@ -75,20 +103,6 @@ func (a *AuthService) InitializeAdmin(username, password string, forceChange boo
 	return a.storage.SaveAdmin(admin)
 }
 
-// AuthenticateAdmin verifies admin credentials
-func (a *AuthService) AuthenticateAdmin(username, password string) (*models.User, error) {
-	admin := a.storage.GetAdminByUsername(username)
-	if admin == nil {
-		return nil, ErrInvalidCredentials
-	}
-
-	if !CheckPassword(password, admin.PasswordHash) {
-		return nil, ErrInvalidCredentials
-	}
-
-	return admin, nil
-}
-
 // AuthenticateChild verifies child credentials
 func (a *AuthService) AuthenticateChild(username, password string) (*models.Child, error) {
 	child := a.storage.GetChildByUsername(username)
@@ -107,6 +121,46 @@ func (a *AuthService) AuthenticateChild(username, password string) (*models.Chil
 	return child, nil
 }
 
+// ChangeChildPassword lets a child replace their own password. Wrong old
+// passwords lock the source IP like failed admin logins do, with separate
+// counters.
+func (a *AuthService) ChangeChildPassword(username, oldPassword, newPassword string, attempt LoginAttempt) error {
+	if until := a.childLimiter.lockedUntil(attempt.IP); !until.IsZero() {
+		return &LockedError{Until: until}
+	}
+
+	child, err := a.AuthenticateChild(username, oldPassword)
+	if err != nil {
+		a.audit(models.AuditChildPasswordFailed, username, attempt, "")
+		if until := a.childLimiter.fail(attempt.IP, username); !until.IsZero() {
+			log.Printf("Child password changes locked until %s (user %q, IP %s)", until.Format(time.RFC3339), username, attempt.IP)
+			return &LockedError{Until: until}
+		}
+		return ErrInvalidCredentials
+	}
+	a.childLimiter.reset(attempt.IP)
+
+	if !child.CanChangeOwnPassword() {
+		return ErrSelfPasswordChangeOff
+	}
+	if err := a.ValidateChildPassword(newPassword, username); err != nil {
+		return err
+	}
+
+	hash, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	child.PasswordHash = hash
+	child.UpdatedAt = time.Now()
+	if err := a.storage.SaveChild(child); err != nil {
+		return err
+	}
+
+	a.audit(models.AuditChildPasswordChange, username, attempt, "")
+	return nil
+}
+
 // ChangeAdminPassword updates an admin's password (user changes their own password)
 func (a *AuthService) ChangeAdminPassword(adminID, oldPassword, newPassword string) error {
 	admin := a.storage.GetAdminByID(adminID)
@@ -193,6 +247,91 @@ func (a *AuthService) ResetAdminPassword(adminID, newPassword string) error {
 	return a.storage.SaveAdmin(admin)
 }
 
+// ============ Refresh Tokens ============
+
+// hashToken returns the hex SHA-256 of a refresh token or API key for storage lookup
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueRefreshToken creates and stores a new refresh token for an admin session
+func (a *AuthService) IssueRefreshToken(userID, sessionID string, ttl time.Duration) (string, *models.RefreshToken, error) {
+	token := GenerateToken()
+	record := &models.RefreshToken{
+		ID:        GenerateID(),
+		TokenHash: hashToken(token),
+		UserID:    userID,
+		SessionID: sessionID,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	if err := a.storage.SaveRefreshToken(record); err != nil {
+		return "", nil, err
+	}
+	return token, record, nil
+}
+
+// RotateRefreshToken exchanges a valid refresh token for a new one. The old
+// token is revoked. Presenting an already-rotated token revokes every token
+// for that user, since it means the token was copied. The token's admin
+// session is extended and returned; tokens from before sessions were
+// tracked get a new session.
+func (a *AuthService) RotateRefreshToken(token string, ttl time.Duration, from LoginAttempt) (*models.User, *models.AdminSession, string, error) {
+	record := a.storage.GetRefreshTokenByHash(hashToken(token))
+	if record == nil {
+		return nil, nil, "", ErrInvalidToken
+	}
+
+	if record.RevokedAt != nil && record.ReplacedBy != "" {
+		a.storage.RevokeRefreshTokensForUser(record.UserID)
+		return nil, nil, "", ErrInvalidToken
+	}
+	if !record.IsValid() {
+		return nil, nil, "", ErrInvalidToken
+	}
+
+	admin := a.storage.GetAdminByID(record.UserID)
+	if admin == nil {
+		return nil, nil, "", ErrUserNotFound
+	}
+
+	session, err := a.refreshAdminSession(record.SessionID, admin.ID, ttl, from)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	newToken, newRecord, err := a.IssueRefreshToken(admin.ID, session.ID, ttl)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	now := time.Now()
+	record.RevokedAt = &now
+	record.ReplacedBy = newRecord.ID
+	if err := a.storage.SaveRefreshToken(record); err != nil {
+		return nil, nil, "", err
+	}
+
+	return admin, session, newToken, nil
+}
+
+// RevokeRefreshToken revokes a refresh token (logout)
+func (a *AuthService) RevokeRefreshToken(token string) error {
+	record := a.storage.GetRefreshTokenByHash(hashToken(token))
+	if record == nil {
+		return ErrInvalidToken
+	}
+	if record.RevokedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	record.RevokedAt = &now
+	return a.storage.SaveRefreshToken(record)
+}
+
 // GenerateID creates a random 16-character hex ID
 func GenerateID() string {
 	bytes := make([]byte, 8)
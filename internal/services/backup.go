@@ -0,0 +1,490 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"parenta/internal/models"
+	"parenta/internal/storage"
+)
+
+// ErrBackupInProgress is returned when a backup is already running
+var ErrBackupInProgress = errors.New("a backup is already in progress")
+
+// backupTimeout bounds a scheduled backup, upload included
+const backupTimeout = 5 * time.Minute
+
+// backupPrefix starts the name of every backup archive
+const backupPrefix = "parenta-backup-"
+
+// BackupTarget is where scheduled backups go
+type BackupTarget struct {
+	// A directory (such as a USB stick), scp://user@host[:port]/dir, or the
+	// http(s) URL of a WebDAV collection
+	Destination string
+
+	// WebDAV basic auth, or the SSH password for scp
+	Username string
+	Password string
+
+	// SSH private key for scp, and the SHA256 fingerprint the server's host
+	// key must have ("SHA256:...", as ssh-keygen -lf prints it)
+	SSHKeyFile string
+	HostKey    string
+
+	// Archives kept in a local directory; 0 keeps them all
+	Keep int
+}
+
+// BackupStatus reports scheduled backups in API responses
+type BackupStatus struct {
+	Enabled     bool       `json:"enabled"`
+	Destination string     `json:"destination,omitempty"` // Without credentials
+	Schedule    string     `json:"schedule,omitempty"`    // Daily local time, "HH:MM"
+	NextRunAt   *time.Time `json:"next_run_at,omitempty"`
+	Running     bool       `json:"running"`
+	models.BackupStatus
+}
+
+// Backups writes the data directory as an archive, on demand or daily at a
+// set time to a BackupTarget. Only one backup runs at a time.
+type Backups struct {
+	storage *storage.Storage
+	target  BackupTarget
+	enabled bool
+	hour    int
+	minute  int
+
+	// Held while an archive is built or delivered
+	runMu sync.Mutex
+
+	mu      sync.Mutex
+	running bool
+	nextRun time.Time
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewBackups creates a Backups. Scheduled backups run daily at at
+// ("HH:MM", local time) once Start is called, if enabled.
+func NewBackups(store *storage.Storage, target BackupTarget, enabled bool, at string) (*Backups, error) {
+	b := &Backups{storage: store, target: target, enabled: enabled}
+	if !enabled {
+		return b, nil
+	}
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return nil, fmt.Errorf("backup time %q must be HH:MM", at)
+	}
+	b.hour, b.minute = t.Hour(), t.Minute()
+	return b, nil
+}
+
+// Start begins the daily schedule. It does nothing if scheduled backups
+// are off.
+func (b *Backups) Start() {
+	if !b.enabled {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stop != nil {
+		return
+	}
+	b.stop, b.done = make(chan struct{}), make(chan struct{})
+	go b.loop(b.stop, b.done)
+	log.Printf("Backups scheduled daily at %02d:%02d to %s", b.hour, b.minute, redactDestination(b.target.Destination))
+}
+
+// Stop ends the schedule, cancelling a running backup
+func (b *Backups) Stop() {
+	b.mu.Lock()
+	stop, done := b.stop, b.done
+	b.stop, b.done = nil, nil
+	b.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// loop runs a backup at each scheduled time until stop is closed
+func (b *Backups) loop(stop, done chan struct{}) {
+	defer close(done)
+	for {
+		next := nextBackupTime(time.Now(), b.hour, b.minute)
+		b.mu.Lock()
+		b.nextRun = next
+		b.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// Stopping abandons an upload rather than hold up shutdown
+		ctx, cancel := context.WithTimeout(context.Background(), backupTimeout)
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		if _, err := b.Run(ctx); err != nil {
+			log.Printf("Scheduled backup failed: %v", err)
+		}
+		cancel()
+	}
+}
+
+// nextBackupTime returns the first hour:minute after now, in now's location
+func nextBackupTime(now time.Time, hour, minute int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, hour, minute, 0, 0, now.Location())
+	}
+	return next
+}
+
+// Write writes a backup archive to w. It fails with ErrBackupInProgress
+// rather than wait for another backup.
+func (b *Backups) Write(w io.Writer) error {
+	if !b.runMu.TryLock() {
+		return ErrBackupInProgress
+	}
+	defer b.runMu.Unlock()
+	return b.storage.WriteBackup(w)
+}
+
+// Run makes a backup and delivers it to the target now, recording the
+// outcome. A backup already running is waited for.
+func (b *Backups) Run(ctx context.Context) (models.BackupStatus, error) {
+	if b.target.Destination == "" {
+		return models.BackupStatus{}, errors.New("no backup destination is configured")
+	}
+
+	b.runMu.Lock()
+	defer b.runMu.Unlock()
+	b.setRunning(true)
+	defer b.setRunning(false)
+
+	status := b.storage.GetBackupStatus()
+	now := time.Now()
+	status.LastRunAt = &now
+
+	name := backupPrefix + now.Format("20060102-150405") + ".tar.gz"
+	var buf bytes.Buffer
+	err := b.storage.WriteBackup(&buf)
+	if err == nil {
+		err = b.deliver(ctx, name, buf.Bytes())
+	}
+
+	if err != nil {
+		status.LastError = err.Error()
+	} else {
+		status.LastError = ""
+		status.LastSuccessAt = &now
+		status.LastFile = name
+		status.LastSizeBytes = int64(buf.Len())
+		log.Printf("Backup %s (%d bytes) written to %s", name, buf.Len(), redactDestination(b.target.Destination))
+	}
+	if saveErr := b.storage.SaveBackupStatus(status); saveErr != nil {
+		log.Printf("Failed to save backup status: %v", saveErr)
+	}
+	return status, err
+}
+
+// Status reports the schedule and the outcome of the last backup
+func (b *Backups) Status() BackupStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := BackupStatus{
+		Enabled:      b.enabled,
+		Running:      b.running,
+		BackupStatus: b.storage.GetBackupStatus(),
+	}
+	if b.target.Destination != "" {
+		s.Destination = redactDestination(b.target.Destination)
+	}
+	if b.enabled {
+		s.Schedule = fmt.Sprintf("%02d:%02d", b.hour, b.minute)
+	}
+	if !b.nextRun.IsZero() {
+		next := b.nextRun
+		s.NextRunAt = &next
+	}
+	return s
+}
+
+// Failed reports whether the last scheduled backup failed
+func (b *Backups) Failed() bool {
+	return b.enabled && b.storage.GetBackupStatus().LastError != ""
+}
+
+func (b *Backups) setRunning(running bool) {
+	b.mu.Lock()
+	b.running = running
+	b.mu.Unlock()
+}
+
+// deliver writes an archive to the target
+func (b *Backups) deliver(ctx context.Context, name string, data []byte) error {
+	u, err := url.Parse(b.target.Destination)
+	if err != nil {
+		return fmt.Errorf("invalid backup destination: %w", err)
+	}
+	switch u.Scheme {
+	case "", "file":
+		dir := b.target.Destination
+		if u.Scheme == "file" {
+			dir = u.Path
+		}
+		return writeLocalBackup(dir, name, data, b.target.Keep)
+	case "http", "https":
+		return b.putWebDAV(ctx, u, name, data)
+	case "scp":
+		return b.copySCP(ctx, u, name, data)
+	}
+	return fmt.Errorf("unsupported backup destination scheme %q", u.Scheme)
+}
+
+// writeLocalBackup writes an archive into dir, then deletes the oldest
+// archives beyond keep
+func writeLocalBackup(dir, name string, data []byte, keep int) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	dest := filepath.Join(dir, name)
+	tmp := dest + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if keep <= 0 {
+		return nil
+	}
+	old, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*.tar.gz"))
+	if err != nil {
+		return err
+	}
+	// The timestamped names sort oldest first
+	sort.Strings(old)
+	for len(old) > keep {
+		if err := os.Remove(old[0]); err != nil {
+			log.Printf("Backup rotation: %v", err)
+		}
+		old = old[1:]
+	}
+	return nil
+}
+
+// putWebDAV uploads an archive into a WebDAV collection
+func (b *Backups) putWebDAV(ctx context.Context, u *url.URL, name string, data []byte) error {
+	dest := *u
+	dest.Path = strings.TrimSuffix(u.Path, "/") + "/" + name
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, dest.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	if b.target.Username != "" || b.target.Password != "" {
+		req.SetBasicAuth(b.target.Username, b.target.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("WebDAV upload to %s: %s", redactDestination(dest.String()), resp.Status)
+	}
+	return nil
+}
+
+// copySCP copies an archive over SSH with the scp protocol. The server's
+// host key must match the configured fingerprint.
+func (b *Backups) copySCP(ctx context.Context, u *url.URL, name string, data []byte) error {
+	config, err := b.sshConfig(u)
+	if err != nil {
+		return err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("ssh %s: %w", addr, err)
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return scpSend(session, u.Path, name, data)
+}
+
+// sshConfig builds the SSH client config for an scp destination
+func (b *Backups) sshConfig(u *url.URL) (*ssh.ClientConfig, error) {
+	user := b.target.Username
+	if u.User != nil && u.User.Username() != "" {
+		user = u.User.Username()
+	}
+	if user == "" {
+		return nil, errors.New("scp destination has no user")
+	}
+
+	var auth []ssh.AuthMethod
+	if b.target.SSHKeyFile != "" {
+		key, err := os.ReadFile(b.target.SSHKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.target.SSHKeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if b.target.Password != "" {
+		auth = append(auth, ssh.Password(b.target.Password))
+	}
+
+	want := b.target.HostKey
+	return &ssh.ClientConfig{
+		User: user,
+		Auth: auth,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if got := ssh.FingerprintSHA256(key); got != want {
+				return fmt.Errorf("host key %s does not match the configured %s", got, want)
+			}
+			return nil
+		},
+		Timeout: 30 * time.Second,
+	}, nil
+}
+
+// scpSession is the part of an SSH session scpSend uses
+type scpSession interface {
+	StdinPipe() (io.WriteCloser, error)
+	StdoutPipe() (io.Reader, error)
+	Start(cmd string) error
+	Wait() error
+}
+
+// scpSend sends one file into dir with the sink side of the scp protocol
+func scpSend(session scpSession, dir, name string, data []byte) error {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if dir == "" {
+		dir = "."
+	}
+	if err := session.Start("scp -t " + shellQuote(dir)); err != nil {
+		return err
+	}
+
+	steps := []func() error{
+		func() error { return scpAck(stdout) },
+		func() error {
+			_, err := fmt.Fprintf(stdin, "C0600 %d %s\n", len(data), path.Base(name))
+			return err
+		},
+		func() error { return scpAck(stdout) },
+		func() error {
+			if _, err := stdin.Write(data); err != nil {
+				return err
+			}
+			_, err := stdin.Write([]byte{0})
+			return err
+		},
+		func() error { return scpAck(stdout) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			stdin.Close()
+			return fmt.Errorf("scp: %w", err)
+		}
+	}
+	stdin.Close()
+	return session.Wait()
+}
+
+// scpAck reads the remote's reply: 0 for OK, else an error message
+func scpAck(r io.Reader) error {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if b[0] == 0 {
+		return nil
+	}
+	var msg []byte
+	for {
+		if _, err := io.ReadFull(r, b); err != nil || b[0] == '\n' {
+			break
+		}
+		msg = append(msg, b[0])
+	}
+	return errors.New(strings.TrimSpace(string(msg)))
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// redactDestination drops any password from a destination URL
+func redactDestination(dest string) string {
+	u, err := url.Parse(dest)
+	if err != nil || u.User == nil {
+		return dest
+	}
+	return u.Redacted()
+}
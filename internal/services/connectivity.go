@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"parenta/internal/models"
+	"parenta/internal/storage"
+)
+
+// ConnectivityTargets are what the connectivity checks query
+type ConnectivityTargets struct {
+	// Resolver the DNS checks ask, normally the local dnsmasq ("host:port")
+	DNSServer string
+
+	// Domain that should resolve when DNS works
+	Domain string
+
+	// Domain that should be blocked. Empty uses the first blocklist rule.
+	BlockedDomain string
+
+	// URL with an IP address host, fetched with HEAD to test the WAN
+	// without DNS
+	HTTPURL string
+}
+
+// ConnectivityCheck is the result of one check
+type ConnectivityCheck struct {
+	Name      string `json:"name"`
+	Target    string `json:"target"`
+	OK        bool   `json:"ok"`
+	Skipped   bool   `json:"skipped,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// Connectivity check names
+const (
+	CheckDNS       = "dns"
+	CheckWAN       = "wan"
+	CheckFiltering = "filtering"
+)
+
+// Connectivity summaries
+const (
+	ConnectivityOK                = "ok"
+	ConnectivityOffline           = "offline"            // WAN and DNS both fail
+	ConnectivityDNSBroken         = "dns_broken"         // WAN works, DNS doesn't
+	ConnectivityWANUnreachable    = "wan_unreachable"    // DNS works, the HTTP endpoint doesn't answer
+	ConnectivityFilteringInactive = "filtering_inactive" // Online, but the blocked domain resolves
+)
+
+// ConnectivityReport is the outcome of a round of checks
+type ConnectivityReport struct {
+	Status    string              `json:"status"`
+	Summary   string              `json:"summary"`
+	Checks    []ConnectivityCheck `json:"checks"`
+	CheckedAt time.Time           `json:"checked_at"`
+}
+
+// ConnectivityChecker tells a WAN outage from broken DNS. It resolves a
+// known domain through dnsmasq, fetches an IP address URL directly, and
+// checks that a blocked domain doesn't resolve. The checks run
+// concurrently, each bounded by a timeout, and the report is reused for a
+// short while.
+type ConnectivityChecker struct {
+	storage *storage.Storage
+	targets ConnectivityTargets
+	timeout time.Duration
+	ttl     time.Duration
+
+	// The mutex is held while checks run, so concurrent callers share them
+	mu     sync.Mutex
+	report *ConnectivityReport
+}
+
+// NewConnectivityChecker creates a ConnectivityChecker. Each check gives up
+// after timeout, and a report is reused for ttl.
+func NewConnectivityChecker(store *storage.Storage, targets ConnectivityTargets, timeout, ttl time.Duration) *ConnectivityChecker {
+	return &ConnectivityChecker{
+		storage: store,
+		targets: targets,
+		timeout: timeout,
+		ttl:     ttl,
+	}
+}
+
+// Check returns the last report if it is fresh, else runs the checks.
+// refresh forces new checks.
+func (c *ConnectivityChecker) Check(ctx context.Context, refresh bool) ConnectivityReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !refresh && c.report != nil && time.Since(c.report.CheckedAt) < c.ttl {
+		return *c.report
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	checks := make([]ConnectivityCheck, 3)
+	var wg sync.WaitGroup
+	for i, check := range []func(context.Context) ConnectivityCheck{c.checkDNS, c.checkWAN, c.checkFiltering} {
+		wg.Add(1)
+		go func(i int, check func(context.Context) ConnectivityCheck) {
+			defer wg.Done()
+			checks[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := summarizeConnectivity(checks[0], checks[1], checks[2])
+	report.CheckedAt = time.Now()
+	c.report = &report
+	return report
+}
+
+// summarizeConnectivity turns the check results into a report
+func summarizeConnectivity(dns, wan, filtering ConnectivityCheck) ConnectivityReport {
+	report := ConnectivityReport{Checks: []ConnectivityCheck{dns, wan, filtering}}
+	switch {
+	case !dns.OK && !wan.OK:
+		report.Status = ConnectivityOffline
+		report.Summary = "the internet connection (WAN) is down"
+	case !dns.OK:
+		report.Status = ConnectivityDNSBroken
+		report.Summary = "the internet is reachable but DNS is broken: " + dns.Detail
+	case !wan.OK:
+		report.Status = ConnectivityWANUnreachable
+		report.Summary = "DNS works but " + wan.Target + " is unreachable: " + wan.Detail
+	case !filtering.OK && !filtering.Skipped:
+		report.Status = ConnectivityFilteringInactive
+		report.Summary = "online, but filtering is not active: " + filtering.Detail
+	default:
+		report.Status = ConnectivityOK
+		report.Summary = "online"
+	}
+	return report
+}
+
+// resolver returns a resolver that asks the configured DNS server only
+func (c *ConnectivityChecker) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, c.targets.DNSServer)
+		},
+	}
+}
+
+// checkDNS resolves the known domain
+func (c *ConnectivityChecker) checkDNS(ctx context.Context) ConnectivityCheck {
+	check := ConnectivityCheck{Name: CheckDNS, Target: c.targets.Domain}
+	start := time.Now()
+	addrs, err := c.resolver().LookupHost(ctx, c.targets.Domain)
+	check.LatencyMS = time.Since(start).Milliseconds()
+
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		check.Detail = c.targets.Domain + " does not exist; is it blocked, or is study mode on?"
+	case err != nil:
+		check.Detail = c.lookupError(err)
+	case blockedAnswer(addrs):
+		check.Detail = c.targets.Domain + " resolves to " + addrs[0] + "; is it blocked?"
+	default:
+		check.OK = true
+		check.Detail = strings.Join(addrs, ", ")
+	}
+	return check
+}
+
+// checkWAN sends a HEAD request to the IP address URL. Any HTTP response
+// means the WAN works.
+func (c *ConnectivityChecker) checkWAN(ctx context.Context) ConnectivityCheck {
+	check := ConnectivityCheck{Name: CheckWAN, Target: c.targets.HTTPURL}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.targets.HTTPURL, nil)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	check.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	resp.Body.Close()
+
+	check.OK = true
+	check.Detail = resp.Status
+	return check
+}
+
+// checkFiltering resolves the blocked domain, which should not exist or
+// resolve to an unroutable address
+func (c *ConnectivityChecker) checkFiltering(ctx context.Context) ConnectivityCheck {
+	domain := c.blockedDomain()
+	check := ConnectivityCheck{Name: CheckFiltering, Target: domain}
+	if domain == "" {
+		check.Skipped = true
+		check.Detail = "no blocklist rules to test"
+		return check
+	}
+
+	start := time.Now()
+	addrs, err := c.resolver().LookupHost(ctx, domain)
+	check.LatencyMS = time.Since(start).Milliseconds()
+
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		check.OK = true
+		check.Detail = "blocked"
+	case err != nil:
+		check.Detail = c.lookupError(err)
+	case blockedAnswer(addrs):
+		check.OK = true
+		check.Detail = "blocked (" + addrs[0] + ")"
+	default:
+		check.Detail = fmt.Sprintf("%s resolves to %s", domain, strings.Join(addrs, ", "))
+	}
+	return check
+}
+
+// lookupError describes a failed lookup. The resolver's own message names
+// the system nameserver, not the one actually asked.
+func (c *ConnectivityChecker) lookupError(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return c.targets.DNSServer + " did not answer in time"
+		}
+		return c.targets.DNSServer + ": " + dnsErr.Err
+	}
+	return err.Error()
+}
+
+// blockedDomain returns the configured blocked domain, or the first
+// blocklist rule in effect
+func (c *ConnectivityChecker) blockedDomain() string {
+	if c.targets.BlockedDomain != "" {
+		return c.targets.BlockedDomain
+	}
+	now := time.Now()
+	for _, rule := range c.storage.ListFilters(models.RuleTypeBlacklist) {
+		if rule.IsEffectiveAt(now) {
+			return strings.TrimPrefix(rule.Domain, "*.")
+		}
+	}
+	return ""
+}
+
+// blockedAnswer reports whether a DNS answer is a blocking one: every
+// address unspecified (0.0.0.0, ::) or loopback
+func blockedAnswer(addrs []string) bool {
+	if len(addrs) == 0 {
+		return false
+	}
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil || !(ip.IsUnspecified() || ip.IsLoopback()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,461 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrDiagnosticUnavailable is returned when the tool a diagnostic needs is
+// not installed
+var ErrDiagnosticUnavailable = errors.New("diagnostic not available on this system")
+
+// NetInterface is a network interface as `ip addr` reports it
+type NetInterface struct {
+	Index     int                `json:"index"`
+	Name      string             `json:"name"`
+	Flags     []string           `json:"flags"`
+	MTU       int                `json:"mtu,omitempty"`
+	State     string             `json:"state,omitempty"`
+	LinkType  string             `json:"link_type,omitempty"`
+	MAC       string             `json:"mac,omitempty"`
+	Addresses []InterfaceAddress `json:"addresses"`
+}
+
+// InterfaceAddress is an address assigned to an interface
+type InterfaceAddress struct {
+	Family    string `json:"family"` // "inet" or "inet6"
+	Address   string `json:"address"`
+	PrefixLen int    `json:"prefix_len"`
+	Broadcast string `json:"broadcast,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+}
+
+// Route is an entry of the routing table as `ip route` reports it
+type Route struct {
+	Family      string `json:"family"`         // "inet" or "inet6"
+	Type        string `json:"type,omitempty"` // unreachable, blackhole, local... Empty for unicast.
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway,omitempty"`
+	Device      string `json:"device,omitempty"`
+	Protocol    string `json:"protocol,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Metric      int    `json:"metric,omitempty"`
+}
+
+// WirelessInterface is a wireless interface as `iwinfo` reports it.
+// Numbers iwinfo reports as unknown are left zero.
+type WirelessInterface struct {
+	Name         string `json:"name"`
+	ESSID        string `json:"essid,omitempty"`
+	BSSID        string `json:"bssid,omitempty"`
+	Mode         string `json:"mode,omitempty"`
+	Channel      int    `json:"channel,omitempty"`
+	FrequencyGHz string `json:"frequency_ghz,omitempty"`
+	HTMode       string `json:"ht_mode,omitempty"`
+	TxPowerDBm   int    `json:"tx_power_dbm,omitempty"`
+	LinkQuality  string `json:"link_quality,omitempty"`
+	SignalDBm    int    `json:"signal_dbm,omitempty"`
+	NoiseDBm     int    `json:"noise_dbm,omitempty"`
+	BitRateMbit  string `json:"bit_rate_mbit,omitempty"`
+	Encryption   string `json:"encryption,omitempty"`
+	Type         string `json:"type,omitempty"`
+	HWModes      string `json:"hw_modes,omitempty"`
+	Hardware     string `json:"hardware,omitempty"`
+	PHY          string `json:"phy,omitempty"`
+	SupportsVAPs bool   `json:"supports_vaps,omitempty"`
+}
+
+// Process is a running process, read from /proc
+type Process struct {
+	PID     int    `json:"pid"`
+	PPID    int    `json:"ppid"`
+	User    string `json:"user,omitempty"`
+	State   string `json:"state"`
+	Name    string `json:"name"`
+	Command string `json:"command,omitempty"` // Empty for kernel threads
+	Threads int    `json:"threads"`
+	VSZKB   int64  `json:"vsz_kb"`
+	RSSKB   int64  `json:"rss_kb"`
+}
+
+// Diagnostics reports the router's interfaces, routes, wireless state and
+// processes as structured data, running fixed commands only
+type Diagnostics struct {
+	runner  CommandRunner
+	procDir string
+}
+
+// NewDiagnostics creates a Diagnostics that runs commands with runner and
+// reads processes from procDir (normally /proc)
+func NewDiagnostics(runner CommandRunner, procDir string) *Diagnostics {
+	return &Diagnostics{runner: runner, procDir: procDir}
+}
+
+// run runs a diagnostic command, telling a missing tool from a failure
+func (d *Diagnostics) run(name string, args ...string) (string, error) {
+	out, err := d.runner.Run(name, args...)
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", fmt.Errorf("%w: %s is not installed", ErrDiagnosticUnavailable, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return out, nil
+}
+
+// Interfaces lists the network interfaces and their addresses
+func (d *Diagnostics) Interfaces() ([]NetInterface, error) {
+	out, err := d.run("ip", "addr", "show")
+	if err != nil {
+		return nil, err
+	}
+	return parseIPAddr(out), nil
+}
+
+// Routes lists the IPv4 and IPv6 main routing tables. A host without IPv6
+// just has no inet6 routes.
+func (d *Diagnostics) Routes() ([]Route, error) {
+	out, err := d.run("ip", "route", "show")
+	if err != nil {
+		return nil, err
+	}
+	routes := parseIPRoute(out, "inet")
+	if out6, err := d.run("ip", "-6", "route", "show"); err == nil {
+		routes = append(routes, parseIPRoute(out6, "inet6")...)
+	}
+	return routes, nil
+}
+
+// Wireless lists the wireless interfaces
+func (d *Diagnostics) Wireless() ([]WirelessInterface, error) {
+	out, err := d.run("iwinfo")
+	if err != nil {
+		return nil, err
+	}
+	return parseIWInfo(out), nil
+}
+
+// Processes lists the running processes by PID
+func (d *Diagnostics) Processes() ([]Process, error) {
+	entries, err := os.ReadDir(d.procDir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiagnosticUnavailable, err)
+	}
+
+	users := map[string]string{}
+	procs := []Process{}
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil || !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(d.procDir, e.Name())
+		stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+		if err != nil {
+			continue // Exited while listing
+		}
+		p, err := parseProcStat(string(stat))
+		if err != nil {
+			continue
+		}
+		if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+			p.Command = parseProcCmdline(cmdline)
+		}
+		if status, err := os.ReadFile(filepath.Join(dir, "status")); err == nil {
+			if uid := procStatusUID(string(status)); uid != "" {
+				p.User = lookupUser(users, uid)
+			}
+		}
+		procs = append(procs, p)
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+	return procs, nil
+}
+
+// ipLinkHeader matches the first line of each interface in `ip addr`:
+// "2: eth0@if5: <BROADCAST,UP> mtu 1500 ..."
+var ipLinkHeader = regexp.MustCompile(`^(\d+):\s+([^:\s]+):\s+<([^>]*)>(.*)$`)
+
+// parseIPAddr parses the output of `ip addr show`, from iproute2 or
+// BusyBox
+func parseIPAddr(out string) []NetInterface {
+	ifaces := []NetInterface{}
+	var cur *NetInterface
+	for _, line := range strings.Split(out, "\n") {
+		if m := ipLinkHeader.FindStringSubmatch(line); m != nil {
+			index, _ := strconv.Atoi(m[1])
+			name, _, _ := strings.Cut(m[2], "@")
+			ifaces = append(ifaces, NetInterface{
+				Index:     index,
+				Name:      name,
+				Flags:     splitNonEmpty(m[3], ","),
+				Addresses: []InterfaceAddress{},
+			})
+			cur = &ifaces[len(ifaces)-1]
+			opts := fieldPairs(strings.Fields(m[4]))
+			cur.MTU, _ = strconv.Atoi(opts["mtu"])
+			cur.State = opts["state"]
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch key := fields[0]; {
+		case strings.HasPrefix(key, "link/"):
+			cur.LinkType = strings.TrimPrefix(key, "link/")
+			if fields[1] != "00:00:00:00:00:00" && strings.Count(fields[1], ":") == 5 {
+				cur.MAC = fields[1]
+			}
+		case key == "inet" || key == "inet6":
+			addr := InterfaceAddress{Family: key, Address: fields[1]}
+			if ip, prefix, ok := strings.Cut(fields[1], "/"); ok {
+				addr.Address = ip
+				addr.PrefixLen, _ = strconv.Atoi(prefix)
+			} else if key == "inet" {
+				addr.PrefixLen = 32
+			} else {
+				addr.PrefixLen = 128
+			}
+			opts := fieldPairs(fields[2:])
+			addr.Broadcast = opts["brd"]
+			addr.Scope = opts["scope"]
+			cur.Addresses = append(cur.Addresses, addr)
+		}
+	}
+	return ifaces
+}
+
+// routeTypes are the route types `ip route` prints before the destination
+var routeTypes = map[string]bool{
+	"unicast": true, "local": true, "broadcast": true, "multicast": true,
+	"throw": true, "unreachable": true, "prohibit": true, "blackhole": true,
+	"nat": true, "anycast": true,
+}
+
+// parseIPRoute parses the output of `ip route show` for one family
+func parseIPRoute(out, family string) []Route {
+	routes := []Route{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		route := Route{Family: family}
+		if routeTypes[fields[0]] && len(fields) > 1 {
+			if fields[0] != "unicast" {
+				route.Type = fields[0]
+			}
+			fields = fields[1:]
+		}
+		route.Destination = fields[0]
+		opts := fieldPairs(fields[1:])
+		route.Gateway = opts["via"]
+		route.Device = opts["dev"]
+		route.Protocol = opts["proto"]
+		route.Scope = opts["scope"]
+		route.Source = opts["src"]
+		route.Metric, _ = strconv.Atoi(opts["metric"])
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// iwinfoFieldSep separates the "Key: value" fields iwinfo prints on a line
+var iwinfoFieldSep = regexp.MustCompile(`\s{2,}`)
+
+// parseIWInfo parses the output of `iwinfo` with no arguments
+func parseIWInfo(out string) []WirelessInterface {
+	ifaces := []WirelessInterface{}
+	var cur *WirelessInterface
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			// "wlan0     ESSID: "Home"". Anything else, such as "No
+			// wireless information available", isn't an interface.
+			name, rest, _ := strings.Cut(line, " ")
+			if !strings.Contains(rest, "ESSID:") {
+				cur = nil
+				continue
+			}
+			ifaces = append(ifaces, WirelessInterface{Name: name})
+			cur = &ifaces[len(ifaces)-1]
+			line = rest
+		}
+		if cur == nil {
+			continue
+		}
+		for _, field := range iwinfoFieldSep.Split(strings.TrimSpace(line), -1) {
+			key, value, ok := strings.Cut(field, ": ")
+			if !ok {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if value == "" || value == "unknown" {
+				continue
+			}
+			setIWInfoField(cur, key, value)
+		}
+	}
+	return ifaces
+}
+
+// setIWInfoField stores one iwinfo field
+func setIWInfoField(iface *WirelessInterface, key, value string) {
+	switch key {
+	case "ESSID":
+		iface.ESSID = strings.Trim(value, `"`)
+	case "Access Point":
+		if value != "00:00:00:00:00:00" {
+			iface.BSSID = value
+		}
+	case "Mode":
+		iface.Mode = value
+	case "Channel":
+		// "6 (2.437 GHz)"
+		channel, freq, _ := strings.Cut(value, " ")
+		iface.Channel, _ = strconv.Atoi(channel)
+		if freq = strings.Trim(freq, "()"); freq != "unknown" {
+			iface.FrequencyGHz = strings.TrimSuffix(freq, " GHz")
+		}
+	case "HT Mode":
+		iface.HTMode = value
+	case "Tx-Power":
+		iface.TxPowerDBm = leadingInt(value)
+	case "Link Quality":
+		if !strings.HasPrefix(value, "unknown") {
+			iface.LinkQuality = value
+		}
+	case "Signal":
+		iface.SignalDBm = leadingInt(value)
+	case "Noise":
+		iface.NoiseDBm = leadingInt(value)
+	case "Bit Rate":
+		iface.BitRateMbit = strings.TrimSuffix(value, " MBit/s")
+	case "Encryption":
+		iface.Encryption = value
+	case "Type":
+		iface.Type = value
+	case "HW Mode(s)":
+		iface.HWModes = value
+	case "Hardware":
+		iface.Hardware = value
+	case "PHY name":
+		iface.PHY = value
+	case "Supports VAPs":
+		iface.SupportsVAPs = value == "yes"
+	}
+}
+
+// pageSizeKB is the memory page size /proc/<pid>/stat counts RSS in
+var pageSizeKB = int64(os.Getpagesize() / 1024)
+
+// parseProcStat parses /proc/<pid>/stat. The name is in parentheses and
+// may itself contain spaces and parentheses, so the fields after it are
+// found from the last ')'.
+func parseProcStat(stat string) (Process, error) {
+	open := strings.IndexByte(stat, '(')
+	end := strings.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return Process{}, errors.New("malformed stat")
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(stat[:open]))
+	if err != nil {
+		return Process{}, fmt.Errorf("malformed pid: %w", err)
+	}
+
+	// Fields from 3 (state) on; see proc(5)
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 22 {
+		return Process{}, errors.New("truncated stat")
+	}
+	p := Process{
+		PID:   pid,
+		Name:  stat[open+1 : end],
+		State: fields[0],
+	}
+	p.PPID, _ = strconv.Atoi(fields[1])
+	p.Threads, _ = strconv.Atoi(fields[17])
+	vsz, _ := strconv.ParseInt(fields[20], 10, 64)
+	rss, _ := strconv.ParseInt(fields[21], 10, 64)
+	p.VSZKB = vsz / 1024
+	p.RSSKB = rss * pageSizeKB
+	return p, nil
+}
+
+// parseProcCmdline joins the NUL-separated arguments of /proc/<pid>/cmdline
+func parseProcCmdline(cmdline []byte) string {
+	return strings.Join(splitNonEmpty(string(cmdline), "\x00"), " ")
+}
+
+// procStatusUID returns the real UID from /proc/<pid>/status
+func procStatusUID(status string) string {
+	for _, line := range strings.Split(status, "\n") {
+		if rest, ok := strings.CutPrefix(line, "Uid:"); ok {
+			if fields := strings.Fields(rest); len(fields) > 0 {
+				return fields[0]
+			}
+		}
+	}
+	return ""
+}
+
+// lookupUser names a UID, falling back to the number
+func lookupUser(cache map[string]string, uid string) string {
+	if name, ok := cache[uid]; ok {
+		return name
+	}
+	name := uid
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	cache[uid] = name
+	return name
+}
+
+// fieldPairs maps each field to the one after it, so "dev eth0 metric 10"
+// gives dev=eth0 and metric=10. The first occurrence of a key wins.
+func fieldPairs(fields []string) map[string]string {
+	pairs := make(map[string]string, len(fields)/2)
+	for i := 0; i+1 < len(fields); i++ {
+		if _, ok := pairs[fields[i]]; !ok {
+			pairs[fields[i]] = fields[i+1]
+		}
+	}
+	return pairs
+}
+
+// splitNonEmpty splits s by sep, dropping empty parts
+func splitNonEmpty(s, sep string) []string {
+	parts := []string{}
+	for _, p := range strings.Split(s, sep) {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// leadingInt parses the number at the start of a value such as "-40 dBm"
+func leadingInt(value string) int {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0
+	}
+	n, _ := strconv.Atoi(fields[0])
+	return n
+}
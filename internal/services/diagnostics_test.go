@@ -0,0 +1,263 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseIPAddr(t *testing.T) {
+	out := `1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN group default qlen 1000
+    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00
+    inet 127.0.0.1/8 scope host lo
+       valid_lft forever preferred_lft forever
+    inet6 ::1/128 scope host
+       valid_lft forever preferred_lft forever
+5: br-lan: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP qlen 1000
+    link/ether aa:bb:cc:00:11:22 brd ff:ff:ff:ff:ff:ff
+    inet 192.168.1.1/24 brd 192.168.1.255 scope global br-lan
+       valid_lft forever preferred_lft forever
+    inet6 fe80::a8bb:ccff:fe00:1122/64 scope link
+       valid_lft forever preferred_lft forever
+9: wan@if3: <BROADCAST,MULTICAST> mtu 1500 qdisc noop state DOWN
+    link/ether aa:bb:cc:00:11:23 brd ff:ff:ff:ff:ff:ff
+10: wg0: <POINTOPOINT,NOARP,UP,LOWER_UP> mtu 1420 qdisc noqueue state UNKNOWN
+    link/none
+    inet 10.8.0.1 scope global wg0
+`
+
+	want := []NetInterface{
+		{
+			Index: 1, Name: "lo", Flags: []string{"LOOPBACK", "UP", "LOWER_UP"}, MTU: 65536, State: "UNKNOWN", LinkType: "loopback",
+			Addresses: []InterfaceAddress{
+				{Family: "inet", Address: "127.0.0.1", PrefixLen: 8, Scope: "host"},
+				{Family: "inet6", Address: "::1", PrefixLen: 128, Scope: "host"},
+			},
+		},
+		{
+			Index: 5, Name: "br-lan", Flags: []string{"BROADCAST", "MULTICAST", "UP", "LOWER_UP"}, MTU: 1500, State: "UP",
+			LinkType: "ether", MAC: "aa:bb:cc:00:11:22",
+			Addresses: []InterfaceAddress{
+				{Family: "inet", Address: "192.168.1.1", PrefixLen: 24, Broadcast: "192.168.1.255", Scope: "global"},
+				{Family: "inet6", Address: "fe80::a8bb:ccff:fe00:1122", PrefixLen: 64, Scope: "link"},
+			},
+		},
+		{
+			Index: 9, Name: "wan", Flags: []string{"BROADCAST", "MULTICAST"}, MTU: 1500, State: "DOWN",
+			LinkType: "ether", MAC: "aa:bb:cc:00:11:23", Addresses: []InterfaceAddress{},
+		},
+		{
+			Index: 10, Name: "wg0", Flags: []string{"POINTOPOINT", "NOARP", "UP", "LOWER_UP"}, MTU: 1420, State: "UNKNOWN",
+			Addresses: []InterfaceAddress{{Family: "inet", Address: "10.8.0.1", PrefixLen: 32, Scope: "global"}},
+		},
+	}
+
+	if got := parseIPAddr(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseIPAddr\n got %+v\nwant %+v", got, want)
+	}
+	if got := parseIPAddr(""); len(got) != 0 {
+		t.Errorf("parseIPAddr(\"\") = %+v, want none", got)
+	}
+}
+
+func TestParseIPRoute(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    string
+		family string
+		want   []Route
+	}{
+		{
+			name:   "ipv4",
+			family: "inet",
+			out: "default via 192.168.0.1 dev wan proto static src 192.168.0.10 metric 10\n" +
+				"192.168.1.0/24 dev br-lan proto kernel scope link src 192.168.1.1 \n" +
+				"unreachable 10.0.0.0/8 metric 5\n",
+			want: []Route{
+				{Family: "inet", Destination: "default", Gateway: "192.168.0.1", Device: "wan", Protocol: "static", Source: "192.168.0.10", Metric: 10},
+				{Family: "inet", Destination: "192.168.1.0/24", Device: "br-lan", Protocol: "kernel", Scope: "link", Source: "192.168.1.1"},
+				{Family: "inet", Type: "unreachable", Destination: "10.0.0.0/8", Metric: 5},
+			},
+		},
+		{
+			name:   "ipv6",
+			family: "inet6",
+			out: "fd00::/64 dev br-lan proto kernel metric 256 pref medium\n" +
+				"default via fe80::1 dev wan proto static metric 1024 pref medium\n",
+			want: []Route{
+				{Family: "inet6", Destination: "fd00::/64", Device: "br-lan", Protocol: "kernel", Metric: 256},
+				{Family: "inet6", Destination: "default", Gateway: "fe80::1", Device: "wan", Protocol: "static", Metric: 1024},
+			},
+		},
+		{
+			name:   "empty",
+			family: "inet",
+			out:    "\n",
+			want:   []Route{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseIPRoute(tt.out, tt.family); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseIPRoute\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIWInfo(t *testing.T) {
+	out := `wlan0     ESSID: "Home Wi-Fi"
+          Access Point: AA:BB:CC:DD:EE:FF
+          Mode: Master  Channel: 6 (2.437 GHz)  HT Mode: HT20
+          Center Channel 1: 6 2: unknown
+          Tx-Power: 20 dBm  Link Quality: 58/70
+          Signal: -52 dBm  Noise: -95 dBm
+          Bit Rate: 144.4 MBit/s
+          Encryption: WPA2 PSK (CCMP)
+          Type: nl80211  HW Mode(s): 802.11bgn
+          Hardware: 14C3:7662 14C3:7662 [MediaTek MT7662E]
+          TX power offset: none
+          Frequency offset: none
+          Supports VAPs: yes  PHY name: phy0
+
+wlan1     ESSID: unknown
+          Access Point: 00:00:00:00:00:00
+          Mode: Client  Channel: unknown (unknown)  HT Mode: VHT80
+          Tx-Power: unknown  Link Quality: unknown/70
+          Signal: unknown  Noise: unknown
+          Bit Rate: unknown
+          Encryption: none
+          Type: nl80211  HW Mode(s): 802.11nac
+          Supports VAPs: no  PHY name: phy1
+`
+
+	want := []WirelessInterface{
+		{
+			Name: "wlan0", ESSID: "Home Wi-Fi", BSSID: "AA:BB:CC:DD:EE:FF", Mode: "Master",
+			Channel: 6, FrequencyGHz: "2.437", HTMode: "HT20", TxPowerDBm: 20, LinkQuality: "58/70",
+			SignalDBm: -52, NoiseDBm: -95, BitRateMbit: "144.4", Encryption: "WPA2 PSK (CCMP)",
+			Type: "nl80211", HWModes: "802.11bgn", Hardware: "14C3:7662 14C3:7662 [MediaTek MT7662E]",
+			PHY: "phy0", SupportsVAPs: true,
+		},
+		{
+			Name: "wlan1", Mode: "Client", HTMode: "VHT80",
+			Encryption: "none", Type: "nl80211", HWModes: "802.11nac", PHY: "phy1",
+		},
+	}
+
+	if got := parseIWInfo(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseIWInfo\n got %+v\nwant %+v", got, want)
+	}
+	if got := parseIWInfo("No wireless information available\n"); len(got) != 0 {
+		t.Errorf("parseIWInfo(no wireless) = %+v, want none", got)
+	}
+}
+
+func TestParseProcStat(t *testing.T) {
+	// Fields after the name: state ppid pgrp session tty_nr tpgid flags
+	// minflt cminflt majflt cmajflt utime stime cutime cstime priority nice
+	// num_threads itrealvalue starttime vsize rss ...
+	tail := " S 1 1234 1234 0 -1 4194560 100 0 0 0 5 3 0 0 20 0 4 0 900 10485760 300 18446744073709551615"
+
+	tests := []struct {
+		name    string
+		stat    string
+		want    Process
+		wantErr bool
+	}{
+		{
+			name: "plain",
+			stat: "1234 (dnsmasq)" + tail,
+			want: Process{PID: 1234, PPID: 1, State: "S", Name: "dnsmasq", Threads: 4, VSZKB: 10240, RSSKB: 300 * pageSizeKB},
+		},
+		{
+			name: "name with spaces and parentheses",
+			stat: "42 (a (b) c)" + tail,
+			want: Process{PID: 42, PPID: 1, State: "S", Name: "a (b) c", Threads: 4, VSZKB: 10240, RSSKB: 300 * pageSizeKB},
+		},
+		{name: "no name", stat: "42 dnsmasq S 1", wantErr: true},
+		{name: "bad pid", stat: "x (dnsmasq)" + tail, wantErr: true},
+		{name: "truncated", stat: "42 (dnsmasq) S 1 1234", wantErr: true},
+		{name: "empty", stat: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProcStat(tt.stat)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProcStat(%q) error = %v, wantErr %v", tt.stat, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseProcStat(%q)\n got %+v\nwant %+v", tt.stat, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnosticsProcesses(t *testing.T) {
+	dir := t.TempDir()
+	write := func(pid, name, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, pid), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, pid, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tail := " S 1 1 1 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 0 4096 2 0\n"
+	write("12", "stat", "12 (parenta)"+tail)
+	write("12", "cmdline", "/usr/bin/parenta\x00-config\x00/etc/parenta.json\x00")
+	write("12", "status", "Name:\tparenta\nUid:\t4294967\t4294967\t4294967\t4294967\n")
+	write("2", "stat", "2 (kthreadd)"+tail)
+	write("self", "stat", "ignored")
+	write("7", "cmdline", "exited before its stat was read")
+
+	procs, err := NewDiagnostics(ExecRunner{}, dir).Processes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(procs) != 2 || procs[0].PID != 2 || procs[1].PID != 12 {
+		t.Fatalf("Processes() = %+v, want PIDs 2 and 12", procs)
+	}
+	if p := procs[1]; p.Command != "/usr/bin/parenta -config /etc/parenta.json" || p.User != "4294967" {
+		t.Errorf("Processes()[1] = %+v", p)
+	}
+	if p := procs[0]; p.Command != "" || p.User != "" {
+		t.Errorf("kernel thread = %+v, want no command or user", p)
+	}
+}
+
+// fakeRunner answers commands from a map, failing the ones it doesn't know
+// as if they weren't installed
+type fakeRunner map[string]string
+
+func (f fakeRunner) Run(name string, args ...string) (string, error) {
+	key := name
+	for _, a := range args {
+		key += " " + a
+	}
+	out, ok := f[key]
+	if !ok {
+		return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+	}
+	return out, nil
+}
+
+func TestDiagnosticsCommands(t *testing.T) {
+	d := NewDiagnostics(fakeRunner{
+		"ip route show": "default via 192.168.0.1 dev wan",
+	}, "/proc")
+
+	routes, err := d.Routes()
+	if err != nil || len(routes) != 1 || routes[0].Gateway != "192.168.0.1" {
+		t.Errorf("Routes() = %+v, %v; want the IPv4 default route only", routes, err)
+	}
+	if _, err := d.Wireless(); !errors.Is(err, ErrDiagnosticUnavailable) {
+		t.Errorf("Wireless() without iwinfo: error = %v, want ErrDiagnosticUnavailable", err)
+	}
+}
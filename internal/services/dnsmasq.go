@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"parenta/internal/models"
 	"parenta/internal/storage"
@@ -17,17 +17,39 @@ type DnsmasqService struct {
 	storage    *storage.Storage
 	confDir    string
 	restartCmd string
+
+	// Resolvers whitelisted domains are forwarded to, normally and in study mode
+	upstream      string
+	studyUpstream string
+
+	// How many times a failed restart is tried before giving up
+	restartAttempts int
+
+	runner CommandRunner
 }
 
-// NewDnsmasqService creates a new DnsmasqService
-func NewDnsmasqService(store *storage.Storage, confDir, restartCmd string) *DnsmasqService {
+// NewDnsmasqService creates a new DnsmasqService. An empty studyUpstream
+// uses upstream in study mode too.
+func NewDnsmasqService(store *storage.Storage, confDir, restartCmd, upstream, studyUpstream string, restartAttempts int) *DnsmasqService {
+	if studyUpstream == "" {
+		studyUpstream = upstream
+	}
 	return &DnsmasqService{
-		storage:    store,
-		confDir:    confDir,
-		restartCmd: restartCmd,
+		storage:         store,
+		confDir:         confDir,
+		restartCmd:      restartCmd,
+		upstream:        upstream,
+		studyUpstream:   studyUpstream,
+		restartAttempts: restartAttempts,
+		runner:          ExecRunner{},
 	}
 }
 
+// SetRunner makes dnsmasq restarts run through r
+func (d *DnsmasqService) SetRunner(r CommandRunner) {
+	d.runner = r
+}
+
 // RegenerateConfigs rebuilds all dnsmasq filter config files
 func (d *DnsmasqService) RegenerateConfigs() error {
 	// Ensure config directory exists
@@ -42,31 +64,37 @@ func (d *DnsmasqService) RegenerateConfigs() error {
 	}
 
 	// Generate whitelist
-	whitelist := d.storage.ListFilters(models.RuleTypeWhitelist)
-	if err := d.writeWhitelist(whitelist); err != nil {
+	if err := d.writeWhitelist(d.StudyModeRequired(time.Now())); err != nil {
 		return fmt.Errorf("write whitelist: %w", err)
 	}
 
 	return nil
 }
 
-// Reload restarts dnsmasq to apply new configuration
+// Reload restarts dnsmasq to apply new configuration. A restart can fail
+// briefly while the old process still holds its port, so failures are
+// retried with backoff.
 func (d *DnsmasqService) Reload() error {
 	parts := strings.Fields(d.restartCmd)
 	if len(parts) == 0 {
 		return fmt.Errorf("invalid restart command")
 	}
 
-	cmd := exec.Command(parts[0], parts[1:]...)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	return Retry("dnsmasq restart", d.restartAttempts, func() error {
+		return runRestart(d.runner, parts[0], parts[1:]...)
+	})
+}
 
-	if err := cmd.Run(); err != nil {
-		errMsg := strings.TrimSpace(stderr.String())
-		if errMsg != "" {
-			return fmt.Errorf("dnsmasq restart: %s", errMsg)
-		}
-		return fmt.Errorf("dnsmasq restart: %w", err)
+// RunRestart runs a service restart command, returning its stderr as the
+// error message if it fails
+func RunRestart(name string, args ...string) error {
+	return runRestart(ExecRunner{}, name, args...)
+}
+
+// runRestart runs a restart command through r
+func runRestart(r CommandRunner, name string, args ...string) error {
+	if _, err := r.Run(name, args...); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(name), err)
 	}
 	return nil
 }
@@ -85,7 +113,11 @@ func (d *DnsmasqService) writeBlocklist(rules []*models.FilterRule) error {
 	buf.WriteString("# Parenta Blocklist - Auto-generated\n")
 	buf.WriteString("# Do not edit manually - changes will be overwritten\n\n")
 
+	now := time.Now()
 	for _, rule := range rules {
+		if !rule.IsEffectiveAt(now) {
+			continue
+		}
 		// address=/domain.com/ returns NXDOMAIN for that domain
 		domain := strings.TrimPrefix(rule.Domain, "*.")
 		fmt.Fprintf(&buf, "address=/%s/\n", domain)
@@ -95,17 +127,27 @@ func (d *DnsmasqService) writeBlocklist(rules []*models.FilterRule) error {
 	return d.atomicWrite(path, buf.Bytes())
 }
 
-// writeWhitelist writes the whitelist dnsmasq config
-func (d *DnsmasqService) writeWhitelist(rules []*models.FilterRule) error {
+// writeWhitelist writes the whitelist dnsmasq config, forwarding to the
+// study mode resolver while study mode is on
+func (d *DnsmasqService) writeWhitelist(studyMode bool) error {
 	var buf bytes.Buffer
 	buf.WriteString("# Parenta Whitelist - Auto-generated\n")
 	buf.WriteString("# Do not edit manually - changes will be overwritten\n\n")
 
+	upstream := d.upstream
+	if studyMode {
+		upstream = d.studyUpstream
+	}
+
 	// For study mode: forward whitelisted domains to upstream DNS
-	for _, rule := range rules {
+	now := time.Now()
+	for _, rule := range d.storage.ListFilters(models.RuleTypeWhitelist) {
+		if !rule.IsEffectiveAt(now) {
+			continue
+		}
 		domain := strings.TrimPrefix(rule.Domain, "*.")
 		// server=/domain.com/8.8.8.8 forwards queries to upstream
-		fmt.Fprintf(&buf, "server=/%s/8.8.8.8\n", domain)
+		fmt.Fprintf(&buf, "server=/%s/%s\n", domain, upstream)
 	}
 
 	path := filepath.Join(d.confDir, "parenta-whitelist.conf")
@@ -137,13 +179,84 @@ func (d *DnsmasqService) EnableStudyMode() error {
 	if err := d.GenerateStudyModeBlock(); err != nil {
 		return err
 	}
+	if err := d.writeWhitelist(true); err != nil {
+		return fmt.Errorf("write whitelist: %w", err)
+	}
 	return d.Reload()
 }
 
+// ResyncResult lists the config files a resync wrote and removed
+type ResyncResult struct {
+	Written   []string `json:"written"`
+	Removed   []string `json:"removed"`
+	StudyMode bool     `json:"study_mode"`
+}
+
+// Resync reconciles the on-disk configs with current data: it regenerates
+// the blocklist and whitelist, writes or removes the study mode file
+// depending on StudyModeRequired, and reloads dnsmasq once
+func (d *DnsmasqService) Resync() (*ResyncResult, error) {
+	if err := d.RegenerateConfigs(); err != nil {
+		return nil, err
+	}
+
+	result := &ResyncResult{
+		Written: []string{
+			filepath.Join(d.confDir, "parenta-blocklist.conf"),
+			filepath.Join(d.confDir, "parenta-whitelist.conf"),
+		},
+		Removed:   []string{},
+		StudyMode: d.StudyModeRequired(time.Now()),
+	}
+
+	studyPath := filepath.Join(d.confDir, "parenta-studymode.conf")
+	if result.StudyMode {
+		if err := d.GenerateStudyModeBlock(); err != nil {
+			return nil, fmt.Errorf("write study mode: %w", err)
+		}
+		result.Written = append(result.Written, studyPath)
+	} else {
+		err := os.Remove(studyPath)
+		if err == nil {
+			result.Removed = append(result.Removed, studyPath)
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("remove study mode: %w", err)
+		}
+	}
+
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// StudyModeRequired reports whether any active child is in study mode at t,
+// either by their own filter mode or by their schedule's current block
+func (d *DnsmasqService) StudyModeRequired(t time.Time) bool {
+	for _, child := range d.storage.ListChildren() {
+		if !child.IsActive {
+			continue
+		}
+		if child.FilterMode == models.FilterModeStudy {
+			return true
+		}
+		if child.ScheduleID == "" {
+			continue
+		}
+		if schedule := d.storage.GetSchedule(child.ScheduleID); schedule != nil && schedule.FilterModeAt(t) == models.FilterModeStudy {
+			return true
+		}
+	}
+	return false
+}
+
 // DisableStudyMode deactivates study mode
 func (d *DnsmasqService) DisableStudyMode() error {
 	path := filepath.Join(d.confDir, "parenta-studymode.conf")
 	// Remove the file if it exists
 	os.Remove(path)
+	if err := d.writeWhitelist(false); err != nil {
+		return fmt.Errorf("write whitelist: %w", err)
+	}
 	return d.Reload()
 }
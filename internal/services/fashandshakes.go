@@ -0,0 +1,112 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// FASHandshakeTTL is how long a verified FAS payload can be used to log in
+const FASHandshakeTTL = time.Hour
+
+// FASUsedHIDTTL is how long a hid that completed a login is refused for,
+// matching openNDS's default sessiontimeout of a day
+const FASUsedHIDTTL = 24 * time.Hour
+
+var (
+	ErrFASUnknownHID = errors.New("no verified FAS payload for this hid")
+	ErrFASUsedHID    = errors.New("FAS hid already used")
+)
+
+// FASHandshake is what a verified level 2/3 FAS payload said about a
+// client. At those levels the portal login is bound to it, so nothing the
+// client posts can change the MAC, hid or gateway.
+type FASHandshake struct {
+	HID            string
+	ClientMAC      string
+	ClientIP       string
+	GatewayName    string
+	GatewayHash    string
+	GatewayAddress string
+	AuthDir        string
+	ExpiresAt      time.Time
+}
+
+// FASHandshakes holds verified FAS payloads by hid until a login uses
+// them, and then remembers the hid so the payload can't be replayed. They
+// are only kept in memory, so a restart forgets them.
+type FASHandshakes struct {
+	mu      sync.Mutex
+	pending map[string]*FASHandshake
+	used    map[string]*FASHandshake // ExpiresAt is when the hid may be forgotten
+}
+
+// NewFASHandshakes creates an empty handshake store
+func NewFASHandshakes() *FASHandshakes {
+	return &FASHandshakes{
+		pending: make(map[string]*FASHandshake),
+		used:    make(map[string]*FASHandshake),
+	}
+}
+
+// Record keeps a verified payload for its hid. A client may load the portal
+// again before logging in, so an unused hid is simply refreshed; a used one
+// is refused with ErrFASUsedHID. Expired entries are pruned.
+func (f *FASHandshakes) Record(hs FASHandshake) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	f.prune(now)
+	if _, ok := f.used[hs.HID]; ok {
+		return ErrFASUsedHID
+	}
+	hs.ExpiresAt = now.Add(FASHandshakeTTL)
+	f.pending[hs.HID] = &hs
+	return nil
+}
+
+// Lookup returns the verified payload for a hid, or ErrFASUnknownHID if
+// none was recorded or it expired. If a login already used it, the payload
+// is returned with ErrFASUsedHID, so a double submit can be recognized.
+func (f *FASHandshakes) Lookup(hid string) (FASHandshake, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if hs, ok := f.used[hid]; ok && now.Before(hs.ExpiresAt) {
+		return *hs, ErrFASUsedHID
+	}
+	hs, ok := f.pending[hid]
+	if !ok || now.After(hs.ExpiresAt) {
+		return FASHandshake{}, ErrFASUnknownHID
+	}
+	return *hs, nil
+}
+
+// Consume marks a hid as used once it has logged a client in
+func (f *FASHandshakes) Consume(hid string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hs, ok := f.pending[hid]
+	if !ok {
+		hs = &FASHandshake{HID: hid}
+	}
+	delete(f.pending, hid)
+	hs.ExpiresAt = time.Now().Add(FASUsedHIDTTL)
+	f.used[hid] = hs
+}
+
+// prune drops expired entries; f.mu must be held
+func (f *FASHandshakes) prune(now time.Time) {
+	for hid, hs := range f.pending {
+		if now.After(hs.ExpiresAt) {
+			delete(f.pending, hid)
+		}
+	}
+	for hid, hs := range f.used {
+		if now.After(hs.ExpiresAt) {
+			delete(f.used, hid)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Fixtures in openNDS's fas_secure_enabled 2/3 format: the payload text is
+// AES-256-CBC encrypted as PHP's openssl_encrypt does, base64-encoded, then
+// base64-encoded again for transport. They were made with
+//
+//	printf '%s' "$payload" | openssl enc -aes-256-cbc -K "$key" -iv "$iv" -base64 -A | base64 -w0
+//
+// keyed with the NUL-padded faskey or with the first 32 characters of its
+// SHA-256 hex digest.
+const (
+	testFASKey = "1234567890abcdef-parenta"
+	testFASIV2 = "4f8a1c2e9b7d3a60"
+	testFASIV3 = "b3e91d5c07f2a846"
+
+	testFASHid2 = "8c3f0e2ab5d94e17a6c1f2b3d4e5f607"
+	testFASHid3 = "17e4b9c0d2a35f68e9b1c4d7a0f3e6b2"
+
+	testFASPayload2 = "hid=8c3f0e2ab5d94e17a6c1f2b3d4e5f607, clientip=192.168.1.50, clientmac=a8:bb:cc:00:00:01, client_type=cpd_can, gatewayname=Parenta%20Home, gatewayurl=http%3a%2f%2fstatus.client, version=10.2.0, gatewayaddress=192.168.1.1:2050, gatewaymac=f4:ec:38:11:22:33, originurl=http%3a%2f%2fconnectivitycheck.gstatic.com%2fgenerate_204, clientif=br-lan, themespec=, "
+	testFASPayload3 = "hid=17e4b9c0d2a35f68e9b1c4d7a0f3e6b2, clientip=10.0.0.23, clientmac=a8:bb:cc:00:00:02, client_type=cpd_can, gatewayname=Parenta%20Home, gatewayurl=https%3a%2f%2fstatus.client, version=10.2.0, gatewayaddress=10.0.0.1:2050, gatewaymac=f4:ec:38:11:22:33, originurl=https%3a%2f%2fwww.apple.com%2flibrary%2ftest%2fsuccess.html, clientif=br-lan, themespec=, "
+
+	// Level 2 payload keyed with the padded faskey
+	testFASLevel2 = "RzFVL2RyMHZZdDZhbGdOWUVGQ2tOZGt6RkpGbzVVbktDUVJ1N1o5YXdPWHozamd5KzFwdWJjS25DMDFhN2lrRXErd2tCUVpQMmdvOGlrbkdVTXBYbEpYbk1iekYwa084Skh0VllUbk0zWVRYZWdUdUZjd2MxOXlIRHdmYjR2TzI2ZTJGVEJmNStSa1lkT0JVQ0R2TVgrVTI0bTN5UjhBVno1WTNzTUpPQzZBVHUyTllnVkUrazlBdjJXSUdpeVJTVXl0b0RxTDNBVStMeDh5Q0lrQk9OeHhsZ01IVGZEb0VlUm1QRllnYzBrQ2VMck1oOEIzQjcrRkxPVnAwUSt0VUR0WDVSOEVQcEN2VURKUjFkTVBQSmg4TWhrV29CTEtDdlNNWXhTWk1tYXlFejl6U2Z6MUdFUTlVcEFZdG5nV1hKMytHVzhvUlQ0aFBzVFFWL3hMTkpkU0pKYThtNUJ4RU1NVGJmbXVEV3RBMXRSSVVSdEhQaitDNDFXNzRzTTh4RTZaZXhUWVc1cDBaSlp5NDZFZlhGSERHYzlDOUJsbG5ZNUVDUEhvcDRycVN6SGlWY08yMndjV0h1bHEzS09FcHFXbmJEMnVpbVFCbGhaSFBsN3J6cWJ6aUpkUVJ5TDBiVDdoK0lyWFdJdFU9"
+	// Level 2 payload keyed with the faskey's digest
+	testFASLevel2Digest = "WHRWNmVRSDJLT08vN1VrRDhRczBhMFVMNWRoTWllems2YjJLOTdRNHdTMXVlV2xVUEUwRGE5cWZvOUdGWklSUUh3ZCs0aGRTaTNWN0hlZElZSjdobGN1RC8yNDhLdUR1dEZWV3MxNnRPc2RsRHUzazJUYlFLc1hzTEFIM25wazYzYWNrcDhWUnpvcHE3Um0xT1QxOUxyQVdPTm5UeHU5QVlST0svTHVGdFdHbzNhUm9aaDlVbm1pbGZURC9RbEl3dWk5Q2pid1piMURocVpRdytYaGo4WXhQcEg5UU80V2UwM1Y5SW9wd296VWw4QW1vaU1rM1JjM2x2Wk5DeEROZjZsbWJDREJQNDhsZ21uZHNDY2ZkM01yZkZQUlo5d2NYeU8yOHgwa0FIZmY4SXQ3dXZYelhPTE43T2VJeDNQY2t6U2ZNM0ZEVDNHb0lWWUswWWNtMXZvSG1IUzVWU1JjVHlYNjV2Vys4cDE2czlCN055VVJiZmZGMUlzbmQvQUR4TEdjR3NiVFFmTkxpNk5pNUprbmpFNVdXdkw4S2N3TkF0M2JaUUx1TWFndk9lQUgwWmkvS2M2aGd2cFdhV3JNcGdFZjQ2Zk9RVkR3b0ZDRExpWWxMQWwzNXYvcWdBQ3RZcWU5a05rU25iVUU9"
+	// Level 3 payload keyed with the faskey's digest
+	testFASLevel3 = "N29NWW5qaUh5Mm9ITWxYbko1eGlEV1VpOENOZW9ERzdBVlZ1UENRMyt1RGk2NnJsTVlDbkhSRFVWdUpIWVBqbGI5OEtCRkk0ZXZzMDE5MElIYmpocHRWN3NSdm9qRlFyZ3NxMDdRM0dGNk1VaWc1ZVlNd0ZFWVAxMDQ1bFJCZ2lnb3RpTTMvcTNmOWJTL2R0YkZ4ZHFSRmZaZ3RETnh5aHpkWTJVdkZLQTZQd0hzVW05d3NFYTQyNkZ6cGdkdnNsanhaNTlhU053R1UvZGFkWWVVQ3ExSDg0SkVoNDN2K05FYVJpQS9vUHVwVG44N2I3ejV5SVZack5BU3NwQnBYUDVlWlNvb3hBTVFrK0xXSmEyWGYxVDhraHNmTFU1K29SVWZydDBaaXc3MDhzbDRSUm5OeVI2bEpkMzY1SlpiOU9kM1UzVTgrL2MvTC8vRkQ4VVc4UkJxeTJyaCtmMytONk1pcEZqa1RIbGo1enJEWVhxa3dmemVXMTVWQ1FBMnhHbStzemdUS1p3Y2dKUHpSZ2RsV0VtUlhSSzJWSEhkUUJoeFJNZjUwL0todzdVc3BYS0x2alZWdE9qcDEvZDJUMTJxcmt3aWdmc1ZVN0tIeWx0MTgwRWtVWml3QmhqOW1rUVVzU3RObTBGMVU9"
+
+	// Two blocks, encrypted with -nopad, ending in the byte 0x11: more
+	// padding than a block holds
+	testFASBadPadding = "MW5qNk44aFRUWVhoZVRpd1VNMHNoYkZsQWo4dmdHZ3lzMXlHOGVBTi9Pcz0="
+	// A well-formed payload with no hid
+	testFASNoHid = "enhvbC9XV0tyclozN21uaXgwNkJDNS9IVUlwT1RGUkJZbEZtaXNDZ3o2VGZUYkpLQko1enliTEh2dTRQdHQvYmh5NFZielFPcWpNeVNZVDhPVDRYZVE9PQ=="
+)
+
+func TestDecryptFASPayload(t *testing.T) {
+	// The inner base64 alone, with '+' turned into spaces as query parsing does
+	inner, err := base64.StdEncoding.DecodeString(testFASLevel2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	singleEncoded := strings.ReplaceAll(string(inner), "+", " ")
+	if !strings.Contains(singleEncoded, " ") {
+		t.Fatal("fixture has no '+' to exercise")
+	}
+
+	tests := []struct {
+		name   string
+		fas    string
+		iv     string
+		faskey string
+		want   string // Empty when the payload must be rejected
+	}{
+		{"level 2", testFASLevel2, testFASIV2, testFASKey, testFASPayload2},
+		{"level 2 with the digest key", testFASLevel2Digest, testFASIV2, testFASKey, testFASPayload2},
+		{"level 3", testFASLevel3, testFASIV3, testFASKey, testFASPayload3},
+		{"single base64 with spaces for '+'", singleEncoded, testFASIV2, testFASKey, testFASPayload2},
+
+		{"wrong IV", testFASLevel2, testFASIV3, testFASKey, ""},
+		{"short IV", testFASLevel2, testFASIV2[:8], testFASKey, ""},
+		{"missing IV", testFASLevel3, "", testFASKey, ""},
+		{"bad padding", testFASBadPadding, testFASIV2, testFASKey, ""},
+		{"no hid", testFASNoHid, testFASIV2, testFASKey, ""},
+		{"wrong faskey", testFASLevel2, testFASIV2, "not-the-faskey", ""},
+		{"no faskey", testFASLevel2, testFASIV2, "", ""},
+		{"not base64", "hid=forged, clientmac=a8:bb:cc:00:00:01", testFASIV2, testFASKey, ""},
+		{"plain base64 payload", base64.StdEncoding.EncodeToString([]byte(testFASPayload2)), testFASIV2, testFASKey, ""},
+		{"truncated", base64.StdEncoding.EncodeToString(inner[:len(inner)-5]), testFASIV2, testFASKey, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecryptFASPayload(tt.fas, tt.iv, tt.faskey)
+			if tt.want == "" {
+				if !errors.Is(err, ErrFASPayload) {
+					t.Errorf("got %q, %v; want ErrFASPayload", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFASReturnHash(t *testing.T) {
+	// printf '%s%s' "$hid" "$faskey" | sha256sum
+	const want = "de4638d3861dd93331ce1e167c36994f5653906f832dd1831c735b2c7adda508"
+
+	tests := []struct {
+		name   string
+		hid    string
+		faskey string
+		want   bool
+	}{
+		{"hid and faskey", testFASHid2, testFASKey, true},
+		{"surrounding whitespace", " " + testFASHid2 + "\n", testFASKey + " ", true},
+		{"other hid", testFASHid3, testFASKey, false},
+		{"other faskey", testFASHid2, "not-the-faskey", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FASReturnHash(tt.hid, tt.faskey); (got == want) != tt.want {
+				t.Errorf("FASReturnHash(%q, %q) = %s", tt.hid, tt.faskey, got)
+			}
+		})
+	}
+}
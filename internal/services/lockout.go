@@ -0,0 +1,319 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"parenta/internal/models"
+)
+
+// ErrAccountLocked is returned (wrapped in a LockedError) when logins are
+// refused because of too many failed attempts
+var ErrAccountLocked = errors.New("too many failed login attempts")
+
+// maxLockout caps the exponential backoff
+const maxLockout = 24 * time.Hour
+
+// LockedError reports when a locked account or IP may try again
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s, try again after %s", ErrAccountLocked, e.Until.Format(time.RFC3339))
+}
+
+// Is makes errors.Is(err, ErrAccountLocked) match
+func (e *LockedError) Is(target error) bool {
+	return target == ErrAccountLocked
+}
+
+// Lockout kinds
+const (
+	LockoutAccount       = "account"        // An admin account
+	LockoutAdminIP       = "admin_ip"       // A source IP, for admin logins
+	LockoutChildPassword = "child_password" // A source IP, for child password changes
+)
+
+// Lockout is an account or source IP currently refused after failed logins
+type Lockout struct {
+	Kind        string    `json:"kind"`
+	Username    string    `json:"username"` // For an IP, the last username tried from it
+	IP          string    `json:"ip,omitempty"`
+	LockedUntil time.Time `json:"locked_until"`
+	FailedCount int       `json:"failed_count"`
+}
+
+// LoginAttempt describes where a login came from
+type LoginAttempt struct {
+	IP        string
+	UserAgent string
+}
+
+// ipAttempts tracks consecutive failures from one source IP
+type ipAttempts struct {
+	failures     int
+	lockedUntil  time.Time
+	lastUsername string
+}
+
+// loginLimiter holds the lockout policy and per-IP state (in memory only)
+type loginLimiter struct {
+	maxAttempts int
+	cooldown    time.Duration
+
+	mu  sync.Mutex
+	ips map[string]*ipAttempts
+}
+
+// lockDuration returns the lockout for a failure count, doubling for every
+// further maxAttempts failures. Returns 0 if the count doesn't trigger a lock.
+func (l *loginLimiter) lockDuration(failures int) time.Duration {
+	if l.maxAttempts <= 0 || failures < l.maxAttempts || failures%l.maxAttempts != 0 {
+		return 0
+	}
+
+	d := l.cooldown
+	for i := failures/l.maxAttempts - 1; i > 0 && d < maxLockout; i-- {
+		d *= 2
+	}
+	if d > maxLockout {
+		d = maxLockout
+	}
+	return d
+}
+
+// lockedUntil returns when an IP's lockout ends, or the zero time if it
+// isn't locked
+func (l *loginLimiter) lockedUntil(ip string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if state, ok := l.ips[ip]; ok && time.Now().Before(state.lockedUntil) {
+		return state.lockedUntil
+	}
+	return time.Time{}
+}
+
+// fail counts a failure from an IP, returning when the lockout it triggers
+// ends, or the zero time
+func (l *loginLimiter) fail(ip, username string) time.Time {
+	if ip == "" {
+		return time.Time{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.ips[ip]
+	if state == nil {
+		state = &ipAttempts{}
+		l.ips[ip] = state
+	}
+	state.failures++
+	state.lastUsername = username
+	if d := l.lockDuration(state.failures); d > 0 {
+		state.lockedUntil = time.Now().Add(d)
+		return state.lockedUntil
+	}
+	return time.Time{}
+}
+
+// reset forgets an IP's failures
+func (l *loginLimiter) reset(ip string) {
+	l.mu.Lock()
+	delete(l.ips, ip)
+	l.mu.Unlock()
+}
+
+// locked lists the IPs locked now as lockouts of kind
+func (l *loginLimiter) locked(kind string) []Lockout {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var result []Lockout
+	now := time.Now()
+	for ip, state := range l.ips {
+		if now.Before(state.lockedUntil) {
+			result = append(result, Lockout{
+				Kind:        kind,
+				Username:    state.lastUsername,
+				IP:          ip,
+				LockedUntil: state.lockedUntil,
+				FailedCount: state.failures,
+			})
+		}
+	}
+	return result
+}
+
+// forget drops the failures of IPs whose last attempt was on username.
+// Returns how many of them were locked.
+func (l *loginLimiter) forget(username string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	locked := 0
+	now := time.Now()
+	for ip, state := range l.ips {
+		if state.lastUsername == username {
+			if now.Before(state.lockedUntil) {
+				locked++
+			}
+			delete(l.ips, ip)
+		}
+	}
+	return locked
+}
+
+// AuthenticateAdmin verifies admin credentials, enforcing account and
+// source-IP lockout and recording the attempt in the audit log
+func (a *AuthService) AuthenticateAdmin(username, password string, attempt LoginAttempt) (*models.User, error) {
+	if until := a.ipLockedUntil(attempt.IP); !until.IsZero() {
+		a.audit(models.AuditLoginLocked, username, attempt, "source IP locked")
+		return nil, &LockedError{Until: until}
+	}
+
+	admin := a.storage.GetAdminByUsername(username)
+	if admin != nil && admin.IsLocked() {
+		a.audit(models.AuditLoginLocked, username, attempt, "account locked")
+		return nil, &LockedError{Until: admin.LockedUntil}
+	}
+
+	if admin == nil || !CheckPassword(password, admin.PasswordHash) {
+		return nil, a.recordFailure(admin, username, attempt)
+	}
+
+	// Success resets both counters
+	if admin.FailedLogins > 0 || !admin.LockedUntil.IsZero() {
+		admin.FailedLogins = 0
+		admin.LockedUntil = time.Time{}
+		if err := a.storage.SaveAdmin(admin); err != nil {
+			log.Printf("Failed to reset login counter for %s: %v", admin.Username, err)
+		}
+	}
+	a.limiter.reset(attempt.IP)
+
+	a.audit(models.AuditLoginSuccess, username, attempt, "")
+	return admin, nil
+}
+
+// recordFailure bumps the account and IP counters, locking either when the
+// threshold is reached. Returns the error to hand back to the caller.
+func (a *AuthService) recordFailure(admin *models.User, username string, attempt LoginAttempt) error {
+	var lockedUntil time.Time
+
+	if admin != nil {
+		admin.FailedLogins++
+		if d := a.limiter.lockDuration(admin.FailedLogins); d > 0 {
+			admin.LockedUntil = time.Now().Add(d)
+			lockedUntil = admin.LockedUntil
+		}
+		if err := a.storage.SaveAdmin(admin); err != nil {
+			log.Printf("Failed to record login failure for %s: %v", admin.Username, err)
+		}
+	}
+
+	if until := a.limiter.fail(attempt.IP, username); until.After(lockedUntil) {
+		lockedUntil = until
+	}
+
+	a.audit(models.AuditLoginFailure, username, attempt, "")
+
+	if !lockedUntil.IsZero() {
+		log.Printf("Admin login locked until %s (user %q, IP %s)", lockedUntil.Format(time.RFC3339), username, attempt.IP)
+		return &LockedError{Until: lockedUntil}
+	}
+	return ErrInvalidCredentials
+}
+
+// ipLockedUntil returns when an IP's lockout ends, or the zero time if it isn't locked
+func (a *AuthService) ipLockedUntil(ip string) time.Time {
+	return a.limiter.lockedUntil(ip)
+}
+
+// UnlockAdmin clears an admin's lockout and any IP lockouts caused by
+// attempts on that account
+func (a *AuthService) UnlockAdmin(id, unlockedBy string, attempt LoginAttempt) error {
+	admin := a.storage.GetAdminByID(id)
+	if admin == nil {
+		return ErrUserNotFound
+	}
+
+	admin.FailedLogins = 0
+	admin.LockedUntil = time.Time{}
+	if err := a.storage.SaveAdmin(admin); err != nil {
+		return err
+	}
+
+	a.limiter.forget(admin.Username)
+
+	a.audit(models.AuditAdminUnlock, admin.Username, attempt, "unlocked by "+unlockedBy)
+	return nil
+}
+
+// Lockouts lists the admin accounts and source IPs locked now, the ones
+// locked longest first
+func (a *AuthService) Lockouts() []Lockout {
+	result := []Lockout{}
+	for _, admin := range a.storage.ListAdmins() {
+		if admin.IsLocked() {
+			result = append(result, Lockout{
+				Kind:        LockoutAccount,
+				Username:    admin.Username,
+				LockedUntil: admin.LockedUntil,
+				FailedCount: admin.FailedLogins,
+			})
+		}
+	}
+	result = append(result, a.limiter.locked(LockoutAdminIP)...)
+	result = append(result, a.childLimiter.locked(LockoutChildPassword)...)
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LockedUntil.After(result[j].LockedUntil)
+	})
+	return result
+}
+
+// ClearLockouts unlocks a username: the admin account of that name, if
+// there is one, and any source IP whose last failed attempt was on it.
+// Failure counts start again from zero. Returns how many lockouts were
+// lifted.
+func (a *AuthService) ClearLockouts(username, clearedBy string, attempt LoginAttempt) (int, error) {
+	cleared := 0
+	if admin := a.storage.GetAdminByUsername(username); admin != nil && (admin.FailedLogins > 0 || !admin.LockedUntil.IsZero()) {
+		if admin.IsLocked() {
+			cleared++
+		}
+		admin.FailedLogins = 0
+		admin.LockedUntil = time.Time{}
+		if err := a.storage.SaveAdmin(admin); err != nil {
+			return 0, err
+		}
+	}
+	cleared += a.limiter.forget(username)
+	cleared += a.childLimiter.forget(username)
+
+	a.audit(models.AuditLockoutClear, username, attempt, fmt.Sprintf("%d cleared by %s", cleared, clearedBy))
+	return cleared, nil
+}
+
+// audit records an event in the audit log
+func (a *AuthService) audit(action, username string, attempt LoginAttempt, detail string) {
+	entry := &models.AuditEntry{
+		ID:        GenerateID(),
+		Time:      time.Now(),
+		Action:    action,
+		Username:  username,
+		IP:        attempt.IP,
+		UserAgent: attempt.UserAgent,
+		Detail:    detail,
+	}
+	if err := a.storage.AddAuditEntry(entry); err != nil {
+		log.Printf("Failed to write audit entry: %v", err)
+	}
+}
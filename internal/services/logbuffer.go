@@ -0,0 +1,109 @@
+package services
+
+import (
+	"strings"
+	"sync"
+)
+
+// LogBuffer keeps the most recent lines of Parenta's own log output and
+// passes new ones to subscribers, so logs can be viewed on routers without
+// syslog. Install it with log.SetOutput alongside stderr.
+type LogBuffer struct {
+	mu      sync.Mutex
+	lines   []string // Ring of the last cap(lines) lines
+	next    int      // Index the next line is written to
+	full    bool
+	partial string // Output after the last newline
+	subs    map[chan string]struct{}
+}
+
+// logSubscriberBuffer is how many lines a slow subscriber may fall behind
+// before lines are dropped for it
+const logSubscriberBuffer = 256
+
+// NewLogBuffer creates a LogBuffer holding up to size lines
+func NewLogBuffer(size int) *LogBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &LogBuffer{
+		lines: make([]string, size),
+		subs:  make(map[chan string]struct{}),
+	}
+}
+
+// Write implements io.Writer. Each complete line is prefixed with
+// "parenta: ", so it matches the filter syslog lines from Parenta match.
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := b.partial + string(p)
+	for {
+		i := strings.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		b.add("parenta: " + data[:i])
+		data = data[i+1:]
+	}
+	b.partial = data
+	return len(p), nil
+}
+
+// add stores a line and sends it to subscribers. Caller must hold mu.
+func (b *LogBuffer) add(line string) {
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- line:
+		default:
+			// A subscriber that isn't keeping up misses lines rather than
+			// blocking logging
+		}
+	}
+}
+
+// Lines returns the buffered lines, oldest first
+func (b *LogBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+	result := make([]string, 0, len(b.lines))
+	result = append(result, b.lines[b.next:]...)
+	return append(result, b.lines[:b.next]...)
+}
+
+// Subscribe returns a channel receiving each new line and a function that
+// ends the subscription. The channel is closed once cancel is called.
+func (b *LogBuffer) Subscribe() (<-chan string, func()) {
+	ch := make(chan string, logSubscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Subscribers returns how many subscriptions are open
+func (b *LogBuffer) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
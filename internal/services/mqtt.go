@@ -0,0 +1,439 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"parenta/internal/models"
+	"parenta/internal/storage"
+)
+
+// MQTT bridge limits and timings
+const (
+	// MaxMQTTAdjustMinutes bounds an adjust_quota command either way
+	MaxMQTTAdjustMinutes = 720
+
+	// mqttPollInterval is how often state is republished if nothing else
+	// triggers it, so logins show up between ticks
+	mqttPollInterval = 10 * time.Second
+
+	// mqttMaxBackoff caps the wait between reconnection attempts, which
+	// doubles from a second
+	mqttMaxBackoff = 2 * time.Minute
+
+	mqttPublishTimeout = 5 * time.Second
+)
+
+// MQTTOptions configures the MQTT bridge
+type MQTTOptions struct {
+	Broker   string // tcp://, ssl://, ws:// or wss:// URL
+	Username string
+	Password string
+	ClientID string
+
+	// Root of the state and command topics, and of Home Assistant discovery
+	// configs; a DiscoveryPrefix of "-" turns discovery off
+	TopicPrefix     string
+	DiscoveryPrefix string
+
+	// PEM CA certificate for a TLS broker, instead of the system roots
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+// mqttChildState is what has been published for a child
+type mqttChildState struct {
+	Online    bool
+	Paused    bool
+	Remaining int
+}
+
+// MQTTBridge publishes each child's state to an MQTT broker as retained
+// topics and acts on commands from it, for Home Assistant:
+//
+//	<prefix>/child/<id>/online             ON or OFF, whether a session is open
+//	<prefix>/child/<id>/remaining_minutes  minutes left today
+//	<prefix>/child/<id>/paused             ON or OFF, whether the account is disabled
+//	<prefix>/child/<id>/set_pause          command: ON, OFF, true, false, 1 or 0
+//	<prefix>/child/<id>/adjust_quota       command: minutes to add, negative to take away
+//
+// <prefix>/status is "online" while the bridge is connected and "offline"
+// otherwise.
+type MQTTBridge struct {
+	storage *storage.Storage
+	ticker  *SessionTicker
+	opts    MQTTOptions
+	client  mqtt.Client
+
+	refresh chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+
+	// State published for each child, kept by the publishing loop. resend
+	// is set on connecting to have everything sent again.
+	published map[string]mqttChildState
+	resend    atomic.Bool
+}
+
+// NewMQTTBridge creates a bridge. It fails if the CA file can't be read.
+func NewMQTTBridge(store *storage.Storage, ticker *SessionTicker, opts MQTTOptions) (*MQTTBridge, error) {
+	b := &MQTTBridge{
+		storage:   store,
+		ticker:    ticker,
+		opts:      opts,
+		refresh:   make(chan struct{}, 1),
+		published: make(map[string]mqttChildState),
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	clientOpts := mqtt.NewClientOptions().
+		AddBroker(opts.Broker).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetTLSConfig(tlsConfig).
+		SetCleanSession(true).
+		SetOrderMatters(false).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(mqttMaxBackoff).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10*time.Second).
+		SetWill(b.statusTopic(), "offline", 1, true).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("MQTT connection lost: %v", err)
+		})
+	b.client = mqtt.NewClient(clientOpts)
+	return b, nil
+}
+
+// Start connects to the broker in the background, retrying until it
+// succeeds, and begins publishing. Have the ticker call Refresh so changes
+// are published as they happen.
+func (b *MQTTBridge) Start() {
+	b.stop, b.done = make(chan struct{}), make(chan struct{})
+	b.client.Connect()
+	go b.loop()
+	log.Printf("MQTT bridge connecting to %s", b.opts.Broker)
+}
+
+// Stop marks the bridge offline and disconnects cleanly
+func (b *MQTTBridge) Stop() {
+	if b.stop == nil {
+		return
+	}
+	close(b.stop)
+	<-b.done
+
+	if b.client.IsConnectionOpen() {
+		b.publish(b.statusTopic(), "offline")
+	}
+	b.client.Disconnect(250)
+}
+
+// Refresh republishes children's state soon. It doesn't block.
+func (b *MQTTBridge) Refresh() {
+	select {
+	case b.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// loop publishes state when asked to and every mqttPollInterval
+func (b *MQTTBridge) loop() {
+	defer close(b.done)
+	poll := time.NewTicker(mqttPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-poll.C:
+		case <-b.refresh:
+		}
+		b.publishState()
+	}
+}
+
+// onConnect subscribes to the command topics and has everything published
+// again, as the broker may have lost retained messages
+func (b *MQTTBridge) onConnect(client mqtt.Client) {
+	log.Printf("MQTT connected to %s", b.opts.Broker)
+
+	filters := map[string]byte{
+		b.opts.TopicPrefix + "/child/+/set_pause":    1,
+		b.opts.TopicPrefix + "/child/+/adjust_quota": 1,
+	}
+	if token := client.SubscribeMultiple(filters, b.handleCommand); token.WaitTimeout(mqttPublishTimeout) && token.Error() != nil {
+		log.Printf("MQTT subscribe failed: %v", token.Error())
+	}
+
+	b.resend.Store(true)
+	b.publish(b.statusTopic(), "online")
+	b.Refresh()
+}
+
+// publishState publishes the state of children whose state changed, with
+// discovery configs for new children, and clears the topics of deleted
+// children
+func (b *MQTTBridge) publishState() {
+	if !b.client.IsConnectionOpen() {
+		return
+	}
+	if b.resend.Swap(false) {
+		clear(b.published)
+	}
+
+	seen := make(map[string]bool)
+	for _, child := range b.storage.ListChildren() {
+		seen[child.ID] = true
+		state := mqttChildState{
+			Online:    len(b.storage.ListChildSessions(child.ID)) > 0,
+			Paused:    !child.IsActive,
+			Remaining: child.RemainingMinutes(),
+		}
+
+		last, ok := b.published[child.ID]
+		if !ok {
+			b.publishDiscovery(child)
+		}
+		if ok && last == state {
+			continue
+		}
+		if !ok || last.Online != state.Online {
+			b.publish(b.childTopic(child.ID, "online"), onOff(state.Online))
+		}
+		if !ok || last.Paused != state.Paused {
+			b.publish(b.childTopic(child.ID, "paused"), onOff(state.Paused))
+		}
+		if !ok || last.Remaining != state.Remaining {
+			b.publish(b.childTopic(child.ID, "remaining_minutes"), strconv.Itoa(state.Remaining))
+		}
+		b.published[child.ID] = state
+	}
+
+	for id := range b.published {
+		if seen[id] {
+			continue
+		}
+		for _, name := range []string{"online", "paused", "remaining_minutes"} {
+			b.publish(b.childTopic(id, name), "")
+		}
+		for _, entity := range mqttEntities {
+			if b.discoveryEnabled() {
+				b.publish(b.discoveryTopic(id, entity), "")
+			}
+		}
+		delete(b.published, id)
+	}
+}
+
+// handleCommand acts on a set_pause or adjust_quota message as the admin API
+// would. Invalid commands are logged and ignored.
+func (b *MQTTBridge) handleCommand(_ mqtt.Client, msg mqtt.Message) {
+	// <prefix>/child/<id>/<command>
+	rest := strings.TrimPrefix(msg.Topic(), b.opts.TopicPrefix+"/child/")
+	id, command, ok := strings.Cut(rest, "/")
+	if !ok || id == "" {
+		return
+	}
+	payload := strings.TrimSpace(string(msg.Payload()))
+
+	var err error
+	switch command {
+	case "set_pause":
+		err = b.setPaused(id, payload)
+	case "adjust_quota":
+		err = b.adjustQuota(id, payload)
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("MQTT %s for child %s ignored: %v", command, id, err)
+		return
+	}
+	b.Refresh()
+}
+
+// setPaused disables or re-enables a child's account. Pausing ends their
+// sessions right away, as deactivating them through the API does.
+func (b *MQTTBridge) setPaused(id, payload string) error {
+	paused, err := parseOnOff(payload)
+	if err != nil {
+		return err
+	}
+	child := b.storage.GetChild(id)
+	if child == nil {
+		return errors.New("child not found")
+	}
+
+	child.IsActive = !paused
+	child.UpdatedAt = time.Now()
+	if err := b.storage.SaveChild(child); err != nil {
+		return err
+	}
+	if paused {
+		if n := b.ticker.EndChildSessions(child.ID, "deactivated"); n > 0 {
+			log.Printf("Child %s paused over MQTT, ended %d session(s)", child.Name, n)
+		}
+	}
+	return nil
+}
+
+// adjustQuota gives a child more time today, or takes time away, as
+// POST /api/children/{id}/adjust-quota does
+func (b *MQTTBridge) adjustQuota(id, payload string) error {
+	minutes, err := strconv.Atoi(payload)
+	if err != nil || minutes == 0 || minutes < -MaxMQTTAdjustMinutes || minutes > MaxMQTTAdjustMinutes {
+		return fmt.Errorf("%q must be a whole number of minutes between -%d and %d, other than 0", payload, MaxMQTTAdjustMinutes, MaxMQTTAdjustMinutes)
+	}
+	child := b.storage.GetChild(id)
+	if child == nil {
+		return errors.New("child not found")
+	}
+
+	child.AdjustQuota(minutes)
+	child.UpdatedAt = time.Now()
+	return b.storage.SaveChild(child)
+}
+
+// mqttEntities are the Home Assistant entities announced for each child
+var mqttEntities = []string{"online", "remaining_minutes", "paused", "adjust_quota"}
+
+// publishDiscovery announces a child's entities to Home Assistant, grouped
+// as one device per child
+func (b *MQTTBridge) publishDiscovery(child *models.Child) {
+	if !b.discoveryEnabled() {
+		return
+	}
+
+	device := map[string]any{
+		"identifiers":  []string{"parenta_" + child.ID},
+		"name":         child.Name,
+		"manufacturer": "Parenta",
+		"model":        "Child account",
+	}
+	for _, entity := range mqttEntities {
+		config := map[string]any{
+			"unique_id":          "parenta_" + child.ID + "_" + entity,
+			"device":             device,
+			"availability_topic": b.statusTopic(),
+		}
+		switch entity {
+		case "online":
+			config["name"] = "Online"
+			config["state_topic"] = b.childTopic(child.ID, "online")
+			config["device_class"] = "connectivity"
+		case "remaining_minutes":
+			config["name"] = "Remaining time"
+			config["state_topic"] = b.childTopic(child.ID, "remaining_minutes")
+			config["unit_of_measurement"] = "min"
+			config["device_class"] = "duration"
+		case "paused":
+			config["name"] = "Paused"
+			config["state_topic"] = b.childTopic(child.ID, "paused")
+			config["command_topic"] = b.childTopic(child.ID, "set_pause")
+			config["icon"] = "mdi:pause-circle"
+		case "adjust_quota":
+			config["name"] = "Adjust time"
+			config["command_topic"] = b.childTopic(child.ID, "adjust_quota")
+			config["min"] = -MaxMQTTAdjustMinutes
+			config["max"] = MaxMQTTAdjustMinutes
+			config["step"] = 5
+			config["mode"] = "box"
+			config["unit_of_measurement"] = "min"
+		}
+
+		payload, err := json.Marshal(config)
+		if err != nil {
+			continue
+		}
+		b.publish(b.discoveryTopic(child.ID, entity), string(payload))
+	}
+}
+
+// publish sends a retained message, logging failures
+func (b *MQTTBridge) publish(topic, payload string) {
+	token := b.client.Publish(topic, 1, true, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		log.Printf("MQTT publish to %s timed out", topic)
+		return
+	}
+	if err := token.Error(); err != nil {
+		log.Printf("MQTT publish to %s failed: %v", topic, err)
+	}
+}
+
+// statusTopic is the bridge's availability topic
+func (b *MQTTBridge) statusTopic() string {
+	return b.opts.TopicPrefix + "/status"
+}
+
+// childTopic is one of a child's state or command topics
+func (b *MQTTBridge) childTopic(id, name string) string {
+	return b.opts.TopicPrefix + "/child/" + id + "/" + name
+}
+
+// discoveryEnabled reports whether Home Assistant discovery configs are
+// published
+func (b *MQTTBridge) discoveryEnabled() bool {
+	return b.opts.DiscoveryPrefix != "" && b.opts.DiscoveryPrefix != "-"
+}
+
+// discoveryTopic is the Home Assistant discovery config topic of one of a
+// child's entities
+func (b *MQTTBridge) discoveryTopic(id, entity string) string {
+	component := "sensor"
+	switch entity {
+	case "online":
+		component = "binary_sensor"
+	case "paused":
+		component = "switch"
+	case "adjust_quota":
+		component = "number"
+	}
+	return fmt.Sprintf("%s/%s/parenta_%s/%s/config", b.opts.DiscoveryPrefix, component, id, entity)
+}
+
+// onOff formats a boolean state the way Home Assistant expects
+func onOff(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// parseOnOff parses a set_pause payload
+func parseOnOff(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q must be ON or OFF", s)
+}
@@ -1,25 +1,121 @@
 package services
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
+	"net"
+	"sort"
 	"strings"
+	"time"
+
+	"parenta/internal/models"
 )
 
+// NDSClient controls one openNDS instance. *NDSCtl implements it.
+type NDSClient interface {
+	Auth(mac string, sessionMinutes, uploadKbps, downloadKbps int) error
+	Deauth(macOrIP string) error
+	DeauthBest(session *models.Session) error
+	JSON() ([]ClientInfo, error)
+	GetClient(mac string) (*ClientInfo, error)
+	Status() (string, error)
+	IsRunning() bool
+}
+
 // NDSCtl wraps the ndsctl command-line tool
 type NDSCtl struct {
 	binaryPath string
+	socket     string // Control socket of the openNDS instance (-s); empty uses ndsctl's default
+	runner     CommandRunner
 }
 
 // NewNDSCtl creates a new NDSCtl instance
 func NewNDSCtl(binaryPath string) *NDSCtl {
 	return &NDSCtl{
 		binaryPath: binaryPath,
+		runner:     ExecRunner{},
+	}
+}
+
+// NDSCtlPool holds one NDSCtl per openNDS gateway, keyed by gateway IP
+type NDSCtlPool struct {
+	clients  map[string]*NDSCtl
+	fallback *NDSCtl
+}
+
+// NewNDSCtlPool creates an NDSCtl for each gateway. Gateways without an entry
+// in paths use defaultPath, and those without one in sockets use ndsctl's
+// default control socket.
+func NewNDSCtlPool(defaultPath string, gatewayIPs []string, paths, sockets map[string]string) *NDSCtlPool {
+	pool := &NDSCtlPool{
+		clients:  make(map[string]*NDSCtl),
+		fallback: NewNDSCtl(defaultPath),
+	}
+	for _, ip := range gatewayIPs {
+		path, socket := paths[ip], sockets[ip]
+		if path == "" && socket == "" {
+			pool.clients[ip] = pool.fallback
+			continue
+		}
+		if path == "" {
+			path = defaultPath
+		}
+		pool.clients[ip] = &NDSCtl{binaryPath: path, socket: socket, runner: ExecRunner{}}
+	}
+	return pool
+}
+
+// SetRunner makes every NDSCtl in the pool run ndsctl through r
+func (p *NDSCtlPool) SetRunner(r CommandRunner) {
+	p.fallback.runner = r
+	for _, n := range p.clients {
+		n.runner = r
 	}
 }
 
+// Get returns the NDSCtl for a gateway IP, or the default instance if unknown
+func (p *NDSCtlPool) Get(gatewayIP string) NDSClient {
+	if n, ok := p.clients[gatewayIP]; ok {
+		return n
+	}
+	return p.fallback
+}
+
+// Default returns the NDSCtl for the default ndsctl binary
+func (p *NDSCtlPool) Default() NDSClient {
+	return p.fallback
+}
+
+// ForSession returns the NDSCtl of the gateway a session logged in through.
+// Sessions recorded before gateways were tracked use the default instance.
+func (p *NDSCtlPool) ForSession(session *models.Session) NDSClient {
+	return p.Get(session.GatewayIP)
+}
+
+// All returns each distinct NDSCtl once, the default instance first
+func (p *NDSCtlPool) All() []NDSClient {
+	all := []NDSClient{p.fallback}
+	seen := map[*NDSCtl]bool{p.fallback: true}
+	for _, ip := range sortedKeys(p.clients) {
+		if n := p.clients[ip]; !seen[n] {
+			seen[n] = true
+			all = append(all, n)
+		}
+	}
+	return all
+}
+
+// sortedKeys returns a map's keys in order, for a stable iteration
+func sortedKeys(m map[string]*NDSCtl) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // ClientInfo represents an authenticated client from ndsctl json output
 type ClientInfo struct {
 	ClientType string `json:"client_type"`
@@ -36,6 +132,9 @@ type ClientInfo struct {
 // sessionMinutes: session duration in minutes (0 = unlimited)
 // uploadKbps/downloadKbps: bandwidth limits in Kbps (0 = unlimited)
 func (n *NDSCtl) Auth(mac string, sessionMinutes, uploadKbps, downloadKbps int) error {
+	if !models.IsValidMAC(mac) {
+		return fmt.Errorf("ndsctl auth: invalid MAC %q", mac)
+	}
 	// ndsctl auth mac sessiontimeout uploadrate downloadrate uploadquota downloadquota customstring
 	args := []string{
 		"auth",
@@ -52,9 +151,102 @@ func (n *NDSCtl) Auth(mac string, sessionMinutes, uploadKbps, downloadKbps int)
 
 // Deauth removes authentication for a client
 func (n *NDSCtl) Deauth(macOrIP string) error {
+	if !models.IsValidMAC(macOrIP) && net.ParseIP(macOrIP) == nil {
+		return fmt.Errorf("ndsctl deauth: invalid MAC or IP %q", macOrIP)
+	}
 	return n.exec("deauth", macOrIP)
 }
 
+// DeauthBest takes a session's device offline. It deauths by MAC, falling
+// back to the IP when the MAC is empty or rejected, then checks the client
+// list to confirm the device is gone and retries once if it isn't. If the
+// list can't be read, the deauth result is returned unverified.
+func (n *NDSCtl) DeauthBest(session *models.Session) error {
+	if session.MAC == "" && session.IP == "" {
+		return errors.New("session has no MAC or IP")
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := n.deauthMACOrIP(session)
+
+		clients, listErr := n.JSON()
+		if listErr != nil {
+			return err
+		}
+		if !clientAuthenticated(clients, session) {
+			return nil
+		}
+		if attempt == 2 {
+			if err == nil {
+				err = fmt.Errorf("client %s still authenticated after deauth", session.MAC)
+			}
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// deauthMACOrIP deauths by MAC, then by IP if that fails
+func (n *NDSCtl) deauthMACOrIP(session *models.Session) error {
+	var err error
+	if session.MAC != "" {
+		if err = n.Deauth(session.MAC); err == nil {
+			return nil
+		}
+	}
+	if session.IP != "" {
+		err = n.Deauth(session.IP)
+	}
+	return err
+}
+
+// clientAuthenticated reports whether the session's MAC or IP is still an
+// authenticated client
+func clientAuthenticated(clients []ClientInfo, session *models.Session) bool {
+	for _, c := range clients {
+		if !strings.EqualFold(c.State, "Authenticated") {
+			continue
+		}
+		if session.MAC != "" && strings.EqualFold(c.MAC, session.MAC) {
+			return true
+		}
+		if session.IP != "" && c.IP == session.IP {
+			return true
+		}
+	}
+	return false
+}
+
+// GatewayInfo is what openNDS reports about the network it manages
+type GatewayInfo struct {
+	Interface string
+	Address   string
+	Version   string
+}
+
+// ParseGatewayInfo reads the managed interface, its IPv4 address and the
+// openNDS version from ndsctl status output. Fields not found are empty.
+func ParseGatewayInfo(status string) GatewayInfo {
+	var info GatewayInfo
+	for _, line := range strings.Split(status, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "managed interface":
+			info.Interface = value
+		case "managed ipv4 address":
+			// Some versions append the prefix length
+			info.Address, _, _ = strings.Cut(value, "/")
+		case "version":
+			info.Version = value
+		}
+	}
+	return info
+}
+
 // Status returns the current openNDS status as a string
 func (n *NDSCtl) Status() (string, error) {
 	return n.execOutput("status")
@@ -81,6 +273,21 @@ func (n *NDSCtl) JSON() ([]ClientInfo, error) {
 	return clients, nil
 }
 
+// GetClient returns the openNDS client with the given MAC, or nil if
+// openNDS doesn't list it
+func (n *NDSCtl) GetClient(mac string) (*ClientInfo, error) {
+	clients, err := n.JSON()
+	if err != nil {
+		return nil, err
+	}
+	for i := range clients {
+		if strings.EqualFold(clients[i].MAC, mac) {
+			return &clients[i], nil
+		}
+	}
+	return nil, nil
+}
+
 // IsRunning checks if openNDS is running
 func (n *NDSCtl) IsRunning() bool {
 	_, err := n.execOutput("status")
@@ -89,33 +296,19 @@ func (n *NDSCtl) IsRunning() bool {
 
 // exec runs ndsctl with the given arguments
 func (n *NDSCtl) exec(args ...string) error {
-	cmd := exec.Command(n.binaryPath, args...)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		errMsg := strings.TrimSpace(stderr.String())
-		if errMsg != "" {
-			return fmt.Errorf("ndsctl %s: %s", args[0], errMsg)
-		}
-		return fmt.Errorf("ndsctl %s: %w", args[0], err)
-	}
-	return nil
+	_, err := n.execOutput(args...)
+	return err
 }
 
-// execOutput runs ndsctl and returns stdout
+// execOutput runs ndsctl against the instance's socket and returns stdout
 func (n *NDSCtl) execOutput(args ...string) (string, error) {
-	cmd := exec.Command(n.binaryPath, args...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		errMsg := strings.TrimSpace(stderr.String())
-		if errMsg != "" {
-			return "", fmt.Errorf("ndsctl %s: %s", args[0], errMsg)
-		}
+	cmdArgs := args
+	if n.socket != "" {
+		cmdArgs = append([]string{"-s", n.socket}, args...)
+	}
+	output, err := n.runner.Run(n.binaryPath, cmdArgs...)
+	if err != nil {
 		return "", fmt.Errorf("ndsctl %s: %w", args[0], err)
 	}
-	return strings.TrimSpace(stdout.String()), nil
+	return output, nil
 }
@@ -0,0 +1,398 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"parenta/internal/models"
+	"parenta/internal/storage"
+)
+
+const (
+	// notificationQueueSize bounds the deliveries waiting to be sent; more
+	// are dropped rather than hold up the ticker or a login
+	notificationQueueSize = 100
+
+	// notificationAttempts is how often a delivery is tried, waiting
+	// notificationRetryDelay times the attempt number in between
+	notificationAttempts   = 3
+	notificationRetryDelay = 10 * time.Second
+
+	// notificationTimeout limits a single send
+	notificationTimeout = 15 * time.Second
+
+	// notificationTestTimeout limits a test send, so a bad URL fails fast
+	notificationTestTimeout = 5 * time.Second
+
+	// failedLoginBurst failed portal logins from one device within
+	// failedLoginWindow send a failed_logins notification
+	failedLoginBurst  = 5
+	failedLoginWindow = 10 * time.Minute
+
+	// healthCheckInterval is how often health is checked for transitions
+	healthCheckInterval = 5 * time.Minute
+)
+
+// ErrChannelNotConfigured is returned when sending through a channel whose
+// settings are incomplete
+var ErrChannelNotConfigured = errors.New("channel is not configured")
+
+// Notification is a message about one event
+type Notification struct {
+	Event   models.NotificationEvent `json:"event"`
+	Title   string                   `json:"title"`
+	Message string                   `json:"message"`
+	Data    map[string]any           `json:"data,omitempty"`
+	Time    time.Time                `json:"time"`
+
+	// HTML version of the message for email, if there is one
+	HTML string `json:"-"`
+}
+
+// NewNotification creates a notification stamped with the current time
+func NewNotification(event models.NotificationEvent, title, message string, data map[string]any) Notification {
+	return Notification{Event: event, Title: title, Message: message, Data: data, Time: time.Now()}
+}
+
+// NotificationChannel delivers notifications somewhere. Send returns the
+// HTTP status of the delivery for channels that have one, or 0.
+type NotificationChannel interface {
+	Send(ctx context.Context, n Notification) (int, error)
+}
+
+// HealthCheck reports the system health ("healthy", "degraded" or "down")
+// and what's wrong
+type HealthCheck func(ctx context.Context) (status string, problems []string)
+
+// delivery is a notification waiting to go out through one channel
+type delivery struct {
+	channel      string
+	notification Notification
+}
+
+// failedLogins counts recent failed logins from one device
+type failedLogins struct {
+	first    time.Time
+	count    int
+	notified bool
+}
+
+// Notifier sends notifications through the channels each event is routed
+// to in the settings, and to the webhook subscriptions that ask for them.
+// Deliveries are queued and sent in the background with retries, so
+// producers never wait on the network.
+type Notifier struct {
+	storage  *storage.Storage
+	client   *http.Client
+	queue    chan delivery
+	webhooks *Webhooks
+
+	mu     sync.Mutex
+	stop   chan struct{}
+	done   sync.WaitGroup
+	health HealthCheck
+
+	// Failed portal logins by device, for spotting bursts
+	loginsMu sync.Mutex
+	logins   map[string]*failedLogins
+}
+
+// NewNotifier creates a Notifier. Notifications are queued from the start
+// but only sent once Start is called.
+func NewNotifier(store *storage.Storage) *Notifier {
+	return &Notifier{
+		storage:  store,
+		client:   &http.Client{Timeout: notificationTimeout},
+		queue:    make(chan delivery, notificationQueueSize),
+		webhooks: NewWebhooks(store),
+		logins:   make(map[string]*failedLogins),
+	}
+}
+
+// Webhooks returns the webhook dispatcher, for its delivery log
+func (n *Notifier) Webhooks() *Webhooks {
+	return n.webhooks
+}
+
+// SetHealthCheck watches check for health transitions once Start is called
+func (n *Notifier) SetHealthCheck(check HealthCheck) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.health = check
+}
+
+// Start begins sending queued notifications and watching health
+func (n *Notifier) Start() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stop != nil {
+		return
+	}
+	n.stop = make(chan struct{})
+	n.webhooks.Start()
+
+	n.done.Add(1)
+	go n.deliver(n.stop)
+	if n.health != nil {
+		n.done.Add(1)
+		go n.watchHealth(n.stop, n.health)
+	}
+}
+
+// Stop ends delivery. Notifications still queued are dropped.
+func (n *Notifier) Stop() {
+	n.mu.Lock()
+	stop := n.stop
+	n.stop = nil
+	n.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		n.done.Wait()
+		n.webhooks.Stop()
+	}
+}
+
+// Notify queues a notification for each channel its event is routed to
+// and each webhook subscription that asks for it
+func (n *Notifier) Notify(note Notification) {
+	n.webhooks.Dispatch(note)
+
+	settings := n.storage.GetSettings().Notifications
+	for _, channel := range settings.Routes[note.Event] {
+		select {
+		case n.queue <- delivery{channel: channel, notification: note}:
+		default:
+			log.Printf("Notification queue full, dropping %s via %s", note.Event, channel)
+		}
+	}
+}
+
+// Test sends a test notification through a channel right away and returns
+// the HTTP status, if the channel has one
+func (n *Notifier) Test(ctx context.Context, channel string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, notificationTestTimeout)
+	defer cancel()
+	note := NewNotification(models.EventTest, "Parenta test notification",
+		"This is a test from Parenta. If you can read it, notifications work.", nil)
+	return n.send(ctx, channel, note)
+}
+
+// send delivers a notification through a channel with its current settings
+func (n *Notifier) send(ctx context.Context, channel string, note Notification) (int, error) {
+	ch, err := newNotificationChannel(channel, n.storage.GetSettings().Notifications, n.client)
+	if err != nil {
+		return 0, err
+	}
+	return ch.Send(ctx, note)
+}
+
+// deliver sends queued notifications until stop is closed
+func (n *Notifier) deliver(stop chan struct{}) {
+	defer n.done.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case d := <-n.queue:
+			n.sendWithRetry(stop, d)
+		}
+	}
+}
+
+// sendWithRetry tries a delivery up to notificationAttempts times. Missing
+// settings aren't retried.
+func (n *Notifier) sendWithRetry(stop chan struct{}, d delivery) {
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+		_, err := n.send(ctx, d.channel, d.notification)
+		cancel()
+		if err == nil {
+			return
+		}
+		if errors.Is(err, ErrChannelNotConfigured) || attempt == notificationAttempts {
+			log.Printf("Notification %s via %s failed: %v", d.notification.Event, d.channel, err)
+			return
+		}
+
+		timer := time.NewTimer(time.Duration(attempt) * notificationRetryDelay)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// LoginFailed records a failed portal login from a device, notifying once
+// when failedLoginBurst of them happen within failedLoginWindow
+func (n *Notifier) LoginFailed(device, username string) {
+	now := time.Now()
+
+	n.loginsMu.Lock()
+	for key, f := range n.logins {
+		if now.Sub(f.first) > failedLoginWindow {
+			delete(n.logins, key)
+		}
+	}
+	f := n.logins[device]
+	if f == nil {
+		f = &failedLogins{first: now}
+		n.logins[device] = f
+	}
+	f.count++
+	burst := f.count >= failedLoginBurst && !f.notified
+	if burst {
+		f.notified = true
+	}
+	count := f.count
+	n.loginsMu.Unlock()
+
+	if burst {
+		n.Notify(NewNotification(models.EventFailedLogins, "Repeated failed logins",
+			fmt.Sprintf("%d failed portal logins from %s in %d minutes, most recently as %q.",
+				count, device, int(failedLoginWindow.Minutes()), username),
+			map[string]any{"device": device, "username": username, "count": count}))
+	}
+}
+
+// SessionStarted notifies that a child or guest went online
+func (n *Notifier) SessionStarted(session *models.Session) {
+	n.Notify(NewNotification(models.EventSessionStart, session.ChildName+" is online",
+		fmt.Sprintf("%s logged in on %s.", session.ChildName, session.MAC), sessionData(session)))
+}
+
+// SessionEnded notifies that a session ended, with the reason
+func (n *Notifier) SessionEnded(session *models.Session) {
+	data := sessionData(session)
+	data["ended_at"] = session.EndedAt
+	data["end_reason"] = session.EndReason
+	data["minutes"] = session.DurationMinutes()
+	n.Notify(NewNotification(models.EventSessionEnd, session.ChildName+" is offline",
+		fmt.Sprintf("%s's session on %s ended after %s (%s).", session.ChildName, session.MAC,
+			formatMinutes(session.DurationMinutes()), session.EndReason), data))
+}
+
+// sessionData describes a session in notification data
+func sessionData(session *models.Session) map[string]any {
+	data := map[string]any{
+		"session_id": session.ID,
+		"child_name": session.ChildName,
+		"mac":        session.MAC,
+		"ip":         session.IP,
+		"started_at": session.StartedAt,
+	}
+	if session.ChildID != "" {
+		data["child_id"] = session.ChildID
+	}
+	if session.VoucherID != "" {
+		data["voucher_id"] = session.VoucherID
+	}
+	if session.GatewayName != "" {
+		data["gateway"] = session.GatewayName
+	}
+	return data
+}
+
+// FiltersChanged notifies that filter rules were "added", "removed" or
+// "imported"
+func (n *Notifier) FiltersChanged(action string, rules []*models.FilterRule) {
+	if len(rules) == 0 {
+		return
+	}
+
+	data := map[string]any{"action": action, "count": len(rules)}
+	first := rules[0]
+	sameType, sameCategory := true, true
+	for _, rule := range rules[1:] {
+		sameType = sameType && rule.RuleType == first.RuleType
+		sameCategory = sameCategory && rule.Category == first.Category
+	}
+	if sameType {
+		data["rule_type"] = first.RuleType
+	}
+	if sameCategory && first.Category != "" {
+		data["category"] = first.Category
+	}
+
+	message := fmt.Sprintf("%d filter rules were %s.", len(rules), action)
+	if len(rules) == 1 {
+		data["domain"] = first.Domain
+		message = fmt.Sprintf("The %s rule for %s was %s.", first.RuleType, first.Domain, action)
+	}
+	n.Notify(NewNotification(models.EventFilterChanged, "Filters changed", message, data))
+}
+
+// DailySummary describes each child's usage today
+func (n *Notifier) DailySummary(now time.Time) Notification {
+	children := n.storage.ListChildren()
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+
+	var lines []string
+	usage := make([]map[string]any, 0, len(children))
+	for _, child := range children {
+		line := fmt.Sprintf("%s: %d of %d minutes", child.Name, child.UsedTodayMin, child.EffectiveQuotaMin())
+		if child.BankMinutes > 0 {
+			line += fmt.Sprintf(", %d in the time bank", child.BankMinutes)
+		}
+		lines = append(lines, line)
+		usage = append(usage, map[string]any{
+			"child_id":     child.ID,
+			"name":         child.Name,
+			"used_minutes": child.UsedTodayMin,
+			"quota":        child.EffectiveQuotaMin(),
+			"bank_minutes": child.BankMinutes,
+		})
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No children set up yet.")
+	}
+
+	date := now.Format("2006-01-02")
+	return Notification{
+		Event:   models.EventDailySummary,
+		Title:   "Screen time for " + date,
+		Message: strings.Join(lines, "\n"),
+		Data:    map[string]any{"date": date, "children": usage},
+		Time:    now,
+	}
+}
+
+// watchHealth checks health every healthCheckInterval and notifies when
+// the status changes. The first check only records the starting state.
+func (n *Notifier) watchHealth(stop chan struct{}, check HealthCheck) {
+	defer n.done.Done()
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	last := ""
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+		status, problems := check(ctx)
+		cancel()
+
+		if last != "" && status != last {
+			message := fmt.Sprintf("Health changed from %s to %s.", last, status)
+			if len(problems) > 0 {
+				message += "\n" + strings.Join(problems, "\n")
+			}
+			n.Notify(NewNotification(models.EventHealthChanged, "Parenta is "+status, message,
+				map[string]any{"status": status, "previous": last, "problems": problems}))
+		}
+		last = status
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"parenta/internal/models"
+)
+
+// telegramAPIURL is the Telegram Bot API endpoint
+var telegramAPIURL = "https://api.telegram.org"
+
+// newNotificationChannel returns the named channel with its settings
+func newNotificationChannel(name string, settings models.NotificationSettings, client *http.Client) (NotificationChannel, error) {
+	switch name {
+	case models.ChannelEmail:
+		if !settings.Email.Configured() {
+			return nil, fmt.Errorf("email: %w", ErrChannelNotConfigured)
+		}
+		return emailChannel{settings.Email}, nil
+	case models.ChannelTelegram:
+		if !settings.Telegram.Configured() {
+			return nil, fmt.Errorf("telegram: %w", ErrChannelNotConfigured)
+		}
+		return telegramChannel{settings.Telegram, client}, nil
+	case models.ChannelWebhook:
+		if !settings.Webhook.Configured() {
+			return nil, fmt.Errorf("webhook: %w", ErrChannelNotConfigured)
+		}
+		return webhookChannel{settings.Webhook, client}, nil
+	}
+	return nil, fmt.Errorf("unknown notification channel %q", name)
+}
+
+// emailChannel sends notifications by SMTP
+type emailChannel struct {
+	settings models.EmailSettings
+}
+
+// Send delivers the notification as a plain text email. Port 465 uses TLS
+// from the start; other ports upgrade with STARTTLS when the server offers
+// it. Credentials are never sent over an unencrypted connection.
+func (c emailChannel) Send(ctx context.Context, n Notification) (int, error) {
+	s := c.settings
+	port := s.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: s.Host}
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if port == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("email: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return 0, fmt.Errorf("email: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return 0, fmt.Errorf("email: %w", err)
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return 0, fmt.Errorf("email: %w", err)
+		}
+	}
+
+	if err := client.Mail(s.From); err != nil {
+		return 0, fmt.Errorf("email: %w", err)
+	}
+	for _, to := range s.To {
+		if err := client.Rcpt(to); err != nil {
+			return 0, fmt.Errorf("email to %s: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return 0, fmt.Errorf("email: %w", err)
+	}
+	if _, err := w.Write(emailMessage(s, n)); err != nil {
+		return 0, fmt.Errorf("email: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return 0, fmt.Errorf("email: %w", err)
+	}
+	return 0, client.Quit()
+}
+
+// emailMessage formats a notification as an RFC 5322 message: plain text,
+// or text with an HTML alternative when the notification has HTML
+func emailMessage(s models.EmailSettings, n Notification) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mimeHeader(n.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	text := strings.ReplaceAll(n.Message, "\n", "\r\n") + "\r\n"
+
+	if n.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+		b.WriteString(text)
+		return b.Bytes()
+	}
+
+	mw := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", strings.ReplaceAll(n.HTML, "\n", "\r\n")},
+	} {
+		w, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		w.Write([]byte(part.body))
+	}
+	mw.Close()
+	return b.Bytes()
+}
+
+// mimeHeader encodes a header value that isn't plain ASCII, dropping line
+// breaks so it can't add headers
+func mimeHeader(s string) string {
+	s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	for _, r := range s {
+		if r > 126 || r < 32 {
+			return mime.QEncoding.Encode("utf-8", s)
+		}
+	}
+	return s
+}
+
+// telegramChannel sends notifications through a Telegram bot
+type telegramChannel struct {
+	settings models.TelegramSettings
+	client   *http.Client
+}
+
+// Send posts the notification to the bot's chat
+func (c telegramChannel) Send(ctx context.Context, n Notification) (int, error) {
+	body, err := json.Marshal(map[string]string{
+		"chat_id": c.settings.ChatID,
+		"text":    n.Title + "\n\n" + n.Message,
+	})
+	if err != nil {
+		return 0, err
+	}
+	endpoint := telegramAPIURL + "/bot" + c.settings.BotToken + "/sendMessage"
+	status, resp, err := postJSON(ctx, c.client, endpoint, body)
+	if err != nil {
+		return status, fmt.Errorf("telegram: %w", err)
+	}
+	if status != http.StatusOK {
+		// The API explains failures such as an unknown chat in "description"
+		var result struct {
+			Description string `json:"description"`
+		}
+		json.Unmarshal(resp, &result)
+		if result.Description == "" {
+			result.Description = http.StatusText(status)
+		}
+		return status, fmt.Errorf("telegram: %s", result.Description)
+	}
+	return status, nil
+}
+
+// webhookChannel posts notifications as JSON to a URL
+type webhookChannel struct {
+	settings models.WebhookSettings
+	client   *http.Client
+}
+
+// webhookPayload is a notification with "text" and "content" copies of
+// the message, so Slack and Discord incoming webhooks show it as is
+type webhookPayload struct {
+	Notification
+	Text    string `json:"text"`
+	Content string `json:"content"`
+}
+
+// Send posts the notification to the webhook URL
+func (c webhookChannel) Send(ctx context.Context, n Notification) (int, error) {
+	text := n.Title + "\n" + n.Message
+	body, err := json.Marshal(webhookPayload{Notification: n, Text: text, Content: text})
+	if err != nil {
+		return 0, err
+	}
+	status, _, err := postJSON(ctx, c.client, c.settings.URL, body)
+	if err != nil {
+		return status, fmt.Errorf("webhook: %w", err)
+	}
+	if status < 200 || status > 299 {
+		return status, fmt.Errorf("webhook: %d %s", status, http.StatusText(status))
+	}
+	return status, nil
+}
+
+// postJSON posts body and returns the status and the start of the
+// response. Errors leave out the URL, which may contain a token.
+func postJSON(ctx context.Context, client *http.Client, endpoint string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, errors.New("invalid URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return resp.StatusCode, data, nil
+}
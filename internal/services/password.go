@@ -0,0 +1,89 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"parenta/internal/models"
+)
+
+// commonPasswords are refused when a policy has RejectCommon set
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "password123": true, "passw0rd": true,
+	"123456": true, "1234567": true, "12345678": true, "123456789": true,
+	"1234567890": true, "111111": true, "000000": true, "123123": true,
+	"qwerty": true, "qwerty123": true, "qwertyuiop": true, "asdfgh": true,
+	"abc123": true, "iloveyou": true, "letmein": true, "welcome": true,
+	"monkey": true, "dragon": true, "football": true, "baseball": true,
+	"sunshine": true, "princess": true, "admin": true, "admin123": true,
+	"administrator": true, "changeme": true, "parenta": true, "parenta123": true,
+}
+
+// PasswordPolicyError lists every rule a password failed
+type PasswordPolicyError struct {
+	Failures []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password " + strings.Join(e.Failures, ", ")
+}
+
+// ValidatePassword checks a password against a policy. The error, if any,
+// is a *PasswordPolicyError.
+func ValidatePassword(policy models.PasswordPolicy, password, username string) error {
+	var failures []string
+
+	if len([]rune(password)) < policy.MinLength {
+		failures = append(failures, fmt.Sprintf("must be at least %d characters", policy.MinLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	if policy.RequireUpper && !upper {
+		failures = append(failures, "must contain an uppercase letter")
+	}
+	if policy.RequireLower && !lower {
+		failures = append(failures, "must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !digit {
+		failures = append(failures, "must contain a digit")
+	}
+	if policy.RequireSymbol && !symbol {
+		failures = append(failures, "must contain a symbol")
+	}
+
+	if policy.RejectCommon {
+		if commonPasswords[strings.ToLower(password)] {
+			failures = append(failures, "is too common")
+		} else if username != "" && strings.EqualFold(password, username) {
+			failures = append(failures, "must not be the username")
+		}
+	}
+
+	if len(failures) > 0 {
+		return &PasswordPolicyError{Failures: failures}
+	}
+	return nil
+}
+
+// ValidateAdminPassword checks a password against the admin policy
+func (a *AuthService) ValidateAdminPassword(password, username string) error {
+	return ValidatePassword(a.storage.GetSettings().PasswordPolicy.Admin, password, username)
+}
+
+// ValidateChildPassword checks a password against the child policy
+func (a *AuthService) ValidateChildPassword(password, username string) error {
+	return ValidatePassword(a.storage.GetSettings().PasswordPolicy.Child, password, username)
+}
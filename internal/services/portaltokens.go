@@ -0,0 +1,77 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// PortalTokenTTL is how long a captive portal token lasts at most
+const PortalTokenTTL = 24 * time.Hour
+
+// PortalToken lets a client that logged in through the captive portal check
+// its status without sending its password again. Tokens are only kept in
+// memory, so they don't survive a restart.
+type PortalToken struct {
+	Token     string
+	ChildID   string // Empty for guests
+	MAC       string
+	SessionID string
+	ExpiresAt time.Time
+}
+
+// PortalTokens holds the issued portal tokens
+type PortalTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*PortalToken
+}
+
+// NewPortalTokens creates an empty token store
+func NewPortalTokens() *PortalTokens {
+	return &PortalTokens{tokens: make(map[string]*PortalToken)}
+}
+
+// Issue creates a token for a portal session. Expired tokens are pruned.
+func (p *PortalTokens) Issue(childID, mac, sessionID string) *PortalToken {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for key, t := range p.tokens {
+		if now.After(t.ExpiresAt) {
+			delete(p.tokens, key)
+		}
+	}
+
+	token := &PortalToken{
+		Token:     GenerateToken(),
+		ChildID:   childID,
+		MAC:       mac,
+		SessionID: sessionID,
+		ExpiresAt: now.Add(PortalTokenTTL),
+	}
+	p.tokens[token.Token] = token
+	return token
+}
+
+// Get returns an unexpired token, or nil
+func (p *PortalTokens) Get(token string) *PortalToken {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.tokens[token]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(t.ExpiresAt) {
+		delete(p.tokens, token)
+		return nil
+	}
+	return t
+}
+
+// Revoke removes a token
+func (p *PortalTokens) Revoke(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.tokens, token)
+}
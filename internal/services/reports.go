@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+
+	"parenta/internal/models"
+	"parenta/internal/storage"
+)
+
+//go:embed templates/report.html
+var reportTemplateSource string
+
+// reportTemplate renders a report as a standalone HTML page
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"minutes": formatMinutes,
+	"title":   reportTitle,
+}).Parse(reportTemplateSource))
+
+// ReportGenerator builds usage reports from the stored sessions and the
+// minutes charged to each child
+type ReportGenerator struct {
+	storage *storage.Storage
+}
+
+// NewReportGenerator creates a new ReportGenerator
+func NewReportGenerator(store *storage.Storage) *ReportGenerator {
+	return &ReportGenerator{storage: store}
+}
+
+// Daily reports on the day of date
+func (g *ReportGenerator) Daily(date time.Time) *models.UsageReport {
+	return g.generate(models.ReportDaily, date.Format(models.DateLayout), date, 1)
+}
+
+// Weekly reports on the ISO week (Monday to Sunday) starting on monday
+func (g *ReportGenerator) Weekly(monday time.Time) *models.UsageReport {
+	year, week := monday.ISOWeek()
+	return g.generate(models.ReportWeekly, fmt.Sprintf("%d-W%02d", year, week), monday, 7)
+}
+
+// generate builds a report of days days from first's day
+func (g *ReportGenerator) generate(period, label string, first time.Time, days int) *models.UsageReport {
+	now := time.Now()
+	first = time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, first.Location())
+	end := first.AddDate(0, 0, days)
+
+	report := &models.UsageReport{
+		Period:      period,
+		Label:       label,
+		From:        first.Format(models.DateLayout),
+		To:          end.AddDate(0, 0, -1).Format(models.DateLayout),
+		GeneratedAt: now,
+		Children:    []models.ChildReport{},
+	}
+
+	children := g.storage.ListChildren()
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+	for _, child := range children {
+		// Children created after the period have nothing to report
+		if !child.CreatedAt.IsZero() && !child.CreatedAt.Before(end) {
+			continue
+		}
+		report.Children = append(report.Children, g.childReport(child, first, end, days, now))
+	}
+	return report
+}
+
+// childReport builds one child's part of a report
+func (g *ReportGenerator) childReport(child *models.Child, first, end time.Time, days int, now time.Time) models.ChildReport {
+	cr := models.ChildReport{
+		ChildID:     child.ID,
+		Name:        child.Name,
+		Days:        make([]models.ReportDay, days),
+		TopSessions: []models.ReportSession{},
+	}
+
+	today := now.Format(models.DateLayout)
+	created := ""
+	if !child.CreatedAt.IsZero() {
+		created = child.CreatedAt.In(first.Location()).Format(models.DateLayout)
+	}
+
+	online := g.storage.GetChildUsageFrom(child.ID, first, days)
+	for i := range cr.Days {
+		day := &cr.Days[i]
+		day.Date = online[i].Date
+		day.Tracked = day.Date >= created && day.Date <= today
+		if !day.Tracked {
+			continue
+		}
+
+		day.OnlineMinutes = online[i].MinutesUsed
+		day.SessionCount = online[i].SessionCount
+		day.QuotaMin = child.DailyQuotaMin
+		if day.Date == today && child.LastResetDate == today {
+			day.MinutesUsed = child.UsedTodayMin
+			day.QuotaMin = child.EffectiveQuotaMin()
+		} else {
+			for _, minutes := range child.CategoryUsage[day.Date] {
+				day.MinutesUsed += minutes
+			}
+		}
+		day.OverQuota = day.QuotaMin > 0 && day.MinutesUsed > day.QuotaMin
+
+		cr.TrackedDays++
+		cr.TotalMinutes += day.MinutesUsed
+		if day.OverQuota {
+			cr.DaysOverQuota++
+		}
+	}
+	if cr.TrackedDays > 0 {
+		cr.AverageMinutes = cr.TotalMinutes / cr.TrackedDays
+	}
+
+	var sessions []*models.Session
+	for _, session := range g.storage.ListSessionsByChildID(child.ID) {
+		if session.StartedAt.Before(end) && session.EndTime().After(first) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].DurationMinutes() > sessions[j].DurationMinutes()
+	})
+	if len(sessions) > models.MaxReportSessions {
+		sessions = sessions[:models.MaxReportSessions]
+	}
+	for _, session := range sessions {
+		rs := models.ReportSession{
+			ID:        session.ID,
+			StartedAt: session.StartedAt,
+			EndedAt:   session.EndTime(),
+			Minutes:   session.DurationMinutes(),
+			MAC:       session.MAC,
+			EndReason: session.EndReason,
+		}
+		if device := child.Device(session.MAC); device != nil {
+			rs.Device = device.Name
+		}
+		cr.TopSessions = append(cr.TopSessions, rs)
+	}
+	return cr
+}
+
+// RenderReportHTML renders a report as a standalone HTML page with inline
+// styles, suitable for a browser or an email
+func RenderReportHTML(report *models.UsageReport) ([]byte, error) {
+	var b bytes.Buffer
+	if err := reportTemplate.Execute(&b, report); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// ParseISOWeek parses a week as "YYYY-Www" and returns its Monday in loc
+func ParseISOWeek(s string, loc *time.Location) (time.Time, error) {
+	var year, week int
+	if _, err := fmt.Sscanf(s, "%4d-W%2d", &year, &week); err != nil || len(s) != 8 {
+		return time.Time{}, errors.New("week must be YYYY-Www")
+	}
+
+	// 4 January is always in week 1
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, loc)
+	monday := jan4.AddDate(0, 0, -((int(jan4.Weekday())+6)%7)+(week-1)*7)
+	if y, w := monday.ISOWeek(); y != year || w != week {
+		return time.Time{}, fmt.Errorf("%d has no week %d", year, week)
+	}
+	return monday, nil
+}
+
+// WeekStart returns the Monday of t's ISO week
+func WeekStart(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+}
+
+// reportTitle names a report for people
+func reportTitle(report *models.UsageReport) string {
+	if report.Period == models.ReportWeekly {
+		return fmt.Sprintf("Screen time for the week of %s (%s)", report.From, report.Label)
+	}
+	return "Screen time for " + report.From
+}
+
+// WeeklyReport is the weekly report as a notification, with the HTML
+// report for email
+func (n *Notifier) WeeklyReport(report *models.UsageReport) Notification {
+	var lines []string
+	for _, child := range report.Children {
+		line := fmt.Sprintf("%s: %s in total, %s a day on average", child.Name,
+			formatMinutes(child.TotalMinutes), formatMinutes(child.AverageMinutes))
+		if child.DaysOverQuota > 0 {
+			line += fmt.Sprintf(", over quota on %d days", child.DaysOverQuota)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No children set up yet.")
+	}
+
+	note := Notification{
+		Event:   models.EventWeeklyReport,
+		Title:   reportTitle(report),
+		Message: strings.Join(lines, "\n"),
+		Data:    map[string]any{"week": report.Label, "from": report.From, "to": report.To},
+		Time:    report.GeneratedAt,
+	}
+	if html, err := RenderReportHTML(report); err == nil {
+		note.HTML = string(html)
+	}
+	return note
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"log"
+	"time"
+)
+
+// retryBaseDelay is the wait before the second attempt; it doubles after each failure
+const retryBaseDelay = 500 * time.Millisecond
+
+// Retry runs fn up to attempts times, waiting with exponential backoff
+// between failures, and logs each failed attempt. It returns the last
+// error once all attempts fail.
+func Retry(name string, attempts int, fn func() error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := retryBaseDelay
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(); err == nil {
+			if attempt > 1 {
+				log.Printf("%s succeeded on attempt %d/%d", name, attempt, attempts)
+			}
+			return nil
+		}
+
+		log.Printf("%s failed (attempt %d/%d): %v", name, attempt, attempts, err)
+		if attempt < attempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
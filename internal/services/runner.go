@@ -0,0 +1,35 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// CommandRunner runs external programs such as ndsctl and service restart
+// scripts. Tests replace it with a fake that records the calls.
+type CommandRunner interface {
+	// Run runs name with args and returns its trimmed stdout. When the
+	// command fails, the error is its stderr if it wrote any.
+	Run(name string, args ...string) (string, error)
+}
+
+// ExecRunner runs commands with os/exec
+type ExecRunner struct{}
+
+// Run implements CommandRunner
+func (ExecRunner) Run(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if errMsg := strings.TrimSpace(stderr.String()); errMsg != "" {
+			return "", errors.New(errMsg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
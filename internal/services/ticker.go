@@ -1,127 +1,576 @@
 package services
 
 import (
+	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"parenta/internal/models"
 	"parenta/internal/storage"
 )
 
+// MaxTickEvents is how many ticker events are kept for RecentEvents
+const MaxTickEvents = 500
+
+// Tick event types besides the reasons a session is ended for
+const (
+	TickEventDeviceOffline = "device_offline" // openNDS isn't running, so the session isn't charged
+	TickEventDailyReset    = "daily_reset"
+)
+
+// TickEvent records a decision the ticker made, so unexpected logouts can be
+// explained afterwards. Events about a session ending have the end reason
+// as their type, such as "quota_exceeded" or "schedule_ended".
+type TickEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	SessionID string    `json:"session_id,omitempty"`
+	ChildName string    `json:"child_name,omitempty"`
+	MAC       string    `json:"mac,omitempty"`
+	EventType string    `json:"event_type"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
 // SessionTicker periodically checks sessions and enforces quotas
 type SessionTicker struct {
 	storage  *storage.Storage
-	ndsctl   *NDSCtl
+	ndsPool  *NDSCtlPool
+	dnsmasq  *DnsmasqService
 	interval time.Duration
 	stopChan chan struct{}
 	doneChan chan struct{}
+
+	// Serializes Start, Stop and SetInterval
+	mu sync.Mutex
+
+	// Effective state of timed filter rules at the last tick
+	timedFilters map[string]bool
+
+	// History older than this many days is pruned daily; 0 or less disables it
+	retentionDays int
+	lastPrune     time.Time
+
+	// Sends quota and daily summary notifications, if set
+	notifier *Notifier
+
+	// Called after each tick and whenever a session is ended, if set
+	onChange func()
+
+	// Day ("2006-01-02") each child was last warned their time is nearly
+	// up, and the day the last daily summary was sent
+	warned      map[string]string
+	lastSummary string
+
+	// Ring of the last MaxTickEvents events
+	eventsMu   sync.Mutex
+	events     []TickEvent
+	nextEvent  int
+	eventsFull bool
+
+	// Sessions on a gateway whose openNDS was down at the last tick
+	offline map[string]bool
 }
 
 // NewSessionTicker creates a new SessionTicker
-func NewSessionTicker(store *storage.Storage, ndsctl *NDSCtl, intervalSeconds int) *SessionTicker {
+func NewSessionTicker(store *storage.Storage, ndsPool *NDSCtlPool, dnsmasq *DnsmasqService, intervalSeconds, retentionDays int) *SessionTicker {
 	return &SessionTicker{
-		storage:  store,
-		ndsctl:   ndsctl,
-		interval: time.Duration(intervalSeconds) * time.Second,
-		stopChan: make(chan struct{}),
-		doneChan: make(chan struct{}),
+		storage:       store,
+		ndsPool:       ndsPool,
+		dnsmasq:       dnsmasq,
+		interval:      time.Duration(intervalSeconds) * time.Second,
+		stopChan:      make(chan struct{}),
+		doneChan:      make(chan struct{}),
+		retentionDays: retentionDays,
+		warned:        make(map[string]string),
+		events:        make([]TickEvent, MaxTickEvents),
+	}
+}
+
+// recordEvent adds an event to the ring, overwriting the oldest when full
+func (t *SessionTicker) recordEvent(event TickEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	t.eventsMu.Lock()
+	defer t.eventsMu.Unlock()
+	t.events[t.nextEvent] = event
+	t.nextEvent = (t.nextEvent + 1) % len(t.events)
+	if t.nextEvent == 0 {
+		t.eventsFull = true
+	}
+}
+
+// sessionEvent creates an event about a session
+func sessionEvent(session *models.Session, eventType, detail string) TickEvent {
+	return TickEvent{
+		SessionID: session.ID,
+		ChildName: session.ChildName,
+		MAC:       session.MAC,
+		EventType: eventType,
+		Detail:    detail,
+	}
+}
+
+// RecentEvents returns up to n of the latest events, newest first
+func (t *SessionTicker) RecentEvents(n int) []TickEvent {
+	t.eventsMu.Lock()
+	defer t.eventsMu.Unlock()
+
+	count := t.nextEvent
+	if t.eventsFull {
+		count = len(t.events)
+	}
+	if n > count {
+		n = count
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	result := make([]TickEvent, n)
+	for i := range result {
+		result[i] = t.events[(t.nextEvent-1-i+len(t.events))%len(t.events)]
+	}
+	return result
+}
+
+// SetNotifier sends session end, quota warning, quota exceeded and daily
+// summary notifications through n. Call it before Start.
+func (t *SessionTicker) SetNotifier(n *Notifier) {
+	t.notifier = n
+}
+
+// SetOnChange calls fn after each tick and whenever a session is ended, so
+// children's state can be republished. fn must not block. Call it before
+// Start.
+func (t *SessionTicker) SetOnChange(fn func()) {
+	t.onChange = fn
+}
+
+// changed calls the onChange hook, if set
+func (t *SessionTicker) changed() {
+	if t.onChange != nil {
+		t.onChange()
 	}
 }
 
 // Start begins the ticker loop
 func (t *SessionTicker) Start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.run()
+	log.Printf("Session ticker started (interval: %v)", t.interval)
+}
+
+// run starts the loop goroutine with the current interval and channels.
+// Caller must hold mu.
+func (t *SessionTicker) run() {
 	ticker := time.NewTicker(t.interval)
+	stop, done := t.stopChan, t.doneChan
 	go func() {
-		defer close(t.doneChan)
+		defer close(done)
 		for {
 			select {
 			case <-ticker.C:
 				t.tick()
-			case <-t.stopChan:
+			case <-stop:
 				ticker.Stop()
 				return
 			}
 		}
 	}()
-	log.Printf("Session ticker started (interval: %v)", t.interval)
 }
 
-// Stop stops the ticker loop
-func (t *SessionTicker) Stop() {
+// halt signals the loop to exit and waits for it. Caller must hold mu.
+func (t *SessionTicker) halt() {
 	close(t.stopChan)
 	<-t.doneChan
+}
+
+// Stop stops the ticker loop
+func (t *SessionTicker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.halt()
 	log.Println("Session ticker stopped")
 }
 
+// Interval returns the current tick interval
+func (t *SessionTicker) Interval() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.interval
+}
+
+// SetInterval restarts the loop with a new tick interval. An in-progress
+// tick finishes before the old loop exits.
+func (t *SessionTicker) SetInterval(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.halt()
+	t.interval = d
+	t.stopChan = make(chan struct{})
+	t.doneChan = make(chan struct{})
+	t.run()
+	log.Printf("Session ticker interval changed to %v", d)
+}
+
+// Tick runs one check cycle immediately, outside the loop. Tests use it to
+// drive the ticker without waiting for the interval.
+func (t *SessionTicker) Tick() {
+	t.tick()
+}
+
 // tick performs one quota check cycle
 func (t *SessionTicker) tick() {
 	now := time.Now()
+	defer t.changed()
 
 	// Check for daily reset
 	t.checkDailyReset(now)
 
+	// Apply temporary filter rules that started or ended
+	t.checkFilterWindows(now)
+
+	// Drop history past the retention period
+	t.checkPrune(now)
+
+	// Send the daily usage summary once its time comes
+	t.checkDailySummary(now)
+
 	// Get all active sessions
 	sessions := t.storage.ListSessions()
 
+	// While a session's openNDS is down it has no internet, so don't charge
+	// quota. Each gateway is checked once per tick.
+	running := make(map[NDSClient]bool)
+	ndsRunning := func(session *models.Session) bool {
+		nds := t.ndsPool.ForSession(session)
+		up, ok := running[nds]
+		if !ok {
+			up = nds.IsRunning()
+			running[nds] = up
+			if !up {
+				gateway := session.GatewayIP
+				if gateway == "" {
+					gateway = "default"
+				}
+				log.Printf("openNDS (%s gateway) is not running, skipping quota charge this tick", gateway)
+			}
+		}
+		return up
+	}
+
+	// openNDS's traffic counters by MAC, read once per gateway per tick
+	traffic := make(map[NDSClient]map[string]int64)
+	sessionBytes := func(session *models.Session) (int64, bool) {
+		nds := t.ndsPool.ForSession(session)
+		counters, ok := traffic[nds]
+		if !ok {
+			counters = make(map[string]int64)
+			if clients, err := nds.JSON(); err == nil {
+				for _, c := range clients {
+					counters[strings.ToLower(c.MAC)] = c.Upload + c.Download
+				}
+			}
+			traffic[nds] = counters
+		}
+		total, ok := counters[session.MAC]
+		return total, ok
+	}
+
+	// Group sessions by child: quota is charged once per child per tick,
+	// however many devices they are online on. Data is the sum over them.
+	byChild := make(map[string][]*models.Session)
+	var childIDs []string
+	dataUsed := make(map[string]int64)
+	offline := make(map[string]bool)
 	for _, session := range sessions {
 		if !session.IsActive {
 			continue
 		}
 
-		child := t.storage.GetChild(session.ChildID)
-		if child == nil {
-			// Child was deleted, deauth this session
-			t.deauthSession(session, "child_deleted")
+		if !ndsRunning(session) {
+			session.LastTickAt = now
+			t.storage.SaveSession(session)
+			// Record once per outage, not every tick
+			offline[session.ID] = true
+			if !t.offline[session.ID] {
+				t.recordEvent(sessionEvent(session, TickEventDeviceOffline, "openNDS is not running on the gateway; not charging quota"))
+			}
 			continue
 		}
 
-		// Calculate minutes since last tick
-		var minutesToAdd int
-		if session.LastTickAt.IsZero() {
-			minutesToAdd = int(now.Sub(session.StartedAt).Minutes())
-		} else {
-			minutesToAdd = int(now.Sub(session.LastTickAt).Minutes())
+		// Guests aren't charged quota; their voucher sets a fixed duration
+		if session.VoucherID != "" {
+			t.checkVoucherSession(session, now)
+			continue
+		}
+
+		// Data used since the last tick. A counter lower than last time
+		// means openNDS started counting again.
+		if total, ok := sessionBytes(session); ok {
+			used := total - session.DataBytes
+			if used < 0 {
+				used = total
+			}
+			session.DataBytes = total
+			dataUsed[session.ChildID] += used
 		}
 
-		// Update usage
+		if _, ok := byChild[session.ChildID]; !ok {
+			childIDs = append(childIDs, session.ChildID)
+		}
+		byChild[session.ChildID] = append(byChild[session.ChildID], session)
+	}
+
+	t.offline = offline
+
+	for _, childID := range childIDs {
+		t.chargeChild(childID, byChild[childID], dataUsed[childID], now)
+	}
+}
+
+// chargeChild charges a child for the wall-clock time since the earliest
+// unbilled moment among their sessions, so overlapping devices count once,
+// and for dataBytes of traffic, then ends all of their sessions if quota,
+// data cap or schedule no longer allow access
+func (t *SessionTicker) chargeChild(childID string, sessions []*models.Session, dataBytes int64, now time.Time) {
+	child := t.storage.GetChild(childID)
+	if child == nil {
+		// Child was deleted, deauth their sessions
+		for _, session := range sessions {
+			t.endSession(session, "child_deleted", "the child was deleted")
+		}
+		return
+	}
+
+	var since time.Time
+	for _, session := range sessions {
+		ref := session.LastTickAt
+		if ref.IsZero() {
+			ref = session.StartedAt
+		}
+		if since.IsZero() || ref.Before(since) {
+			since = ref
+		}
+	}
+
+	// Charge whole minutes and carry the remainder to the next tick
+	minutesToAdd := int(now.Sub(since).Minutes())
+	if minutesToAdd > 0 || dataBytes > 0 {
 		if minutesToAdd > 0 {
-			child.UsedTodayMin += minutesToAdd
-			child.UpdatedAt = now
-			t.storage.SaveChild(child)
+			child.ChargeMinutes(minutesToAdd)
+			category := child.UsageCategory(t.storage.GetSchedule(child.ScheduleID), now)
+			child.AddCategoryMinutes(now, category, minutesToAdd)
+		}
+		child.AddDataBytes(dataBytes)
+		child.UpdatedAt = now
+		t.storage.SaveChild(child)
 
-			session.LastTickAt = now
+		billedTo := since.Add(time.Duration(minutesToAdd) * time.Minute)
+		for _, session := range sessions {
+			if minutesToAdd > 0 {
+				session.LastTickAt = billedTo
+			}
 			t.storage.SaveSession(session)
 		}
+	}
 
-		// Check quota exceeded
-		if child.UsedTodayMin >= child.DailyQuotaMin {
-			t.deauthSession(session, "quota_exceeded")
-			continue
+	reason, detail := "", ""
+	if !child.IsActive {
+		reason, detail = "deactivated", "the child's account is disabled"
+	} else if child.RemainingMinutes() <= 0 {
+		reason = "quota_exceeded"
+		detail = fmt.Sprintf("used %d of %d minutes, %d in the time bank", child.UsedTodayMin, child.EffectiveQuotaMin(), child.BankMinutes)
+	} else if child.DataExceeded() {
+		reason = "data_exceeded"
+		detail = fmt.Sprintf("used %.1f of %d MB", child.UsedTodayMB, child.DailyDataQuotaMB)
+	} else if child.InBedtime(now) {
+		reason, detail = "bedtime", fmt.Sprintf("bedtime %s-%s", child.BedtimeStart, child.BedtimeEnd)
+	} else if child.ScheduleID != "" {
+		schedule := t.storage.GetSchedule(child.ScheduleID)
+		if schedule != nil && !schedule.IsAllowedNow() {
+			reason, detail = "schedule_ended", "schedule "+schedule.Name+" blocks access at "+now.Format("15:04")
+		}
+	}
+	if reason != "" {
+		for _, session := range sessions {
+			t.endSession(session, reason, detail)
+		}
+		if reason == "quota_exceeded" && t.notifier != nil {
+			t.notifier.Notify(NewNotification(models.EventQuotaExceeded, child.Name+" is out of time",
+				fmt.Sprintf("%s used all %d minutes for today and was logged out.", child.Name, child.EffectiveQuotaMin()),
+				map[string]any{"child_id": child.ID, "name": child.Name, "used_minutes": child.UsedTodayMin}))
 		}
+		return
+	}
 
-		// Check schedule (if child has a schedule assigned)
-		if child.ScheduleID != "" {
-			schedule := t.storage.GetSchedule(child.ScheduleID)
-			if schedule != nil && !schedule.IsAllowedNow() {
-				t.deauthSession(session, "schedule_ended")
-				continue
+	// A session that has lasted as long as allowed ends on its own; the
+	// child's other devices keep theirs
+	if child.MaxSessionMinutes > 0 {
+		for _, session := range sessions {
+			if session.DurationMinutes() >= child.MaxSessionMinutes {
+				t.endSession(session, "max_session_reached", fmt.Sprintf("the session reached %d minutes", child.MaxSessionMinutes))
 			}
 		}
 	}
+
+	t.checkQuotaWarning(child, now)
+}
+
+// checkQuotaWarning notifies once a day when a child online has no more
+// than the configured warning minutes left
+func (t *SessionTicker) checkQuotaWarning(child *models.Child, now time.Time) {
+	if t.notifier == nil {
+		return
+	}
+	warnAt := t.storage.GetSettings().Notifications.WarningMinutes
+	remaining := child.RemainingMinutes()
+	today := now.Format("2006-01-02")
+	if warnAt <= 0 || remaining > warnAt || t.warned[child.ID] == today {
+		return
+	}
+	t.warned[child.ID] = today
+
+	t.notifier.Notify(NewNotification(models.EventQuotaWarning, child.Name+" has "+formatMinutes(remaining)+" left",
+		fmt.Sprintf("%s has %s of screen time left today.", child.Name, formatMinutes(remaining)),
+		map[string]any{"child_id": child.ID, "name": child.Name, "remaining_minutes": remaining}))
 }
 
-// deauthSession deauthenticates a session and marks it inactive
-func (t *SessionTicker) deauthSession(session *models.Session, reason string) {
+// checkDailySummary sends the daily summary within an hour after the
+// configured time, once a day, and the weekly report along with Sunday's
+func (t *SessionTicker) checkDailySummary(now time.Time) {
+	if t.notifier == nil {
+		return
+	}
+	at, err := time.ParseInLocation("15:04", t.storage.GetSettings().Notifications.SummaryTime, now.Location())
+	if err != nil {
+		return
+	}
+	due := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	today := now.Format("2006-01-02")
+	if now.Before(due) || now.Sub(due) > time.Hour || t.lastSummary == today {
+		return
+	}
+	t.lastSummary = today
+	t.notifier.Notify(t.notifier.DailySummary(now))
+
+	// The week ends on Sunday, so its report goes out with that day's summary
+	if now.Weekday() == time.Sunday {
+		report := NewReportGenerator(t.storage).Weekly(WeekStart(now))
+		t.notifier.Notify(t.notifier.WeeklyReport(report))
+	}
+}
+
+// formatMinutes renders minutes as "1h 5m" or "45m"
+func formatMinutes(minutes int) string {
+	if minutes >= 60 {
+		return fmt.Sprintf("%dh %dm", minutes/60, minutes%60)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// checkVoucherSession ends a guest session once its voucher's duration has
+// passed, or if the voucher was deleted
+func (t *SessionTicker) checkVoucherSession(session *models.Session, now time.Time) {
+	voucher := t.storage.GetVoucher(session.VoucherID)
+	switch {
+	case voucher == nil:
+		t.EndSession(session, "voucher_deleted")
+	case !now.Before(voucher.SessionEndsAt(session.StartedAt)):
+		t.EndSession(session, "voucher_expired")
+	default:
+		session.LastTickAt = now
+		t.storage.SaveSession(session)
+	}
+}
+
+// EndSession deauthenticates a session and marks it inactive
+func (t *SessionTicker) EndSession(session *models.Session, reason string) {
+	t.endSession(session, reason, "")
+}
+
+// endSession ends a session, recording the reason and what led to it
+func (t *SessionTicker) endSession(session *models.Session, reason, detail string) {
 	log.Printf("Deauthenticating %s (child: %s): %s", session.MAC, session.ChildName, reason)
 
 	// Call ndsctl deauth
-	if err := t.ndsctl.Deauth(session.MAC); err != nil {
-		log.Printf("ndsctl deauth error for %s: %v", session.MAC, err)
+	if err := t.ndsPool.ForSession(session).DeauthBest(session); err != nil {
+		log.Printf("ndsctl deauth error for %s (%s): %v", session.MAC, session.IP, err)
 	}
 
 	// Mark session as inactive
-	session.IsActive = false
+	session.End(reason)
 	t.storage.SaveSession(session)
+	t.recordEvent(sessionEvent(session, reason, detail))
+	if t.notifier != nil {
+		t.notifier.SessionEnded(session)
+	}
+	t.changed()
+}
+
+// EndChildSessions ends all of a child's active sessions right away rather
+// than at the next tick. Returns how many were ended.
+func (t *SessionTicker) EndChildSessions(childID, reason string) int {
+	sessions := t.storage.ListChildSessions(childID)
+	for _, session := range sessions {
+		t.EndSession(session, reason)
+	}
+	return len(sessions)
+}
+
+// checkFilterWindows regenerates and reloads dnsmasq when any timed filter
+// rule crosses its effective_from or effective_until boundary
+func (t *SessionTicker) checkFilterWindows(now time.Time) {
+	current := make(map[string]bool)
+	for _, rule := range t.storage.ListFilters("") {
+		if rule.IsTimed() {
+			current[rule.ID] = rule.IsEffectiveAt(now)
+		}
+	}
+
+	// First tick only records state; configs were generated at startup
+	if t.timedFilters == nil {
+		t.timedFilters = current
+		return
+	}
+
+	changed := false
+	for id, active := range current {
+		if prev, ok := t.timedFilters[id]; ok && prev != active {
+			changed = true
+			break
+		}
+	}
+	t.timedFilters = current
+
+	if changed {
+		log.Println("Filter rule effective window crossed, reloading dnsmasq")
+		if err := t.dnsmasq.ApplyAndReload(); err != nil {
+			log.Printf("dnsmasq reload error: %v", err)
+		}
+	}
+}
+
+// checkPrune prunes history older than the retention period, at most once a day
+func (t *SessionTicker) checkPrune(now time.Time) {
+	if t.retentionDays <= 0 || now.Sub(t.lastPrune) < 24*time.Hour {
+		return
+	}
+	t.lastPrune = now
+
+	result, err := t.storage.Prune(now.AddDate(0, 0, -t.retentionDays))
+	if err != nil {
+		log.Printf("Prune error: %v", err)
+		return
+	}
+	if result.Sessions+result.AuditEntries+result.UsageDays > 0 {
+		log.Printf("Pruned history older than %d days: %d sessions, %d audit entries, %d usage days",
+			t.retentionDays, result.Sessions, result.AuditEntries, result.UsageDays)
+	}
 }
 
 // checkDailyReset checks if we need to reset daily quotas
@@ -141,6 +590,11 @@ func (t *SessionTicker) checkDailyReset(now time.Time) {
 
 	if needsReset {
 		log.Printf("Resetting daily quotas for %s", todayStr)
-		t.storage.ResetDailyQuotas(todayStr)
+		if err := t.storage.ResetChildQuotas(todayStr); err != nil {
+			log.Printf("Daily quota reset error: %v", err)
+			t.recordEvent(TickEvent{Timestamp: now, EventType: TickEventDailyReset, Detail: "failed: " + err.Error()})
+		} else {
+			t.recordEvent(TickEvent{Timestamp: now, EventType: TickEventDailyReset, Detail: "quotas reset for " + todayStr})
+		}
 	}
 }
@@ -0,0 +1,451 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"parenta/internal/version"
+)
+
+// Update errors
+var (
+	ErrUpToDate         = errors.New("already running the latest version")
+	ErrUpdateInProgress = errors.New("an update is already in progress")
+	ErrNoUpdateAsset    = errors.New("release has no binary for this platform")
+	ErrNoUpdateChecksum = errors.New("release has no SHA256 for the binary")
+)
+
+// Limits for talking to the release server
+const (
+	updateCheckTimeout    = 15 * time.Second
+	updateDownloadTimeout = 5 * time.Minute
+	maxUpdateSize         = 64 << 20
+)
+
+// checksumAssetNames are release assets that may list the binaries' SHA256
+var checksumAssetNames = []string{"SHA256SUMS", "sha256sums.txt", "checksums.txt"}
+
+// releaseInfo is the part of a GitHub release the updater reads
+type releaseInfo struct {
+	TagName     string         `json:"tag_name"`
+	HTMLURL     string         `json:"html_url"`
+	Body        string         `json:"body"`
+	Draft       bool           `json:"draft"`
+	Prerelease  bool           `json:"prerelease"`
+	PublishedAt time.Time      `json:"published_at"`
+	Assets      []releaseAsset `json:"assets"`
+}
+
+// releaseAsset is a file attached to a release. GitHub reports its hash as
+// digest ("sha256:...").
+type releaseAsset struct {
+	Name        string `json:"name"`
+	DownloadURL string `json:"browser_download_url"`
+	Size        int64  `json:"size"`
+	Digest      string `json:"digest"`
+}
+
+// UpdateInfo compares the running version with the latest release
+type UpdateInfo struct {
+	CurrentVersion  string    `json:"current_version"`
+	LatestVersion   string    `json:"latest_version"`
+	UpdateAvailable bool      `json:"update_available"`
+	Asset           string    `json:"asset,omitempty"`
+	AssetSize       int64     `json:"asset_size,omitempty"`
+	SHA256          string    `json:"sha256,omitempty"`
+	ReleaseURL      string    `json:"release_url,omitempty"`
+	ReleaseNotes    string    `json:"release_notes,omitempty"`
+	PublishedAt     time.Time `json:"published_at"`
+
+	// Why the release can't be installed, if it can't
+	Problem string `json:"problem,omitempty"`
+}
+
+// Updater checks a release feed for new versions and replaces the running
+// binary with the one for this platform. The binary is only replaced once
+// it has been downloaded in full, matched its published SHA256 and run, so
+// a failed update leaves the current binary in place.
+type Updater struct {
+	releaseURL string
+	binary     string
+
+	// Held while an update is applied
+	mu sync.Mutex
+}
+
+// NewUpdater creates an Updater reading the release at releaseURL (GitHub
+// releases API: a single release, or a list of which the newest stable one
+// is used). An empty binary replaces the running executable.
+func NewUpdater(releaseURL, binary string) *Updater {
+	return &Updater{releaseURL: releaseURL, binary: binary}
+}
+
+// AssetName returns the release asset name for this platform, e.g.
+// "parenta-linux-arm64"
+func AssetName() string {
+	return "parenta-" + runtime.GOOS + "-" + runtime.GOARCH
+}
+
+// Check fetches the latest release and compares it with the running version
+func (u *Updater) Check(ctx context.Context) (*UpdateInfo, error) {
+	info, _, _, err := u.check(ctx)
+	return info, err
+}
+
+// check is Check, also returning the asset to install and its SHA256 when
+// they were found
+func (u *Updater) check(ctx context.Context) (*UpdateInfo, *releaseAsset, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, updateCheckTimeout)
+	defer cancel()
+
+	release, err := u.fetchRelease(ctx)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	latest := strings.TrimPrefix(release.TagName, "v")
+	info := &UpdateInfo{
+		CurrentVersion:  version.Version,
+		LatestVersion:   latest,
+		UpdateAvailable: compareVersions(latest, version.Version) > 0,
+		ReleaseURL:      release.HTMLURL,
+		ReleaseNotes:    release.Body,
+		PublishedAt:     release.PublishedAt,
+	}
+
+	asset := release.asset(AssetName())
+	if asset == nil {
+		info.Problem = ErrNoUpdateAsset.Error() + " (" + AssetName() + ")"
+		return info, nil, "", nil
+	}
+	info.Asset, info.AssetSize = asset.Name, asset.Size
+
+	sum, err := u.assetChecksum(ctx, release, asset)
+	if err != nil {
+		info.Problem = err.Error()
+		return info, asset, "", nil
+	}
+	info.SHA256 = sum
+	return info, asset, sum, nil
+}
+
+// Apply downloads the latest release's binary for this platform, verifies
+// its SHA256 and that it runs, and puts it in place of the current binary.
+// The old binary is kept with a ".old" suffix. The caller restarts the
+// service to run the new version.
+func (u *Updater) Apply(ctx context.Context) (*UpdateInfo, error) {
+	if !u.mu.TryLock() {
+		return nil, ErrUpdateInProgress
+	}
+	defer u.mu.Unlock()
+
+	info, asset, sum, err := u.check(ctx)
+	switch {
+	case err != nil:
+		return nil, err
+	case !info.UpdateAvailable:
+		return info, ErrUpToDate
+	case asset == nil:
+		return info, ErrNoUpdateAsset
+	case sum == "" && info.Problem != ErrNoUpdateChecksum.Error():
+		return info, fmt.Errorf("%w (%s)", ErrNoUpdateChecksum, info.Problem)
+	case sum == "":
+		return info, ErrNoUpdateChecksum
+	}
+
+	binary, err := u.binaryPath()
+	if err != nil {
+		return info, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, updateDownloadTimeout)
+	defer cancel()
+	staged, err := u.download(ctx, asset, sum, binary)
+	if err != nil {
+		return info, err
+	}
+	defer os.Remove(staged) // Gone after the rename unless something failed
+
+	if err := checkBinaryRuns(ctx, staged); err != nil {
+		return info, err
+	}
+
+	// Keep the old binary for a manual rollback. Without it the update
+	// still goes ahead.
+	backup := binary + ".old"
+	os.Remove(backup)
+	if err := os.Link(binary, backup); err != nil {
+		log.Printf("Update: could not keep the old binary as %s: %v", backup, err)
+	}
+
+	if err := os.Rename(staged, binary); err != nil {
+		return info, fmt.Errorf("replace binary: %w", err)
+	}
+	log.Printf("Update: replaced %s with v%s (was v%s)", binary, info.LatestVersion, info.CurrentVersion)
+	return info, nil
+}
+
+// binaryPath returns the binary to replace
+func (u *Updater) binaryPath() (string, error) {
+	if u.binary != "" {
+		return u.binary, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("find running binary: %w", err)
+	}
+	return filepath.EvalSymlinks(exe)
+}
+
+// fetchRelease reads the release feed
+func (u *Updater) fetchRelease(ctx context.Context) (*releaseInfo, error) {
+	data, err := httpGet(ctx, u.releaseURL, 1<<20)
+	if err != nil {
+		return nil, fmt.Errorf("fetch release: %w", err)
+	}
+
+	// A list of releases, newest first, or a single one
+	var releases []releaseInfo
+	if err := json.Unmarshal(data, &releases); err == nil {
+		for i := range releases {
+			if !releases[i].Draft && !releases[i].Prerelease {
+				return &releases[i], nil
+			}
+		}
+		return nil, errors.New("fetch release: no stable release found")
+	}
+
+	var release releaseInfo
+	if err := json.Unmarshal(data, &release); err != nil {
+		return nil, fmt.Errorf("fetch release: invalid release JSON: %w", err)
+	}
+	if release.TagName == "" {
+		return nil, errors.New("fetch release: release has no tag_name")
+	}
+	return &release, nil
+}
+
+// asset returns the named asset, or nil
+func (r *releaseInfo) asset(name string) *releaseAsset {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i]
+		}
+	}
+	return nil
+}
+
+// assetChecksum returns the SHA256 published for an asset: its digest, or
+// its line in a checksum file attached to the release
+func (u *Updater) assetChecksum(ctx context.Context, release *releaseInfo, asset *releaseAsset) (string, error) {
+	if sum, ok := strings.CutPrefix(asset.Digest, "sha256:"); ok && isSHA256(sum) {
+		return strings.ToLower(sum), nil
+	}
+
+	names := append([]string{asset.Name + ".sha256"}, checksumAssetNames...)
+	for _, name := range names {
+		file := release.asset(name)
+		if file == nil {
+			continue
+		}
+		data, err := httpGet(ctx, file.DownloadURL, 1<<20)
+		if err != nil {
+			return "", fmt.Errorf("fetch %s: %w", name, err)
+		}
+		if sum := findChecksum(string(data), asset.Name, name == asset.Name+".sha256"); sum != "" {
+			return sum, nil
+		}
+	}
+	return "", ErrNoUpdateChecksum
+}
+
+// findChecksum finds a file's SHA256 in sha256sum output ("<hash>  <name>"
+// per line), matching the name exactly. A line holding only a hash is
+// accepted if bare is set, for a checksum file of that file alone.
+func findChecksum(data, name string, bare bool) string {
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !isSHA256(fields[0]) {
+			continue
+		}
+		if (len(fields) == 1 && bare) || (len(fields) > 1 && strings.TrimPrefix(fields[1], "*") == name) {
+			return strings.ToLower(fields[0])
+		}
+	}
+	return ""
+}
+
+// isSHA256 reports whether s is a hex SHA256
+func isSHA256(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == sha256.Size*2
+}
+
+// download saves an asset next to binary and checks its SHA256, returning
+// the staged file. Nothing is left behind if it fails.
+func (u *Updater) download(ctx context.Context, asset *releaseAsset, sum, binary string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, asset.DownloadURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", asset.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download %s: %s", asset.Name, resp.Status)
+	}
+
+	info, err := os.Stat(binary)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(binary), ".parenta-update-*")
+	if err != nil {
+		return "", fmt.Errorf("stage update: %w", err)
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(resp.Body, maxUpdateSize+1))
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("download %s: %w", asset.Name, err)
+	case n > maxUpdateSize:
+		return "", fmt.Errorf("download %s: larger than %d MB", asset.Name, maxUpdateSize>>20)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != sum {
+		return "", fmt.Errorf("download %s: SHA256 %s does not match the published %s", asset.Name, got, sum)
+	}
+
+	if err := tmp.Chmod(info.Mode().Perm() | 0700); err != nil {
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	ok = true
+	return tmp.Name(), nil
+}
+
+// checkBinaryRuns runs a downloaded binary with -version, so one that
+// can't start here is never installed
+func checkBinaryRuns(ctx context.Context, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "-version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("new binary does not run: %v", err)
+	}
+	if !strings.HasPrefix(string(out), "Parenta v") {
+		return fmt.Errorf("new binary is not Parenta: -version printed %q", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// httpGet fetches a URL, reading at most limit bytes
+func httpGet(ctx context.Context, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json, application/json;q=0.9, */*;q=0.8")
+	req.Header.Set("User-Agent", "Parenta/"+version.Version)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+// compareVersions compares dotted versions such as "1.4.2", returning -1,
+// 0 or 1. A pre-release ("1.4.2-rc1") sorts before its release. Versions
+// that don't parse are only told apart from equal ones.
+func compareVersions(a, b string) int {
+	aNum, aPre, _ := strings.Cut(a, "-")
+	bNum, bPre, _ := strings.Cut(b, "-")
+	aParts, aOK := parseVersion(aNum)
+	bParts, bOK := parseVersion(bNum)
+	if !aOK || !bOK {
+		if a == b {
+			return 0
+		}
+		return 1
+	}
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var x, y int
+		if i < len(aParts) {
+			x = aParts[i]
+		}
+		if i < len(bParts) {
+			y = bParts[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	case aPre < bPre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// parseVersion splits "1.4.2" into its numbers
+func parseVersion(v string) ([]int, bool) {
+	parts := strings.Split(v, ".")
+	nums := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		nums[i] = n
+	}
+	return nums, true
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindChecksum(t *testing.T) {
+	const (
+		armSum  = "0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9"
+		mipsSum = "ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100"
+	)
+	sums := armSum + "  parenta-linux-arm64\n" + strings.ToUpper(mipsSum) + " *parenta-linux-mips\n"
+
+	tests := []struct {
+		name string
+		data string
+		file string
+		bare bool
+		want string
+	}{
+		{"text mode line", sums, "parenta-linux-arm64", false, armSum},
+		{"binary mode line, lowercased", sums, "parenta-linux-mips", false, mipsSum},
+		{"no line for the file", sums, "parenta-linux-mipsel", false, ""},
+		{"prefix of another name", sums, "parenta-linux", false, ""},
+		{"bare hash in the file's own .sha256", armSum + "\n", "parenta-linux-arm64", true, armSum},
+		{"bare hash in a shared SHA256SUMS", armSum + "\n", "parenta-linux-mips", false, ""},
+		{"bare hash ahead of named lines", mipsSum + "\n" + sums, "parenta-linux-arm64", false, armSum},
+		{"not a hash", "deadbeef  parenta-linux-arm64\n", "parenta-linux-arm64", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findChecksum(tt.data, tt.file, tt.bare); got != tt.want {
+				t.Errorf("findChecksum = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"strings"
+
+	"parenta/internal/models"
+)
+
+// uaRule maps a User-Agent substring to a value. Rules are tried in order,
+// so more specific tokens come before the ones they contain.
+type uaRule struct {
+	token string
+	value string
+}
+
+// uaOSRules detect the operating system. Windows Phone also claims to be
+// Android, and Xbox to be Windows.
+var uaOSRules = []uaRule{
+	{"iPad", "iPadOS"},
+	{"iPhone", "iOS"},
+	{"iPod", "iOS"},
+	{"CrOS", "ChromeOS"},
+	{"Windows Phone", "Windows Phone"},
+	{"Android", "Android"},
+	{"PlayStation", "PlayStation"},
+	{"Xbox", "Xbox"},
+	{"Nintendo", "Nintendo"},
+	{"Tizen", "Tizen"},
+	{"Web0S", "webOS"},
+	{"Windows", "Windows"},
+	{"Macintosh", "macOS"},
+	{"Mac OS X", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// uaBrowserRules detect the browser. Most browsers also claim to be Safari
+// or Chrome, so those come last.
+var uaBrowserRules = []uaRule{
+	{"SamsungBrowser", "Samsung Internet"},
+	{"EdgA/", "Edge"},
+	{"EdgiOS/", "Edge"},
+	{"Edg/", "Edge"},
+	{"Edge/", "Edge"}, // EdgeHTML, still on Xbox
+	{"OPR/", "Opera"},
+	{"OPiOS/", "Opera"},
+	{"YaBrowser/", "Yandex"},
+	{"FxiOS/", "Firefox"},
+	{"Firefox/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+}
+
+// uaTypeRules detect the device class before the OS based fallback
+var uaTypeRules = []uaRule{
+	{"PlayStation", "console"},
+	{"Xbox", "console"},
+	{"Nintendo", "console"},
+	{"SMART-TV", "tv"},
+	{"SmartTV", "tv"},
+	{"Tizen", "tv"},
+	{"Web0S", "tv"},
+	{"; AFT", "tv"}, // Fire TV
+	{"iPad", "tablet"},
+	{"Tablet", "tablet"},
+	{"iPhone", "phone"},
+	{"iPod", "phone"},
+	{"Windows Phone", "phone"},
+}
+
+// matchUA returns the value of the first rule whose token is in ua
+func matchUA(ua string, rules []uaRule) string {
+	for _, r := range rules {
+		if strings.Contains(ua, r.token) {
+			return r.value
+		}
+	}
+	return ""
+}
+
+// ClassifyUserAgent works out the OS, browser and device class from a
+// User-Agent header. It only knows common families; anything else is left
+// empty. The raw string is kept, truncated to models.MaxUserAgentLen.
+func ClassifyUserAgent(ua string) models.ClientInfo {
+	ua = strings.TrimSpace(ua)
+	if ua == "" {
+		return models.ClientInfo{}
+	}
+
+	info := models.ClientInfo{
+		UserAgent:  ua,
+		OS:         matchUA(ua, uaOSRules),
+		Browser:    matchUA(ua, uaBrowserRules),
+		DeviceType: matchUA(ua, uaTypeRules),
+	}
+	if len(info.UserAgent) > models.MaxUserAgentLen {
+		info.UserAgent = info.UserAgent[:models.MaxUserAgentLen]
+	}
+
+	if info.DeviceType == "" {
+		switch info.OS {
+		case "Android":
+			// Android tablets leave "Mobile" out of their User-Agent
+			if strings.Contains(ua, "Mobile") {
+				info.DeviceType = "phone"
+			} else {
+				info.DeviceType = "tablet"
+			}
+		case "Windows", "macOS", "Linux", "ChromeOS":
+			info.DeviceType = "desktop"
+		}
+	}
+
+	// Name Apple devices by model, others by OS
+	name := info.OS
+	switch {
+	case strings.Contains(ua, "iPad"):
+		name = "iPad"
+	case strings.Contains(ua, "iPhone"):
+		name = "iPhone"
+	case strings.Contains(ua, "iPod"):
+		name = "iPod"
+	case info.OS == "macOS":
+		name = "Mac"
+	}
+	switch {
+	case name != "" && info.Browser != "":
+		info.Label = name + " – " + info.Browser
+	case name != "":
+		info.Label = name
+	default:
+		info.Label = info.Browser
+	}
+	return info
+}
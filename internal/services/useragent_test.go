@@ -0,0 +1,153 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"parenta/internal/models"
+)
+
+func TestClassifyUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want models.ClientInfo // UserAgent is checked separately
+	}{
+		{
+			name: "iPhone Safari",
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			want: models.ClientInfo{OS: "iOS", Browser: "Safari", DeviceType: "phone", Label: "iPhone – Safari"},
+		},
+		{
+			name: "iPhone Chrome",
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.101 Mobile/15E148 Safari/604.1",
+			want: models.ClientInfo{OS: "iOS", Browser: "Chrome", DeviceType: "phone", Label: "iPhone – Chrome"},
+		},
+		{
+			name: "iPhone captive portal sheet",
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148",
+			want: models.ClientInfo{OS: "iOS", DeviceType: "phone", Label: "iPhone"},
+		},
+		{
+			name: "iPad",
+			ua:   "Mozilla/5.0 (iPad; CPU OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			want: models.ClientInfo{OS: "iPadOS", Browser: "Safari", DeviceType: "tablet", Label: "iPad – Safari"},
+		},
+		{
+			// iPadOS asks for desktop sites by default, and can't be told from a Mac
+			name: "iPad requesting the desktop site",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			want: models.ClientInfo{OS: "macOS", Browser: "Safari", DeviceType: "desktop", Label: "Mac – Safari"},
+		},
+		{
+			name: "Android phone",
+			ua:   "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+			want: models.ClientInfo{OS: "Android", Browser: "Chrome", DeviceType: "phone", Label: "Android – Chrome"},
+		},
+		{
+			name: "Android phone with Samsung Internet",
+			ua:   "Mozilla/5.0 (Linux; Android 13; SM-S911B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+			want: models.ClientInfo{OS: "Android", Browser: "Samsung Internet", DeviceType: "phone", Label: "Android – Samsung Internet"},
+		},
+		{
+			name: "Android tablet",
+			ua:   "Mozilla/5.0 (Linux; Android 13; SM-X200) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Safari/537.36",
+			want: models.ClientInfo{OS: "Android", Browser: "Chrome", DeviceType: "tablet", Label: "Android – Chrome"},
+		},
+		{
+			name: "Android tablet with Firefox",
+			ua:   "Mozilla/5.0 (Android 13; Tablet; rv:121.0) Gecko/121.0 Firefox/121.0",
+			want: models.ClientInfo{OS: "Android", Browser: "Firefox", DeviceType: "tablet", Label: "Android – Firefox"},
+		},
+		{
+			name: "Chromebook",
+			ua:   "Mozilla/5.0 (X11; CrOS x86_64 15633.69.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.6045.212 Safari/537.36",
+			want: models.ClientInfo{OS: "ChromeOS", Browser: "Chrome", DeviceType: "desktop", Label: "ChromeOS – Chrome"},
+		},
+		{
+			name: "Windows Edge",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			want: models.ClientInfo{OS: "Windows", Browser: "Edge", DeviceType: "desktop", Label: "Windows – Edge"},
+		},
+		{
+			name: "Windows Firefox",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			want: models.ClientInfo{OS: "Windows", Browser: "Firefox", DeviceType: "desktop", Label: "Windows – Firefox"},
+		},
+		{
+			name: "macOS Chrome",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			want: models.ClientInfo{OS: "macOS", Browser: "Chrome", DeviceType: "desktop", Label: "Mac – Chrome"},
+		},
+		{
+			name: "Linux Firefox",
+			ua:   "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			want: models.ClientInfo{OS: "Linux", Browser: "Firefox", DeviceType: "desktop", Label: "Linux – Firefox"},
+		},
+		{
+			name: "PlayStation 5",
+			ua:   "Mozilla/5.0 (PlayStation; PlayStation 5/2.26) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0 Safari/605.1.15",
+			want: models.ClientInfo{OS: "PlayStation", Browser: "Safari", DeviceType: "console", Label: "PlayStation – Safari"},
+		},
+		{
+			name: "Xbox",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64; Xbox; Xbox One) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/70.0.3538.102 Safari/537.36 Edge/18.19041",
+			want: models.ClientInfo{OS: "Xbox", Browser: "Edge", DeviceType: "console", Label: "Xbox – Edge"},
+		},
+		{
+			name: "Nintendo Switch",
+			ua:   "Mozilla/5.0 (Nintendo Switch; WifiWebAuthApplet) AppleWebKit/606.4 (KHTML, like Gecko) NF/6.0.1.15.4 NintendoBrowser/5.1.0.20393",
+			want: models.ClientInfo{OS: "Nintendo", DeviceType: "console", Label: "Nintendo"},
+		},
+		{
+			name: "Samsung smart TV",
+			ua:   "Mozilla/5.0 (SMART-TV; LINUX; Tizen 6.0) AppleWebKit/537.36 (KHTML, like Gecko) 76.0.3809.146/6.0 TV Safari/537.36",
+			want: models.ClientInfo{OS: "Tizen", Browser: "Safari", DeviceType: "tv", Label: "Tizen – Safari"},
+		},
+		{
+			name: "LG smart TV",
+			ua:   "Mozilla/5.0 (Web0S; Linux/SmartTV) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/79.0.3945.79 Safari/537.36 WebAppManager",
+			want: models.ClientInfo{OS: "webOS", Browser: "Chrome", DeviceType: "tv", Label: "webOS – Chrome"},
+		},
+		{
+			name: "Fire TV",
+			ua:   "Mozilla/5.0 (Linux; Android 9; AFTMM Build/PS7233; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/108.0.5359.160 Mobile Safari/537.36",
+			want: models.ClientInfo{OS: "Android", Browser: "Chrome", DeviceType: "tv", Label: "Android – Chrome"},
+		},
+		{
+			name: "Windows Phone",
+			ua:   "Mozilla/5.0 (Windows Phone 10.0; Android 6.0.1; Microsoft; Lumia 950) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/52.0.2743.116 Mobile Safari/537.36 Edge/15.15063",
+			want: models.ClientInfo{OS: "Windows Phone", Browser: "Edge", DeviceType: "phone", Label: "Windows Phone – Edge"},
+		},
+		{
+			name: "unknown client",
+			ua:   "curl/8.4.0",
+			want: models.ClientInfo{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ClassifyUserAgent(tt.ua)
+			if got.UserAgent != tt.ua {
+				t.Errorf("UserAgent %q, want it kept", got.UserAgent)
+			}
+			got.UserAgent = ""
+			if got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyUserAgentRaw(t *testing.T) {
+	if got := ClassifyUserAgent("  \t"); got != (models.ClientInfo{}) {
+		t.Errorf("blank User-Agent gave %+v", got)
+	}
+
+	long := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) " + strings.Repeat("x", models.MaxUserAgentLen)
+	got := ClassifyUserAgent(long)
+	if len(got.UserAgent) != models.MaxUserAgentLen || got.OS != "iOS" {
+		t.Errorf("long User-Agent kept %d bytes, OS %q", len(got.UserAgent), got.OS)
+	}
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// voucherAlphabet leaves out characters that are easy to misread: 0/O and 1/I/L
+const voucherAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// VoucherCodeLength is the length of generated voucher codes
+const VoucherCodeLength = 6
+
+// GenerateVoucherCode returns a random code that is easy to read out and type
+func GenerateVoucherCode() string {
+	max := big.NewInt(int64(len(voucherAlphabet)))
+	code := make([]byte, VoucherCodeLength)
+	for i := range code {
+		n, _ := rand.Int(rand.Reader, max)
+		code[i] = voucherAlphabet[n.Int64()]
+	}
+	return string(code)
+}
+
+// NormalizeVoucherCode tidies a code as typed by a guest: spaces and dashes
+// are dropped and letters upper-cased
+func NormalizeVoucherCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, code)
+}
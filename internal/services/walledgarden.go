@@ -0,0 +1,150 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"parenta/internal/storage"
+)
+
+// openNDSInitScript starts and stops openNDS on OpenWrt
+const openNDSInitScript = "/etc/init.d/opennds"
+
+// RestartOpenNDS restarts openNDS, retrying with backoff. Every client is
+// disconnected and must log in again.
+func RestartOpenNDS(attempts int) error {
+	return restartOpenNDS(ExecRunner{}, attempts)
+}
+
+// restartOpenNDS restarts openNDS through r
+func restartOpenNDS(r CommandRunner, attempts int) error {
+	return Retry("opennds restart", attempts, func() error {
+		return runRestart(r, openNDSInitScript, "restart")
+	})
+}
+
+// WalledGardenService writes the walled garden into the openNDS config.
+// Changes are kept pending until Apply, since openNDS has to restart to
+// pick them up.
+type WalledGardenService struct {
+	storage         *storage.Storage
+	scriptPath      string // uci script of the applied walled garden
+	restartAttempts int
+	runner          CommandRunner
+
+	// Serializes Apply
+	mu sync.Mutex
+}
+
+// WalledGardenStatus tells whether the stored walled garden is what
+// openNDS runs with
+type WalledGardenStatus struct {
+	Pending   bool       `json:"pending"` // Stored entries differ from the applied ones
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// NewWalledGardenService creates a new WalledGardenService
+func NewWalledGardenService(store *storage.Storage, scriptPath string, restartAttempts int) *WalledGardenService {
+	return &WalledGardenService{
+		storage:         store,
+		scriptPath:      scriptPath,
+		restartAttempts: restartAttempts,
+		runner:          ExecRunner{},
+	}
+}
+
+// SetRunner makes uci and openNDS restarts run through r
+func (w *WalledGardenService) SetRunner(r CommandRunner) {
+	w.runner = r
+}
+
+// Render returns a shell script that replaces the openNDS walled garden
+// lists with the stored entries. openNDS has a single port list for all
+// hosts, so it is the union of the entries' ports; if any entry allows
+// every port, so does the list.
+func (w *WalledGardenService) Render() string {
+	entries := w.storage.ListWalledGarden()
+
+	hosts := make([]string, 0, len(entries))
+	portSet := make(map[int]bool)
+	anyPort := false
+	for _, e := range entries {
+		hosts = append(hosts, e.Host)
+		if len(e.Ports) == 0 {
+			anyPort = true
+		}
+		for _, p := range e.Ports {
+			portSet[p] = true
+		}
+	}
+	sort.Strings(hosts)
+
+	var ports []int
+	if !anyPort {
+		for p := range portSet {
+			ports = append(ports, p)
+		}
+		sort.Ints(ports)
+	}
+
+	const section = "opennds.@opennds[0]"
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# Parenta walled garden - managed via /api/network/walled-garden, do not edit\n")
+	fmt.Fprintf(&b, "uci -q delete '%s.walledgarden_fqdn_list'\n", section)
+	fmt.Fprintf(&b, "uci -q delete '%s.walledgarden_port_list'\n", section)
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "uci add_list '%s.walledgarden_fqdn_list=%s'\n", section, host)
+	}
+	for _, p := range ports {
+		fmt.Fprintf(&b, "uci add_list '%s.walledgarden_port_list=%d'\n", section, p)
+	}
+	b.WriteString("uci commit opennds\n")
+	return b.String()
+}
+
+// Status compares the stored entries with the last applied script. With
+// nothing applied yet, only a non-empty walled garden is pending.
+func (w *WalledGardenService) Status() WalledGardenStatus {
+	info, err := os.Stat(w.scriptPath)
+	if err != nil {
+		return WalledGardenStatus{Pending: len(w.storage.ListWalledGarden()) > 0}
+	}
+
+	appliedAt := info.ModTime()
+	applied, err := os.ReadFile(w.scriptPath)
+	return WalledGardenStatus{
+		Pending:   err != nil || string(applied) != w.Render(),
+		AppliedAt: &appliedAt,
+	}
+}
+
+// Apply runs the rendered script and restarts openNDS, which disconnects
+// every client. The script is only kept as the applied state once both
+// have succeeded, so a failed apply stays pending.
+func (w *WalledGardenService) Apply() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(w.scriptPath), 0755); err != nil {
+		return fmt.Errorf("create script dir: %w", err)
+	}
+	tmpPath := w.scriptPath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(w.Render()), 0755); err != nil {
+		return fmt.Errorf("write script: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	if _, err := w.runner.Run("sh", tmpPath); err != nil {
+		return fmt.Errorf("update openNDS config: %w", err)
+	}
+	if err := restartOpenNDS(w.runner, w.restartAttempts); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, w.scriptPath)
+}
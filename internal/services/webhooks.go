@@ -0,0 +1,382 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"parenta/internal/models"
+	"parenta/internal/storage"
+)
+
+const (
+	// webhookAttempts is how often a delivery is tried, waiting
+	// webhookRetryDelay before the second attempt and twice as long before
+	// each one after
+	webhookAttempts   = 5
+	webhookRetryDelay = 5 * time.Second
+
+	// webhookTimeout limits a single POST
+	webhookTimeout = 15 * time.Second
+
+	// webhookConcurrency bounds the POSTs in flight at once
+	webhookConcurrency = 4
+
+	// WebhookMaxFailures failed deliveries in a row disable a subscription
+	WebhookMaxFailures = 5
+
+	// MaxWebhookDeliveries is how many recent deliveries are kept, with
+	// their payloads, for the log and redelivery
+	MaxWebhookDeliveries = 200
+
+	// WebhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of
+	// the body keyed with the subscription's secret
+	WebhookSignatureHeader = "X-Parenta-Signature"
+)
+
+// ErrDeliveryNotFound is returned when redelivering a delivery that isn't
+// in the recent log
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// ErrWebhookNotFound is returned for a subscription that doesn't exist
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// errWebhookDisabled abandons a retry once its subscription is turned off
+var errWebhookDisabled = errors.New("webhook is disabled")
+
+// webhookEvent is the body posted to subscriptions: the notification with
+// an ID that stays the same across retries and redeliveries
+type webhookEvent struct {
+	ID string `json:"id"`
+	Notification
+}
+
+// Webhooks posts events to the webhook subscriptions that ask for them,
+// retrying with exponential backoff. Recent deliveries are kept in memory.
+type Webhooks struct {
+	storage *storage.Storage
+	client  *http.Client
+	slots   chan struct{}
+
+	mu         sync.Mutex
+	deliveries []*models.WebhookDelivery // Oldest first
+	ready      chan struct{}
+	stop       chan struct{}
+	started    bool
+	stopped    bool
+	done       sync.WaitGroup
+}
+
+// NewWebhooks creates a Webhooks. Events are accepted from the start but
+// only posted once Start is called.
+func NewWebhooks(store *storage.Storage) *Webhooks {
+	return &Webhooks{
+		storage: store,
+		client:  &http.Client{Timeout: webhookTimeout},
+		slots:   make(chan struct{}, webhookConcurrency),
+		ready:   make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start begins posting deliveries
+func (w *Webhooks) Start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started || w.stopped {
+		return
+	}
+	w.started = true
+	close(w.ready)
+}
+
+// Stop abandons deliveries still waiting for a retry and waits for those
+// being posted
+func (w *Webhooks) Stop() {
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		return
+	}
+	w.stopped = true
+	close(w.stop)
+	w.mu.Unlock()
+	w.done.Wait()
+}
+
+// Dispatch posts a notification to every enabled subscription that asks
+// for its event
+func (w *Webhooks) Dispatch(note Notification) {
+	var subs []*models.WebhookSubscription
+	for _, sub := range w.storage.ListWebhooks() {
+		if sub.Wants(note.Event) {
+			subs = append(subs, sub)
+		}
+	}
+	if len(subs) == 0 {
+		return
+	}
+
+	eventID := GenerateID()
+	payload, err := json.Marshal(webhookEvent{ID: eventID, Notification: note})
+	if err != nil {
+		log.Printf("Webhook %s: %v", note.Event, err)
+		return
+	}
+
+	for _, sub := range subs {
+		d := w.record(sub.ID, eventID, note.Event, payload, false)
+		if d == nil {
+			return
+		}
+		go w.deliverWithRetry(d)
+	}
+}
+
+// Redeliver posts a recent delivery's event to its subscription again, once,
+// and returns the new delivery
+func (w *Webhooks) Redeliver(ctx context.Context, subID, deliveryID string) (*models.WebhookDelivery, error) {
+	if w.storage.GetWebhook(subID) == nil {
+		return nil, ErrWebhookNotFound
+	}
+
+	w.mu.Lock()
+	var original *models.WebhookDelivery
+	for _, d := range w.deliveries {
+		if d.ID == deliveryID && d.SubscriptionID == subID {
+			original = d
+			break
+		}
+	}
+	w.mu.Unlock()
+	if original == nil {
+		return nil, ErrDeliveryNotFound
+	}
+
+	d := w.record(subID, original.EventID, original.Event, original.Payload, true)
+	if d == nil {
+		return nil, errors.New("webhooks are stopped")
+	}
+	defer w.done.Done()
+	_, err := w.attempt(ctx, d)
+	w.finish(d, err)
+	return w.snapshot(d), nil
+}
+
+// Deliveries returns up to limit recent deliveries, newest first, for one
+// subscription or for all of them if subID is empty
+func (w *Webhooks) Deliveries(subID string, limit int) []models.WebhookDelivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	result := []models.WebhookDelivery{}
+	for i := len(w.deliveries) - 1; i >= 0 && len(result) < limit; i-- {
+		if d := w.deliveries[i]; subID == "" || d.SubscriptionID == subID {
+			result = append(result, *d)
+		}
+	}
+	return result
+}
+
+// Forget drops a deleted subscription's deliveries from the log
+func (w *Webhooks) Forget(subID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	kept := w.deliveries[:0]
+	for _, d := range w.deliveries {
+		if d.SubscriptionID != subID {
+			kept = append(kept, d)
+		}
+	}
+	clear(w.deliveries[len(kept):])
+	w.deliveries = kept
+}
+
+// record adds a pending delivery to the log, dropping the oldest past
+// MaxWebhookDeliveries, and counts it in flight until its sender calls
+// done.Done. Returns nil once stopped.
+func (w *Webhooks) record(subID, eventID string, event models.NotificationEvent, payload []byte, redelivery bool) *models.WebhookDelivery {
+	d := &models.WebhookDelivery{
+		ID:             GenerateID(),
+		SubscriptionID: subID,
+		EventID:        eventID,
+		Event:          event,
+		Redelivery:     redelivery,
+		Status:         models.DeliveryPending,
+		CreatedAt:      time.Now(),
+		Payload:        payload,
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return nil
+	}
+	if len(w.deliveries) >= MaxWebhookDeliveries {
+		w.deliveries[0] = nil
+		w.deliveries = w.deliveries[1:]
+	}
+	w.deliveries = append(w.deliveries, d)
+	w.done.Add(1)
+	return d
+}
+
+// snapshot copies a delivery under the lock
+func (w *Webhooks) snapshot(d *models.WebhookDelivery) *models.WebhookDelivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	copied := *d
+	return &copied
+}
+
+// deliverWithRetry tries a delivery up to webhookAttempts times, doubling
+// the wait each time. Client errors other than 408 and 429 aren't retried.
+func (w *Webhooks) deliverWithRetry(d *models.WebhookDelivery) {
+	defer w.done.Done()
+
+	select {
+	case <-w.ready:
+	case <-w.stop:
+		return
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		retry, err := w.attempt(ctx, d)
+		cancel()
+		if err == nil || !retry || attempt == webhookAttempts {
+			w.finish(d, err)
+			return
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-w.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// attempt posts a delivery once with the subscription's current URL and
+// secret. Reports whether a failure is worth retrying.
+func (w *Webhooks) attempt(ctx context.Context, d *models.WebhookDelivery) (retry bool, err error) {
+	sub := w.storage.GetWebhook(d.SubscriptionID)
+	if sub == nil {
+		return false, ErrWebhookNotFound
+	}
+	if !sub.Enabled && !d.Redelivery {
+		return false, errWebhookDisabled
+	}
+
+	select {
+	case w.slots <- struct{}{}:
+		defer func() { <-w.slots }()
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Parenta-Webhook")
+	req.Header.Set("X-Parenta-Event", string(d.Event))
+	req.Header.Set("X-Parenta-Delivery", d.ID)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(sub.Secret, d.Payload))
+
+	start := time.Now()
+	resp, err := w.client.Do(req)
+	elapsed := time.Since(start)
+
+	status := 0
+	if err == nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status < 200 || status > 299 {
+			err = fmt.Errorf("%d %s", status, http.StatusText(status))
+			retry = status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+		}
+	} else {
+		retry = true
+	}
+
+	w.mu.Lock()
+	d.Attempts++
+	d.StatusCode = status
+	d.DurationMs = elapsed.Milliseconds()
+	d.Error = ""
+	if err != nil {
+		d.Error = err.Error()
+	}
+	w.mu.Unlock()
+	return retry, err
+}
+
+// finish records a delivery's outcome on it and its subscription. Enough
+// failures in a row disable the subscription.
+func (w *Webhooks) finish(d *models.WebhookDelivery, err error) {
+	now := time.Now()
+
+	w.mu.Lock()
+	d.CompletedAt = &now
+	d.Status = models.DeliverySucceeded
+	if err != nil {
+		d.Status = models.DeliveryFailed
+		d.Error = err.Error()
+	}
+	statusCode := d.StatusCode
+	w.mu.Unlock()
+
+	if errors.Is(err, ErrWebhookNotFound) || errors.Is(err, errWebhookDisabled) {
+		return
+	}
+	current := w.storage.GetWebhook(d.SubscriptionID)
+	if current == nil {
+		return
+	}
+	sub := *current
+	sub.LastDeliveryAt = &now
+	sub.LastStatus = d.Status
+	sub.LastStatusCode = statusCode
+	sub.LastError = ""
+	if err == nil {
+		sub.ConsecutiveFailures = 0
+	} else {
+		sub.LastError = err.Error()
+		sub.ConsecutiveFailures++
+		if sub.Enabled && sub.ConsecutiveFailures >= WebhookMaxFailures {
+			sub.Enabled = false
+			sub.DisabledReason = fmt.Sprintf("disabled after %d failed deliveries in a row", sub.ConsecutiveFailures)
+			log.Printf("Webhook %s %s", sub.URL, sub.DisabledReason)
+		}
+		log.Printf("Webhook %s to %s failed: %v", d.Event, sub.URL, err)
+	}
+	if err := w.storage.SaveWebhook(&sub); err != nil {
+		log.Printf("Failed to save webhook %s: %v", sub.ID, err)
+	}
+}
+
+// SignWebhook returns the signature header value for a body: "sha256="
+// and the hex HMAC-SHA256 of body keyed with secret
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ErrLocked is returned by Lock when another process holds the data directory
+var ErrLocked = errors.New("data directory is in use by another process")
+
+// Lock takes an exclusive lock on dataDir so the service and the admin CLI
+// never write the JSON files at the same time. The lock is released by the
+// returned function, or by the kernel when the process exits. A missing
+// dataDir is created with dirMode.
+func Lock(dataDir string, dirMode os.FileMode) (func(), error) {
+	if err := os.MkdirAll(dataDir, dirMode); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dataDir, ".lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
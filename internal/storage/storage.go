@@ -1,18 +1,30 @@
 package storage
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"parenta/internal/models"
 )
 
 // Storage handles all data persistence using JSON files
 type Storage struct {
-	dataDir string
-	mu      sync.RWMutex
+	dataDir  string
+	dirMode  os.FileMode
+	fileMode os.FileMode
+	mu       sync.RWMutex
 
 	// In-memory cache
 	admins    []*models.User // Multiple admin support
@@ -20,22 +32,50 @@ type Storage struct {
 	sessions  []*models.Session
 	schedules []*models.Schedule
 	filters   []*models.FilterRule
+
+	refreshTokens []*models.RefreshToken
+	adminSessions []*models.AdminSession
+	apiKeys       []*models.APIKey
+	vouchers      []*models.Voucher
+	auditLog      []*models.AuditEntry
+	preauth       []*models.PreAuthDevice
+	walledGarden  []*models.WalledGardenEntry
+	webhooks      []*models.WebhookSubscription
+
+	holidayMode  models.HolidayMode
+	settings     models.Settings
+	backupStatus models.BackupStatus
+
+	// Random per-install identifier, generated on first start
+	installID string
 }
 
-// New creates a new Storage instance
-func New(dataDir string) (*Storage, error) {
+// New creates a new Storage instance. The data directory is created with
+// dirMode if missing, and data files are written with fileMode.
+func New(dataDir string, dirMode, fileMode os.FileMode) (*Storage, error) {
 	// Ensure data directory exists
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+	if err := os.MkdirAll(dataDir, dirMode); err != nil {
 		return nil, err
 	}
 
 	s := &Storage{
 		dataDir:   dataDir,
+		dirMode:   dirMode,
+		fileMode:  fileMode,
 		admins:    make([]*models.User, 0),
 		children:  make([]*models.Child, 0),
 		sessions:  make([]*models.Session, 0),
 		schedules: make([]*models.Schedule, 0),
 		filters:   make([]*models.FilterRule, 0),
+
+		refreshTokens: make([]*models.RefreshToken, 0),
+		adminSessions: make([]*models.AdminSession, 0),
+		apiKeys:       make([]*models.APIKey, 0),
+		vouchers:      make([]*models.Voucher, 0),
+		auditLog:      make([]*models.AuditEntry, 0),
+		preauth:       make([]*models.PreAuthDevice, 0),
+		walledGarden:  make([]*models.WalledGardenEntry, 0),
+		webhooks:      make([]*models.WebhookSubscription, 0),
 	}
 
 	// Load existing data
@@ -75,6 +115,12 @@ func (s *Storage) loadAll() error {
 	// Load children
 	if data, err := os.ReadFile(s.filePath("children.json")); err == nil {
 		json.Unmarshal(data, &s.children)
+		// Devices registered before private MACs were tracked
+		for _, c := range s.children {
+			for i := range c.Devices {
+				c.Devices[i].Randomized = models.IsRandomizedMAC(c.Devices[i].MAC)
+			}
+		}
 	}
 
 	// Load sessions
@@ -92,14 +138,146 @@ func (s *Storage) loadAll() error {
 		json.Unmarshal(data, &s.filters)
 	}
 
+	// Load refresh tokens
+	if data, err := os.ReadFile(s.filePath("refresh_tokens.json")); err == nil {
+		json.Unmarshal(data, &s.refreshTokens)
+	}
+
+	// Load admin sessions
+	if data, err := os.ReadFile(s.filePath("admin_sessions.json")); err == nil {
+		json.Unmarshal(data, &s.adminSessions)
+	}
+
+	// Load API keys
+	if data, err := os.ReadFile(s.filePath("api_keys.json")); err == nil {
+		json.Unmarshal(data, &s.apiKeys)
+	}
+
+	// Load vouchers
+	if data, err := os.ReadFile(s.filePath("vouchers.json")); err == nil {
+		json.Unmarshal(data, &s.vouchers)
+	}
+
+	// Load audit log
+	if data, err := os.ReadFile(s.filePath("audit.json")); err == nil {
+		json.Unmarshal(data, &s.auditLog)
+	}
+
+	// Load preauth devices
+	if data, err := os.ReadFile(s.filePath("preauth_devices.json")); err == nil {
+		json.Unmarshal(data, &s.preauth)
+	}
+
+	// Load walled garden
+	if data, err := os.ReadFile(s.filePath("walled_garden.json")); err == nil {
+		json.Unmarshal(data, &s.walledGarden)
+	}
+
+	// Load webhook subscriptions
+	if data, err := os.ReadFile(s.filePath("webhooks.json")); err == nil {
+		json.Unmarshal(data, &s.webhooks)
+	}
+
+	// Load holiday mode
+	if data, err := os.ReadFile(s.filePath("holiday.json")); err == nil {
+		json.Unmarshal(data, &s.holidayMode)
+	}
+	models.ApplyHolidayMode(s.holidayMode)
+
+	// Load settings over the defaults, so new options get sensible values
+	s.settings = models.DefaultSettings()
+	if data, err := os.ReadFile(s.filePath("settings.json")); err == nil {
+		json.Unmarshal(data, &s.settings)
+	}
+
+	if data, err := os.ReadFile(s.filePath("backup_status.json")); err == nil {
+		json.Unmarshal(data, &s.backupStatus)
+	}
+
+	// Load or create the install ID
+	var install struct {
+		ID string `json:"id"`
+	}
+	if data, err := os.ReadFile(s.filePath("install.json")); err == nil {
+		json.Unmarshal(data, &install)
+	}
+	if install.ID == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		install.ID = hex.EncodeToString(b)
+		if err := s.saveFile("install.json", install); err != nil {
+			return err
+		}
+	}
+	s.installID = install.ID
+
 	return nil
 }
 
+// DataDir returns the directory the data files are kept in
+func (s *Storage) DataDir() string {
+	return s.dataDir
+}
+
 // filePath returns the full path for a data file
 func (s *Storage) filePath(filename string) string {
 	return filepath.Join(s.dataDir, filename)
 }
 
+// LastModifiedAt returns the latest modification time of the entities' JSON
+// files (e.g. "children" -> children.json). Files that don't exist are
+// skipped; the zero time is returned if none do.
+func (s *Storage) LastModifiedAt(entities ...string) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var latest time.Time
+	for _, entity := range entities {
+		info, err := os.Stat(s.filePath(entity + ".json"))
+		if err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// CheckPermissions returns a warning for the data directory and each data
+// file that grants more access than the configured modes. Files are only
+// tightened when they are next saved.
+func (s *Storage) CheckPermissions() []string {
+	var warnings []string
+	if info, err := os.Stat(s.dataDir); err == nil && info.Mode().Perm()&^s.dirMode != 0 {
+		warnings = append(warnings, fmt.Sprintf("data directory %s has mode %#o, expected %#o", s.dataDir, info.Mode().Perm(), s.dirMode))
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(s.dataDir, "*.json"))
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || info.Mode().Perm()&^s.fileMode == 0 {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s has mode %#o, expected %#o", path, info.Mode().Perm(), s.fileMode))
+	}
+	return warnings
+}
+
+// FileSizes returns the size in bytes of each data file, by file name
+func (s *Storage) FileSizes() map[string]int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sizes := make(map[string]int64)
+	matches, _ := filepath.Glob(filepath.Join(s.dataDir, "*.json"))
+	for _, path := range matches {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			sizes[filepath.Base(path)] = info.Size()
+		}
+	}
+	return sizes
+}
+
 // saveFile atomically writes data to a JSON file
 func (s *Storage) saveFile(filename string, data interface{}) error {
 	jsonData, err := json.MarshalIndent(data, "", "  ")
@@ -111,7 +289,7 @@ func (s *Storage) saveFile(filename string, data interface{}) error {
 	tmpPath := path + ".tmp"
 
 	// Write to temp file first
-	if err := os.WriteFile(tmpPath, jsonData, 0644); err != nil {
+	if err := os.WriteFile(tmpPath, jsonData, s.fileMode); err != nil {
 		return err
 	}
 
@@ -303,6 +481,11 @@ func (s *Storage) DeleteChild(id string) error {
 	return s.saveFile("children.json", s.children)
 }
 
+// InstallID returns the random identifier of this installation
+func (s *Storage) InstallID() string {
+	return s.installID
+}
+
 // ============ Session Methods ============
 
 // ListSessions returns all active sessions
@@ -332,6 +515,68 @@ func (s *Storage) GetSession(id string) *models.Session {
 	return nil
 }
 
+// ListChildSessions returns a child's active sessions, oldest first
+func (s *Storage) ListChildSessions(childID string) []*models.Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*models.Session, 0)
+	for _, sess := range s.sessions {
+		if sess.ChildID == childID && sess.IsActive {
+			result = append(result, sess)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].StartedAt.Before(result[j].StartedAt) })
+	return result
+}
+
+// ListSessionsByChildID returns all of a child's sessions, active and ended
+func (s *Storage) ListSessionsByChildID(childID string) []*models.Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*models.Session, 0)
+	for _, sess := range s.sessions {
+		if sess.ChildID == childID {
+			result = append(result, sess)
+		}
+	}
+	return result
+}
+
+// ListAllSessions returns one page of active and ended sessions, newest
+// first, optionally filtered by child, with the total number of matches.
+// Pages start at 1.
+func (s *Storage) ListAllSessions(childID string, page, limit int) ([]*models.Session, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matched := make([]*models.Session, 0)
+	for _, sess := range s.sessions {
+		if childID == "" || sess.ChildID == childID {
+			matched = append(matched, sess)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].StartedAt.After(matched[j].StartedAt) })
+
+	total := len(matched)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		return []*models.Session{}, total
+	}
+	start := (page - 1) * limit
+	if start >= total {
+		return []*models.Session{}, total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+	return matched[start:end], total
+}
+
 // GetSessionByMAC returns an active session for a MAC address
 func (s *Storage) GetSessionByMAC(mac string) *models.Session {
 	s.mu.RLock()
@@ -345,6 +590,24 @@ func (s *Storage) GetSessionByMAC(mac string) *models.Session {
 	return nil
 }
 
+// GetSessionByIP returns the active session for an IP. If DHCP has handed
+// the address out twice, the most recently started session wins.
+func (s *Storage) GetSessionByIP(ip string) *models.Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found *models.Session
+	if ip == "" {
+		return nil
+	}
+	for _, sess := range s.sessions {
+		if (sess.IP == ip || sess.IPv6 == ip) && sess.IsActive && (found == nil || sess.StartedAt.After(found.StartedAt)) {
+			found = sess
+		}
+	}
+	return found
+}
+
 // SaveSession saves or updates a session
 func (s *Storage) SaveSession(session *models.Session) error {
 	s.mu.Lock()
@@ -365,6 +628,62 @@ func (s *Storage) SaveSession(session *models.Session) error {
 	return s.saveFile("sessions.json", s.sessions)
 }
 
+// GetChildDailyUsage returns a child's usage for each of the last days days
+// (oldest first, today last). Sessions spanning midnight are split so each
+// day gets the minutes that fell on it; a session counts towards every day
+// it touched.
+func (s *Storage) GetChildDailyUsage(childID string, days int) []models.DailyUsage {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return s.GetChildUsageFrom(childID, today.AddDate(0, 0, -(days-1)), days)
+}
+
+// GetChildUsageFrom returns a child's usage for each of days days starting
+// with first's day, split across midnights like GetChildDailyUsage
+func (s *Storage) GetChildUsageFrom(childID string, first time.Time, days int) []models.DailyUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	first = time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, first.Location())
+	usage := make([]models.DailyUsage, days)
+	index := make(map[string]int, days)
+	for i := range usage {
+		date := first.AddDate(0, 0, i).Format(models.DateLayout)
+		usage[i].Date = date
+		index[date] = i
+	}
+
+	seconds := make([]float64, days)
+	for _, sess := range s.sessions {
+		if sess.ChildID != childID {
+			continue
+		}
+
+		start, end := sess.StartedAt, sess.EndTime()
+		if start.Before(first) {
+			start = first
+		}
+		for start.Before(end) {
+			y, m, d := start.Date()
+			midnight := time.Date(y, m, d+1, 0, 0, 0, 0, start.Location())
+			segEnd := end
+			if midnight.Before(segEnd) {
+				segEnd = midnight
+			}
+			if i, ok := index[start.Format(models.DateLayout)]; ok {
+				seconds[i] += segEnd.Sub(start).Seconds()
+				usage[i].SessionCount++
+			}
+			start = segEnd
+		}
+	}
+
+	for i := range usage {
+		usage[i].MinutesUsed = int(seconds[i] / 60)
+	}
+	return usage
+}
+
 // DeleteSession removes a session by ID
 func (s *Storage) DeleteSession(id string) error {
 	s.mu.Lock()
@@ -476,6 +795,20 @@ func (s *Storage) SaveFilter(filter *models.FilterRule) error {
 	return s.saveFile("filters.json", s.filters)
 }
 
+// BatchSaveFilters adds many new filter rules with a single write of
+// filters.json
+func (s *Storage) BatchSaveFilters(filters []*models.FilterRule) error {
+	if len(filters) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.filters = append(s.filters, filters...)
+	return s.saveFile("filters.json", s.filters)
+}
+
 // DeleteFilter removes a filter by ID
 func (s *Storage) DeleteFilter(id string) error {
 	s.mu.Lock()
@@ -491,19 +824,801 @@ func (s *Storage) DeleteFilter(id string) error {
 	return s.saveFile("filters.json", s.filters)
 }
 
-// ============ Utility Methods ============
+// ============ Refresh Token Methods ============
 
-// ResetDailyQuotas resets all children's used_today to 0
-func (s *Storage) ResetDailyQuotas(dateStr string) error {
+// GetRefreshTokenByHash returns a refresh token record by its token hash
+func (s *Storage) GetRefreshTokenByHash(hash string) *models.RefreshToken {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, t := range s.refreshTokens {
+		if t.TokenHash == hash {
+			return t
+		}
+	}
+	return nil
+}
+
+// SaveRefreshToken creates or updates a refresh token record.
+// Expired records are pruned on every save.
+func (s *Storage) SaveRefreshToken(token *models.RefreshToken) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for _, c := range s.children {
-		c.UsedTodayMin = 0
-		c.LastResetDate = dateStr
+	now := time.Now()
+	kept := make([]*models.RefreshToken, 0, len(s.refreshTokens)+1)
+	found := false
+	for _, t := range s.refreshTokens {
+		if t.ID == token.ID {
+			t = token
+			found = true
+		}
+		if now.Before(t.ExpiresAt) {
+			kept = append(kept, t)
+		}
+	}
+	if !found {
+		kept = append(kept, token)
 	}
+	s.refreshTokens = kept
 
-	return s.saveFile("children.json", s.children)
+	return s.saveFile("refresh_tokens.json", s.refreshTokens)
+}
+
+// RevokeRefreshTokensForSession revokes every active refresh token of an admin session
+func (s *Storage) RevokeRefreshTokensForSession(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range s.refreshTokens {
+		if t.SessionID == sessionID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+
+	return s.saveFile("refresh_tokens.json", s.refreshTokens)
+}
+
+// RevokeRefreshTokensForUser revokes every active refresh token for a user
+func (s *Storage) RevokeRefreshTokensForUser(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range s.refreshTokens {
+		if t.UserID == userID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+
+	return s.saveFile("refresh_tokens.json", s.refreshTokens)
+}
+
+// ============ Admin Session Methods ============
+
+// ListAdminSessions returns the sessions of an admin, including revoked and
+// expired ones not yet pruned. An empty userID returns every session.
+func (s *Storage) ListAdminSessions(userID string) []*models.AdminSession {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*models.AdminSession, 0)
+	for _, sess := range s.adminSessions {
+		if userID == "" || sess.UserID == userID {
+			result = append(result, sess)
+		}
+	}
+	return result
+}
+
+// GetAdminSession returns an admin session by ID
+func (s *Storage) GetAdminSession(id string) *models.AdminSession {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, sess := range s.adminSessions {
+		if sess.ID == id {
+			return sess
+		}
+	}
+	return nil
+}
+
+// SaveAdminSession creates or updates an admin session.
+// Expired sessions are pruned on every save.
+func (s *Storage) SaveAdminSession(session *models.AdminSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	kept := make([]*models.AdminSession, 0, len(s.adminSessions)+1)
+	found := false
+	for _, sess := range s.adminSessions {
+		if sess.ID == session.ID {
+			sess = session
+			found = true
+		}
+		if now.Before(sess.ExpiresAt) {
+			kept = append(kept, sess)
+		}
+	}
+	if !found {
+		kept = append(kept, session)
+	}
+	s.adminSessions = kept
+
+	return s.saveFile("admin_sessions.json", s.adminSessions)
+}
+
+// TouchAdminSessions records last-seen times for many sessions in one write.
+// Updated sessions are replaced rather than modified, since callers may be
+// reading the old records.
+func (s *Storage) TouchAdminSessions(seen map[string]time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for i, sess := range s.adminSessions {
+		if t, ok := seen[sess.ID]; ok && t.After(sess.LastSeenAt) {
+			updated := *sess
+			updated.LastSeenAt = t
+			s.adminSessions[i] = &updated
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	return s.saveFile("admin_sessions.json", s.adminSessions)
+}
+
+// ============ API Key Methods ============
+
+// ListAPIKeys returns the API keys of an admin, or every key if userID is empty
+func (s *Storage) ListAPIKeys(userID string) []*models.APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*models.APIKey, 0)
+	for _, k := range s.apiKeys {
+		if userID == "" || k.UserID == userID {
+			result = append(result, k)
+		}
+	}
+	return result
+}
+
+// GetAPIKey returns an API key by ID
+func (s *Storage) GetAPIKey(id string) *models.APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, k := range s.apiKeys {
+		if k.ID == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// GetAPIKeyByHash returns an API key by its key hash
+func (s *Storage) GetAPIKeyByHash(hash string) *models.APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, k := range s.apiKeys {
+		if k.KeyHash == hash {
+			return k
+		}
+	}
+	return nil
+}
+
+// SaveAPIKey creates or updates an API key
+func (s *Storage) SaveAPIKey(key *models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for i, k := range s.apiKeys {
+		if k.ID == key.ID {
+			s.apiKeys[i] = key
+			found = true
+			break
+		}
+	}
+	if !found {
+		s.apiKeys = append(s.apiKeys, key)
+	}
+
+	return s.saveFile("api_keys.json", s.apiKeys)
+}
+
+// DeleteAPIKey removes an API key
+func (s *Storage) DeleteAPIKey(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, k := range s.apiKeys {
+		if k.ID == id {
+			s.apiKeys = append(s.apiKeys[:i], s.apiKeys[i+1:]...)
+			return s.saveFile("api_keys.json", s.apiKeys)
+		}
+	}
+	return nil
+}
+
+// ============ Voucher Methods ============
+
+// ListVouchers returns all vouchers
+func (s *Storage) ListVouchers() []*models.Voucher {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*models.Voucher, len(s.vouchers))
+	copy(result, s.vouchers)
+	return result
+}
+
+// GetVoucher returns a voucher by ID
+func (s *Storage) GetVoucher(id string) *models.Voucher {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, v := range s.vouchers {
+		if v.ID == id {
+			return v
+		}
+	}
+	return nil
+}
+
+// GetVoucherByCode returns a voucher by its code, ignoring case
+func (s *Storage) GetVoucherByCode(code string) *models.Voucher {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, v := range s.vouchers {
+		if strings.EqualFold(v.Code, code) {
+			return v
+		}
+	}
+	return nil
+}
+
+// SaveVoucher creates or updates a voucher
+func (s *Storage) SaveVoucher(voucher *models.Voucher) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for i, v := range s.vouchers {
+		if v.ID == voucher.ID {
+			s.vouchers[i] = voucher
+			found = true
+			break
+		}
+	}
+	if !found {
+		s.vouchers = append(s.vouchers, voucher)
+	}
+
+	return s.saveFile("vouchers.json", s.vouchers)
+}
+
+// UseVoucher redeems one use of the voucher with the given code, checking
+// and counting the use under one lock so concurrent logins can't overspend
+// it. Returns nil if there is no such code or it can't be used at now.
+func (s *Storage) UseVoucher(code string, now time.Time) (*models.Voucher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, v := range s.vouchers {
+		if !strings.EqualFold(v.Code, code) {
+			continue
+		}
+		if !v.IsRedeemableAt(now) {
+			return nil, nil
+		}
+		// Replace rather than modify, since readers may hold the old record
+		used := *v
+		used.Uses++
+		s.vouchers[i] = &used
+		return &used, s.saveFile("vouchers.json", s.vouchers)
+	}
+	return nil, nil
+}
+
+// DeleteVoucher removes a voucher
+func (s *Storage) DeleteVoucher(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, v := range s.vouchers {
+		if v.ID == id {
+			s.vouchers = append(s.vouchers[:i], s.vouchers[i+1:]...)
+			return s.saveFile("vouchers.json", s.vouchers)
+		}
+	}
+	return nil
+}
+
+// ListVoucherSessions returns the active sessions started with a voucher
+func (s *Storage) ListVoucherSessions(voucherID string) []*models.Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*models.Session, 0)
+	for _, sess := range s.sessions {
+		if sess.VoucherID == voucherID && sess.IsActive {
+			result = append(result, sess)
+		}
+	}
+	return result
+}
+
+// ============ Preauth Device Methods ============
+
+// ListPreAuthDevices returns all devices that bypass the portal
+func (s *Storage) ListPreAuthDevices() []*models.PreAuthDevice {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*models.PreAuthDevice, len(s.preauth))
+	copy(result, s.preauth)
+	return result
+}
+
+// GetPreAuthDevice returns the preauth device with this MAC, or nil
+func (s *Storage) GetPreAuthDevice(mac string) *models.PreAuthDevice {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, d := range s.preauth {
+		if strings.EqualFold(d.MAC, mac) {
+			return d
+		}
+	}
+	return nil
+}
+
+// SavePreAuthDevice creates or updates a preauth device, keyed by MAC
+func (s *Storage) SavePreAuthDevice(device *models.PreAuthDevice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for i, d := range s.preauth {
+		if strings.EqualFold(d.MAC, device.MAC) {
+			s.preauth[i] = device
+			found = true
+			break
+		}
+	}
+	if !found {
+		s.preauth = append(s.preauth, device)
+	}
+
+	return s.saveFile("preauth_devices.json", s.preauth)
+}
+
+// DeletePreAuthDevice removes a preauth device
+func (s *Storage) DeletePreAuthDevice(mac string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, d := range s.preauth {
+		if strings.EqualFold(d.MAC, mac) {
+			s.preauth = append(s.preauth[:i], s.preauth[i+1:]...)
+			return s.saveFile("preauth_devices.json", s.preauth)
+		}
+	}
+	return nil
+}
+
+// ============ Walled Garden Methods ============
+
+// ListWalledGarden returns all hosts reachable before login
+func (s *Storage) ListWalledGarden() []*models.WalledGardenEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*models.WalledGardenEntry, len(s.walledGarden))
+	copy(result, s.walledGarden)
+	return result
+}
+
+// GetWalledGardenEntry returns a walled garden entry by ID, or nil
+func (s *Storage) GetWalledGardenEntry(id string) *models.WalledGardenEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, e := range s.walledGarden {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// GetWalledGardenEntryByHost returns the walled garden entry for a host, or nil
+func (s *Storage) GetWalledGardenEntryByHost(host string) *models.WalledGardenEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, e := range s.walledGarden {
+		if strings.EqualFold(e.Host, host) {
+			return e
+		}
+	}
+	return nil
+}
+
+// SaveWalledGardenEntry creates or updates a walled garden entry
+func (s *Storage) SaveWalledGardenEntry(entry *models.WalledGardenEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for i, e := range s.walledGarden {
+		if e.ID == entry.ID {
+			s.walledGarden[i] = entry
+			found = true
+			break
+		}
+	}
+	if !found {
+		s.walledGarden = append(s.walledGarden, entry)
+	}
+
+	return s.saveFile("walled_garden.json", s.walledGarden)
+}
+
+// DeleteWalledGardenEntry removes a walled garden entry
+func (s *Storage) DeleteWalledGardenEntry(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, e := range s.walledGarden {
+		if e.ID == id {
+			s.walledGarden = append(s.walledGarden[:i], s.walledGarden[i+1:]...)
+			return s.saveFile("walled_garden.json", s.walledGarden)
+		}
+	}
+	return nil
+}
+
+// ============ Webhook Methods ============
+
+// ListWebhooks returns all webhook subscriptions
+func (s *Storage) ListWebhooks() []*models.WebhookSubscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*models.WebhookSubscription, len(s.webhooks))
+	copy(result, s.webhooks)
+	return result
+}
+
+// GetWebhook returns a webhook subscription by ID, or nil
+func (s *Storage) GetWebhook(id string) *models.WebhookSubscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, w := range s.webhooks {
+		if w.ID == id {
+			return w
+		}
+	}
+	return nil
+}
+
+// SaveWebhook creates or updates a webhook subscription
+func (s *Storage) SaveWebhook(webhook *models.WebhookSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for i, w := range s.webhooks {
+		if w.ID == webhook.ID {
+			s.webhooks[i] = webhook
+			found = true
+			break
+		}
+	}
+	if !found {
+		s.webhooks = append(s.webhooks, webhook)
+	}
+
+	return s.saveFile("webhooks.json", s.webhooks)
+}
+
+// DeleteWebhook removes a webhook subscription
+func (s *Storage) DeleteWebhook(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, w := range s.webhooks {
+		if w.ID == id {
+			s.webhooks = append(s.webhooks[:i], s.webhooks[i+1:]...)
+			return s.saveFile("webhooks.json", s.webhooks)
+		}
+	}
+	return nil
+}
+
+// ============ Audit Log Methods ============
+
+// ListAuditEntries returns the most recent audit entries, newest first.
+// A limit of 0 returns all entries.
+func (s *Storage) ListAuditEntries(limit int) []*models.AuditEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := len(s.auditLog)
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	result := make([]*models.AuditEntry, 0, n)
+	for i := len(s.auditLog) - 1; i >= 0 && len(result) < n; i-- {
+		result = append(result, s.auditLog[i])
+	}
+	return result
+}
+
+// AddAuditEntry appends an entry to the audit log
+func (s *Storage) AddAuditEntry(entry *models.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.auditLog = append(s.auditLog, entry)
+	if len(s.auditLog) > models.MaxAuditEntries {
+		s.auditLog = s.auditLog[len(s.auditLog)-models.MaxAuditEntries:]
+	}
+
+	return s.saveFile("audit.json", s.auditLog)
+}
+
+// ============ Holiday Mode Methods ============
+
+// GetHolidayMode returns the current holiday mode state
+func (s *Storage) GetHolidayMode() models.HolidayMode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.holidayMode
+}
+
+// SaveHolidayMode persists holiday mode and applies it to quota calculations
+func (s *Storage) SaveHolidayMode(mode models.HolidayMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.holidayMode = mode
+	models.ApplyHolidayMode(mode)
+
+	return s.saveFile("holiday.json", s.holidayMode)
+}
+
+// ============ Backup Methods ============
+
+// GetBackupStatus returns the outcome of the last scheduled backup
+func (s *Storage) GetBackupStatus() models.BackupStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.backupStatus
+}
+
+// SaveBackupStatus records the outcome of a scheduled backup
+func (s *Storage) SaveBackupStatus(status models.BackupStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.backupStatus = status
+	return s.saveFile("backup_status.json", s.backupStatus)
+}
+
+// WriteBackup writes every data file as a gzipped tar archive. Writes are
+// held off meanwhile, so the files are consistent with each other.
+func (s *Storage) WriteBackup(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := filepath.Glob(s.filePath("*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, path := range names {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:    filepath.Base(path),
+			Mode:    int64(s.fileMode.Perm()),
+			Size:    int64(len(data)),
+			ModTime: info.ModTime(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ============ Settings Methods ============
+
+// GetSettings returns the current settings
+func (s *Storage) GetSettings() models.Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings
+}
+
+// SaveSettings persists the settings
+func (s *Storage) SaveSettings(settings models.Settings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.settings = settings
+	return s.saveFile("settings.json", s.settings)
+}
+
+// SaveDataFile atomically writes a raw file, such as an uploaded image, to
+// the data directory
+func (s *Storage) SaveDataFile(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.filePath(name)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, s.fileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// DataFilePath returns the path of a file in the data directory
+func (s *Storage) DataFilePath(name string) string {
+	return s.filePath(name)
+}
+
+// DeleteDataFile removes a raw file from the data directory
+func (s *Storage) DeleteDataFile(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.filePath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ============ Utility Methods ============
+
+// ErrChildNotFound is returned when updating a child that doesn't exist
+var ErrChildNotFound = errors.New("child not found")
+
+// ResetChildQuota resets one child's used time and data to 0 under a single
+// lock, so time charged concurrently isn't lost to a read-modify-write
+func (s *Storage) ResetChildQuota(childID, dateStr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.children {
+		if c.ID == childID {
+			c.UsedTodayMin = 0
+			c.UsedTodayMB = 0
+			c.LastResetDate = dateStr
+			c.UpdatedAt = time.Now()
+			return s.saveFile("children.json", s.children)
+		}
+	}
+	return ErrChildNotFound
+}
+
+// ResetChildQuotas resets the used time and data of every child not yet
+// reset on dateStr to 0. Children already reset that day keep the time
+// charged since, so a second run loses nothing.
+func (s *Storage) ResetChildQuotas(dateStr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	changed := false
+	for _, c := range s.children {
+		if c.LastResetDate == dateStr {
+			continue
+		}
+		c.UsedTodayMin = 0
+		c.UsedTodayMB = 0
+		c.LastResetDate = dateStr
+		c.UpdatedAt = now
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return s.saveFile("children.json", s.children)
+}
+
+// PruneResult counts the records Prune removed
+type PruneResult struct {
+	Sessions     int `json:"sessions"`
+	AuditEntries int `json:"audit_entries"`
+	UsageDays    int `json:"usage_days"` // Days of per-category usage, over all children
+}
+
+// Prune removes history from before cutoff: inactive sessions that ended
+// before it, audit entries and per-category usage days. Active sessions are
+// always kept. Only files that changed are written.
+func (s *Storage) Prune(cutoff time.Time) (PruneResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result PruneResult
+
+	sessions := make([]*models.Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		if !sess.IsActive && sess.EndTime().Before(cutoff) {
+			result.Sessions++
+			continue
+		}
+		sessions = append(sessions, sess)
+	}
+	if result.Sessions > 0 {
+		s.sessions = sessions
+		if err := s.saveFile("sessions.json", s.sessions); err != nil {
+			return result, err
+		}
+	}
+
+	audit := make([]*models.AuditEntry, 0, len(s.auditLog))
+	for _, entry := range s.auditLog {
+		if entry.Time.Before(cutoff) {
+			result.AuditEntries++
+			continue
+		}
+		audit = append(audit, entry)
+	}
+	if result.AuditEntries > 0 {
+		s.auditLog = audit
+		if err := s.saveFile("audit.json", s.auditLog); err != nil {
+			return result, err
+		}
+	}
+
+	oldest := cutoff.Format(models.DateLayout)
+	for _, c := range s.children {
+		for date := range c.CategoryUsage {
+			if date < oldest {
+				delete(c.CategoryUsage, date)
+				result.UsageDays++
+			}
+		}
+	}
+	if result.UsageDays > 0 {
+		if err := s.saveFile("children.json", s.children); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
 }
 
 // ClearInactiveSessions removes all inactive sessions
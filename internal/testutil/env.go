@@ -0,0 +1,131 @@
+package testutil
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"parenta/internal/config"
+	"parenta/internal/models"
+	"parenta/internal/services"
+	"parenta/internal/storage"
+)
+
+// Env is a complete set of services over a temporary data directory, with
+// ndsctl and dnsmasq restarts faked. The ticker isn't started; call
+// Ticker.Tick to run a cycle.
+type Env struct {
+	Config   *config.Config
+	Storage  *storage.Storage
+	NDS      *FakeNDS
+	Restarts *RecordingRunner
+	NDSPool  *services.NDSCtlPool
+	Dnsmasq  *services.DnsmasqService
+	Garden   *services.WalledGardenService
+	Auth     *services.AuthService
+	Ticker   *services.SessionTicker
+}
+
+// NewEnv builds an Env in directories removed when the test ends
+func NewEnv(t testing.TB) *Env {
+	t.Helper()
+	dir := t.TempDir()
+
+	cfg := &config.Config{}
+	cfg.Server.Host = "127.0.0.1"
+	cfg.Server.Port = 8080
+	cfg.Storage.DataDir = filepath.Join(dir, "data")
+	cfg.Storage.RetentionDays = 365
+	cfg.Storage.MaxFileSizeBytes = 10 << 20
+	cfg.OpenNDS.NDSCtlPath = "ndsctl"
+	cfg.OpenNDS.GatewayIPs = []string{"192.168.1.1"}
+	cfg.Dnsmasq.ConfDir = filepath.Join(dir, "dnsmasq.d")
+	cfg.Dnsmasq.RestartCmd = "/etc/init.d/dnsmasq restart"
+	cfg.Dnsmasq.UpstreamDNS = "8.8.8.8"
+	cfg.Session.JWTSecret = "test-secret-0123456789"
+	cfg.Session.JWTExpiryHours = 24
+	cfg.Session.AccessTokenMinutes = 15
+	cfg.Session.RefreshTokenDays = 30
+	cfg.Session.TickIntervalSeconds = 30
+	cfg.Session.MaxLoginAttempts = 5
+	cfg.Session.LockoutMinutes = 5
+	cfg.Session.AuthMode = "both"
+	cfg.Defaults.DailyQuotaMinutes = 120
+	cfg.System.AllowedCommands = config.DefaultAllowedCommands()
+	cfg.System.RestartAttempts = 1
+	cfg.System.DashboardCacheSeconds = -1
+
+	store, err := storage.New(cfg.Storage.DataDir, config.DefaultDirMode, config.DefaultFileMode)
+	if err != nil {
+		t.Fatalf("storage: %v", err)
+	}
+
+	nds := NewFakeNDS()
+	pool := services.NewNDSCtlPool(cfg.OpenNDS.NDSCtlPath, cfg.OpenNDS.GatewayIPs, nil, nil)
+	pool.SetRunner(nds)
+
+	restarts := &RecordingRunner{}
+	dnsmasq := services.NewDnsmasqService(store, cfg.Dnsmasq.ConfDir, cfg.Dnsmasq.RestartCmd,
+		cfg.Dnsmasq.UpstreamDNS, "", cfg.System.RestartAttempts)
+	dnsmasq.SetRunner(restarts)
+
+	garden := services.NewWalledGardenService(store, filepath.Join(dir, "opennds-walledgarden.sh"), cfg.System.RestartAttempts)
+	garden.SetRunner(restarts)
+
+	authSvc := services.NewAuthService(store, cfg.Session.JWTSecret, cfg.Session.JWTExpiryHours,
+		cfg.Session.MaxLoginAttempts, time.Duration(cfg.Session.LockoutMinutes)*time.Minute)
+
+	return &Env{
+		Config:   cfg,
+		Storage:  store,
+		NDS:      nds,
+		Restarts: restarts,
+		NDSPool:  pool,
+		Dnsmasq:  dnsmasq,
+		Garden:   garden,
+		Auth:     authSvc,
+		Ticker:   services.NewSessionTicker(store, pool, dnsmasq, cfg.Session.TickIntervalSeconds, cfg.Storage.RetentionDays),
+	}
+}
+
+// AddChild saves an active child with the given login and daily quota
+func (e *Env) AddChild(t testing.TB, username, password string, quotaMin int) *models.Child {
+	t.Helper()
+	hash, err := services.HashPassword(password)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	now := time.Now()
+	child := &models.Child{
+		ID:            services.GenerateID(),
+		Username:      username,
+		PasswordHash:  hash,
+		Name:          username,
+		DailyQuotaMin: quotaMin,
+		FilterMode:    models.FilterModeNormal,
+		Devices:       make([]models.Device, 0),
+		IsActive:      true,
+		LastResetDate: now.Format("2006-01-02"),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := e.Storage.SaveChild(child); err != nil {
+		t.Fatalf("save child: %v", err)
+	}
+	return child
+}
+
+// AddAdmin saves an admin with the given login and role, who doesn't have
+// to change their password
+func (e *Env) AddAdmin(t testing.TB, username, password string, role models.UserRole) *models.User {
+	t.Helper()
+	admin, err := e.Auth.CreateAdmin(username, password, username, role)
+	if err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	admin.ForcePasswordChange = false
+	if err := e.Storage.SaveAdmin(admin); err != nil {
+		t.Fatalf("save admin: %v", err)
+	}
+	return admin
+}
@@ -0,0 +1,150 @@
+// Package testutil provides fakes for the programs Parenta drives, so the
+// FAS, ticker and ndsctl code can be tested together without openNDS or
+// dnsmasq installed.
+package testutil
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"parenta/internal/services"
+)
+
+// FakeNDS emulates ndsctl as a services.CommandRunner. It keeps a client
+// list that auth and deauth update, and records every call.
+type FakeNDS struct {
+	mu      sync.Mutex
+	clients map[string]services.ClientInfo // keyed by lowercase MAC
+	calls   [][]string
+
+	// Running is reported by "ndsctl status"; while false, status fails
+	Running bool
+
+	// StatusOutput is what "ndsctl status" prints while running
+	StatusOutput string
+}
+
+// NewFakeNDS creates a running FakeNDS with no clients
+func NewFakeNDS() *FakeNDS {
+	return &FakeNDS{
+		clients:      make(map[string]services.ClientInfo),
+		Running:      true,
+		StatusOutput: "openNDS Status",
+	}
+}
+
+// Run implements services.CommandRunner
+func (f *FakeNDS) Run(name string, args ...string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Drop the control socket, which only selects the instance
+	if len(args) >= 2 && args[0] == "-s" {
+		args = args[2:]
+	}
+	f.calls = append(f.calls, append([]string(nil), args...))
+	if len(args) == 0 {
+		return "", errors.New("no command given")
+	}
+
+	switch args[0] {
+	case "status":
+		if !f.Running {
+			return "", errors.New("openNDS is not running")
+		}
+		return f.StatusOutput, nil
+	case "json":
+		clients := make([]services.ClientInfo, 0, len(f.clients))
+		for _, c := range f.clients {
+			clients = append(clients, c)
+		}
+		data, err := json.Marshal(clients)
+		return string(data), err
+	case "auth":
+		if len(args) < 2 {
+			return "", errors.New("auth: missing MAC")
+		}
+		mac := strings.ToLower(args[1])
+		f.clients[mac] = services.ClientInfo{MAC: mac, State: "Authenticated"}
+		return "", nil
+	case "deauth":
+		if len(args) < 2 {
+			return "", errors.New("deauth: missing MAC or IP")
+		}
+		target := strings.ToLower(args[1])
+		for mac, c := range f.clients {
+			if mac == target || c.IP == target {
+				delete(f.clients, mac)
+				return "", nil
+			}
+		}
+		return "", errors.New("Client " + args[1] + " not found")
+	}
+	return "", errors.New("unknown command " + args[0])
+}
+
+// AddClient lists a client as authenticated, as if it had logged in
+// outside Parenta
+func (f *FakeNDS) AddClient(mac, ip string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	mac = strings.ToLower(mac)
+	f.clients[mac] = services.ClientInfo{MAC: mac, IP: ip, State: "Authenticated"}
+}
+
+// Authenticated reports whether a MAC is currently an authenticated client
+func (f *FakeNDS) Authenticated(mac string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.clients[strings.ToLower(mac)]
+	return ok
+}
+
+// Calls returns the ndsctl commands run so far, without the socket option
+func (f *FakeNDS) Calls() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]string(nil), f.calls...)
+}
+
+// Called reports how many times ndsctl was run with the given command and
+// first argument; an empty arg matches any
+func (f *FakeNDS) Called(command, arg string) int {
+	n := 0
+	for _, call := range f.Calls() {
+		if call[0] != command {
+			continue
+		}
+		if arg == "" || (len(call) > 1 && strings.EqualFold(call[1], arg)) {
+			n++
+		}
+	}
+	return n
+}
+
+// RecordingRunner is a services.CommandRunner that runs nothing. It records
+// each command line and returns Err, for dnsmasq restarts and the like.
+type RecordingRunner struct {
+	mu    sync.Mutex
+	calls []string
+
+	// Err is returned from every Run
+	Err error
+}
+
+// Run implements services.CommandRunner
+func (r *RecordingRunner) Run(name string, args ...string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, strings.Join(append([]string{name}, args...), " "))
+	return "", r.Err
+}
+
+// Calls returns the command lines run so far
+func (r *RecordingRunner) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.calls...)
+}
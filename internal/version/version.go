@@ -0,0 +1,6 @@
+// Package version holds the Parenta build version. Release builds set it
+// with -ldflags "-X parenta/internal/version.Version=1.2.3".
+package version
+
+// Version is the running Parenta version
+var Version = "1.0.0"
@@ -42,7 +42,7 @@ try {
     $env:GOARCH = "arm64"
 
     $OutputBinary = Join-Path $BuildDir $BinaryName
-    go build -trimpath -ldflags="-s -w -X main.Version=$Version" -o $OutputBinary ./cmd/parenta
+    go build -trimpath -ldflags="-s -w -X parenta/internal/version.Version=$Version" -o $OutputBinary ./cmd/parenta
 
     if ($LASTEXITCODE -ne 0) {
         throw "go build failed"
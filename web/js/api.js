@@ -3,24 +3,63 @@
 const API = {
     baseUrl: '',
     token: null,
+    refreshToken: null,
 
     // Initialize from localStorage
     init() {
         this.token = localStorage.getItem('parenta_token');
+        this.refreshToken = localStorage.getItem('parenta_refresh_token');
     },
 
-    // Set auth token
-    setToken(token) {
+    // Set auth token (and refresh token, when the server issues one)
+    setToken(token, refreshToken) {
         this.token = token;
         if (token) {
             localStorage.setItem('parenta_token', token);
         } else {
             localStorage.removeItem('parenta_token');
         }
+
+        if (refreshToken !== undefined || !token) {
+            this.refreshToken = refreshToken || null;
+            if (this.refreshToken) {
+                localStorage.setItem('parenta_refresh_token', this.refreshToken);
+            } else {
+                localStorage.removeItem('parenta_refresh_token');
+            }
+        }
+    },
+
+    // CSRF token for cookie sessions (the cookie is readable, the session cookie is not)
+    csrfToken() {
+        const match = document.cookie.match(/(?:^|;\s*)parenta_csrf=([^;]*)/);
+        return match ? decodeURIComponent(match[1]) : null;
+    },
+
+    // True if the server set a cookie session
+    hasCookieSession() {
+        return this.csrfToken() !== null;
+    },
+
+    // Exchange the refresh token (body or cookie) for a new access token
+    async refresh() {
+        if (!this.refreshToken && !this.hasCookieSession()) return false;
+
+        const response = await fetch(this.baseUrl + '/api/auth/refresh', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            credentials: 'same-origin',
+            body: JSON.stringify({ refresh_token: this.refreshToken || '' })
+        });
+        if (!response.ok) return false;
+
+        const json = await response.json();
+        this.setToken(json.token, json.refresh_token);
+        return true;
     },
 
     // Make API request
-    async request(method, path, data = null) {
+    async request(method, path, data = null, retried = false) {
         const headers = {
             'Content-Type': 'application/json',
         };
@@ -29,9 +68,15 @@ const API = {
             headers['Authorization'] = `Bearer ${this.token}`;
         }
 
+        const csrf = this.csrfToken();
+        if (csrf && method !== 'GET') {
+            headers['X-CSRF-Token'] = csrf;
+        }
+
         const options = {
             method,
             headers,
+            credentials: 'same-origin',
         };
 
         if (data && (method === 'POST' || method === 'PUT')) {
@@ -40,8 +85,11 @@ const API = {
 
         const response = await fetch(this.baseUrl + path, options);
 
-        // Handle 401 - redirect to login (but not for portal/fas endpoints)
+        // Handle 401 - try a token refresh once, then clear (but not for portal/fas endpoints)
         if (response.status === 401) {
+            if (!retried && !path.startsWith('/fas/') && await this.refresh()) {
+                return this.request(method, path, data, true);
+            }
             if (!path.startsWith('/fas/')) {
                 this.setToken(null);
             }
@@ -51,12 +99,37 @@ const API = {
         const json = await response.json();
 
         if (!response.ok) {
-            throw new Error(json.error || 'Request failed');
+            // Errors are {"error": {"code", "message"}}
+            const detail = json.error || {};
+            const error = new Error(detail.message || 'Request failed');
+            error.code = detail.code || null;
+            error.status = response.status;
+            throw error;
         }
 
         return json;
     },
 
+    // Fetch a binary response, such as an image, as a Blob
+    async getBlob(path, retried = false) {
+        const headers = {};
+        if (this.token) {
+            headers['Authorization'] = `Bearer ${this.token}`;
+        }
+
+        const response = await fetch(this.baseUrl + path, { headers, credentials: 'same-origin' });
+        if (response.status === 401 && !retried && await this.refresh()) {
+            return this.getBlob(path, true);
+        }
+        if (!response.ok) {
+            const json = await response.json().catch(() => ({}));
+            const error = new Error((json.error && json.error.message) || 'Request failed');
+            error.status = response.status;
+            throw error;
+        }
+        return response.blob();
+    },
+
     // Convenience methods
     get(path) {
         return this.request('GET', path);
@@ -77,11 +150,23 @@ const API = {
     // Auth endpoints
     async login(username, password) {
         const result = await this.post('/api/auth/login', { username, password });
-        this.setToken(result.token);
+        this.setToken(result.token, result.refresh_token);
         return result;
     },
 
     logout() {
+        // Revoke server-side; the local tokens are cleared regardless
+        if (this.token || this.hasCookieSession()) {
+            const headers = { 'Content-Type': 'application/json' };
+            if (this.token) headers['Authorization'] = `Bearer ${this.token}`;
+            if (this.csrfToken()) headers['X-CSRF-Token'] = this.csrfToken();
+            fetch(this.baseUrl + '/api/auth/logout', {
+                method: 'POST',
+                headers,
+                credentials: 'same-origin',
+                body: JSON.stringify({ refresh_token: this.refreshToken })
+            }).catch(() => {});
+        }
         this.setToken(null);
     },
 
@@ -89,6 +174,10 @@ const API = {
         return this.get('/api/auth/me');
     },
 
+    updateMe(displayName) {
+        return this.put('/api/auth/me', { display_name: displayName });
+    },
+
     changePassword(oldPassword, newPassword) {
         return this.post('/api/auth/password', {
             old_password: oldPassword,
@@ -57,12 +57,14 @@ const ChildrenPage = {
 
                                     <div style="margin-top: 0.75rem; font-size: 0.85rem; color: var(--text-secondary);">
                                         ${c.devices.length} device(s) registered
+                                        ${c.daily_data_quota_mb ? ` · Data: ${c.used_today_mb} of ${c.daily_data_quota_mb} MB` : ''}
                                         ${c.schedule_name ? ` · Schedule: ${escapeHtml(c.schedule_name)}` : ''}
                                     </div>
 
                                     <div class="btn-group" style="margin-top: 1rem;">
                                         <button class="btn-small btn-secondary" onclick="ChildrenPage.showEditModal('${c.id}')">Edit</button>
                                         <button class="btn-small btn-secondary" onclick="ChildrenPage.resetQuota('${c.id}')">Reset</button>
+                                        <button class="btn-small btn-secondary" onclick="ChildrenPage.showQR('${c.id}')" title="QR code that opens the portal with this child's username">QR</button>
                                         <button class="btn-small btn-danger" onclick="ChildrenPage.deleteChild('${c.id}')">Delete</button>
                                     </div>
                                 </div>
@@ -71,6 +73,18 @@ const ChildrenPage = {
                     </div>
                 `}
 
+                <!-- Portal QR Modal -->
+                <div id="child-qr-modal" class="modal-overlay hidden">
+                    <div class="modal" style="text-align: center;">
+                        <h2>Portal QR Code</h2>
+                        <img id="child-qr-image" alt="Portal QR code" width="256" height="256">
+                        <p style="font-size: 0.85rem; color: var(--text-secondary);">Scanning it opens the login page with the username filled in. The password is not included.</p>
+                        <div class="form-actions">
+                            <button type="button" class="btn-secondary" onclick="ChildrenPage.hideQR()">Close</button>
+                        </div>
+                    </div>
+                </div>
+
                 <!-- Add/Edit Modal -->
                 <div id="child-modal" class="modal-overlay hidden">
                     <div class="modal">
@@ -111,6 +125,23 @@ const ChildrenPage = {
                                 </select>
                             </div>
 
+                            <div style="margin-top: 1rem;">
+                                <label for="child-max-devices">Devices online at once <small>(0 = unlimited)</small></label>
+                                <input type="number" id="child-max-devices" min="0" max="20" value="0" style="width: 100px;">
+                            </div>
+
+                            <div style="margin-top: 1rem;">
+                                <label for="child-data-quota">Daily data cap <small>(MB, 0 = unlimited)</small></label>
+                                <input type="number" id="child-data-quota" min="0" max="1048576" value="0" style="width: 100px;">
+                            </div>
+
+                            <div style="margin-top: 1rem;">
+                                <label for="child-max-session">Longest session <small>(minutes, 0 = unlimited)</small></label>
+                                <input type="number" id="child-max-session" min="0" max="1440" value="0" style="width: 100px;">
+                                <label for="child-break" style="margin-top: 0.5rem;">Break between sessions <small>(minutes)</small></label>
+                                <input type="number" id="child-break" min="0" max="1440" value="0" style="width: 100px;">
+                            </div>
+
                             <div style="margin-top: 1rem;">
                                 <label for="child-schedule">Schedule</label>
                                 <select id="child-schedule">
@@ -230,6 +261,10 @@ const ChildrenPage = {
         document.getElementById('child-password').value = '';
         document.getElementById('child-quota').value = '120';
         document.getElementById('child-mode').value = 'normal';
+        document.getElementById('child-max-devices').value = '0';
+        document.getElementById('child-data-quota').value = '0';
+        document.getElementById('child-max-session').value = '0';
+        document.getElementById('child-break').value = '0';
         document.getElementById('password-hint').textContent = '(required)';
         document.getElementById('child-password').required = true;
         document.getElementById('child-error').classList.add('hidden');
@@ -250,6 +285,10 @@ const ChildrenPage = {
             document.getElementById('child-password').value = '';
             document.getElementById('child-quota').value = child.daily_quota_min;
             document.getElementById('child-mode').value = child.filter_mode;
+            document.getElementById('child-max-devices').value = child.max_concurrent_devices || 0;
+            document.getElementById('child-data-quota').value = child.daily_data_quota_mb || 0;
+            document.getElementById('child-max-session').value = child.max_session_minutes || 0;
+            document.getElementById('child-break').value = child.break_minutes || 0;
             document.getElementById('password-hint').textContent = '(leave blank to keep current)';
             document.getElementById('child-password').required = false;
             document.getElementById('child-error').classList.add('hidden');
@@ -276,6 +315,10 @@ const ChildrenPage = {
             daily_quota_min: parseInt(document.getElementById('child-quota').value),
             filter_mode: document.getElementById('child-mode').value,
             schedule_id: document.getElementById('child-schedule').value || '',
+            max_concurrent_devices: parseInt(document.getElementById('child-max-devices').value) || 0,
+            daily_data_quota_mb: parseInt(document.getElementById('child-data-quota').value) || 0,
+            max_session_minutes: parseInt(document.getElementById('child-max-session').value) || 0,
+            break_minutes: parseInt(document.getElementById('child-break').value) || 0,
             is_active: true
         };
 
@@ -309,6 +352,22 @@ const ChildrenPage = {
         }
     },
 
+    async showQR(id) {
+        try {
+            const blob = await API.getBlob(`/api/children/${id}/qr`);
+            const img = document.getElementById('child-qr-image');
+            if (img.src) URL.revokeObjectURL(img.src);
+            img.src = URL.createObjectURL(blob);
+            document.getElementById('child-qr-modal').classList.remove('hidden');
+        } catch (error) {
+            alert('Failed to load QR code: ' + error.message);
+        }
+    },
+
+    hideQR() {
+        document.getElementById('child-qr-modal').classList.add('hidden');
+    },
+
     async resetQuota(id) {
         if (!confirm('Reset this child\'s daily quota to full?')) {
             return;
@@ -73,6 +73,12 @@ const FiltersPage = {
                                 <option value="other">Other</option>
                             </select>
 
+                            <label for="filter-from">Active from (optional)</label>
+                            <input type="datetime-local" id="filter-from">
+
+                            <label for="filter-until">Active until (optional)</label>
+                            <input type="datetime-local" id="filter-until">
+
                             <div id="filter-error" class="error hidden"></div>
 
                             <div class="form-actions">
@@ -145,6 +151,7 @@ const FiltersPage = {
                     ${rules.map(f => `
                         <div class="list-item">
                             <code>${escapeHtml(f.domain)}</code>
+                            ${this.renderWindow(f)}
                             <button class="btn-small btn-danger" onclick="FiltersPage.deleteFilter('${f.id}')">Remove</button>
                         </div>
                     `).join('')}
@@ -155,6 +162,16 @@ const FiltersPage = {
         return html;
     },
 
+    // Describe a temporary rule's effective window
+    renderWindow(f) {
+        if (!f.effective_from && !f.effective_until) return '';
+        const fmt = (s) => new Date(s).toLocaleString();
+        const parts = [];
+        if (f.effective_from) parts.push(`from ${fmt(f.effective_from)}`);
+        if (f.effective_until) parts.push(`until ${fmt(f.effective_until)}`);
+        return `<span style="font-size: 0.8rem; color: var(--text-secondary);">${parts.join(' ')}</span>`;
+    },
+
     switchTab(tab) {
         this.currentTab = tab;
         this.render();
@@ -164,6 +181,8 @@ const FiltersPage = {
         document.getElementById('filter-domain').value = '';
         document.getElementById('filter-type').value = this.currentTab;
         document.getElementById('filter-category').value = '';
+        document.getElementById('filter-from').value = '';
+        document.getElementById('filter-until').value = '';
         document.getElementById('filter-error').classList.add('hidden');
         document.getElementById('filter-modal').classList.remove('hidden');
     },
@@ -192,6 +211,11 @@ const FiltersPage = {
             category: document.getElementById('filter-category').value
         };
 
+        const from = document.getElementById('filter-from').value;
+        const until = document.getElementById('filter-until').value;
+        if (from) data.effective_from = new Date(from).toISOString();
+        if (until) data.effective_until = new Date(until).toISOString();
+
         // Clean domain
         data.domain = data.domain.replace(/^(https?:\/\/)?(www\.)?/, '');
 
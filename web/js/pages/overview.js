@@ -28,6 +28,13 @@ const OverviewPage = {
                     </span>
                 </div>
 
+                ${dashboard.opennds_running ? '' : `
+                <div class="error">
+                    <strong>OpenNDS is not running.</strong>
+                    Children cannot log in and quota is paused until the captive portal is back.
+                    Restart it from the System page.
+                </div>`}
+
                 <!-- Main Stats -->
                 <div class="stats-grid">
                     <div class="stat-card">
@@ -107,6 +114,7 @@ const OverviewPage = {
                                 <tr>
                                     <th>Child</th>
                                     <th>Device</th>
+                                    <th>Gateway</th>
                                     <th>Started</th>
                                     <th>Duration</th>
                                     <th>Remaining</th>
@@ -118,6 +126,7 @@ const OverviewPage = {
                                     <tr>
                                         <td><strong>${escapeHtml(s.child_name)}</strong></td>
                                         <td><code>${s.mac}</code></td>
+                                        <td>${escapeHtml(s.gateway_name || s.gateway_ip || '-')}</td>
                                         <td>${formatTime(s.started_at)}</td>
                                         <td>${formatMinutes(s.duration_min)}</td>
                                         <td>
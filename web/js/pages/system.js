@@ -1,4 +1,4 @@
-// System Page - Status, commands, logs, and admin management
+// System Page - Status, diagnostics, logs, and admin management
 
 const SystemPage = {
     logRefreshInterval: null,
@@ -20,7 +20,7 @@ const SystemPage = {
                 <!-- Tabs -->
                 <div class="tabs">
                     <div class="tab active" onclick="SystemPage.showTab('status')">Status</div>
-                    <div class="tab" onclick="SystemPage.showTab('commands')">Commands</div>
+                    <div class="tab" onclick="SystemPage.showTab('commands')">Diagnostics</div>
                     <div class="tab" onclick="SystemPage.showTab('logs')">Logs</div>
                     <div class="tab" onclick="SystemPage.showTab('admins')">Admins</div>
                 </div>
@@ -89,14 +89,28 @@ const SystemPage = {
                     </div>
                 </div>
 
-                <!-- Commands Tab -->
+                <!-- Diagnostics Tab -->
                 <div id="tab-commands" class="tab-content hidden">
+                    <div class="card">
+                        <div class="card-header">
+                            <h2>Diagnostics</h2>
+                        </div>
+                        <div class="command-buttons">
+                            <button class="btn-small btn-secondary" onclick="SystemPage.loadDiagnostics('interfaces')">Interfaces</button>
+                            <button class="btn-small btn-secondary" onclick="SystemPage.loadDiagnostics('routes')">Routes</button>
+                            <button class="btn-small btn-secondary" onclick="SystemPage.loadDiagnostics('wireless')">WiFi</button>
+                            <button class="btn-small btn-secondary" onclick="SystemPage.loadDiagnostics('processes')">Processes</button>
+                        </div>
+                        <div id="diagnostics-output"></div>
+                    </div>
+
                     <div class="card">
                         <div class="card-header">
                             <h2>Custom Shell</h2>
                         </div>
                         <p style="font-size: 0.9rem; color: var(--text-secondary); margin-bottom: 1rem;">
-                            Execute any shell command on the router. Use with caution.
+                            Execute any shell command on the router. Only for super admins, and only when
+                            <code>system.allow_raw_commands</code> is turned on in the config file.
                         </p>
                         <form id="shell-form" onsubmit="SystemPage.executeShell(event)" style="display: flex; gap: 0.5rem; margin-bottom: 1rem;">
                             <input type="text" id="shell-input" placeholder="Enter command (e.g., ls -la /etc)" style="flex: 1;">
@@ -107,27 +121,6 @@ const SystemPage = {
                         </div>
                     </div>
 
-                    <div class="card">
-                        <div class="card-header">
-                            <h2>Quick Commands</h2>
-                        </div>
-                        <p style="font-size: 0.9rem; color: var(--text-secondary); margin-bottom: 1rem;">
-                            Run common diagnostic commands.
-                        </p>
-                        <div class="command-buttons">
-                            <button class="btn-small btn-secondary" onclick="SystemPage.runCommand('uptime', [])">Uptime</button>
-                            <button class="btn-small btn-secondary" onclick="SystemPage.runCommand('free', ['-m'])">Memory</button>
-                            <button class="btn-small btn-secondary" onclick="SystemPage.runCommand('df', ['-h'])">Disk</button>
-                            <button class="btn-small btn-secondary" onclick="SystemPage.runCommand('ps', [])">Processes</button>
-                            <button class="btn-small btn-secondary" onclick="SystemPage.runCommand('ifconfig', [])">Network</button>
-                            <button class="btn-small btn-secondary" onclick="SystemPage.runCommand('iwinfo', [])">WiFi Info</button>
-                            <button class="btn-small btn-secondary" onclick="SystemPage.runCommand('ndsctl', ['status'])">NDS Status</button>
-                            <button class="btn-small btn-secondary" onclick="SystemPage.runCommand('ndsctl', ['json'])">NDS Clients</button>
-                        </div>
-                        <div id="command-output" class="command-output hidden">
-                            <pre></pre>
-                        </div>
-                    </div>
                 </div>
 
                 <!-- Logs Tab -->
@@ -167,7 +160,7 @@ const SystemPage = {
                                 <div class="admin-item">
                                     <div class="admin-info">
                                         <span class="admin-name">${escapeHtml(a.display_name || a.username)}</span>
-                                        <span class="admin-role">${a.role === 'super' ? 'Super Admin' : 'Admin'} - @${escapeHtml(a.username)}</span>
+                                        <span class="admin-role">${a.role === 'super' ? 'Super Admin' : a.role === 'viewer' ? 'Viewer' : 'Admin'} - @${escapeHtml(a.username)}</span>
                                     </div>
                                     <div class="btn-group">
                                         <button class="btn-small btn-secondary" onclick="SystemPage.showEditAdminModal('${a.id}')">Edit</button>
@@ -231,6 +224,7 @@ const SystemPage = {
                             <select id="admin-role">
                                 <option value="admin">Admin</option>
                                 <option value="super">Super Admin (can manage other admins)</option>
+                                <option value="viewer">Viewer (read-only)</option>
                             </select>
 
                             <div id="admin-error" class="error hidden"></div>
@@ -298,7 +292,60 @@ const SystemPage = {
         }
     },
 
-    // ============ Commands ============
+    // ============ Diagnostics ============
+
+    async loadDiagnostics(kind) {
+        const output = document.getElementById('diagnostics-output');
+        output.innerHTML = '<p>Loading...</p>';
+
+        try {
+            const rows = await API.get(`/api/diagnostics/${kind}`);
+            output.innerHTML = rows.length === 0
+                ? '<div class="empty-state"><p>Nothing to show.</p></div>'
+                : this.renderDiagnostics(kind, rows);
+        } catch (error) {
+            output.innerHTML = `<div class="error">${escapeHtml(error.message)}</div>`;
+        }
+    },
+
+    renderDiagnostics(kind, rows) {
+        const table = (headers, cells) => `
+            <table>
+                <thead><tr>${headers.map(h => `<th>${h}</th>`).join('')}</tr></thead>
+                <tbody>
+                    ${rows.map(r => `<tr>${cells(r).map(c => `<td>${escapeHtml(String(c ?? ''))}</td>`).join('')}</tr>`).join('')}
+                </tbody>
+            </table>
+        `;
+
+        switch (kind) {
+            case 'interfaces':
+                return table(['Name', 'State', 'MAC', 'MTU', 'Addresses'], i => [
+                    i.name, i.state, i.mac, i.mtu,
+                    i.addresses.map(a => `${a.address}/${a.prefix_len}`).join(', ')
+                ]);
+            case 'routes':
+                return table(['Destination', 'Gateway', 'Device', 'Protocol', 'Source', 'Metric'], r => [
+                    r.type ? `${r.type} ${r.destination}` : r.destination,
+                    r.gateway, r.device, r.protocol, r.source, r.metric
+                ]);
+            case 'wireless':
+                return table(['Interface', 'SSID', 'Mode', 'Channel', 'Signal', 'Noise', 'Bit Rate', 'Encryption'], w => [
+                    w.name, w.essid, w.mode, w.channel,
+                    w.signal_dbm ? `${w.signal_dbm} dBm` : '',
+                    w.noise_dbm ? `${w.noise_dbm} dBm` : '',
+                    w.bit_rate_mbit ? `${w.bit_rate_mbit} Mbit/s` : '',
+                    w.encryption
+                ]);
+            case 'processes':
+                return table(['PID', 'User', 'State', 'Memory', 'Command'], p => [
+                    p.pid, p.user, p.state, `${(p.rss_kb / 1024).toFixed(1)} MB`, p.command || `[${p.name}]`
+                ]);
+        }
+        return '';
+    },
+
+    // ============ Shell ============
 
     async executeShell(e) {
         e.preventDefault();
@@ -327,21 +374,6 @@ const SystemPage = {
         }
     },
 
-    async runCommand(command, args) {
-        const outputDiv = document.getElementById('command-output');
-        const pre = outputDiv.querySelector('pre');
-
-        outputDiv.classList.remove('hidden');
-        pre.textContent = `Running: ${command} ${args.join(' ')}...`;
-
-        try {
-            const result = await API.post('/api/system/command', { command, args });
-            pre.textContent = `$ ${result.command}\n\n${result.output}${result.error ? '\n\nError: ' + result.error : ''}`;
-        } catch (error) {
-            pre.textContent = `Error: ${error.message}`;
-        }
-    },
-
     // ============ Logs ============
 
     async loadLogs() {
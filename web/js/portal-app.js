@@ -42,7 +42,11 @@ const PortalApp = {
             mac: params.get('mac') || '',
             ip: params.get('ip') || '',
             authdir: params.get('authdir') || '',
-            originurl: params.get('originurl') || ''
+            originurl: params.get('originurl') || '',
+            gatewayip: params.get('gatewayip') || '',
+            gatewayname: params.get('gatewayname') || '',
+            gatewayhash: params.get('gatewayhash') || '',
+            gatewayaddress: params.get('gatewayaddress') || ''
         };
 
         // Set hidden form fields
@@ -50,17 +54,25 @@ const PortalApp = {
             const el = document.getElementById('fas-' + key);
             if (el) el.value = this.fasParams[key];
         });
+
+        // A child's QR code fills in their username
+        const username = params.get('username');
+        const usernameEl = document.getElementById('username');
+        if (username && usernameEl) {
+            usernameEl.value = username;
+            usernameEl.removeAttribute('autofocus');
+            document.getElementById('password').focus();
+        }
     },
 
     // Check for auth redirect (from form submission)
     checkAuthRedirect() {
         const params = new URLSearchParams(window.location.search);
         const authType = params.get('auth_type');
-        const token = params.get('token');
         const forceChange = params.get('force_password_change') === 'true';
 
-        if (authType === 'admin' && token) {
-            API.setToken(token);
+        // The form login flow sets a session cookie; tokens are never in the URL
+        if (authType === 'admin' && API.hasCookieSession()) {
             this.userType = 'admin';
             this.isAuthenticated = true;
             this.forcePasswordChange = forceChange;
@@ -75,8 +87,8 @@ const PortalApp = {
         // Already authenticated from redirect
         if (this.isAuthenticated) return;
 
-        // Check admin JWT
-        if (API.token) {
+        // Check admin JWT (header token or cookie session)
+        if (API.token || API.hasCookieSession()) {
             try {
                 const user = await API.getMe();
                 this.userType = 'admin';
@@ -88,10 +100,26 @@ const PortalApp = {
             }
         }
 
-        // Check child session by MAC (if we have MAC from captive portal)
-        if (this.fasParams.mac) {
+        // Check child session by portal token, else by MAC or IP (from the captive portal)
+        const portalToken = localStorage.getItem('parenta_portal_token');
+        if (portalToken) {
+            try {
+                const status = await API.get('/fas/status?token=' + encodeURIComponent(portalToken));
+                this.userType = 'child';
+                this.isAuthenticated = true;
+                this.childData = status;
+                return;
+            } catch (e) {
+                // Expired, or the session has ended
+                localStorage.removeItem('parenta_portal_token');
+            }
+        }
+        if (this.fasParams.mac || this.fasParams.ip) {
+            const query = this.fasParams.mac
+                ? 'mac=' + encodeURIComponent(this.fasParams.mac)
+                : 'ip=' + encodeURIComponent(this.fasParams.ip);
             try {
-                const status = await API.get('/fas/status?mac=' + encodeURIComponent(this.fasParams.mac));
+                const status = await API.get('/fas/status?' + query);
                 this.userType = 'child';
                 this.isAuthenticated = true;
                 this.childData = status;
@@ -110,6 +138,12 @@ const PortalApp = {
             loginForm.addEventListener('submit', (e) => this.handleLogin(e));
         }
 
+        // Guest code form
+        const voucherForm = document.getElementById('voucher-form');
+        if (voucherForm) {
+            voucherForm.addEventListener('submit', (e) => this.handleVoucher(e));
+        }
+
         // Logout button
         const logoutBtn = document.getElementById('logout-btn');
         if (logoutBtn) {
@@ -162,6 +196,21 @@ const PortalApp = {
                     document.getElementById('wifi-name').textContent = 'Parenta';
                     document.getElementById('used-today').textContent = this.childData.used_today || 0;
                     document.getElementById('daily-quota').textContent = this.childData.daily_quota || 0;
+
+                    // Minutes left don't help while the schedule blocks access
+                    const blocked = this.childData.schedule_allowed === false;
+                    document.getElementById('schedule-blocked').classList.toggle('hidden', !blocked);
+                    document.getElementById('close-window-note').classList.toggle('hidden', blocked);
+                    if (blocked && this.childData.next_change) {
+                        const next = new Date(this.childData.next_change);
+                        const format = { hour: '2-digit', minute: '2-digit' };
+                        if (next.toDateString() !== new Date().toDateString()) {
+                            format.weekday = 'short';
+                        }
+                        document.getElementById('blocked-until-time').textContent = next.toLocaleTimeString([], format);
+                        document.getElementById('blocked-now').classList.add('hidden');
+                        document.getElementById('blocked-until').classList.remove('hidden');
+                    }
                 }
             }
         } else {
@@ -190,17 +239,26 @@ const PortalApp = {
                 mac: this.fasParams.mac,
                 ip: this.fasParams.ip,
                 authdir: this.fasParams.authdir,
-                originurl: this.fasParams.originurl
+                originurl: this.fasParams.originurl,
+                gatewayip: this.fasParams.gatewayip,
+                gatewayname: this.fasParams.gatewayname,
+                gatewayhash: this.fasParams.gatewayhash,
+                gatewayaddress: this.fasParams.gatewayaddress
             });
 
             errorEl.classList.add('hidden');
 
             if (result.type === 'admin') {
                 // Admin login
-                API.setToken(result.token);
+                API.setToken(result.token, result.refresh_token);
                 this.userType = 'admin';
                 this.isAuthenticated = true;
                 this.forcePasswordChange = result.force_password_change;
+                // Let openNDS complete the login; it returns to the portal
+                if (result.auth_url) {
+                    window.location.href = result.auth_url;
+                    return;
+                }
                 this.updateUI();
                 Router.start();
             } else if (result.type === 'child') {
@@ -208,9 +266,17 @@ const PortalApp = {
                 this.userType = 'child';
                 this.isAuthenticated = true;
                 this.childData = result;
+                if (result.portal_token) {
+                    localStorage.setItem('parenta_portal_token', result.portal_token);
+                }
 
-                // Redirect to original URL or show status
-                if (result.redirect_url && result.redirect_url !== 'null' && result.redirect_url !== '') {
+                // Let openNDS complete the login (it returns to the welcome page),
+                // else show the welcome page (which links on to the original URL), or the status
+                if (result.auth_url) {
+                    window.location.href = result.auth_url;
+                } else if (result.welcome_url && this.fasParams.mac) {
+                    window.location.href = result.welcome_url;
+                } else if (result.redirect_url && result.redirect_url !== 'null' && result.redirect_url !== '') {
                     window.location.href = result.redirect_url;
                 } else {
                     this.updateUI();
@@ -222,6 +288,51 @@ const PortalApp = {
         }
     },
 
+    // Handle guest code login
+    async handleVoucher(e) {
+        e.preventDefault();
+
+        const voucher = document.getElementById('voucher').value;
+        const errorEl = document.getElementById('login-error');
+
+        try {
+            const result = await API.post('/fas/auth', {
+                voucher,
+                hid: this.fasParams.hid,
+                mac: this.fasParams.mac,
+                ip: this.fasParams.ip,
+                authdir: this.fasParams.authdir,
+                originurl: this.fasParams.originurl,
+                gatewayip: this.fasParams.gatewayip,
+                gatewayname: this.fasParams.gatewayname,
+                gatewayhash: this.fasParams.gatewayhash,
+                gatewayaddress: this.fasParams.gatewayaddress
+            });
+
+            errorEl.classList.add('hidden');
+            if (result.portal_token) {
+                localStorage.setItem('parenta_portal_token', result.portal_token);
+            }
+
+            if (result.auth_url) {
+                window.location.href = result.auth_url;
+            } else if (result.welcome_url && this.fasParams.mac) {
+                window.location.href = result.welcome_url;
+            } else if (result.redirect_url && result.redirect_url !== 'null') {
+                window.location.href = result.redirect_url;
+            } else {
+                // Guests share the child status view
+                this.userType = 'child';
+                this.isAuthenticated = true;
+                this.childData = { child_name: 'Guest', remaining_minutes: result.remaining_minutes };
+                this.updateUI();
+            }
+        } catch (error) {
+            errorEl.textContent = error.message || 'Invalid or expired guest code';
+            errorEl.classList.remove('hidden');
+        }
+    },
+
     // Handle logout
     handleLogout() {
         API.logout();